@@ -0,0 +1,429 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+/*
+Package approvals holds the large transfers of a writer until an operator approves them.
+
+A transfer above the approval threshold of its asset is added to a queue persisted in the approval directory of the
+chain, and is not relayed until it is approved. Operators decide with `platdot approvals approve|reject`, which signs
+the decision with the relayer key of the chain and writes it to the directory. The decision covers the digest of the
+transfer as queued, its asset, amount and payload with the recipient. The writer applies the decisions signed by its own
+key for the transfer they were made for, and resolves the approved transfers. Decided transfers are pruned from the
+queue after DecidedRetention, a transfer observed again afterwards waits for a new decision.
+*/
+package approvals
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	log "github.com/ChainSafe/log15"
+	"github.com/Platdot-network/Platdot/chains/limits"
+	"github.com/Platdot-network/Platdot/chains/persist"
+	"github.com/hacpy/go-ethereum/common"
+	"github.com/hacpy/go-ethereum/common/hexutil"
+	"github.com/hacpy/go-ethereum/crypto"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+// Chain config options of the approvals
+const (
+	ThresholdOpt = "approvalThreshold"
+	DirOpt       = "approvalDir"
+)
+
+// Time between two reads of the decisions of the operators
+var PollInterval = 10 * time.Second
+
+// Time the decided transfers are kept in the queue
+var DecidedRetention = 7 * 24 * time.Hour
+
+// Status of a queued transfer
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+)
+
+const queueFile = "queue.json"
+const decisionsDir = "decisions"
+
+var ErrPending = errors.New("transfer awaiting manual approval")
+var ErrRejected = errors.New("transfer rejected by an operator")
+var ErrChanged = errors.New("transfer differs from the one queued for approval")
+
+type Config struct {
+	Dir        string                      // Directory of the queue and the decisions
+	Thresholds map[msg.ResourceId]*big.Int // Transfers above the threshold of their asset wait for an approval
+	Default    *big.Int                    // Threshold of the assets without threshold of their own
+}
+
+// ParseConfig parses the approval thresholds of the chain options, see limits.ParseAmounts for their format. The
+// approval directory defaults to a directory of the blockstore. It returns nil if no threshold is configured.
+func ParseConfig(opts map[string]string, blockstorePath string, chain msg.ChainId) (*Config, error) {
+	value, ok := opts[ThresholdOpt]
+	if !ok || value == "" {
+		return nil, nil
+	}
+	thresholds, any, err := limits.ParseAmounts(ThresholdOpt, value)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := opts[DirOpt]
+	if dir == "" {
+		blockstorePath, err = persist.BlockstoreDir(blockstorePath)
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(blockstorePath, fmt.Sprintf("approvals-%d", chain))
+	}
+	return &Config{Dir: dir, Thresholds: thresholds, Default: any}, nil
+}
+
+// threshold returns the threshold of an asset, nil if its transfers don't need an approval
+func (c *Config) threshold(rId msg.ResourceId) *big.Int {
+	if threshold, ok := c.Thresholds[rId]; ok {
+		return threshold
+	}
+	return c.Default
+}
+
+// Entry is a transfer of the queue
+type Entry struct {
+	Source      msg.ChainId      `json:"source"`
+	Destination msg.ChainId      `json:"destination"`
+	Nonce       msg.Nonce        `json:"nonce"`
+	Type        msg.TransferType `json:"type"`
+	ResourceId  string           `json:"resourceId"`
+	Payload     []hexutil.Bytes  `json:"payload"` // Payload of the message as received
+	Amount      string           `json:"amount"`
+	Status      string           `json:"status"`
+	Queued      time.Time        `json:"queued"`
+	Decided     *time.Time       `json:"decided,omitempty"`
+}
+
+func newEntry(m msg.Message, amount *big.Int, now time.Time) (*Entry, error) {
+	e := &Entry{
+		Source:      m.Source,
+		Destination: m.Destination,
+		Nonce:       m.DepositNonce,
+		Type:        m.Type,
+		ResourceId:  m.ResourceId.Hex(),
+		Amount:      amount.String(),
+		Status:      StatusPending,
+		Queued:      now,
+	}
+	for _, p := range m.Payload {
+		b, ok := p.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("unsupported payload of a %s", m.Type)
+		}
+		e.Payload = append(e.Payload, append([]byte(nil), b...))
+	}
+	return e, nil
+}
+
+// Message returns the message of the transfer
+func (e *Entry) Message() msg.Message {
+	payload := make([]interface{}, len(e.Payload))
+	for i, p := range e.Payload {
+		payload[i] = []byte(p)
+	}
+	return msg.Message{
+		Source:       e.Source,
+		Destination:  e.Destination,
+		Type:         e.Type,
+		DepositNonce: e.Nonce,
+		ResourceId:   msg.ResourceIdFromSlice(common.FromHex(e.ResourceId)),
+		Payload:      payload,
+	}
+}
+
+// Digest returns the hash of the transfer as queued, which the decisions cover
+func (e *Entry) Digest() string {
+	payload := make([]string, len(e.Payload))
+	for i, p := range e.Payload {
+		payload[i] = p.String()
+	}
+	data := fmt.Sprintf("platdot transfer source=%d destination=%d nonce=%d type=%s resource=%s amount=%s payload=%s",
+		e.Source, e.Destination, e.Nonce, e.Type, e.ResourceId, e.Amount, strings.Join(payload, ","))
+	return crypto.Keccak256Hash([]byte(data)).Hex()
+}
+
+// Recipient returns the recipient of a fungible transfer, as is if it is printable and in hex otherwise
+func (e *Entry) Recipient() string {
+	if len(e.Payload) < 2 || e.Type == msg.NonFungibleTransfer || e.Type == msg.GenericTransfer {
+		return ""
+	}
+	for _, r := range string(e.Payload[1]) {
+		if r > unicode.MaxASCII || !unicode.IsPrint(r) {
+			return e.Payload[1].String()
+		}
+	}
+	return string(e.Payload[1])
+}
+
+// LoadEntries reads the queue of an approval directory, by source and nonce
+func LoadEntries(dir string) ([]*Entry, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, queueFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var entries []*Entry
+	err = json.Unmarshal(data, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("invalid approval queue: %w", err)
+	}
+	return entries, nil
+}
+
+type Queue struct {
+	cfg     *Config
+	chain   msg.ChainId
+	signer  Signer
+	lock    sync.Mutex
+	entries map[persist.Key]*Entry
+	log     log.Logger
+	metrics *Metrics
+	now     func() time.Time
+}
+
+// NewQueue loads the queue of the approval directory, the decisions are verified with the signer. metrics may be nil.
+func NewQueue(cfg *Config, chain msg.ChainId, signer Signer, log log.Logger, m *Metrics) (*Queue, error) {
+	entries, err := LoadEntries(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	q := &Queue{
+		cfg:     cfg,
+		chain:   chain,
+		signer:  signer,
+		entries: make(map[persist.Key]*Entry),
+		log:     log,
+		metrics: m,
+		now:     time.Now,
+	}
+	for _, e := range entries {
+		q.entries[persist.Key{Source: e.Source, Nonce: e.Nonce}] = e
+	}
+	q.updateMetrics()
+	return q, nil
+}
+
+// Hold returns nil if the transfer of a message doesn't need an approval or is approved. Otherwise it returns
+// ErrPending, the transfer being queued if it wasn't, or ErrRejected.
+func (q *Queue) Hold(m msg.Message, amount *big.Int) error {
+	threshold := q.cfg.threshold(m.ResourceId)
+	if threshold == nil || amount.Cmp(threshold) <= 0 {
+		return nil
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	key := persist.KeyOf(m)
+	if e, ok := q.entries[key]; ok {
+		received, err := newEntry(m, amount, e.Queued)
+		if err != nil {
+			return err
+		}
+		if received.Digest() != e.Digest() {
+			return ErrChanged
+		}
+		switch e.Status {
+		case StatusApproved:
+			return nil
+		case StatusRejected:
+			return ErrRejected
+		default:
+			return ErrPending
+		}
+	}
+
+	e, err := newEntry(m, amount, q.now())
+	if err != nil {
+		return err
+	}
+	q.entries[key] = e
+	err = q.save()
+	if err != nil {
+		return fmt.Errorf("failed to queue the transfer: %w", err)
+	}
+	return ErrPending
+}
+
+// sorted returns the entries by source and nonce, lock must be held
+func (q *Queue) sorted() []*Entry {
+	res := make([]*Entry, 0, len(q.entries))
+	for _, e := range q.entries {
+		res = append(res, e)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Source != res[j].Source {
+			return res[i].Source < res[j].Source
+		}
+		return res[i].Nonce < res[j].Nonce
+	})
+	return res
+}
+
+// save persists the queue, lock must be held
+func (q *Queue) save() error {
+	q.updateMetrics()
+	return persist.WriteJSON(filepath.Join(q.cfg.Dir, queueFile), q.sorted())
+}
+
+// applyDecisions applies the decisions signed for the pending transfers and returns the approved messages. Decisions
+// with an invalid signature are set aside.
+func (q *Queue) applyDecisions() []msg.Message {
+	dir := filepath.Join(q.cfg.Dir, decisionsDir)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			q.log.Error("Failed to read the approval decisions", "dir", dir, "err", err)
+		}
+		return nil
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	var approved []msg.Message
+	changed := false
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(dir, f.Name())
+		d, err := readDecision(path)
+		if err == nil && (d.Chain != q.chain || !q.signer.Verify(d.signedData(), d.Signature)) {
+			err = errors.New("invalid signature")
+		}
+		if err != nil {
+			q.log.Error("Rejecting an approval decision", "file", path, "err", err)
+			_ = os.Rename(path, path+".invalid")
+			continue
+		}
+
+		e, ok := q.entries[persist.Key{Source: d.Source, Nonce: d.Nonce}]
+		if !ok {
+			// The transfer may not be observed yet, keep the decision
+			continue
+		}
+		if d.Digest != e.Digest() {
+			q.log.Error("Rejecting an approval decision made for another transfer", "file", path, "src", e.Source,
+				"nonce", e.Nonce, "digest", d.Digest, "queued", e.Digest())
+			_ = os.Rename(path, path+".invalid")
+			continue
+		}
+		if e.Status == StatusPending {
+			now := q.now()
+			e.Decided = &now
+			e.Status = StatusRejected
+			if d.Approve {
+				e.Status = StatusApproved
+				approved = append(approved, e.Message())
+			}
+			changed = true
+			q.log.Info("Applied an approval decision", "src", e.Source, "nonce", e.Nonce, "status", e.Status)
+		}
+		_ = os.Remove(path)
+	}
+
+	if q.prune() {
+		changed = true
+	}
+	if changed {
+		err = q.save()
+		if err != nil {
+			q.log.Error("Failed to persist the approval queue", "err", err)
+		}
+	}
+	return approved
+}
+
+// prune removes the transfers decided more than DecidedRetention ago and returns whether any was removed, lock must be
+// held
+func (q *Queue) prune() bool {
+	pruned := false
+	for key, e := range q.entries {
+		if e.Decided != nil && q.now().Sub(*e.Decided) > DecidedRetention {
+			delete(q.entries, key)
+			pruned = true
+		}
+	}
+	return pruned
+}
+
+// Run applies the decisions of the operators every PollInterval until stop is closed, and resolves the approved
+// messages. resolve is expected to call Hold again.
+func (q *Queue) Run(resolve func(m msg.Message), stop <-chan int) {
+	persist.Run(PollInterval, q.applyDecisions, resolve, stop)
+}
+
+// updateMetrics exposes the pending transfers, lock must be held
+func (q *Queue) updateMetrics() {
+	if q.metrics == nil {
+		return
+	}
+	pending := 0
+	for _, e := range q.entries {
+		if e.Status == StatusPending {
+			pending++
+		}
+	}
+	q.metrics.Pending.Set(float64(pending))
+}
+
+// Decision is the verdict of an operator on a queued transfer, signed with the relayer key of the chain. It only
+// applies to the transfer of its digest, see Entry.Digest.
+type Decision struct {
+	Chain     msg.ChainId   `json:"chain"`
+	Source    msg.ChainId   `json:"source"`
+	Nonce     msg.Nonce     `json:"nonce"`
+	Digest    string        `json:"digest"`
+	Approve   bool          `json:"approve"`
+	Signature hexutil.Bytes `json:"signature"`
+}
+
+func (d *Decision) signedData() []byte {
+	return []byte(fmt.Sprintf("platdot approval chain=%d source=%d nonce=%d digest=%s approve=%t", d.Chain, d.Source, d.Nonce, d.Digest, d.Approve))
+}
+
+func readDecision(path string) (*Decision, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	d := &Decision{}
+	err = json.Unmarshal(data, d)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Decide signs a decision on a queued transfer and writes it to the approval directory, for the writer to apply it
+func Decide(dir string, chain msg.ChainId, signer Signer, e *Entry, approve bool) error {
+	source, nonce := e.Source, e.Nonce
+	d := &Decision{Chain: chain, Source: source, Nonce: nonce, Digest: e.Digest(), Approve: approve}
+	sig, err := signer.Sign(d.signedData())
+	if err != nil {
+		return err
+	}
+	d.Signature = sig
+	name := strconv.FormatUint(uint64(source), 10) + "-" + strconv.FormatUint(uint64(nonce), 10) + ".json"
+	return persist.WriteJSON(filepath.Join(dir, decisionsDir, name), d)
+}
@@ -0,0 +1,186 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package approvals
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ChainSafe/log15"
+	"github.com/Platdot-network/Platdot/connections/signer"
+	"github.com/Platdot-Network/go-substrate-rpc-client/v3/signature"
+	"github.com/rjman-ljm/platdot-utils/crypto/secp256k1"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+var testResource = msg.ResourceIdFromSlice([]byte{1})
+
+func newTestQueue(t *testing.T, dir string, signer Signer) *Queue {
+	cfg, err := ParseConfig(map[string]string{ThresholdOpt: testResource.Hex() + ":100", DirOpt: dir}, "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := NewQueue(cfg, 2, signer, log15.New(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func newTestSigner(t *testing.T) Signer {
+	kp, err := secp256k1.GenerateKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewSecp256k1Signer(signer.NewSecp256k1(kp))
+}
+
+// queued returns the queued transfer of a nonce
+func queued(t *testing.T, dir string, nonce msg.Nonce) *Entry {
+	entries, err := LoadEntries(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Nonce == nonce {
+			return e
+		}
+	}
+	t.Fatalf("Transfer %d not queued", nonce)
+	return nil
+}
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := ParseConfig(map[string]string{}, "", 1)
+	if err != nil || cfg != nil {
+		t.Fatalf("Expected no config, got %v %v", cfg, err)
+	}
+
+	cfg, err = ParseConfig(map[string]string{ThresholdOpt: "*:5"}, "/tmp/blockstore", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Dir != "/tmp/blockstore/approvals-1" || cfg.threshold(testResource).Int64() != 5 {
+		t.Fatalf("Unexpected config: %+v", cfg)
+	}
+
+	if _, err = ParseConfig(map[string]string{ThresholdOpt: "*:-5"}, "", 1); err == nil {
+		t.Fatal("Expected an error for a negative threshold")
+	}
+}
+
+func TestApproval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "approvals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	signer := newTestSigner(t)
+	q := newTestQueue(t, dir, signer)
+
+	small := msg.NewMultiSigTransfer(1, 2, 1, big.NewInt(100), testResource, []byte("alice"))
+	if err := q.Hold(small, big.NewInt(100)); err != nil {
+		t.Fatal(err)
+	}
+	large := msg.NewMultiSigTransfer(1, 2, 2, big.NewInt(500), testResource, []byte("alice"))
+	if err := q.Hold(large, big.NewInt(500)); err != ErrPending {
+		t.Fatalf("Got: %v Expected: %v", err, ErrPending)
+	}
+	rejected := msg.NewMultiSigTransfer(1, 2, 3, big.NewInt(500), testResource, []byte("bob"))
+	if err := q.Hold(rejected, big.NewInt(500)); err != ErrPending {
+		t.Fatalf("Got: %v Expected: %v", err, ErrPending)
+	}
+
+	// The queue survives a restart
+	q = newTestQueue(t, dir, signer)
+	if err := q.Hold(large, big.NewInt(500)); err != ErrPending {
+		t.Fatalf("Got: %v Expected: %v", err, ErrPending)
+	}
+
+	// Decisions signed by another key are set aside
+	if err := Decide(dir, 2, newTestSigner(t), queued(t, dir, 2), true); err != nil {
+		t.Fatal(err)
+	}
+	if approved := q.applyDecisions(); len(approved) != 0 {
+		t.Fatalf("Got %d approved transfers, expected none", len(approved))
+	}
+	if _, err := os.Stat(filepath.Join(dir, decisionsDir, "1-2.json.invalid")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Decisions made for another transfer with the same nonce are set aside
+	forged := *queued(t, dir, 2)
+	forged.Payload = append(forged.Payload[:1:1], []byte("mallory"))
+	if err := Decide(dir, 2, signer, &forged, true); err != nil {
+		t.Fatal(err)
+	}
+	if approved := q.applyDecisions(); len(approved) != 0 {
+		t.Fatalf("Got %d approved transfers, expected none", len(approved))
+	}
+
+	if err := Decide(dir, 2, signer, queued(t, dir, 2), true); err != nil {
+		t.Fatal(err)
+	}
+	if err := Decide(dir, 2, signer, queued(t, dir, 3), false); err != nil {
+		t.Fatal(err)
+	}
+	approved := q.applyDecisions()
+	if len(approved) != 1 || approved[0].DepositNonce != 2 || string(approved[0].Payload[1].([]byte)) != "alice" {
+		t.Fatalf("Unexpected approved transfers: %+v", approved)
+	}
+	if err := q.Hold(approved[0], big.NewInt(500)); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Hold(rejected, big.NewInt(500)); err != ErrRejected {
+		t.Fatalf("Got: %v Expected: %v", err, ErrRejected)
+	}
+	// The approval doesn't cover another recipient
+	changed := msg.NewMultiSigTransfer(1, 2, 2, big.NewInt(500), testResource, []byte("mallory"))
+	if err := q.Hold(changed, big.NewInt(500)); err != ErrChanged {
+		t.Fatalf("Got: %v Expected: %v", err, ErrChanged)
+	}
+
+	entries, err := LoadEntries(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Status != StatusApproved || entries[1].Status != StatusRejected {
+		t.Fatalf("Unexpected queue: %+v", entries)
+	}
+	if entries[0].Recipient() != "alice" {
+		t.Fatalf("Got recipient %s, expected alice", entries[0].Recipient())
+	}
+
+	// The decided transfers are pruned after the retention
+	now := time.Now()
+	q.now = func() time.Time { return now.Add(DecidedRetention + time.Minute) }
+	q.applyDecisions()
+	if entries, err = LoadEntries(dir); err != nil || len(entries) != 0 {
+		t.Fatalf("Got %v %v, expected the decided transfers pruned", entries, err)
+	}
+}
+
+func TestSr25519Signer(t *testing.T) {
+	signer := NewSr25519Signer(signer.NewSr25519(signature.TestKeyringPairAlice))
+	d := &Decision{Chain: 1, Source: 2, Nonce: 3, Digest: "0x01", Approve: true}
+	sig, err := signer.Sign(d.signedData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !signer.Verify(d.signedData(), sig) {
+		t.Fatal("Expected a valid signature")
+	}
+	d.Approve = false
+	if signer.Verify(d.signedData(), sig) {
+		t.Fatal("Expected the signature of another decision to be invalid")
+	}
+	d.Approve, d.Digest = true, "0x02"
+	if signer.Verify(d.signedData(), sig) {
+		t.Fatal("Expected the signature of a decision on another transfer to be invalid")
+	}
+}
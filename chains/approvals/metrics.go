@@ -0,0 +1,28 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package approvals
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics exposes the transfers awaiting an approval
+type Metrics struct {
+	Pending prometheus.Gauge
+}
+
+func NewMetrics(chain string) *Metrics {
+	metrics := &Metrics{
+		Pending: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: fmt.Sprintf("%s_approvals_pending", chain),
+			Help: "Number of transfers awaiting a manual approval",
+		}),
+	}
+
+	prometheus.MustRegister(metrics.Pending)
+
+	return metrics
+}
@@ -0,0 +1,49 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package approvals
+
+import (
+	"github.com/Platdot-network/Platdot/connections/signer"
+	"github.com/hacpy/go-ethereum/crypto"
+)
+
+// Signer signs the decisions of the operators and verifies them
+type Signer interface {
+	Sign(data []byte) ([]byte, error)
+	Verify(data []byte, sig []byte) bool
+}
+
+type secp256k1Signer struct {
+	s signer.Signer
+}
+
+// NewSecp256k1Signer signs with the key of an ethlike relayer
+func NewSecp256k1Signer(s signer.Signer) Signer {
+	return &secp256k1Signer{s: s}
+}
+
+func (s *secp256k1Signer) Sign(data []byte) ([]byte, error) {
+	return s.s.Sign(crypto.Keccak256(data))
+}
+
+func (s *secp256k1Signer) Verify(data []byte, sig []byte) bool {
+	return signer.Verify(signer.Secp256k1, s.s.PublicKey(), crypto.Keccak256(data), sig)
+}
+
+type sr25519Signer struct {
+	s signer.Signer
+}
+
+// NewSr25519Signer signs with the key of a substrate relayer
+func NewSr25519Signer(s signer.Signer) Signer {
+	return &sr25519Signer{s: s}
+}
+
+func (s *sr25519Signer) Sign(data []byte) ([]byte, error) {
+	return s.s.Sign(data)
+}
+
+func (s *sr25519Signer) Verify(data []byte, sig []byte) bool {
+	return signer.Verify(signer.Sr25519, s.s.PublicKey(), data, sig)
+}
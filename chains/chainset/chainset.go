@@ -15,6 +15,9 @@ const (
 
 	ChainXLike
 	ChainXAssetLike
+
+	// AssetsLike chains carry their tokens in the Assets pallet, like Statemine and Statemint
+	AssetsLike
 )
 
 /// Chain name constants
@@ -26,6 +29,9 @@ const (
 
 	NameKusama				string = "kusama"
 	NamePolkadot			string = "polkadot"
+
+	NameStatemine			string = "statemine"
+	NameStatemint			string = "statemint"
 )
 
 const(
@@ -38,6 +44,8 @@ const(
 
 	TokenXBTC	string = "XBTC"
 	TokenXAsset string = "XASSET"
+
+	TokenUSDT	string = "USDT"
 )
 
 type ChainInfo struct{
@@ -52,5 +60,7 @@ var (
 		{ NamePlaton,			TokenLAT, PlatonLike},
 		{ NameKusama, 		TokenKSM, KusamaLike },
 		{ NamePolkadot,		TokenDOT, PolkadotLike },
+		{ NameStatemine,		TokenKSM, AssetsLike },
+		{ NameStatemint,		TokenDOT, AssetsLike },
 	}
 )
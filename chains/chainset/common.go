@@ -1,6 +1,8 @@
 package chainset
 
 import (
+	"math/big"
+
 	"github.com/Platdot-Network/substrate-go/client"
 	"github.com/Platdot-Network/substrate-go/expand"
 	"github.com/Platdot-Network/substrate-go/expand/chainx/xevents"
@@ -66,11 +68,32 @@ func (bc *ChainCore) MakeCrossChainTansferCall(m msg.Message, meta *types.Metada
 		return bc.MakeXAssetTransferCall(m, meta, assetId)
 	case ChainXAssetV1Like:
 		return bc.MakeXAssetTransferCall(m, meta, assetId)
+	case AssetsLike:
+		if assetId == OriginAsset {
+			return bc.MakeBalanceTransferCall(m, meta, assetId)
+		}
+		return bc.MakeAssetsTransferCall(m, meta, assetId)
 	default:
 		return bc.MakeBalanceTransferCall(m, meta, assetId)
 	}
 }
 
+/// MakeTransferCall makes the call sending amount of the asset to the recipient of the message, no fee deducted
+func (bc *ChainCore) MakeTransferCall(m msg.Message, meta *types.Metadata, assetId xevents.AssetId, amount *big.Int) (types.Call, error) {
+	recipient := bc.GetSubChainRecipient(m)
+	switch bc.ChainInfo.Type {
+	case ChainXAssetLike, ChainXAssetV1Like:
+		return bc.xassetTransferCall(meta, recipient, assetId, amount)
+	case AssetsLike:
+		if assetId == OriginAsset {
+			return bc.balanceTransferCall(meta, recipient, amount)
+		}
+		return bc.assetsTransferCall(meta, recipient, assetId, amount)
+	default:
+		return bc.balanceTransferCall(meta, recipient, amount)
+	}
+}
+
 func (bc *ChainCore) MakeBalanceTransferCall(m msg.Message, meta *types.Metadata, assetId xevents.AssetId) (types.Call, error) {
 	/// Get Recipient
 	recipient := bc.GetSubChainRecipient(m)
@@ -81,8 +104,13 @@ func (bc *ChainCore) MakeBalanceTransferCall(m msg.Message, meta *types.Metadata
 		return types.Call{}, err
 	}
 
+	return bc.balanceTransferCall(meta, recipient, sendAmount)
+}
+
+func (bc *ChainCore) balanceTransferCall(meta *types.Metadata, recipient interface{}, sendAmount *big.Int) (types.Call, error) {
 	/// Get Call
 	var c types.Call
+	var err error
 	if bc.ChainInfo.Type == ChainXV1Like {
 		c, err = types.NewCall(
 			meta,
@@ -116,8 +144,13 @@ func (bc *ChainCore) MakeXAssetTransferCall(m msg.Message, meta *types.Metadata,
 		return types.Call{}, err
 	}
 
+	return bc.xassetTransferCall(meta, recipient, assetId, sendAmount)
+}
+
+func (bc *ChainCore) xassetTransferCall(meta *types.Metadata, recipient interface{}, assetId xevents.AssetId, sendAmount *big.Int) (types.Call, error) {
 	/// Get Call
 	var c types.Call
+	var err error
 	if bc.ChainInfo.Type == ChainXAssetV1Like {
 		c, err = types.NewCall(
 			meta,
@@ -142,3 +175,32 @@ func (bc *ChainCore) MakeXAssetTransferCall(m msg.Message, meta *types.Metadata,
 
 	return c, nil
 }
+
+func (bc *ChainCore) MakeAssetsTransferCall(m msg.Message, meta *types.Metadata, assetId xevents.AssetId) (types.Call, error) {
+	/// GetRecipient
+	recipient := bc.GetSubChainRecipient(m)
+
+	/// GetAmount
+	sendAmount, err := bc.GetAmountToSub(m.Payload[0].([]byte), assetId)
+	if err != nil {
+		return types.Call{}, err
+	}
+
+	return bc.assetsTransferCall(meta, recipient, assetId, sendAmount)
+}
+
+func (bc *ChainCore) assetsTransferCall(meta *types.Metadata, recipient interface{}, assetId xevents.AssetId, sendAmount *big.Int) (types.Call, error) {
+	/// Get Call, the asset id comes first
+	c, err := types.NewCall(
+		meta,
+		string(utils.AssetsTransferKeepAliveMethod),
+		types.NewUCompactFromUInt(uint64(assetId)),
+		recipient,
+		types.NewUCompact(sendAmount),
+	)
+	if err != nil {
+		return types.Call{}, err
+	}
+
+	return c, nil
+}
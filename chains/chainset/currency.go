@@ -18,13 +18,14 @@ const (
 	SinglePCX int64 = 1e8
 )
 
-// The precision-difference constant between Eth-Like and Sub-Like
+// The declared precision of Sub-Like currencies, checked against the chain when it starts
 const (
-	DiffKSM    = 1000000     /// KSM    is 12 digits
-	DiffDOT    = 100000000   /// DOT    is 10 digits
-	DiffXBTC   = 10000000000 /// XBTC   is 8  digits
-	DiffPCX    = 10000000000 /// PCX	   is 8  digits
-	DiffXAsset = 10000000000 /// XAsset is 8  digits
+	DecimalsKSM    uint8 = 12 /// KSM    is 12 digits
+	DecimalsDOT    uint8 = 10 /// DOT    is 10 digits
+	DecimalsXBTC   uint8 = 8  /// XBTC   is 8  digits
+	DecimalsPCX    uint8 = 8  /// PCX	   is 8  digits
+	DecimalsXAsset uint8 = 8  /// XAsset is 8  digits
+	DecimalsUSDT   uint8 = 6  /// USDT   is 6  digits
 )
 
 /// Fixed handling fee for cross-chain transactions
@@ -45,17 +46,18 @@ type Currency struct {
 	AssetId      xevents.AssetId
 	ResourceId   string
 	Name         string
-	Difference   int64
+	Decimals     uint8
 	FixedFee     int64
 	ExtraFeeRate int64
 }
 
 var currencies = []Currency{
-	{OriginAsset, ResourceIdOrigin, TokenKSM, DiffKSM, FixedKSMFee, ExtraFeeRate},
-	{OriginAsset, ResourceIdOrigin, TokenDOT, DiffDOT, FixedDOTFee, ExtraFeeRate},
-	{OriginAsset, ResourceIdOrigin, TokenPCX, DiffPCX, FixedPCXFee, ExtraFeeRate},
-	{AssetXBTC, ResourceIdXBTC, TokenXBTC, DiffXBTC, 0, ExtraNoneFeeRate},
-	{XAssetId, ResourceIdXAsset, TokenXAsset, DiffXAsset, 0, ExtraNoneFeeRate},
+	{OriginAsset, ResourceIdOrigin, TokenKSM, DecimalsKSM, FixedKSMFee, ExtraFeeRate},
+	{OriginAsset, ResourceIdOrigin, TokenDOT, DecimalsDOT, FixedDOTFee, ExtraFeeRate},
+	{OriginAsset, ResourceIdOrigin, TokenPCX, DecimalsPCX, FixedPCXFee, ExtraFeeRate},
+	{AssetXBTC, ResourceIdXBTC, TokenXBTC, DecimalsXBTC, 0, ExtraNoneFeeRate},
+	{XAssetId, ResourceIdXAsset, TokenXAsset, DecimalsXAsset, 0, ExtraNoneFeeRate},
+	{AssetUSDT, ResourceIdUSDT, TokenUSDT, DecimalsUSDT, 0, ExtraNoneFeeRate},
 }
 
 /// AssetId Type
@@ -63,6 +65,9 @@ const (
 	OriginAsset xevents.AssetId = 0
 	AssetXBTC   xevents.AssetId = 1
 	XAssetId    xevents.AssetId = 999
+
+	/// Assets pallet of Statemine/Statemint
+	AssetUSDT xevents.AssetId = 1984
 )
 
 const ResourceIdPrefix = "0000000000000000000000000000000000000000000000000000000000000"
@@ -72,6 +77,7 @@ const (
 	ResourceIdXAsset string = ResourceIdPrefix + "999"
 	ResourceIdAKSM   string = ResourceIdPrefix + "000"
 	ResourceIdPDOT   string = ResourceIdPrefix + "002"
+	ResourceIdUSDT   string = ResourceIdPrefix + "003"
 )
 
 func (bc *ChainCore) GetCurrencyByAssetId(assetId xevents.AssetId) (*Currency, error) {
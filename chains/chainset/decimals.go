@@ -0,0 +1,136 @@
+package chainset
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/hacpy/go-ethereum/common"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+// The Eth-Like precision assumed until the token contract of a resource is discovered
+const EthLikeDecimals uint8 = 18
+
+// The highest precision-difference whose scaling still fits an int64
+const maxDifference = 18
+
+// The decimals discovered on the chains while they are initialized
+var discovered = struct {
+	lock    sync.RWMutex
+	ethLike map[msg.ResourceId]uint8
+	subLike map[string]uint8
+}{
+	ethLike: make(map[msg.ResourceId]uint8),
+	subLike: make(map[string]uint8),
+}
+
+// ResetDiscoveredDecimals forgets the decimals discovered so far, the declared precisions apply until the chains
+// discover them again
+func ResetDiscoveredDecimals() {
+	discovered.lock.Lock()
+	defer discovered.lock.Unlock()
+	discovered.ethLike = make(map[msg.ResourceId]uint8)
+	discovered.subLike = make(map[string]uint8)
+}
+
+// ResourceIds returns the resource ids of all currencies
+func ResourceIds() []msg.ResourceId {
+	var res []msg.ResourceId
+	seen := make(map[msg.ResourceId]bool)
+	for _, currency := range currencies {
+		rId := currency.resourceId()
+		if !seen[rId] {
+			seen[rId] = true
+			res = append(res, rId)
+		}
+	}
+	return res
+}
+
+// SetEthLikeDecimals records the decimals of the token contract of a resource on an Eth-Like chain. It fails if
+// another Eth-Like chain has another token precision, or if the tokens can't be scaled to the discovered Sub-Like
+// currencies of the resource.
+func SetEthLikeDecimals(rId msg.ResourceId, decimals uint8) error {
+	discovered.lock.Lock()
+	defer discovered.lock.Unlock()
+	if known, ok := discovered.ethLike[rId]; ok && known != decimals {
+		return fmt.Errorf("resource %x has %d decimals, but %d on another chain", rId, decimals, known)
+	}
+
+	for _, currency := range currencies {
+		if currency.resourceId() != rId {
+			continue
+		}
+		// Only the currencies of the Sub-Like chains started alongside are checked
+		sub, ok := discovered.subLike[currency.Name]
+		if !ok {
+			continue
+		}
+		if err := checkDifference(currency.Name, decimals, sub); err != nil {
+			return err
+		}
+	}
+
+	discovered.ethLike[rId] = decimals
+	return nil
+}
+
+// SetSubLikeDecimals records the decimals of a Sub-Like currency. It fails if they differ from the declared
+// precision of the currency, or if the discovered Eth-Like tokens of the currency can't be scaled to it.
+func SetSubLikeDecimals(token string, decimals uint8) error {
+	discovered.lock.Lock()
+	defer discovered.lock.Unlock()
+	for _, currency := range currencies {
+		if currency.Name != token {
+			continue
+		}
+		if currency.Decimals != decimals {
+			return fmt.Errorf("%s has %d decimals on chain, but %d are configured", token, decimals, currency.Decimals)
+		}
+		eth, ok := discovered.ethLike[currency.resourceId()]
+		if !ok {
+			continue
+		}
+		if err := checkDifference(token, eth, decimals); err != nil {
+			return err
+		}
+	}
+
+	discovered.subLike[token] = decimals
+	return nil
+}
+
+func checkDifference(token string, eth uint8, sub uint8) error {
+	if eth < sub {
+		return fmt.Errorf("%s has %d decimals on Eth-Like chains, fewer than the %d of Sub-Like chains", token, eth, sub)
+	}
+	if eth-sub > maxDifference {
+		return fmt.Errorf("%s has %d decimals on Eth-Like chains, too many more than the %d of Sub-Like chains", token, eth, sub)
+	}
+	return nil
+}
+
+func (c *Currency) resourceId() msg.ResourceId {
+	return msg.ResourceIdFromSlice(common.FromHex(c.ResourceId))
+}
+
+// Difference returns the scaling between the Eth-Like token and the Sub-Like currency, from their discovered
+// decimals or the declared ones if they weren't discovered
+func (c *Currency) Difference() (*big.Int, error) {
+	discovered.lock.RLock()
+	eth, ok := discovered.ethLike[c.resourceId()]
+	if !ok {
+		eth = EthLikeDecimals
+	}
+	sub, ok := discovered.subLike[c.Name]
+	if !ok {
+		sub = c.Decimals
+	}
+	discovered.lock.RUnlock()
+
+	if err := checkDifference(c.Name, eth, sub); err != nil {
+		return nil, err
+	}
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(eth-sub)), nil), nil
+}
@@ -0,0 +1,53 @@
+package chainset
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/hacpy/go-ethereum/common"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+func TestDifference(t *testing.T) {
+	defer ResetDiscoveredDecimals()
+	ksm := currencies[0]
+
+	diff, err := ksm.Difference()
+	if err != nil || diff.Cmp(big.NewInt(1e6)) != 0 {
+		t.Fatalf("Got: %v %v Expected: the declared difference 1e6", diff, err)
+	}
+
+	// A token with fewer decimals is scaled automatically
+	rId := msg.ResourceIdFromSlice(common.FromHex(ResourceIdOrigin))
+	if err := SetEthLikeDecimals(rId, 15); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetSubLikeDecimals(TokenKSM, 12); err != nil {
+		t.Fatal(err)
+	}
+	diff, err = ksm.Difference()
+	if err != nil || diff.Cmp(big.NewInt(1e3)) != 0 {
+		t.Fatalf("Got: %v %v Expected: 1e3", diff, err)
+	}
+}
+
+func TestInconsistentDecimals(t *testing.T) {
+	defer ResetDiscoveredDecimals()
+	rId := msg.ResourceIdFromSlice(common.FromHex(ResourceIdOrigin))
+
+	if err := SetSubLikeDecimals(TokenKSM, 10); err == nil {
+		t.Fatal("Decimals differing from the declared ones should be refused")
+	}
+	if err := SetSubLikeDecimals(TokenKSM, 12); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetEthLikeDecimals(rId, 6); err == nil {
+		t.Fatal("A token with fewer decimals than the Sub-Like currency should be refused")
+	}
+	if err := SetEthLikeDecimals(rId, 18); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetEthLikeDecimals(rId, 15); err == nil {
+		t.Fatal("Eth-Like chains with different token decimals should be refused")
+	}
+}
@@ -30,7 +30,11 @@ func (bc *ChainCore) GetAmountToSub(origin []byte, assetId xevents.AssetId) (*bi
 	if err != nil {
 		return big.NewInt(0), err
 	}
-	return bc.CalculateAmountToSub(origin, currency.Difference, currency.FixedFee, currency.ExtraFeeRate, currency.Name)
+	difference, err := currency.Difference()
+	if err != nil {
+		return big.NewInt(0), err
+	}
+	return bc.CalculateAmountToSub(origin, difference.Int64(), currency.FixedFee, currency.ExtraFeeRate, currency.Name)
 }
 
 func (bc *ChainCore) GetAmountToEth(origin []byte, assetId xevents.AssetId) (*big.Int, error) {
@@ -38,7 +42,44 @@ func (bc *ChainCore) GetAmountToEth(origin []byte, assetId xevents.AssetId) (*bi
 	if err != nil {
 		return big.NewInt(0), err
 	}
-	return bc.CalculateAmountToEth(origin, currency.Difference, currency.FixedFee, currency.ExtraFeeRate, currency.Name)
+	difference, err := currency.Difference()
+	if err != nil {
+		return big.NewInt(0), err
+	}
+	return bc.CalculateAmountToEth(origin, difference.Int64(), currency.FixedFee, currency.ExtraFeeRate, currency.Name)
+}
+
+/// GetFeeToSub returns the handling fee deducted from a transfer of origin to the substrate chain, in its units
+func (bc *ChainCore) GetFeeToSub(origin []byte, assetId xevents.AssetId) (*big.Int, error) {
+	currency, err := bc.GetCurrencyByAssetId(assetId)
+	if err != nil {
+		return nil, err
+	}
+	difference, err := currency.Difference()
+	if err != nil {
+		return nil, err
+	}
+	receiveAmount := big.NewInt(0).Div(big.NewInt(0).SetBytes(origin), difference)
+	return handlingFee(receiveAmount, currency.FixedFee, currency.ExtraFeeRate), nil
+}
+
+/// GetFeeToEth returns the handling fee deducted from a deposit of origin sent to an ethlike chain, in the units of
+/// the substrate chain
+func (bc *ChainCore) GetFeeToEth(origin []byte, assetId xevents.AssetId) (*big.Int, error) {
+	currency, err := bc.GetCurrencyByAssetId(assetId)
+	if err != nil {
+		return nil, err
+	}
+	return handlingFee(big.NewInt(0).SetBytes(origin), currency.FixedFee, currency.ExtraFeeRate), nil
+}
+
+/// handlingFee returns the fixed fee plus the extra fee rate of amount
+func handlingFee(amount *big.Int, fixedTokenFee int64, extraFeeRate int64) *big.Int {
+	fee := big.NewInt(fixedTokenFee)
+	if extraFeeRate != 0 {
+		fee.Add(fee, big.NewInt(0).Div(amount, big.NewInt(extraFeeRate)))
+	}
+	return fee
 }
 
 func (bc *ChainCore) CalculateAmountToSub(origin []byte, singleToken int64, fixedTokenFee int64, extraFeeRate int64, token string) (*big.Int, error) {
@@ -46,12 +87,7 @@ func (bc *ChainCore) CalculateAmountToSub(origin []byte, singleToken int64, fixe
 	receiveAmount := big.NewInt(0).Div(originAmount, big.NewInt(singleToken))
 
 	/// Calculate fixedFee and extraFee
-	fixedFee := big.NewInt(fixedTokenFee)
-	extraFee := big.NewInt(0)
-	if extraFeeRate != 0 {
-		extraFee.Div(receiveAmount, big.NewInt(extraFeeRate))
-	}
-	fee := big.NewInt(0).Add(fixedFee, extraFee)
+	fee := handlingFee(receiveAmount, fixedTokenFee, extraFeeRate)
 
 	sendAmount := big.NewInt(0).Sub(receiveAmount, fee)
 	if sendAmount.Cmp(big.NewInt(0)) == -1 {
@@ -63,12 +99,7 @@ func (bc *ChainCore) CalculateAmountToSub(origin []byte, singleToken int64, fixe
 func (bc *ChainCore) CalculateAmountToEth(origin []byte, singleToken int64, fixedTokenFee int64, extraFeeRate int64, token string) (*big.Int, error) {
 	originAmount := big.NewInt(0).SetBytes(origin)
 	/// Calculate fixedFee and extraFee
-	fixedFee := big.NewInt(fixedTokenFee)
-	extraFee := big.NewInt(0)
-	if extraFeeRate != 0 {
-		extraFee.Div(originAmount, big.NewInt(extraFeeRate))
-	}
-	fee := big.NewInt(0).Add(fixedFee, extraFee)
+	fee := handlingFee(originAmount, fixedTokenFee, extraFeeRate)
 	actualAmount := big.NewInt(0).Sub(originAmount, fee)
 	if actualAmount.Cmp(big.NewInt(0)) == -1 {
 		return big.NewInt(0), fmt.Errorf("amount is too low to pay the handling fee")
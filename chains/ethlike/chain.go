@@ -24,9 +24,20 @@ import (
 	"github.com/ChainSafe/log15"
 	bridge "github.com/Platdot-network/Platdot/bindings/Bridge"
 	erc20Handler "github.com/Platdot-network/Platdot/bindings/ERC20Handler"
+	erc721Handler "github.com/Platdot-network/Platdot/bindings/ERC721Handler"
+	genericHandler "github.com/Platdot-network/Platdot/bindings/GenericHandler"
+	"github.com/Platdot-network/Platdot/chains"
+	"github.com/Platdot-network/Platdot/chains/approvals"
+	"github.com/Platdot-network/Platdot/chains/lifecycle"
 	"github.com/Platdot-network/Platdot/chains/chainset"
-	"github.com/Platdot-network/Platdot/config"
+	"github.com/Platdot-network/Platdot/chains/limits"
+	"github.com/Platdot-network/Platdot/chains/screening"
+	"github.com/Platdot-network/Platdot/chains/webhooks"
+	utils "github.com/Platdot-network/Platdot/shared/ethlike"
 	connection "github.com/Platdot-network/Platdot/connections/ethlike"
+	"github.com/Platdot-network/Platdot/connections/pool"
+	"github.com/Platdot-network/Platdot/connections/signer"
+	eth "github.com/hacpy/go-ethereum"
 	"github.com/hacpy/go-ethereum/accounts/abi/bind"
 	"github.com/hacpy/go-ethereum/common"
 	"github.com/hacpy/go-ethereum/ethclient"
@@ -41,22 +52,26 @@ import (
 )
 
 var _ core.Chain = &Chain{}
+var _ chains.Replayer = &Chain{}
 
 var _ Connection = &connection.Connection{}
 
 type Connection interface {
 	GetEndPoint() string
 	Connect() error
-	Reconnect(endpoint string) error
-	Keypair() *secp256k1.Keypair
+	Reconnect() error
+	Address() common.Address
 	Opts() *bind.TransactOpts
 	CallOpts() *bind.CallOpts
 	LockAndUpdateOpts() error
 	UnlockOpts()
 	Client() *ethclient.Client
+	Backend() bind.ContractBackend
 	EnsureHasBytecode(address common.Address) error
 	LatestBlock() (*big.Int, error)
 	WaitForBlock(block *big.Int, delay *big.Int) error
+	SubscribeLogs(query eth.FilterQuery, confirmations uint64) *connection.LogSubscription
+	Confirm(quorum int, expected []byte, fetch func(client *ethclient.Client) ([]byte, error)) error
 	Close()
 }
 
@@ -70,8 +85,8 @@ type Chain struct {
 
 // checkBlockstore queries the blockstore for the latest known block. If the latest block is
 // greater than cfg.startBlock, then cfg.startBlock is replaced with the latest known block.
-func setupBlockstore(cfg *Config, kp *secp256k1.Keypair) (*blockstore.Blockstore, error) {
-	bs, err := blockstore.NewBlockstore(cfg.blockstorePath, cfg.id, kp.Address())
+func setupBlockstore(cfg *Config, address common.Address) (*blockstore.Blockstore, error) {
+	bs, err := blockstore.NewBlockstore(cfg.blockstorePath, cfg.id, address.String())
 	if err != nil {
 		return nil, err
 	}
@@ -79,6 +94,61 @@ func setupBlockstore(cfg *Config, kp *secp256k1.Keypair) (*blockstore.Blockstore
 	return bs, nil
 }
 
+// loadKeypair loads the relayer key of a bech32 address from the keystore
+func loadKeypair(from string, keystorePath string, insecure bool) (*secp256k1.Keypair, error) {
+	ethBytes, _ := common.PlatonToEth(from)
+	ethAddress := common.BytesToAddress(ethBytes)
+	pwdCache := keystorePath + "/.cache"
+	kpI, err := keystore.KeypairFromAddress(
+		ethAddress.String(),
+		keystore.EthChain,
+		keystorePath,
+		insecure,
+		pwdCache,
+		ethAddress.String()[:32],
+	)
+	if err != nil {
+		return nil, err
+	}
+	kp, _ := kpI.(*secp256k1.Keypair)
+	return kp, nil
+}
+
+// loadSigner returns the signer of the relayer key, which is held by the signing service if one is configured and
+// loaded from the keystore otherwise
+func loadSigner(from string, keystorePath string, insecure bool, remote *signer.Config) (signer.Signer, error) {
+	if remote != nil {
+		return signer.NewRemote(remote, signer.Secp256k1, from)
+	}
+	kp, err := loadKeypair(from, keystorePath, insecure)
+	if err != nil {
+		return nil, err
+	}
+	return signer.NewSecp256k1(kp), nil
+}
+
+// ApprovalSigner returns the signer of the approval decisions of the chain, with the relayer key
+func ApprovalSigner(chainCfg *core.ChainConfig) (approvals.Signer, error) {
+	remote, err := signer.ParseConfig(chainCfg.Opts)
+	if err != nil {
+		return nil, err
+	}
+	s, err := loadSigner(chainCfg.From, chainCfg.KeystorePath, chainCfg.Insecure, remote)
+	if err != nil {
+		return nil, err
+	}
+	return approvals.NewSecp256k1Signer(s), nil
+}
+
+// LocalKey returns the relayer key of the chain loaded from the keystore, as served by a signing service
+func LocalKey(chainCfg *core.ChainConfig) (signer.Key, error) {
+	kp, err := loadKeypair(chainCfg.From, chainCfg.KeystorePath, chainCfg.Insecure)
+	if err != nil {
+		return signer.Key{}, err
+	}
+	return signer.Key{Address: chainCfg.From, Signer: signer.NewSecp256k1(kp)}, nil
+}
+
 func InitializeChain(chainCfg *core.ChainConfig, logger log15.Logger, sysErr chan<- error, m *metrics.ChainMetrics) (*Chain, error) {
 	// parse config
 	cfg, err := parseChainConfig(chainCfg)
@@ -92,30 +162,23 @@ func InitializeChain(chainCfg *core.ChainConfig, logger log15.Logger, sysErr cha
 	networkId, _ := strconv.ParseUint(cfg.networkId, 0, 64)
 
 	// load key
-	ethBytes, _ := common.PlatonToEth(cfg.from)
-	ethAddress := common.BytesToAddress(ethBytes)
-	pwdCache := cfg.keystorePath + "/.cache"
-	kpI, err := keystore.KeypairFromAddress(
-		ethAddress.String(),
-		keystore.EthChain,
-		cfg.keystorePath,
-		chainCfg.Insecure,
-		pwdCache,
-		ethAddress.String()[:32],
-	)
+	s, err := loadSigner(cfg.from, cfg.keystorePath, chainCfg.Insecure, cfg.signer)
 	if err != nil {
 		return nil, err
 	}
-	kp, _ := kpI.(*secp256k1.Keypair)
 
 	// init block store
-	bs, err := setupBlockstore(cfg, kp)
+	bs, err := setupBlockstore(cfg, signer.EthAddress(s))
 	if err != nil {
 		return nil, err
 	}
 
 	stop := make(chan int)
-	conn := connection.NewConnection(networkId, cfg.endpoint[config.InitialEndPointId], cfg.http, kp, logger, cfg.gasLimit, cfg.maxGasPrice, cfg.gasMultiplier)
+	var pm *pool.Metrics
+	if m != nil {
+		pm = pool.NewMetrics(cfg.name)
+	}
+	conn := connection.NewConnection(networkId, cfg.endpoint, cfg.http, s, logger, cfg.gasLimit, cfg.maxGasPrice, cfg.gasMultiplier, cfg.maxEndpointLag, pm)
 	err = conn.Connect()
 	if err != nil {
 		return nil, err
@@ -125,16 +188,47 @@ func InitializeChain(chainCfg *core.ChainConfig, logger log15.Logger, sysErr cha
 		return nil, err
 	}
 
-	bridgeContract, err := bridge.NewBridge(cfg.bridgeContract, conn.Client())
+	bridgeContract, err := bridge.NewBridge(cfg.bridgeContract, conn.Backend())
+	if err != nil {
+		return nil, err
+	}
+
+	erc20HandlerContract, err := erc20Handler.NewERC20Handler(cfg.erc20HandlerContract, conn.Backend())
 	if err != nil {
 		return nil, err
 	}
 
-	erc20HandlerContract, err := erc20Handler.NewERC20Handler(cfg.erc20HandlerContract, conn.Client())
+	// Refuse to start if the tokens can't be scaled to the Sub-Like currencies
+	err = discoverDecimals(erc20HandlerContract, conn.Backend(), conn.CallOpts(), logger)
 	if err != nil {
 		return nil, err
 	}
 
+	// The erc721 and generic handlers are optional, deposits to an unset handler are not recognized
+	var erc721HandlerContract *erc721Handler.ERC721Handler
+	if cfg.erc721HandlerContract != utils.ZeroAddress {
+		err = conn.EnsureHasBytecode(cfg.erc721HandlerContract)
+		if err != nil {
+			return nil, err
+		}
+		erc721HandlerContract, err = erc721Handler.NewERC721Handler(cfg.erc721HandlerContract, conn.Backend())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var genericHandlerContract *genericHandler.GenericHandler
+	if cfg.genericHandlerContract != utils.ZeroAddress {
+		err = conn.EnsureHasBytecode(cfg.genericHandlerContract)
+		if err != nil {
+			return nil, err
+		}
+		genericHandlerContract, err = genericHandler.NewGenericHandler(cfg.genericHandlerContract, conn.Backend())
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	//if chainCfg.LatestBlock {
 	if cfg.startBlock.Uint64() == 0 {
 		curr, err := conn.LatestBlock()
@@ -148,10 +242,61 @@ func InitializeChain(chainCfg *core.ChainConfig, logger log15.Logger, sysErr cha
 	}
 
 	listener := NewListener(conn, cfg, logger, bs, stop, sysErr, m)
-	listener.setContracts(bridgeContract, erc20HandlerContract)
+	listener.setContracts(bridgeContract, erc20HandlerContract, erc721HandlerContract, genericHandlerContract)
+	listener.deposits = conn.SubscribeLogs(buildQuery(cfg.bridgeContract, utils.Deposit, nil, nil), cfg.blockConfirmations.Uint64())
+	if cfg.confirmationTiers != nil {
+		path, err := deferredPath(cfg.blockstorePath, cfg.id)
+		if err != nil {
+			return nil, err
+		}
+		listener.deferred, err = loadDeferredDeposits(path)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	writer := NewWriter(conn, cfg, logger, *kp, stop, sysErr, m, bc)
+	writer := NewWriter(conn, cfg, logger, stop, sysErr, m, bc)
 	writer.setContract(bridgeContract)
+	var lm *limits.Metrics
+	if m != nil {
+		lm = limits.NewMetrics(cfg.name)
+	}
+	writer.limiter, err = limits.NewLimiter(cfg.limits, logger, lm)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.approvals != nil {
+		var am *approvals.Metrics
+		if m != nil {
+			am = approvals.NewMetrics(cfg.name)
+		}
+		writer.approvals, err = approvals.NewQueue(cfg.approvals, cfg.id, approvals.NewSecp256k1Signer(s), logger, am)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.screening != nil {
+		var sm *screening.Metrics
+		if m != nil {
+			sm = screening.NewMetrics(cfg.name)
+		}
+		writer.screener, err = screening.NewScreener(cfg.screening, logger, sm)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.webhooks != nil {
+		var wm *webhooks.Metrics
+		if m != nil {
+			wm = webhooks.NewMetrics(cfg.name)
+		}
+		writer.notifier = webhooks.NewNotifier(cfg.webhooks, cfg.name, logger, wm)
+		listener.notifier = writer.notifier
+	}
+	/// Shared with the other chains recording to the same directory
+	writer.lifecycle = lifecycle.Open(cfg.lifecycleDir)
+	listener.lifecycle = writer.lifecycle
+	writer.proposals = conn.SubscribeLogs(buildQuery(cfg.bridgeContract, utils.ProposalEvent, nil, nil), cfg.blockConfirmations.Uint64())
 
 	return &Chain{
 		cfg:      chainCfg,
@@ -182,6 +327,16 @@ func (c *Chain) Start() error {
 	return nil
 }
 
+// Replay runs the listener over the blocks from start to end, routing the deposits found to r
+func (c *Chain) Replay(start uint64, end uint64, r chains.Router) error {
+	return c.listener.replay(start, end, r)
+}
+
+// Resolver returns the writer of the chain
+func (c *Chain) Resolver() chains.Resolver {
+	return c.writer
+}
+
 func (c *Chain) Id() msg.ChainId {
 	return c.cfg.Id
 }
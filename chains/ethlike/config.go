@@ -10,6 +10,13 @@ import (
 	"strconv"
 
 	"github.com/hacpy/go-ethereum/common"
+	"github.com/Platdot-network/Platdot/chains/approvals"
+	"github.com/Platdot-network/Platdot/chains/lifecycle"
+	"github.com/Platdot-network/Platdot/chains/limits"
+	"github.com/Platdot-network/Platdot/chains/screening"
+	"github.com/Platdot-network/Platdot/chains/webhooks"
+	"github.com/Platdot-network/Platdot/connections/signer"
+	"github.com/Platdot-network/Platdot/connections/pool"
 	utils "github.com/Platdot-network/Platdot/shared/ethlike"
 	"github.com/rjman-ljm/platdot-utils/core"
 	"github.com/rjman-ljm/platdot-utils/msg"
@@ -39,6 +46,9 @@ var (
 	BlockConfirmationsOpt = "blockConfirmations"
 	PrefixOpt             = "prefix"
 	NetworkIdOpt          = "networkId"
+	MaxEndpointLagOpt     = "maxEndpointLag"
+	DepositQuorumOpt      = "depositQuorum"
+	ConfirmationTiersOpt  = "confirmationTiers"
 )
 
 // Config encapsulates all necessary parameters in ethereum compatible forms
@@ -65,6 +75,15 @@ type Config struct {
 	startBlock             *big.Int
 	endBlock			   *big.Int
 	blockConfirmations     *big.Int
+	maxEndpointLag         uint64             // Blocks an endpoint may lag behind the others before it is avoided
+	depositQuorum          int                // Endpoints which must agree on a deposit before it is routed
+	confirmationTiers      *confirmationTiers // Confirmations of the large deposits, nil if all wait for blockConfirmations
+	limits                 *limits.Config     // Limits of the transfers over a rolling window
+	screening              *screening.Config  // Screening lists of the transfers, nil if not screened
+	approvals              *approvals.Config  // Approval thresholds of the transfers, nil if none needs an approval
+	webhooks               *webhooks.Config   // Endpoints notified of the transfers, nil if none is configured
+	lifecycleDir           string             // Directory recording the state of the transfers
+	signer                 *signer.Config     // Signing service holding the relayer key, nil to use the keystore
 }
 
 // parseChainConfig uses a core.ChainConfig to construct a corresponding Config
@@ -93,6 +112,7 @@ func parseChainConfig(chainCfg *core.ChainConfig) (*Config, error) {
 		startBlock:             big.NewInt(0),
 		endBlock:               big.NewInt(0),
 		blockConfirmations:     big.NewInt(0),
+		maxEndpointLag:         pool.DefaultMaxLag,
 	}
 
 	//fmt.Printf("load config: http is %v\n prefix is %v\nnetworkId is %v\n id is %v\n", config.http, config.prefix, config.networkId, config.id)
@@ -207,6 +227,89 @@ func parseChainConfig(chainCfg *core.ChainConfig) (*Config, error) {
 		delete(chainCfg.Opts, NetworkIdOpt)
 	}
 
+	if maxLag, ok := chainCfg.Opts[MaxEndpointLagOpt]; ok && maxLag != "" {
+		val, err := strconv.ParseUint(maxLag, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse %s", MaxEndpointLagOpt)
+		}
+		config.maxEndpointLag = val
+		delete(chainCfg.Opts, MaxEndpointLagOpt)
+	}
+
+	if quorum, ok := chainCfg.Opts[DepositQuorumOpt]; ok && quorum != "" {
+		val, err := strconv.ParseUint(quorum, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse %s", DepositQuorumOpt)
+		}
+		if int(val) > len(config.endpoint) {
+			return nil, fmt.Errorf("%s %d exceeds the %d configured endpoints", DepositQuorumOpt, val, len(config.endpoint))
+		}
+		config.depositQuorum = int(val)
+		delete(chainCfg.Opts, DepositQuorumOpt)
+	}
+
+	if tiers, ok := chainCfg.Opts[ConfirmationTiersOpt]; ok && tiers != "" {
+		val, err := parseConfirmationTiers(tiers)
+		if err != nil {
+			return nil, err
+		}
+		config.confirmationTiers = val
+		delete(chainCfg.Opts, ConfirmationTiersOpt)
+	}
+
+	limitsCfg, err := limits.ParseConfig(chainCfg.Opts)
+	if err != nil {
+		return nil, err
+	}
+	limitsCfg.Path, err = limits.StatePath(chainCfg.BlockstorePath, chainCfg.Id)
+	if err != nil {
+		return nil, err
+	}
+	config.limits = limitsCfg
+	for _, opt := range []string{limits.WindowOpt, limits.TotalOpt, limits.PerRecipientOpt, limits.SingleOpt} {
+		delete(chainCfg.Opts, opt)
+	}
+
+	approvalsCfg, err := approvals.ParseConfig(chainCfg.Opts, chainCfg.BlockstorePath, chainCfg.Id)
+	if err != nil {
+		return nil, err
+	}
+	config.approvals = approvalsCfg
+	delete(chainCfg.Opts, approvals.ThresholdOpt)
+	delete(chainCfg.Opts, approvals.DirOpt)
+
+	config.screening = screening.ParseConfig(chainCfg.Opts)
+	if config.screening != nil {
+		config.screening.Path, err = screening.StatePath(chainCfg.BlockstorePath, chainCfg.Id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	delete(chainCfg.Opts, screening.BlocklistOpt)
+	delete(chainCfg.Opts, screening.AllowlistOpt)
+
+	webhooksCfg, err := webhooks.ParseConfig(chainCfg.Opts)
+	if err != nil {
+		return nil, err
+	}
+	config.webhooks = webhooksCfg
+	delete(chainCfg.Opts, webhooks.URLsOpt)
+	delete(chainCfg.Opts, webhooks.SecretOpt)
+	delete(chainCfg.Opts, webhooks.RetriesOpt)
+
+	config.lifecycleDir, err = lifecycle.ParseDir(chainCfg.Opts, chainCfg.BlockstorePath)
+	if err != nil {
+		return nil, err
+	}
+	delete(chainCfg.Opts, lifecycle.DirOpt)
+
+	config.signer, err = signer.ParseConfig(chainCfg.Opts)
+	if err != nil {
+		return nil, err
+	}
+	delete(chainCfg.Opts, signer.URLOpt)
+	delete(chainCfg.Opts, signer.TokenFileOpt)
+
 	if len(chainCfg.Opts) != 0 {
 		return nil, fmt.Errorf("unknown Opts Encountered: %#v", chainCfg.Opts)
 	}
@@ -0,0 +1,175 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package ethlike
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/Platdot-network/Platdot/bindings/Bridge"
+	"github.com/Platdot-network/Platdot/bindings/ERC20Handler"
+	"github.com/Platdot-network/Platdot/bindings/ERC721Handler"
+	"github.com/Platdot-network/Platdot/bindings/GenericHandler"
+	"github.com/Platdot-network/Platdot/connections/pool"
+	utils "github.com/Platdot-network/Platdot/shared/ethlike"
+	eth "github.com/hacpy/go-ethereum"
+	"github.com/hacpy/go-ethereum/accounts/abi"
+	"github.com/hacpy/go-ethereum/accounts/abi/bind"
+	ethcommon "github.com/hacpy/go-ethereum/common"
+	ethtypes "github.com/hacpy/go-ethereum/core/types"
+	"github.com/hacpy/go-ethereum/crypto"
+	"github.com/hacpy/go-ethereum/ethclient"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+var bridgeABI, _ = abi.JSON(strings.NewReader(Bridge.BridgeABI))
+
+// All handlers share the signature of getDepositRecord, only the record differs
+var (
+	erc20HandlerABI, _   = abi.JSON(strings.NewReader(ERC20Handler.ERC20HandlerABI))
+	erc721HandlerABI, _  = abi.JSON(strings.NewReader(ERC721Handler.ERC721HandlerABI))
+	genericHandlerABI, _ = abi.JSON(strings.NewReader(GenericHandler.GenericHandlerABI))
+)
+
+// deposit is a deposit log with the handler of its resource and its encoded deposit record. The messages are built
+// from the record as read here, so that the digest of the deposits covers exactly what is routed.
+type deposit struct {
+	log     ethtypes.Log
+	destId  msg.ChainId
+	rId     msg.ResourceId
+	nonce   msg.Nonce
+	handler ethcommon.Address
+	record  []byte
+}
+
+// fetchDeposits reads the handler and the deposit record of every deposit log through caller, at the given block or
+// at the latest block if nil
+func fetchDeposits(caller bind.ContractCaller, bridge ethcommon.Address, logs []ethtypes.Log, block *big.Int) ([]*deposit, error) {
+	ctx := context.Background()
+	deposits := make([]*deposit, 0, len(logs))
+	for _, log := range logs {
+		if len(log.Data) < 96 {
+			return nil, fmt.Errorf("invalid deposit log in tx %s", log.TxHash.Hex())
+		}
+		d := &deposit{
+			log:    log,
+			destId: msg.ChainId(big.NewInt(0).SetBytes(log.Data[:32]).Uint64()),
+			rId:    msg.ResourceIdFromSlice(log.Data[32:64]),
+			nonce:  msg.Nonce(big.NewInt(0).SetBytes(log.Data[64:96]).Uint64()),
+		}
+
+		data, err := bridgeABI.Pack("_resourceIDToHandlerAddress", [32]byte(d.rId))
+		if err != nil {
+			return nil, err
+		}
+		handler, err := caller.CallContract(ctx, eth.CallMsg{To: &bridge, Data: data}, block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get handler from resource ID %x: %w", d.rId, err)
+		}
+		d.handler = ethcommon.BytesToAddress(handler)
+
+		data, err = erc20HandlerABI.Pack("getDepositRecord", uint64(d.nonce), uint8(d.destId))
+		if err != nil {
+			return nil, err
+		}
+		d.record, err = caller.CallContract(ctx, eth.CallMsg{To: &d.handler, Data: data}, block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get deposit record %d: %w", d.nonce, err)
+		}
+		deposits = append(deposits, d)
+	}
+	return deposits, nil
+}
+
+// unpackRecord decodes an encoded deposit record into record, a pointer to the record type of the handler
+func unpackRecord(handlerABI abi.ABI, data []byte, record interface{}) error {
+	out, err := handlerABI.Unpack("getDepositRecord", data)
+	if err != nil {
+		return err
+	}
+	if len(out) == 0 {
+		return errors.New("empty deposit record")
+	}
+	abi.ConvertType(out[0], record)
+	return nil
+}
+
+// depositDigest returns the hash of the deposits of a block, by log index
+func depositDigest(block ethcommon.Hash, deposits []*deposit) []byte {
+	sorted := append([]*deposit(nil), deposits...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].log.Index < sorted[j].log.Index
+	})
+
+	data := block.Bytes()
+	for _, d := range sorted {
+		data = append(data, d.log.TxHash.Bytes()...)
+		data = appendBytes(data, big.NewInt(int64(d.log.Index)).Bytes())
+		data = appendBytes(data, d.log.Data)
+		data = append(data, d.handler.Bytes()...)
+		data = appendBytes(data, d.record)
+	}
+	return crypto.Keccak256(data)
+}
+
+// appendBytes appends b prefixed by its length
+func appendBytes(data []byte, b []byte) []byte {
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], uint64(len(b)))
+	return append(append(data, size[:]...), b...)
+}
+
+// confirmDeposits checks the deposits of a block on the other endpoints before they are routed. The digest of the
+// deposits as routed, their logs, handlers and records, must be returned by depositQuorum endpoints for the canonical
+// block at their height. With a depositQuorum of 0 or 1, the deposits are trusted as returned by the endpoint
+// connected to.
+func (l *listener) confirmDeposits(deposits []*deposit) error {
+	if l.cfg.depositQuorum <= 1 || len(deposits) == 0 {
+		return nil
+	}
+
+	log := deposits[0].log
+	expected := depositDigest(log.BlockHash, deposits)
+	err := l.conn.Confirm(l.cfg.depositQuorum, expected, depositFetcher(l.cfg.bridgeContract, new(big.Int).SetUint64(log.BlockNumber)))
+	var disagreement *pool.DisagreementError
+	if errors.As(err, &disagreement) {
+		l.log.Error("Endpoints disagree on the deposits, refusing them", "block", log.BlockNumber, "hash", log.BlockHash.Hex(),
+			"deposits", len(deposits), "source", disagreement.Source, "disagreeing", disagreement.Endpoint)
+	} else if err != nil {
+		l.log.Error("Deposits not confirmed by enough endpoints, refusing them", "block", log.BlockNumber,
+			"deposits", len(deposits), "quorum", l.cfg.depositQuorum, "err", err)
+	}
+	return err
+}
+
+// depositFetcher returns the digest of the deposits of the canonical block at a height, as known by an endpoint
+func depositFetcher(bridge ethcommon.Address, block *big.Int) func(client *ethclient.Client) ([]byte, error) {
+	return func(client *ethclient.Client) ([]byte, error) {
+		header, err := client.HeaderByNumber(context.Background(), block)
+		if err != nil {
+			return nil, err
+		}
+		hash := header.Hash()
+		query := eth.FilterQuery{
+			BlockHash: &hash,
+			Addresses: []ethcommon.Address{bridge},
+			Topics:    [][]ethcommon.Hash{{utils.Deposit.GetTopic()}},
+		}
+		logs, err := client.FilterLogs(context.Background(), query)
+		if err != nil {
+			return nil, err
+		}
+
+		deposits, err := fetchDeposits(client, bridge, logs, block)
+		if err != nil {
+			return nil, err
+		}
+		return depositDigest(hash, deposits), nil
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package ethlike
+
+import (
+	"fmt"
+
+	"github.com/ChainSafe/log15"
+	"github.com/Platdot-network/Platdot/bindings/ERC20"
+	"github.com/Platdot-network/Platdot/bindings/ERC20Handler"
+	"github.com/Platdot-network/Platdot/chains/chainset"
+	utils "github.com/Platdot-network/Platdot/shared/ethlike"
+	"github.com/hacpy/go-ethereum/accounts/abi/bind"
+)
+
+// discoverDecimals resolves the token contract of every currency through the erc20 handler and records its
+// decimals, the scaling to the Sub-Like currencies is computed from them. Resources without token contract are
+// not bridged by the chain and skipped.
+func discoverDecimals(handler *ERC20Handler.ERC20Handler, backend bind.ContractBackend, opts *bind.CallOpts, log log15.Logger) error {
+	for _, rId := range chainset.ResourceIds() {
+		token, err := handler.ResourceIDToTokenContractAddress(opts, rId)
+		if err != nil {
+			return fmt.Errorf("failed to get the token contract of resource %x: %w", rId, err)
+		}
+		if token == utils.ZeroAddress {
+			continue
+		}
+
+		erc20, err := ERC20.NewERC20(token, backend)
+		if err != nil {
+			return err
+		}
+		decimals, err := erc20.Decimals(opts)
+		if err != nil {
+			return fmt.Errorf("failed to get the decimals of token %s: %w", token.Hex(), err)
+		}
+		err = chainset.SetEthLikeDecimals(rId, decimals)
+		if err != nil {
+			return err
+		}
+		log.Info("Discovered token decimals", "resource", rId.Hex(), "token", token.Hex(), "decimals", decimals)
+	}
+	return nil
+}
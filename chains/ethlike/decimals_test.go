@@ -0,0 +1,44 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package ethlike
+
+import (
+	"testing"
+
+	"github.com/Platdot-network/Platdot/bindings/ERC20PresetMinterPauser"
+	"github.com/Platdot-network/Platdot/chains/chainset"
+	"github.com/hacpy/go-ethereum/accounts/abi/bind"
+	"github.com/hacpy/go-ethereum/common"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+func TestDiscoverDecimals(t *testing.T) {
+	defer chainset.ResetDiscoveredDecimals()
+	c := newSimChain(t, false, false)
+	rId := msg.ResourceIdFromSlice(common.FromHex(chainset.ResourceIdOrigin))
+
+	// Resources without token contract are skipped
+	err := discoverDecimals(c.listener.erc20HandlerContract, c.backend, &bind.CallOpts{}, TestLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenAddr, _, _, err := ERC20PresetMinterPauser.DeployERC20PresetMinterPauser(c.opts, c.backend, "", "")
+	c.commit(err)
+	_, err = c.bridge.AdminSetResource(c.opts, c.cfg.erc20HandlerContract, rId, tokenAddr)
+	c.commit(err)
+
+	err = discoverDecimals(c.listener.erc20HandlerContract, c.backend, &bind.CallOpts{}, TestLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	currency, err := chainset.NewChainCore(chainset.NameKusama).GetCurrencyByResourceId(rId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff, err := currency.Difference()
+	if err != nil || diff.Int64() != 1e6 {
+		t.Fatalf("Got: %v %v Expected: the difference between 18 and 12 decimals", diff, err)
+	}
+}
@@ -4,52 +4,58 @@
 package ethlike
 
 import (
-	"github.com/hacpy/go-ethereum/accounts/abi/bind"
+	"github.com/Platdot-network/Platdot/bindings/ERC20Handler"
+	"github.com/Platdot-network/Platdot/bindings/ERC721Handler"
+	"github.com/Platdot-network/Platdot/bindings/GenericHandler"
+	"github.com/Platdot-network/Platdot/chains/screening"
 	"github.com/rjman-ljm/platdot-utils/msg"
 )
 
-func (l *listener) handleMultiSigDepositedEvent(destId msg.ChainId, nonce msg.Nonce) (msg.Message, error) {
-	l.log.Info("Handling MultiSig deposit event", "dest", destId, "nonce", nonce)
+func (l *listener) handleMultiSigDepositedEvent(d *deposit) (msg.Message, error) {
+	l.log.Info("Handling MultiSig deposit event", "dest", d.destId, "nonce", d.nonce)
 
-	record, err := l.erc20HandlerContract.GetDepositRecord(&bind.CallOpts{From: l.conn.Keypair().CommonAddress()}, uint64(nonce), uint8(destId))
+	var record ERC20Handler.ERC20HandlerDepositRecord
+	err := unpackRecord(erc20HandlerABI, d.record, &record)
 	if err != nil {
 		l.log.Error("Error Unpacking MultiSig Deposit Record", "err", err)
 		return msg.Message{}, err
 	}
 
-	return msg.NewMultiSigTransfer(
+	return screening.WithDepositor(msg.NewMultiSigTransfer(
 		l.cfg.id,
-		destId,
-		nonce,
+		d.destId,
+		d.nonce,
 		record.Amount,
 		record.ResourceID,
 		record.DestinationRecipientAddress,
-	), nil
+	), record.Depositer.Bytes()), nil
 }
 
-func (l *listener) handleErc20DepositedEvent(destId msg.ChainId, nonce msg.Nonce) (msg.Message, error) {
-	l.log.Info("Handling fungible deposit event", "dest", destId, "nonce", nonce)
+func (l *listener) handleErc20DepositedEvent(d *deposit) (msg.Message, error) {
+	l.log.Info("Handling fungible deposit event", "dest", d.destId, "nonce", d.nonce)
 
-	record, err := l.erc20HandlerContract.GetDepositRecord(&bind.CallOpts{From: l.conn.Keypair().CommonAddress()}, uint64(nonce), uint8(destId))
+	var record ERC20Handler.ERC20HandlerDepositRecord
+	err := unpackRecord(erc20HandlerABI, d.record, &record)
 	if err != nil {
 		l.log.Error("Error Unpacking ERC20 Deposit Record", "err", err)
 		return msg.Message{}, err
 	}
 
-	return msg.NewFungibleTransfer(
+	return screening.WithDepositor(msg.NewFungibleTransfer(
 		l.cfg.id,
-		destId,
-		nonce,
+		d.destId,
+		d.nonce,
 		record.Amount,
 		record.ResourceID,
 		record.DestinationRecipientAddress,
-	), nil
+	), record.Depositer.Bytes()), nil
 }
 
-func (l *listener) handleErc721DepositedEvent(destId msg.ChainId, nonce msg.Nonce) (msg.Message, error) {
-	l.log.Info("Handling nonfungible deposit event")
+func (l *listener) handleErc721DepositedEvent(d *deposit) (msg.Message, error) {
+	l.log.Info("Handling nonfungible deposit event", "dest", d.destId, "nonce", d.nonce)
 
-	record, err := l.erc721HandlerContract.GetDepositRecord(&bind.CallOpts{From: l.conn.Keypair().CommonAddress()}, uint64(nonce), uint8(destId))
+	var record ERC721Handler.ERC721HandlerDepositRecord
+	err := unpackRecord(erc721HandlerABI, d.record, &record)
 	if err != nil {
 		l.log.Error("Error Unpacking ERC721 Deposit Record", "err", err)
 		return msg.Message{}, err
@@ -57,8 +63,8 @@ func (l *listener) handleErc721DepositedEvent(destId msg.ChainId, nonce msg.Nonc
 
 	return msg.NewNonFungibleTransfer(
 		l.cfg.id,
-		destId,
-		nonce,
+		d.destId,
+		d.nonce,
 		record.ResourceID,
 		record.TokenID,
 		record.DestinationRecipientAddress,
@@ -66,19 +72,20 @@ func (l *listener) handleErc721DepositedEvent(destId msg.ChainId, nonce msg.Nonc
 	), nil
 }
 
-func (l *listener) handleGenericDepositedEvent(destId msg.ChainId, nonce msg.Nonce) (msg.Message, error) {
-	l.log.Info("Handling generic deposit event")
+func (l *listener) handleGenericDepositedEvent(d *deposit) (msg.Message, error) {
+	l.log.Info("Handling generic deposit event", "dest", d.destId, "nonce", d.nonce)
 
-	record, err := l.genericHandlerContract.GetDepositRecord(&bind.CallOpts{From: l.conn.Keypair().CommonAddress()}, uint64(nonce), uint8(destId))
+	var record GenericHandler.GenericHandlerDepositRecord
+	err := unpackRecord(genericHandlerABI, d.record, &record)
 	if err != nil {
 		l.log.Error("Error Unpacking Generic Deposit Record", "err", err)
-		return msg.Message{}, nil
+		return msg.Message{}, err
 	}
 
 	return msg.NewGenericTransfer(
 		l.cfg.id,
-		destId,
-		nonce,
+		d.destId,
+		d.nonce,
 		record.ResourceID,
 		record.MetaData[:],
 	), nil
@@ -0,0 +1,281 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package ethlike
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/Platdot-network/Platdot/bindings/Bridge"
+	"github.com/Platdot-network/Platdot/bindings/CentrifugeAsset"
+	"github.com/Platdot-network/Platdot/bindings/ERC20Handler"
+	"github.com/Platdot-network/Platdot/bindings/ERC721Handler"
+	"github.com/Platdot-network/Platdot/bindings/ERC721MinterBurnerPauser"
+	"github.com/Platdot-network/Platdot/bindings/GenericHandler"
+	utils "github.com/Platdot-network/Platdot/shared/ethlike"
+	"github.com/hacpy/go-ethereum/accounts/abi/bind"
+	"github.com/hacpy/go-ethereum/accounts/abi/bind/backends"
+	"github.com/hacpy/go-ethereum/common"
+	"github.com/hacpy/go-ethereum/core"
+	ethtypes "github.com/hacpy/go-ethereum/core/types"
+	"github.com/rjman-ljm/platdot-utils/crypto/secp256k1"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+var erc721ResourceId = msg.ResourceIdFromSlice(common.LeftPadBytes([]byte{0x72}, 32))
+var genericResourceId = msg.ResourceIdFromSlice(common.LeftPadBytes([]byte{0x6e}, 32))
+
+// simConnection is a connection only providing the relayer address and the simulated backend the contracts are
+// bound to
+type simConnection struct {
+	Connection
+	kp      *secp256k1.Keypair
+	backend *backends.SimulatedBackend
+}
+
+func (c *simConnection) Address() common.Address {
+	return c.kp.CommonAddress()
+}
+
+func (c *simConnection) Backend() bind.ContractBackend {
+	return c.backend
+}
+
+// simChain is a bridge with all handlers deployed on a simulated backend, Alice is the only relayer
+type simChain struct {
+	t        *testing.T
+	backend  *backends.SimulatedBackend
+	opts     *bind.TransactOpts
+	bridge   *Bridge.Bridge
+	erc721   *ERC721MinterBurnerPauser.ERC721MinterBurnerPauser
+	asset    *CentrifugeAsset.CentrifugeAsset
+	cfg      *Config
+	listener *listener
+	router   *MockRouter
+}
+
+func newSimChain(t *testing.T, withErc721 bool, withGeneric bool) *simChain {
+	opts, err := bind.NewKeyedTransactorWithChainID(AliceKp.PrivateKey(), big.NewInt(1337))
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := backends.NewSimulatedBackend(core.GenesisAlloc{
+		AliceKp.CommonAddress(): {Balance: new(big.Int).Exp(big.NewInt(10), big.NewInt(20), nil)},
+	}, 30000000)
+	c := &simChain{t: t, backend: backend, opts: opts}
+
+	bridgeAddr, _, bridgeContract, err := Bridge.DeployBridge(opts, backend, uint8(TestChainId), []common.Address{AliceKp.CommonAddress()}, TestRelayerThreshold, big.NewInt(0), big.NewInt(100))
+	c.commit(err)
+	c.bridge = bridgeContract
+	erc20HandlerAddr, _, erc20HandlerContract, err := ERC20Handler.DeployERC20Handler(opts, backend, bridgeAddr, [][32]byte{}, []common.Address{}, []common.Address{})
+	c.commit(err)
+	erc721HandlerAddr, _, _, err := ERC721Handler.DeployERC721Handler(opts, backend, bridgeAddr, [][32]byte{}, []common.Address{}, []common.Address{})
+	c.commit(err)
+	genericHandlerAddr, _, _, err := GenericHandler.DeployGenericHandler(opts, backend, bridgeAddr, [][32]byte{}, []common.Address{}, [][4]byte{}, []*big.Int{}, [][4]byte{})
+	c.commit(err)
+
+	erc721Addr, _, erc721Contract, err := ERC721MinterBurnerPauser.DeployERC721MinterBurnerPauser(opts, backend, "", "", "")
+	c.commit(err)
+	c.erc721 = erc721Contract
+	assetAddr, _, assetContract, err := CentrifugeAsset.DeployCentrifugeAsset(opts, backend)
+	c.commit(err)
+	c.asset = assetContract
+
+	_, err = bridgeContract.AdminSetResource(opts, erc721HandlerAddr, erc721ResourceId, erc721Addr)
+	c.commit(err)
+	_, err = bridgeContract.AdminSetGenericResource(opts, genericHandlerAddr, genericResourceId, assetAddr, [4]byte{}, big.NewInt(0), utils.StoreFunctionSig)
+	c.commit(err)
+
+	c.cfg = createConfig("alice", nil, nil)
+	c.cfg.bridgeContract = bridgeAddr
+	c.cfg.erc20HandlerContract = erc20HandlerAddr
+	c.cfg.erc721HandlerContract = erc721HandlerAddr
+	c.cfg.genericHandlerContract = genericHandlerAddr
+
+	var erc721HandlerContract *ERC721Handler.ERC721Handler
+	if withErc721 {
+		erc721HandlerContract, err = ERC721Handler.NewERC721Handler(erc721HandlerAddr, backend)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var genericHandlerContract *GenericHandler.GenericHandler
+	if withGeneric {
+		genericHandlerContract, err = GenericHandler.NewGenericHandler(genericHandlerAddr, backend)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.router = &MockRouter{msgs: make(chan msg.Message, 1)}
+	c.listener = NewListener(&simConnection{kp: AliceKp, backend: backend}, c.cfg, TestLogger, nil, nil, nil, nil)
+	c.listener.setContracts(bridgeContract, erc20HandlerContract, erc721HandlerContract, genericHandlerContract)
+	c.listener.setRouter(c.router)
+	return c
+}
+
+// commit mines the pending transactions
+func (c *simChain) commit(err error) {
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.backend.Commit()
+}
+
+// deposit makes a deposit and returns the deposit logs of the bridge
+func (c *simChain) deposit(rId msg.ResourceId, data []byte) []ethtypes.Log {
+	_, err := c.bridge.Deposit(c.opts, uint8(TestChainId), rId, data)
+	c.commit(err)
+
+	logs, err := c.backend.FilterLogs(context.Background(), buildQuery(c.cfg.bridgeContract, utils.Deposit, nil, nil))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return logs
+}
+
+// execute votes and executes the proposal of a message, as the writer of the destination chain would
+func (c *simChain) execute(m msg.Message, handler common.Address, data []byte) {
+	dataHash := utils.Hash(append(handler.Bytes(), data...))
+	_, err := c.bridge.VoteProposal(c.opts, uint8(m.Source), uint64(m.DepositNonce), m.ResourceId, dataHash)
+	c.commit(err)
+	_, err = c.bridge.ExecuteProposal(c.opts, uint8(m.Source), uint64(m.DepositNonce), data, m.ResourceId)
+	c.commit(err)
+}
+
+func TestErc721DepositFlow(t *testing.T) {
+	c := newSimChain(t, true, true)
+	tokenId := big.NewInt(42)
+
+	_, err := c.erc721.Mint(c.opts, AliceKp.CommonAddress(), tokenId, "metadata")
+	c.commit(err)
+	_, err = c.erc721.Approve(c.opts, c.cfg.erc721HandlerContract, tokenId)
+	c.commit(err)
+
+	logs := c.deposit(erc721ResourceId, utils.ConstructErc721DepositData(tokenId, BobKp.CommonAddress().Bytes()))
+	err = c.listener.handleDeposits(logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := <-c.router.msgs
+	if m.Type != msg.NonFungibleTransfer || m.ResourceId != erc721ResourceId || m.DepositNonce != 1 {
+		t.Fatalf("Unexpected message: %+v", m)
+	}
+	if new(big.Int).SetBytes(m.Payload[0].([]byte)).Cmp(tokenId) != 0 {
+		t.Fatalf("Got token: %x Expected: %s", m.Payload[0], tokenId)
+	}
+
+	// The token is locked in the handler, executing the proposal releases it to the recipient
+	c.execute(m, c.cfg.erc721HandlerContract, ConstructErc721ProposalData(m.Payload[0].([]byte), m.Payload[1].([]byte), m.Payload[2].([]byte)))
+	owner, err := c.erc721.OwnerOf(&bind.CallOpts{}, tokenId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner != BobKp.CommonAddress() {
+		t.Fatalf("Got owner: %s Expected: %s", owner.Hex(), BobKp.CommonAddress().Hex())
+	}
+}
+
+func TestGenericDepositFlow(t *testing.T) {
+	c := newSimChain(t, true, true)
+	hash := utils.Hash([]byte("asset"))
+
+	logs := c.deposit(genericResourceId, utils.ConstructGenericDepositData(hash[:]))
+	err := c.listener.handleDeposits(logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := <-c.router.msgs
+	if m.Type != msg.GenericTransfer || m.ResourceId != genericResourceId || m.DepositNonce != 1 {
+		t.Fatalf("Unexpected message: %+v", m)
+	}
+
+	c.execute(m, c.cfg.genericHandlerContract, ConstructGenericProposalData(m.Payload[0].([]byte)))
+	stored, err := c.asset.AssetsStored(&bind.CallOpts{}, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored {
+		t.Fatal("The asset should be stored by the generic proposal")
+	}
+}
+
+func TestDepositToUnsetHandler(t *testing.T) {
+	c := newSimChain(t, true, false)
+	hash := utils.Hash([]byte("asset"))
+
+	// The deposit is not recognized without the generic handler, instead of panicking
+	logs := c.deposit(genericResourceId, utils.ConstructGenericDepositData(hash[:]))
+	err := c.listener.handleDeposits(logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-c.router.msgs:
+		t.Fatalf("Unexpected message: %+v", m)
+	default:
+	}
+}
+
+func TestDepositRoutedOnce(t *testing.T) {
+	c := newSimChain(t, true, true)
+	hash := utils.Hash([]byte("asset"))
+
+	logs := c.deposit(genericResourceId, utils.ConstructGenericDepositData(hash[:]))
+	err := c.listener.handleDeposits(logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-c.router.msgs
+
+	// The block processed again after a failure doesn't route its deposits twice
+	err = c.listener.handleDeposits(logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-c.router.msgs:
+		t.Fatalf("Unexpected message: %+v", m)
+	default:
+	}
+}
+
+func TestDepositDigest(t *testing.T) {
+	c := newSimChain(t, true, true)
+	hash := utils.Hash([]byte("asset"))
+	logs := c.deposit(genericResourceId, utils.ConstructGenericDepositData(hash[:]))
+	block := logs[0].BlockHash
+
+	deposits, err := fetchDeposits(c.backend, c.cfg.bridgeContract, logs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deposits) != 1 || deposits[0].handler != c.cfg.genericHandlerContract || deposits[0].nonce != 1 {
+		t.Fatalf("Unexpected deposits: %+v", deposits)
+	}
+	digest := depositDigest(block, deposits)
+
+	// The records are the same at the block of the deposit
+	atBlock, err := fetchDeposits(c.backend, c.cfg.bridgeContract, logs, new(big.Int).SetUint64(logs[0].BlockNumber))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(depositDigest(block, atBlock), digest) {
+		t.Fatal("Expected the same digest at the block of the deposit")
+	}
+
+	forged := *deposits[0]
+	forged.record = append([]byte(nil), forged.record...)
+	forged.record[len(forged.record)-1] ^= 1
+	if bytes.Equal(depositDigest(block, []*deposit{&forged}), digest) {
+		t.Fatal("Expected another digest for a forged record")
+	}
+	if bytes.Equal(depositDigest(common.Hash{1}, deposits), digest) {
+		t.Fatal("Expected another digest for another block")
+	}
+}
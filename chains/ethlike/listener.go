@@ -17,10 +17,14 @@ import (
 	"github.com/Platdot-network/Platdot/bindings/ERC721Handler"
 	"github.com/Platdot-network/Platdot/bindings/GenericHandler"
 	"github.com/Platdot-network/Platdot/chains"
+	"github.com/Platdot-network/Platdot/chains/lifecycle"
+	"github.com/Platdot-network/Platdot/chains/webhooks"
+	connection "github.com/Platdot-network/Platdot/connections/ethlike"
+	"github.com/Platdot-network/Platdot/connections/pool"
 	utils "github.com/Platdot-network/Platdot/shared/ethlike"
 	eth "github.com/hacpy/go-ethereum"
-	"github.com/hacpy/go-ethereum/accounts/abi/bind"
 	ethcommon "github.com/hacpy/go-ethereum/common"
+	ethtypes "github.com/hacpy/go-ethereum/core/types"
 	"github.com/rjman-ljm/platdot-utils/blockstore"
 	metrics "github.com/rjman-ljm/platdot-utils/metrics/types"
 	"github.com/rjman-ljm/platdot-utils/msg"
@@ -46,6 +50,13 @@ type listener struct {
 	latestBlock            metrics.LatestBlock
 	metrics                *metrics.ChainMetrics
 	blockConfirmations     *big.Int
+	deposits               *connection.LogSubscription // Deposit logs, when subscribed over websocket
+	tiers                  *confirmationTiers          // Confirmations of the large deposits, nil if none is deferred
+	deferred               *deferredDeposits           // Large deposits awaiting their confirmations
+	notifier               *webhooks.Notifier          // Notifies the webhooks of the deposits, if set
+	lifecycle              *lifecycle.Store            // Records the deposits as observed, if set
+	routedBlock            ethcommon.Hash              // Block of the deposits in routed
+	routed                 map[uint]bool               // Log indices of the deposits of routedBlock already routed
 }
 
 // NewListener creates and returns a listener
@@ -60,12 +71,16 @@ func NewListener(conn Connection, cfg *Config, log log15.Logger, bs blockstore.B
 		latestBlock:        metrics.LatestBlock{LastUpdated: time.Now()},
 		metrics:            m,
 		blockConfirmations: cfg.blockConfirmations,
+		tiers:              cfg.confirmationTiers,
 	}
 }
 
-func (l *listener) setContracts(bridge *Bridge.Bridge, erc20Handler *ERC20Handler.ERC20Handler) {
+// setContracts sets the bound contracts, the erc721 and generic handlers are nil when not configured
+func (l *listener) setContracts(bridge *Bridge.Bridge, erc20Handler *ERC20Handler.ERC20Handler, erc721Handler *ERC721Handler.ERC721Handler, genericHandler *GenericHandler.GenericHandler) {
 	l.bridgeContract = bridge
 	l.erc20HandlerContract = erc20Handler
+	l.erc721HandlerContract = erc721Handler
+	l.genericHandlerContract = genericHandler
 }
 
 // sets the router
@@ -73,6 +88,20 @@ func (l *listener) setRouter(r chains.Router) {
 	l.router = r
 }
 
+// replay polls the blocks from start to end and routes their deposits to r, without writing to the blockstore
+func (l *listener) replay(start uint64, end uint64, r chains.Router) error {
+	l.router = r
+	l.blockstore = &blockstore.EmptyStore{}
+	/// Route every deposit of the replayed blocks, they are confirmed already
+	l.tiers = nil
+	l.deferred = nil
+	l.notifier = nil
+	l.lifecycle = nil
+	l.cfg.startBlock = big.NewInt(0).SetUint64(start)
+	l.cfg.endBlock = big.NewInt(0).SetUint64(end)
+	return l.pollBlocks()
+}
+
 // start registers all subscriptions provided by the config
 func (l *listener) start() error {
 	l.log.Debug("Starting listener...")
@@ -93,32 +122,15 @@ func (l *listener) connect() {
 	}
 }
 
-func (l *listener) getAnotherEndPoint() string {
-	curEndPoint := l.conn.GetEndPoint()
-	limit := len(l.cfg.endpoint)-1
-	if limit < 0 {
-		l.log.Error("cfg `EndPoint set Error`, can't switch endpoint")
-	}
-
-	for i, url := range l.cfg.endpoint {
-		if url == curEndPoint && i < limit {
-			return l.cfg.endpoint[i+1]
-		}
-	}
-	return l.cfg.endpoint[0]
-}
-
 func (l *listener) reconnect() {
 	ClientRetryLimit := BlockRetryLimit
 	for {
 		l.log.Info("Reconnect", "Time", ClientRetryLimit)
 		if ClientRetryLimit == 0 {
-			curEndPoint := l.getAnotherEndPoint()
-			l.log.Info("Connecting to another endpoint", "EndPoint", curEndPoint)
-
-			err := l.conn.Reconnect(curEndPoint)
+			err := l.conn.Reconnect()
+			l.log.Info("Connecting to another endpoint", "EndPoint", l.conn.GetEndPoint())
 			if err != nil {
-				l.log.Error("Reconnect Error", "EndPoint", curEndPoint)
+				l.log.Error("Reconnect Error", "EndPoint", l.conn.GetEndPoint(), "err", err)
 			}
 
 			ClientRetryLimit = BlockRetryLimit
@@ -140,7 +152,8 @@ func (l *listener) reconnect() {
 
 // pollBlocks will poll for the latest block and proceed to parse the associated events as it sees new blocks.
 // Polling begins at the block defined in `l.cfg.startBlock`. Failed attempts to fetch the latest block or parse
-// a block will be retried up to BlockRetryLimit times before continuing to the next block.
+// a block will be retried up to BlockRetryLimit times before continuing to the next block. A block whose deposits are
+// not confirmed by enough endpoints is retried with a backoff until they are, see confirmDeposits.
 func (l *listener) pollBlocks() error {
 	l.log.Info("Polling Blocks...", "ChainId", l.cfg.id, "Chain", l.cfg.name)
 	var currentBlock = l.cfg.startBlock
@@ -148,6 +161,7 @@ func (l *listener) pollBlocks() error {
 	//fmt.Printf("endBlock is %v\n", endBlock.Uint64())
 
 	var retry = BlockRetryLimit
+	var backoff time.Duration // Delay before confirming the deposits of the current block again
 
 	for {
 		select {
@@ -172,10 +186,15 @@ func (l *listener) pollBlocks() error {
 				l.metrics.LatestKnownBlock.Set(float64(latestBlock.Int64()))
 			}
 
-			// Sleep if the difference is less than BlockDelay; (latest - current) < BlockDelay
+			// Wait if the difference is less than BlockDelay; (latest - current) < BlockDelay
 			if big.NewInt(0).Sub(latestBlock, currentBlock).Cmp(l.blockConfirmations) == -1 {
 				l.log.Debug("Block not ready, will retry", "target", currentBlock, "latest", latestBlock)
-				time.Sleep(BlockRetryInterval)
+				err = l.conn.WaitForBlock(currentBlock, l.blockConfirmations)
+				if err != nil {
+					l.log.Error("Waiting for block failed", "block", currentBlock, "err", err)
+					retry--
+					time.Sleep(BlockRetryInterval)
+				}
 				continue
 			}
 
@@ -186,19 +205,35 @@ func (l *listener) pollBlocks() error {
 				return nil
 			}
 
-			// Parse out events
+			// Parse out events. Deposits not confirmed by enough endpoints hold back the block, however long it takes,
+			// instead of consuming the retries
 			err = l.getDepositEventsForBlock(currentBlock)
-			if err != nil {
+			if pool.Unconfirmed(err) {
+				if backoff == 0 {
+					l.alertUnconfirmed(currentBlock.Uint64(), err)
+				}
+				backoff = pool.Backoff(backoff, BlockRetryInterval)
+				select {
+				case <-l.stop:
+					return errors.New("polling terminated")
+				case <-time.After(backoff):
+				}
+				continue
+			} else if err != nil {
 				l.log.Error("Failed to get events for block", "block", currentBlock, "err", err)
 				retry--
 				continue
 			}
 
+			// Route the large deposits which have their confirmations now
+			l.routeDeferred(latestBlock)
+
 			// Write to block store. Not a critical operation, no need to retry
 			err = l.blockstore.StoreBlock(currentBlock)
 			if err != nil {
 				l.log.Error("Failed to write latest block to blockstore", "block", currentBlock, "err", err)
 			}
+			l.deposits.Processed(currentBlock)
 
 			if l.metrics != nil {
 				l.metrics.BlocksProcessed.Inc()
@@ -211,44 +246,75 @@ func (l *listener) pollBlocks() error {
 			// Goto next block and reset retry counter
 			currentBlock.Add(currentBlock, big.NewInt(1))
 			retry = BlockRetryLimit
+			backoff = 0
 		}
 	}
 }
 
+// alertUnconfirmed reports a block held back until its deposits are confirmed by enough endpoints
+func (l *listener) alertUnconfirmed(block uint64, err error) {
+	l.log.Crit("Deposits not confirmed, holding back the block until enough endpoints agree", "block", block, "err", err)
+	l.notifier.Notify(webhooks.BlockEvent(webhooks.DepositUnconfirmed, l.cfg.id, block, err.Error()))
+}
+
 // getDepositEventsForBlock looks for the deposit event in the latest block
 func (l *listener) getDepositEventsForBlock(latestBlock *big.Int) error {
 	l.log.Debug("Querying block for deposit events", "block", latestBlock)
 
-	query := buildQuery(l.cfg.bridgeContract, utils.Deposit, latestBlock, latestBlock)
+	// Use the logs of the subscription if it covers the block, otherwise query for logs
+	logs, ok := l.deposits.Logs(latestBlock)
+	if !ok {
+		var err error
+		query := buildQuery(l.cfg.bridgeContract, utils.Deposit, latestBlock, latestBlock)
+		logs, err = l.conn.Client().FilterLogs(context.Background(), query)
+		if err != nil {
+			return fmt.Errorf("unable to Filter Logs: %w", err)
+		}
+	}
+
+	return l.handleDeposits(logs)
+}
 
-	// Query for logs
-	logs, err := l.conn.Client().FilterLogs(context.Background(), query)
+// handleDeposits reads through the deposit logs and routes a message for each deposit to a recognized handler. The
+// handlers and the deposit records are all read from the current endpoint, at the block of the logs if the deposits
+// are confirmed on other endpoints. When the block is processed again after a failure, the deposits routed or deferred
+// by the previous attempt are skipped.
+func (l *listener) handleDeposits(logs []ethtypes.Log) error {
+	var at *big.Int
+	if l.cfg.depositQuorum > 1 && len(logs) > 0 {
+		at = new(big.Int).SetUint64(logs[0].BlockNumber)
+	}
+	deposits, err := fetchDeposits(l.conn.Backend(), l.cfg.bridgeContract, logs, at)
 	if err != nil {
-		return fmt.Errorf("unable to Filter Logs: %w", err)
+		return err
 	}
 
-	// Read through the log events and handle their deposit event if handler is recognized
-	for _, log := range logs {
-		var m msg.Message
-		destId := msg.ChainId(big.NewInt(0).SetBytes(log.Data[:32]).Uint64())
-		rId := msg.ResourceIdFromSlice(log.Data[32:64])
-		nonce := msg.Nonce(big.NewInt(0).SetBytes(log.Data[64:96]).Uint64())
-
-		l.log.Info("Parse event successfully.", "DestId", destId, "ResourceId", rId.Shorten(), "Nonce", nonce)
+	// Refuse the whole block if any deposit isn't confirmed, the block is processed again
+	err = l.confirmDeposits(deposits)
+	if err != nil {
+		return err
+	}
 
-		addr, err := l.bridgeContract.ResourceIDToHandlerAddress(&bind.CallOpts{From: l.conn.Keypair().CommonAddress()}, rId)
-		if err != nil {
-			return fmt.Errorf("failed to get handler from resource ID %x", rId)
+	for _, d := range deposits {
+		var m msg.Message
+		log, destId, nonce, addr := d.log, d.destId, d.nonce, d.handler
+		if log.BlockHash != l.routedBlock || l.routed == nil {
+			l.routedBlock, l.routed = log.BlockHash, make(map[uint]bool)
+		}
+		if l.routed[log.Index] {
+			continue
 		}
 
+		l.log.Info("Parse event successfully.", "DestId", destId, "ResourceId", d.rId.Shorten(), "Nonce", nonce)
+
 		if addr == l.cfg.erc20HandlerContract && chainset.IsMultiSigTransfer(destId) {
-			m, err = l.handleMultiSigDepositedEvent(destId, nonce)
+			m, err = l.handleMultiSigDepositedEvent(d)
 		} else if addr == l.cfg.erc20HandlerContract && !chainset.IsMultiSigTransfer(destId) {
-			m, err = l.handleErc20DepositedEvent(destId, nonce)
-		} else if addr == l.cfg.erc721HandlerContract {
-			m, err = l.handleErc721DepositedEvent(destId, nonce)
-		} else if addr == l.cfg.genericHandlerContract {
-			m, err = l.handleGenericDepositedEvent(destId, nonce)
+			m, err = l.handleErc20DepositedEvent(d)
+		} else if l.erc721HandlerContract != nil && addr == l.cfg.erc721HandlerContract {
+			m, err = l.handleErc721DepositedEvent(d)
+		} else if l.genericHandlerContract != nil && addr == l.cfg.genericHandlerContract {
+			m, err = l.handleGenericDepositedEvent(d)
 		} else {
 			l.log.Error("event has unrecognized handler", "handler", addr.Hex())
 			return nil
@@ -256,6 +322,25 @@ func (l *listener) getDepositEventsForBlock(latestBlock *big.Int) error {
 		if err != nil {
 			return err
 		}
+		// A block processed again after a failure or a restart is only notified once
+		moved, err := l.lifecycle.Record(m, lifecycle.Observed, l.cfg.name, log.TxHash.Hex(), "")
+		if err != nil {
+			l.log.Warn("Failed to record the transfer state", "DestId", destId, "Nonce", nonce, "err", err)
+		}
+		if moved {
+			e := webhooks.NewEvent(webhooks.DepositObserved, m)
+			e.TxHash = log.TxHash.Hex()
+			l.notifier.Notify(e)
+		}
+
+		deferred, err := l.deferDeposit(m, log)
+		if err != nil {
+			return err
+		}
+		l.routed[log.Index] = true
+		if deferred {
+			continue
+		}
 
 		err = l.router.Send(m)
 		if err != nil {
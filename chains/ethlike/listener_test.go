@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/Platdot-network/Platdot/bindings/Bridge"
 	"github.com/Platdot-network/Platdot/bindings/ERC20Handler"
+	"github.com/Platdot-network/Platdot/chains/screening"
 	"github.com/Platdot-network/Platdot/config"
 	utils "github.com/Platdot-network/Platdot/shared/ethlike"
 	ethtest "github.com/Platdot-network/Platdot/shared/ethlike/testing"
@@ -50,7 +51,7 @@ func createTestListener(t *testing.T, config *Config, contracts *utils.DeployedC
 
 	router := &MockRouter{msgs: make(chan msg.Message)}
 	listener := NewListener(conn, &newConfig, TestLogger, &blockstore.EmptyStore{}, stop, sysErr, nil)
-	listener.setContracts(bridgeContract, erc20HandlerContract)
+	listener.setContracts(bridgeContract, erc20HandlerContract, nil, nil)
 	listener.setRouter(router)
 	// Start the listener
 	err = listener.start()
@@ -110,14 +111,14 @@ func TestListener_Erc20DepositedEvent(t *testing.T) {
 
 	ethtest.RegisterResource(t, client, contracts.BridgeAddress, contracts.ERC20HandlerAddress, resourceId, erc20Contract)
 
-	expectedMessage := msg.NewFungibleTransfer(
+	expectedMessage := screening.WithDepositor(msg.NewFungibleTransfer(
 		src,
 		dst,
 		1,
 		amount,
 		resourceId,
 		common.HexToAddress(BobKp.Address()).Bytes(),
-	)
+	), AliceKp.CommonAddress().Bytes())
 	// Create an ERC20 Deposit
 	createErc20Deposit(
 		t,
@@ -133,14 +134,14 @@ func TestListener_Erc20DepositedEvent(t *testing.T) {
 	verifyMessage(t, router, expectedMessage, errs)
 
 	// Create second deposit, verify nonce change
-	expectedMessage = msg.NewFungibleTransfer(
+	expectedMessage = screening.WithDepositor(msg.NewFungibleTransfer(
 		src,
 		dst,
 		2,
 		amount,
 		resourceId,
 		common.HexToAddress(BobKp.Address()).Bytes(),
-	)
+	), AliceKp.CommonAddress().Bytes())
 	createErc20Deposit(
 		t,
 		l.bridgeContract,
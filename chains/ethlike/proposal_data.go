@@ -4,6 +4,7 @@
 package ethlike
 
 import (
+	"fmt"
 	"math/big"
 
 	"github.com/hacpy/go-ethereum/common"
@@ -45,3 +46,16 @@ func ConstructGenericProposalData(metadata []byte) []byte {
 	data = append(data, metadata...)                             // metadata ([]byte)
 	return data
 }
+
+// parseErc20ProposalData returns the amount and the recipient of the data of an Erc20 proposal
+func parseErc20ProposalData(data []byte) (*big.Int, []byte, error) {
+	if len(data) < 64 {
+		return nil, nil, fmt.Errorf("erc20 proposal data too short: %d bytes", len(data))
+	}
+	amount := new(big.Int).SetBytes(data[:32])
+	recipientLen := new(big.Int).SetBytes(data[32:64])
+	if !recipientLen.IsUint64() || recipientLen.Uint64() > uint64(len(data)-64) {
+		return nil, nil, fmt.Errorf("invalid erc20 proposal recipient length: %s", recipientLen)
+	}
+	return amount, data[64 : 64+recipientLen.Uint64()], nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package ethlike
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/Platdot-network/Platdot/bindings/Bridge"
+	"github.com/Platdot-network/Platdot/chains"
+	"github.com/hacpy/go-ethereum/accounts/abi"
+	"github.com/hacpy/go-ethereum/accounts/abi/bind"
+	ethcommon "github.com/hacpy/go-ethereum/common"
+	ethtypes "github.com/hacpy/go-ethereum/core/types"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+var _ chains.Auditor = &Chain{}
+
+// txReader fetches the transactions which emitted the proposal events
+type txReader interface {
+	TransactionByHash(ctx context.Context, hash ethcommon.Hash) (*ethtypes.Transaction, bool, error)
+}
+
+// executions returns the proposals transferred by the bridge between the blocks start and end. The amount and the
+// recipient of Erc20 proposals are read from the data of the executeProposal transaction.
+func executions(bridge *Bridge.Bridge, txs txReader, start uint64, end uint64) ([]chains.Execution, error) {
+	bridgeAbi, err := abi.JSON(strings.NewReader(Bridge.BridgeABI))
+	if err != nil {
+		return nil, err
+	}
+
+	it, err := bridge.FilterProposalEvent(&bind.FilterOpts{Start: start, End: &end})
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	var res []chains.Execution
+	for it.Next() {
+		evt := it.Event
+		if evt.Status != TransferredStatus {
+			continue
+		}
+		e := chains.Execution{
+			Source: msg.ChainId(evt.OriginChainID),
+			Nonce:  msg.Nonce(evt.DepositNonce),
+			Block:  evt.Raw.BlockNumber,
+			Tx:     evt.Raw.TxHash.Hex(),
+		}
+
+		tx, _, err := txs.TransactionByHash(context.Background(), evt.Raw.TxHash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch transaction %s: %w", evt.Raw.TxHash.Hex(), err)
+		}
+		if data, ok := executedData(bridgeAbi, tx.Data()); ok {
+			e.Amount, e.Recipient, _ = parseErc20ProposalData(data)
+		}
+		res = append(res, e)
+	}
+	return res, it.Error()
+}
+
+// executedData returns the proposal data of an executeProposal call
+func executedData(bridgeAbi abi.ABI, input []byte) ([]byte, bool) {
+	if len(input) < 4 {
+		return nil, false
+	}
+	method, err := bridgeAbi.MethodById(input[:4])
+	if err != nil || method.Name != "executeProposal" {
+		return nil, false
+	}
+	args, err := method.Inputs.Unpack(input[4:])
+	if err != nil || len(args) != 4 {
+		return nil, false
+	}
+	data, ok := args[2].([]byte)
+	return data, ok
+}
+
+// Executions returns the deposits executed by the bridge between the blocks start and end
+func (c *Chain) Executions(start uint64, end uint64) ([]chains.Execution, error) {
+	return executions(c.writer.bridgeContract, c.conn.Client(), start, end)
+}
+
+// ExpectedAmount returns the amount of the message, already scaled to the chain by its source
+func (c *Chain) ExpectedAmount(m msg.Message) (*big.Int, error) {
+	if m.Type != msg.MultiSigTransfer && m.Type != msg.FungibleTransfer {
+		return nil, fmt.Errorf("message type %s has no amount", m.Type)
+	}
+	return new(big.Int).SetBytes(m.Payload[0].([]byte)), nil
+}
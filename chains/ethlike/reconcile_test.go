@@ -0,0 +1,43 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package ethlike
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/Platdot-network/Platdot/bindings/ERC20PresetMinterPauser"
+	"github.com/Platdot-network/Platdot/chains/chainset"
+	"github.com/hacpy/go-ethereum/common"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+func TestExecutions(t *testing.T) {
+	c := newSimChain(t, false, false)
+	rId := msg.ResourceIdFromSlice(common.FromHex(chainset.ResourceIdXBTC))
+
+	// The handler holds the locked tokens the proposal releases
+	tokenAddr, _, token, err := ERC20PresetMinterPauser.DeployERC20PresetMinterPauser(c.opts, c.backend, "", "")
+	c.commit(err)
+	_, err = c.bridge.AdminSetResource(c.opts, c.cfg.erc20HandlerContract, rId, tokenAddr)
+	c.commit(err)
+	_, err = token.Mint(c.opts, c.cfg.erc20HandlerContract, big.NewInt(100))
+	c.commit(err)
+
+	m := msg.NewFungibleTransfer(1, TestChainId, 7, big.NewInt(42), rId, BobKp.CommonAddress().Bytes())
+	c.execute(m, c.cfg.erc20HandlerContract, ConstructErc20ProposalData(m.Payload[0].([]byte), m.Payload[1].([]byte)))
+
+	res, err := executions(c.bridge, c.backend, 0, c.backend.Blockchain().CurrentBlock().NumberU64())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 {
+		t.Fatalf("Got %d executions, expected 1", len(res))
+	}
+	e := res[0]
+	if e.Source != 1 || e.Nonce != 7 || e.Amount.Int64() != 42 || !bytes.Equal(e.Recipient, BobKp.CommonAddress().Bytes()) {
+		t.Fatalf("Unexpected execution: %+v", e)
+	}
+}
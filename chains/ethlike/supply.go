@@ -0,0 +1,45 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package ethlike
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/Platdot-network/Platdot/bindings/ERC20"
+	"github.com/Platdot-network/Platdot/bindings/ERC20Handler"
+	"github.com/Platdot-network/Platdot/chains"
+	utils "github.com/Platdot-network/Platdot/shared/ethlike"
+	"github.com/hacpy/go-ethereum/accounts/abi/bind"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+var _ chains.Issuer = &Chain{}
+
+// tokenSupply returns the total supply of the token contract of a resource, chains.ErrNotIssued if the resource has
+// no token contract
+func tokenSupply(handler *ERC20Handler.ERC20Handler, backend bind.ContractBackend, opts *bind.CallOpts, rId msg.ResourceId) (*big.Int, error) {
+	token, err := handler.ResourceIDToTokenContractAddress(opts, rId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the token contract of resource %x: %w", rId, err)
+	}
+	if token == utils.ZeroAddress {
+		return nil, chains.ErrNotIssued
+	}
+
+	erc20, err := ERC20.NewERC20(token, backend)
+	if err != nil {
+		return nil, err
+	}
+	supply, err := erc20.TotalSupply(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the supply of token %s: %w", token.Hex(), err)
+	}
+	return supply, nil
+}
+
+// Supply returns the total supply of the wrapped token of a resource
+func (c *Chain) Supply(rId msg.ResourceId) (*big.Int, error) {
+	return tokenSupply(c.listener.erc20HandlerContract, c.conn.Backend(), c.conn.CallOpts(), rId)
+}
@@ -0,0 +1,42 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package ethlike
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/Platdot-network/Platdot/bindings/ERC20PresetMinterPauser"
+	"github.com/Platdot-network/Platdot/chains"
+	"github.com/Platdot-network/Platdot/chains/chainset"
+	"github.com/hacpy/go-ethereum/accounts/abi/bind"
+	"github.com/hacpy/go-ethereum/common"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+func TestTokenSupply(t *testing.T) {
+	c := newSimChain(t, false, false)
+	rId := msg.ResourceIdFromSlice(common.FromHex(chainset.ResourceIdXBTC))
+
+	_, err := tokenSupply(c.listener.erc20HandlerContract, c.backend, &bind.CallOpts{}, rId)
+	if !errors.Is(err, chains.ErrNotIssued) {
+		t.Fatalf("Got: %v Expected: %v", err, chains.ErrNotIssued)
+	}
+
+	tokenAddr, _, token, err := ERC20PresetMinterPauser.DeployERC20PresetMinterPauser(c.opts, c.backend, "", "")
+	c.commit(err)
+	_, err = c.bridge.AdminSetResource(c.opts, c.cfg.erc20HandlerContract, rId, tokenAddr)
+	c.commit(err)
+	_, err = token.Mint(c.opts, BobKp.CommonAddress(), big.NewInt(42))
+	c.commit(err)
+
+	supply, err := tokenSupply(c.listener.erc20HandlerContract, c.backend, &bind.CallOpts{}, rId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if supply.Int64() != 42 {
+		t.Fatalf("Got: %s Expected: 42", supply)
+	}
+}
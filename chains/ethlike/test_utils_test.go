@@ -4,8 +4,9 @@ import (
 	"fmt"
 	"github.com/ChainSafe/log15"
 	"github.com/Platdot-network/Platdot/bindings/Bridge"
-	"github.com/Platdot-network/Platdot/config"
 	connection "github.com/Platdot-network/Platdot/connections/ethlike"
+	"github.com/Platdot-network/Platdot/connections/pool"
+	"github.com/Platdot-network/Platdot/connections/signer"
 	utils "github.com/Platdot-network/Platdot/shared/ethlike"
 	"github.com/hacpy/go-ethereum/common"
 	"github.com/rjman-ljm/platdot-utils/keystore"
@@ -67,13 +68,15 @@ func newLocalConnection(t *testing.T, cfg *Config) *connection.Connection {
 	kp := keystore.TestKeyRing.EthereumKeys[cfg.from]
 	conn := connection.NewConnection(
 		DefaultNetworkId,
-		TestEndpoint[config.InitialEndPointId],
+		TestEndpoint,
 		false,
-		kp,
+		signer.NewSecp256k1(kp),
 		TestLogger,
 		big.NewInt(DefaultGasLimit),
 		big.NewInt(DefaultGasPrice),
-		big.NewFloat(DefaultGasMultiplier))
+		big.NewFloat(DefaultGasMultiplier),
+		pool.DefaultMaxLag,
+		nil)
 	err := conn.Connect()
 	if err != nil {
 		t.Fatal(err)
@@ -0,0 +1,248 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package ethlike
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Platdot-network/Platdot/chains/limits"
+	"github.com/Platdot-network/Platdot/chains/persist"
+	"github.com/hacpy/go-ethereum/accounts/abi/bind"
+	"github.com/hacpy/go-ethereum/common"
+	"github.com/hacpy/go-ethereum/common/hexutil"
+	ethtypes "github.com/hacpy/go-ethereum/core/types"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+// confirmationTier requires depth confirmations for the deposits of at least threshold
+type confirmationTier struct {
+	threshold *big.Int
+	depth     uint64
+}
+
+// confirmationTiers are the confirmations required by the large deposits, by asset
+type confirmationTiers struct {
+	assets map[msg.ResourceId][]confirmationTier
+	any    []confirmationTier // Tiers of the assets without tiers of their own
+}
+
+// parseConfirmationTiers parses tiers given as `resourceId:threshold:depth` separated by commas, with `*` for the
+// assets without tiers of their own, e.g. "*:1000000000000000000000:30,*:10000000000000000000000:100"
+func parseConfirmationTiers(value string) (*confirmationTiers, error) {
+	tiers := &confirmationTiers{assets: make(map[msg.ResourceId][]confirmationTier)}
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid %s: %s", ConfirmationTiersOpt, entry)
+		}
+		threshold, ok := new(big.Int).SetString(parts[1], 10)
+		if !ok || threshold.Sign() < 0 {
+			return nil, fmt.Errorf("invalid %s threshold: %s", ConfirmationTiersOpt, parts[1])
+		}
+		depth, err := strconv.ParseUint(parts[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s depth: %s", ConfirmationTiersOpt, parts[2])
+		}
+		tier := confirmationTier{threshold: threshold, depth: depth}
+		if parts[0] == limits.AnyResource {
+			tiers.any = append(tiers.any, tier)
+			continue
+		}
+		rId := common.FromHex(parts[0])
+		if len(rId) != 32 {
+			return nil, fmt.Errorf("invalid %s resource id: %s", ConfirmationTiersOpt, parts[0])
+		}
+		tiers.assets[msg.ResourceIdFromSlice(rId)] = append(tiers.assets[msg.ResourceIdFromSlice(rId)], tier)
+	}
+	return tiers, nil
+}
+
+// depth returns the confirmations required by a deposit of amount, 0 if no tier applies
+func (t *confirmationTiers) depth(rId msg.ResourceId, amount *big.Int) uint64 {
+	tiers, ok := t.assets[rId]
+	if !ok {
+		tiers = t.any
+	}
+	var depth uint64
+	for _, tier := range tiers {
+		if amount.Cmp(tier.threshold) >= 0 && tier.depth > depth {
+			depth = tier.depth
+		}
+	}
+	return depth
+}
+
+// deferredDeposit is a deposit routed once its block has the confirmations required by its amount
+type deferredDeposit struct {
+	Block       uint64           `json:"block"`
+	Depth       uint64           `json:"depth"`
+	Source      msg.ChainId      `json:"source"`
+	Destination msg.ChainId      `json:"destination"`
+	Nonce       msg.Nonce        `json:"nonce"`
+	Type        msg.TransferType `json:"type"`
+	ResourceId  string           `json:"resourceId"`
+	Payload     []hexutil.Bytes  `json:"payload"`
+}
+
+// Message returns the message of the deposit
+func (d *deferredDeposit) Message() msg.Message {
+	payload := make([]interface{}, len(d.Payload))
+	for i, p := range d.Payload {
+		payload[i] = []byte(p)
+	}
+	return msg.Message{
+		Source:       d.Source,
+		Destination:  d.Destination,
+		Type:         d.Type,
+		DepositNonce: d.Nonce,
+		ResourceId:   msg.ResourceIdFromSlice(common.FromHex(d.ResourceId)),
+		Payload:      payload,
+	}
+}
+
+// deferredDeposits are the deposits awaiting their confirmations, persisted so that a restart doesn't lose the
+// deposits of the blocks already processed. They are only used by the polling goroutine of the listener.
+type deferredDeposits struct {
+	path     string
+	deposits []*deferredDeposit
+}
+
+// deferredPath returns the file of the deferred deposits of a chain, in the blockstore directory
+func deferredPath(blockstorePath string, chain msg.ChainId) (string, error) {
+	blockstorePath, err := persist.BlockstoreDir(blockstorePath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(blockstorePath, fmt.Sprintf("deferred-%d.json", chain)), nil
+}
+
+func loadDeferredDeposits(path string) (*deferredDeposits, error) {
+	d := &deferredDeposits{path: path}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return d, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &d.deposits)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deferred deposits: %w", err)
+	}
+	return d, nil
+}
+
+// add defers the deposit of a message, made at block
+func (d *deferredDeposits) add(m msg.Message, block uint64, depth uint64) error {
+	for _, deposit := range d.deposits {
+		if deposit.Destination == m.Destination && deposit.Nonce == m.DepositNonce {
+			return nil
+		}
+	}
+	deposit := &deferredDeposit{
+		Block:       block,
+		Depth:       depth,
+		Source:      m.Source,
+		Destination: m.Destination,
+		Nonce:       m.DepositNonce,
+		Type:        m.Type,
+		ResourceId:  m.ResourceId.Hex(),
+	}
+	for _, p := range m.Payload {
+		b, ok := p.([]byte)
+		if !ok {
+			return fmt.Errorf("unsupported payload of a %s", m.Type)
+		}
+		deposit.Payload = append(deposit.Payload, append([]byte(nil), b...))
+	}
+	d.deposits = append(d.deposits, deposit)
+	sort.SliceStable(d.deposits, func(i, j int) bool {
+		return d.deposits[i].Block+d.deposits[i].Depth < d.deposits[j].Block+d.deposits[j].Depth
+	})
+	return d.save()
+}
+
+// confirmed removes and returns the deposits with enough confirmations at the latest block
+func (d *deferredDeposits) confirmed(latest uint64) ([]*deferredDeposit, error) {
+	var res, rest []*deferredDeposit
+	for _, deposit := range d.deposits {
+		if latest >= deposit.Block+deposit.Depth {
+			res = append(res, deposit)
+		} else {
+			rest = append(rest, deposit)
+		}
+	}
+	if len(res) == 0 {
+		return nil, nil
+	}
+	d.deposits = rest
+	return res, d.save()
+}
+
+func (d *deferredDeposits) save() error {
+	return persist.WriteJSON(d.path, d.deposits)
+}
+
+// deferDeposit returns whether the deposit needs more confirmations than the listener waits for, in which case it
+// is deferred until its block has them
+func (l *listener) deferDeposit(m msg.Message, log ethtypes.Log) (bool, error) {
+	if l.tiers == nil || l.deferred == nil || (m.Type != msg.MultiSigTransfer && m.Type != msg.FungibleTransfer) {
+		return false, nil
+	}
+	depth := l.tiers.depth(m.ResourceId, new(big.Int).SetBytes(m.Payload[0].([]byte)))
+	if depth <= l.blockConfirmations.Uint64() {
+		return false, nil
+	}
+	err := l.deferred.add(m, log.BlockNumber, depth)
+	if err != nil {
+		return false, fmt.Errorf("failed to defer the deposit: %w", err)
+	}
+	l.log.Info("Large deposit, waiting for more confirmations", "block", log.BlockNumber, "depth", depth,
+		"DestId", m.Destination, "Nonce", m.DepositNonce, "ResourceId", m.ResourceId.Shorten())
+	return true, nil
+}
+
+// routeDeferred routes the deferred deposits whose block has the required confirmations at the latest block. The
+// deposit record is read again, a deposit which was reorganized away is dropped.
+func (l *listener) routeDeferred(latest *big.Int) {
+	if l.deferred == nil {
+		return
+	}
+	deposits, err := l.deferred.confirmed(latest.Uint64())
+	if err != nil {
+		l.log.Error("Failed to persist the deferred deposits", "err", err)
+	}
+	for _, d := range deposits {
+		m := d.Message()
+		record, err := l.erc20HandlerContract.GetDepositRecord(&bind.CallOpts{From: l.conn.Address()}, uint64(m.DepositNonce), uint8(m.Destination))
+		if err != nil {
+			/// Retry with the next block
+			l.log.Error("Failed to read a deferred deposit again", "DestId", m.Destination, "Nonce", m.DepositNonce, "err", err)
+			_ = l.deferred.add(m, d.Block, d.Depth)
+			continue
+		}
+		if record.ResourceID != m.ResourceId || record.Amount.Cmp(new(big.Int).SetBytes(m.Payload[0].([]byte))) != 0 ||
+			!bytes.Equal(record.DestinationRecipientAddress, m.Payload[1].([]byte)) {
+			l.log.Error("Deferred deposit changed while waiting for its confirmations, dropping it", "block", d.Block,
+				"DestId", m.Destination, "Nonce", m.DepositNonce)
+			continue
+		}
+
+		l.log.Info("Deferred deposit confirmed, routing it", "block", d.Block, "depth", d.Depth, "DestId", m.Destination, "Nonce", m.DepositNonce)
+		err = l.router.Send(m)
+		if err != nil {
+			/// Retry with the next block
+			l.log.Error("subscription error: failed to route message", "err", err)
+			_ = l.deferred.add(m, d.Block, d.Depth)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package ethlike
+
+import (
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Platdot-network/Platdot/bindings/ERC20PresetMinterPauser"
+	"github.com/Platdot-network/Platdot/chains/chainset"
+	utils "github.com/Platdot-network/Platdot/shared/ethlike"
+	"github.com/hacpy/go-ethereum/common"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+type failingRouter struct{}
+
+func (failingRouter) Send(msg.Message) error {
+	return errors.New("router unavailable")
+}
+
+func TestParseConfirmationTiers(t *testing.T) {
+	rId := msg.ResourceIdFromSlice(common.FromHex(chainset.ResourceIdXBTC))
+	tiers, err := parseConfirmationTiers(rId.Hex() + ":100:20," + rId.Hex() + ":1000:50,*:10:30")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		rId    msg.ResourceId
+		amount int64
+		depth  uint64
+	}{
+		{rId, 99, 0},
+		{rId, 100, 20},
+		{rId, 5000, 50},
+		{msg.ResourceId{}, 9, 0},
+		{msg.ResourceId{}, 10, 30},
+	}
+	for _, tt := range tests {
+		if depth := tiers.depth(tt.rId, big.NewInt(tt.amount)); depth != tt.depth {
+			t.Errorf("Got depth %d for %d of %x, expected %d", depth, tt.amount, tt.rId, tt.depth)
+		}
+	}
+
+	for _, value := range []string{"*:10", "*:ten:30", "*:10:-1", "0x01:10:30"} {
+		if _, err = parseConfirmationTiers(value); err == nil {
+			t.Errorf("Expected an error for %s", value)
+		}
+	}
+}
+
+func TestDeferLargeDeposits(t *testing.T) {
+	c := newSimChain(t, false, false)
+	rId := msg.ResourceIdFromSlice(common.FromHex(chainset.ResourceIdXBTC))
+	dir, err := ioutil.TempDir("", "deferred")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "deferred.json")
+
+	c.listener.tiers, err = parseConfirmationTiers("*:100:10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.listener.deferred, err = loadDeferredDeposits(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenAddr, _, token, err := ERC20PresetMinterPauser.DeployERC20PresetMinterPauser(c.opts, c.backend, "", "")
+	c.commit(err)
+	_, err = c.bridge.AdminSetResource(c.opts, c.cfg.erc20HandlerContract, rId, tokenAddr)
+	c.commit(err)
+	_, err = token.Mint(c.opts, AliceKp.CommonAddress(), big.NewInt(1000))
+	c.commit(err)
+	_, err = token.Approve(c.opts, c.cfg.erc20HandlerContract, big.NewInt(1000))
+	c.commit(err)
+
+	// The large deposit waits for its confirmations
+	logs := c.deposit(rId, utils.ConstructErc20DepositData(BobKp.CommonAddress().Bytes(), big.NewInt(500)))
+	err = c.listener.handleDeposits(logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := logs[0].BlockNumber
+
+	// The small one is routed at once
+	logs = c.deposit(rId, utils.ConstructErc20DepositData(BobKp.CommonAddress().Bytes(), big.NewInt(5)))
+	err = c.listener.handleDeposits(logs[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := <-c.router.msgs
+	if m.DepositNonce != 2 || new(big.Int).SetBytes(m.Payload[0].([]byte)).Int64() != 5 {
+		t.Fatalf("Unexpected message: %+v", m)
+	}
+
+	c.listener.routeDeferred(new(big.Int).SetUint64(block + 9))
+	select {
+	case m = <-c.router.msgs:
+		t.Fatalf("Unexpected message before the confirmations: %+v", m)
+	default:
+	}
+
+	// The deferred deposit survives a restart
+	c.listener.deferred, err = loadDeferredDeposits(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A deposit which fails to be routed is kept for the next block
+	c.listener.setRouter(failingRouter{})
+	c.listener.routeDeferred(new(big.Int).SetUint64(block + 10))
+	c.listener.deferred, err = loadDeferredDeposits(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.listener.deferred.deposits) != 1 {
+		t.Fatalf("Expected the deposit to be kept, got %d", len(c.listener.deferred.deposits))
+	}
+
+	c.listener.setRouter(c.router)
+	c.listener.routeDeferred(new(big.Int).SetUint64(block + 11))
+	select {
+	case m = <-c.router.msgs:
+		if m.DepositNonce != 1 || new(big.Int).SetBytes(m.Payload[0].([]byte)).Int64() != 500 ||
+			common.BytesToAddress(m.Payload[1].([]byte)) != BobKp.CommonAddress() {
+			t.Fatalf("Unexpected message: %+v", m)
+		}
+	default:
+		t.Fatal("Expected the deferred deposit to be routed")
+	}
+	if len(c.listener.deferred.deposits) != 0 {
+		t.Fatal("Expected no deposit left")
+	}
+}
@@ -6,14 +6,21 @@ package ethlike
 import (
 	"github.com/ChainSafe/log15"
 	"github.com/Platdot-network/Platdot/bindings/Bridge"
+	"github.com/Platdot-network/Platdot/chains"
 	"github.com/Platdot-network/Platdot/chains/chainset"
+	"github.com/Platdot-network/Platdot/chains/approvals"
+	"github.com/Platdot-network/Platdot/chains/lifecycle"
+	"github.com/Platdot-network/Platdot/chains/limits"
+	"github.com/Platdot-network/Platdot/chains/screening"
+	"github.com/Platdot-network/Platdot/chains/webhooks"
+	connection "github.com/Platdot-network/Platdot/connections/ethlike"
 	"github.com/rjman-ljm/platdot-utils/core"
-	"github.com/rjman-ljm/platdot-utils/crypto/secp256k1"
 	metrics "github.com/rjman-ljm/platdot-utils/metrics/types"
 	"github.com/rjman-ljm/platdot-utils/msg"
 )
 
 var _ core.Writer = &writer{}
+var _ chains.Resolver = &writer{}
 
 var PassedStatus uint8 = 2
 var TransferredStatus uint8 = 3
@@ -23,20 +30,24 @@ type writer struct {
 	cfg            Config
 	conn           Connection
 	bridgeContract *Bridge.Bridge // instance of bound receiver bridgeContract
-	kp             secp256k1.Keypair
 	log            log15.Logger
 	stop           <-chan int
 	sysErr         chan<- error // Reports fatal error to core
 	metrics        *metrics.ChainMetrics
 	chainCore      *chainset.ChainCore
+	proposals      *connection.LogSubscription // ProposalEvent logs, when subscribed over websocket
+	limiter        *limits.Limiter             // Caps the transfers over a rolling window, if set
+	screener       *screening.Screener         // Holds back the transfers of blocked parties, if set
+	approvals      *approvals.Queue            // Holds the large transfers until they are approved, if set
+	notifier       *webhooks.Notifier          // Notifies the webhooks of the transfers, if set
+	lifecycle      *lifecycle.Store            // Records the state of the transfers, if set
 }
 
 // NewWriter creates and returns writer
-func NewWriter(conn Connection, cfg *Config, log log15.Logger, kp secp256k1.Keypair, stop <-chan int, sysErr chan<- error, m *metrics.ChainMetrics, bc *chainset.ChainCore) *writer {
+func NewWriter(conn Connection, cfg *Config, log log15.Logger, stop <-chan int, sysErr chan<- error, m *metrics.ChainMetrics, bc *chainset.ChainCore) *writer {
 	return &writer{
 		cfg:       *cfg,
 		conn:      conn,
-		kp:        kp,
 		log:       log,
 		stop:      stop,
 		sysErr:    sysErr,
@@ -47,6 +58,18 @@ func NewWriter(conn Connection, cfg *Config, log log15.Logger, kp secp256k1.Keyp
 
 func (w *writer) start() error {
 	w.log.Debug("Starting writer...", "chain", w.cfg.name)
+	if w.limiter != nil {
+		go w.limiter.Run(func(m msg.Message) { w.ResolveMessage(m) }, w.stop)
+	}
+	if w.screener != nil {
+		go w.screener.Run(func(m msg.Message) { w.ResolveMessage(m) }, w.stop)
+	}
+	if w.approvals != nil {
+		go w.approvals.Run(func(m msg.Message) { w.ResolveMessage(m) }, w.stop)
+	}
+	if w.notifier != nil {
+		go w.notifier.Run(w.stop)
+	}
 	return nil
 }
 
@@ -55,6 +78,24 @@ func (w *writer) setContract(bridge *Bridge.Bridge) {
 	w.bridgeContract = bridge
 }
 
+// record moves the transfer of the message to a state and returns whether it moved, a failure to record it doesn't
+// hold back the transfer
+func (w *writer) record(m msg.Message, to lifecycle.State, txHash string, note string) bool {
+	moved, err := w.lifecycle.Record(m, to, w.cfg.name, txHash, note)
+	if err != nil {
+		w.log.Warn("Failed to record the transfer state", "src", m.Source, "nonce", m.DepositNonce, "state", to, "err", err)
+	}
+	return moved
+}
+
+// recordValidated records the transfer of a message as validated, the first time it passes the checks of the writer
+func (w *writer) recordValidated(m msg.Message) {
+	_, err := w.lifecycle.RecordValidated(m, w.cfg.name)
+	if err != nil {
+		w.log.Warn("Failed to record the transfer state", "src", m.Source, "nonce", m.DepositNonce, "state", lifecycle.Validated, "err", err)
+	}
+}
+
 // ResolveMessage handles any given message based on type
 // A bool is returned to indicate failure/success, this should be ignored except for within tests.
 func (w *writer) ResolveMessage(m msg.Message) bool {
@@ -6,7 +6,10 @@ package ethlike
 import (
 	"context"
 	"errors"
+	"fmt"
+	"github.com/Platdot-network/Platdot/chains/lifecycle"
 	"github.com/Platdot-network/Platdot/chains/substrate"
+	"github.com/Platdot-network/Platdot/chains/webhooks"
 	"github.com/hacpy/go-ethereum/common"
 	"math/big"
 	"time"
@@ -52,6 +55,34 @@ func (w *writer) proposalIsFinalized(srcId msg.ChainId, nonce msg.Nonce, dataHas
 	return prop.Status == TransferredStatus || prop.Status == CancelledStatus // Transferred (3)
 }
 
+// IsComplete returns true if the proposal of the message is Transferred or Cancelled
+func (w *writer) IsComplete(m msg.Message) (bool, error) {
+	var dataHash [32]byte
+	switch m.Type {
+	case msg.MultiSigTransfer, msg.FungibleTransfer:
+		recipient, err := common.PlatonToEth(string(m.Payload[1].([]byte)))
+		if err != nil {
+			return false, err
+		}
+		data := ConstructErc20ProposalData(m.Payload[0].([]byte), recipient)
+		dataHash = utils.Hash(append(w.cfg.erc20HandlerContract.Bytes(), data...))
+	case msg.NonFungibleTransfer:
+		data := ConstructErc721ProposalData(m.Payload[0].([]byte), m.Payload[1].([]byte), m.Payload[2].([]byte))
+		dataHash = utils.Hash(append(w.cfg.erc721HandlerContract.Bytes(), data...))
+	case msg.GenericTransfer:
+		data := ConstructGenericProposalData(m.Payload[0].([]byte))
+		dataHash = utils.Hash(append(w.cfg.genericHandlerContract.Bytes(), data...))
+	default:
+		return false, fmt.Errorf("unknown message type %v", m.Type)
+	}
+
+	prop, err := w.bridgeContract.GetProposal(w.conn.CallOpts(), uint8(m.Source), uint64(m.DepositNonce), dataHash)
+	if err != nil {
+		return false, err
+	}
+	return prop.Status == TransferredStatus || prop.Status == CancelledStatus, nil
+}
+
 func (w *writer) proposalIsPassed(srcId msg.ChainId, nonce msg.Nonce, dataHash [32]byte) bool {
 	prop, err := w.bridgeContract.GetProposal(w.conn.CallOpts(), uint8(srcId), uint64(nonce), dataHash)
 	if err != nil {
@@ -93,6 +124,11 @@ func (w *writer) shouldVote(m msg.Message, dataHash [32]byte) bool {
 func (w *writer) createMultiSigProposal(m msg.Message) bool {
 	w.log.Info("Creating MultiSig Redeem proposal", "src", m.Source, "nonce", m.DepositNonce)
 
+	if !w.screenTransfer(m) || !w.approveTransfer(m) || !w.allowTransfer(m) {
+		return true
+	}
+	w.recordValidated(m)
+
 	/// Convert to Alaya Address
 	m.Payload[1], _ = common.PlatonToEth(string(m.Payload[1].([]byte)))
 
@@ -102,6 +138,7 @@ func (w *writer) createMultiSigProposal(m msg.Message) bool {
 	if !w.shouldVote(m, dataHash) {
 		if w.proposalIsPassed(m.Source, m.DepositNonce, dataHash) {
 			// Execute if proposal passed
+			w.record(m, lifecycle.Passed, "", "")
 			w.executeProposal(m, data, dataHash)
 			return true
 		} else {
@@ -129,6 +166,11 @@ func (w *writer) createMultiSigProposal(m msg.Message) bool {
 func (w *writer) createErc20Proposal(m msg.Message) bool {
 	w.log.Info("Creating erc20 Token proposal", "src", m.Source, "nonce", m.DepositNonce)
 
+	if !w.screenTransfer(m) || !w.approveTransfer(m) || !w.allowTransfer(m) {
+		return true
+	}
+	w.recordValidated(m)
+
 	/// Convert to Alaya Address
 	m.Payload[1], _ = common.PlatonToEth(string(m.Payload[1].([]byte)))
 
@@ -138,6 +180,7 @@ func (w *writer) createErc20Proposal(m msg.Message) bool {
 	if !w.shouldVote(m, dataHash) {
 		if w.proposalIsPassed(m.Source, m.DepositNonce, dataHash) {
 			// Execute if proposal passed
+			w.record(m, lifecycle.Passed, "", "")
 			w.executeProposal(m, data, dataHash)
 			return true
 		} else {
@@ -159,11 +202,78 @@ func (w *writer) createErc20Proposal(m msg.Message) bool {
 	return true
 }
 
+// screenTransfer returns whether the parties of the transfer of the message passed the screening. Otherwise the
+// transfer is parked until the screening lists clear it.
+func (w *writer) screenTransfer(m msg.Message) bool {
+	if w.screener == nil {
+		return true
+	}
+	err := w.screener.Screen(m)
+	if err != nil {
+		w.log.Warn("Transfer flagged by the screening, parking it for manual review", "src", m.Source, "nonce", m.DepositNonce, "err", err)
+		w.park(m, err)
+		return false
+	}
+	return true
+}
+
+// park records the transfer of the message as parked, and notifies it the first time it is parked
+func (w *writer) park(m msg.Message, reason error) {
+	if w.record(m, lifecycle.Parked, "", reason.Error()) {
+		w.notifier.Notify(webhooks.NewEvent(webhooks.TransferParked, m).SetReason(reason.Error()))
+	}
+}
+
+// transferAmount returns the amount the proposal of a transfer releases, in the smallest unit of the token on this
+// chain. The substrate listeners convert the deposited amount to the units of the destination before routing it.
+func transferAmount(m msg.Message) *big.Int {
+	return new(big.Int).SetBytes(m.Payload[0].([]byte))
+}
+
+// approveTransfer returns whether the transfer of the message doesn't need a manual approval or was approved.
+// Otherwise the transfer waits in the approval queue until an operator decides on it.
+func (w *writer) approveTransfer(m msg.Message) bool {
+	if w.approvals == nil {
+		return true
+	}
+	amount := transferAmount(m)
+	err := w.approvals.Hold(m, amount)
+	if err != nil {
+		w.log.Warn("Transfer held for a manual approval", "src", m.Source, "nonce", m.DepositNonce,
+			"resource", m.ResourceId.Hex(), "amount", amount, "err", err)
+		w.park(m, err)
+		return false
+	}
+	return true
+}
+
+// allowTransfer returns whether the transfer of the message fits within the limits and its asset is not paused.
+// Otherwise the transfer is parked, the limiter resolves it again later.
+func (w *writer) allowTransfer(m msg.Message) bool {
+	if w.limiter == nil {
+		return true
+	}
+	amount := transferAmount(m)
+	err := w.limiter.Allow(m, amount)
+	if err != nil {
+		w.log.Warn("Transfer over a limit or paused, parking it until it is allowed", "src", m.Source, "nonce", m.DepositNonce,
+			"resource", m.ResourceId.Hex(), "amount", amount, "err", err)
+		w.park(m, err)
+		return false
+	}
+	return true
+}
+
 // createErc721Proposal creates an Erc721 proposal.
 // Returns true if the proposal is succesfully created or is complete
 func (w *writer) createErc721Proposal(m msg.Message) bool {
 	w.log.Info("Creating erc721 proposal", "src", m.Source, "nonce", m.DepositNonce)
 
+	if w.cfg.erc721HandlerContract == utils.ZeroAddress {
+		w.log.Error("No erc721 handler configured", "src", m.Source, "nonce", m.DepositNonce)
+		return false
+	}
+
 	data := ConstructErc721ProposalData(m.Payload[0].([]byte), m.Payload[1].([]byte), m.Payload[2].([]byte))
 	dataHash := utils.Hash(append(w.cfg.erc721HandlerContract.Bytes(), data...))
 
@@ -197,6 +307,11 @@ func (w *writer) createErc721Proposal(m msg.Message) bool {
 func (w *writer) createGenericDepositProposal(m msg.Message) bool {
 	w.log.Info("Creating generic proposal", "src", m.Source, "nonce", m.DepositNonce)
 
+	if w.cfg.genericHandlerContract == utils.ZeroAddress {
+		w.log.Error("No generic handler configured", "src", m.Source, "nonce", m.DepositNonce)
+		return false
+	}
+
 	metadata := m.Payload[0].([]byte)
 	data := ConstructGenericProposalData(metadata)
 	toHash := append(w.cfg.genericHandlerContract.Bytes(), data...)
@@ -253,12 +368,16 @@ func (w *writer) watchThenExecute(m msg.Message, data []byte, dataHash [32]byte,
 				}
 			}
 
-			// query for logs
-			query := buildQuery(w.cfg.bridgeContract, utils.ProposalEvent, latestBlock, latestBlock)
-			evts, err := w.conn.Client().FilterLogs(context.Background(), query)
-			if err != nil {
-				w.log.Error("Failed to fetch logs", "err", err)
-				return
+			// Use the logs of the subscription if it covers the block, otherwise query for logs
+			evts, ok := w.proposals.Logs(latestBlock)
+			if !ok {
+				var err error
+				query := buildQuery(w.cfg.bridgeContract, utils.ProposalEvent, latestBlock, latestBlock)
+				evts, err = w.conn.Client().FilterLogs(context.Background(), query)
+				if err != nil {
+					w.log.Error("Failed to fetch logs", "err", err)
+					return
+				}
 			}
 
 			// Execute the proposal once we find the matching finalized event
@@ -271,6 +390,7 @@ func (w *writer) watchThenExecute(m msg.Message, data []byte, dataHash [32]byte,
 				if m.Source == msg.ChainId(sourceId) &&
 					m.DepositNonce.Big().Uint64() == depositNonce &&
 					utils.IsFinalized(uint8(status)) {
+					w.record(m, lifecycle.Passed, evt.TxHash.Hex(), "")
 					w.executeProposal(m, data, dataHash)
 					return
 				} else {
@@ -282,6 +402,7 @@ func (w *writer) watchThenExecute(m msg.Message, data []byte, dataHash [32]byte,
 		}
 	}
 	log.Warn("Block watch limit exceeded, skipping execution", "source", m.Source, "dest", m.Destination, "nonce", m.DepositNonce)
+	w.record(m, lifecycle.Failed, "", "no finalization event within the block watch limit")
 }
 
 // voteProposal submits a vote proposal
@@ -309,6 +430,10 @@ func (w *writer) voteProposal(m msg.Message, dataHash [32]byte) {
 
 			if err == nil {
 				w.log.Info("Submitted proposal vote", "tx", tx.Hash(), "src", m.Source, "depositNonce", m.DepositNonce)
+				e := webhooks.NewEvent(webhooks.VoteSubmitted, m)
+				e.TxHash = tx.Hash().Hex()
+				w.notifier.Notify(e)
+				w.record(m, lifecycle.Voted, e.TxHash, "")
 				if w.metrics != nil {
 					w.metrics.VotesSubmitted.Inc()
 				}
@@ -329,6 +454,8 @@ func (w *writer) voteProposal(m msg.Message, dataHash [32]byte) {
 		}
 	}
 	w.log.Error("Submission of Vote transaction failed", "source", m.Source, "dest", m.Destination, "depositNonce", m.DepositNonce)
+	w.notifier.Notify(webhooks.NewEvent(webhooks.TransferFailed, m).SetReason("submission of the vote failed"))
+	w.record(m, lifecycle.Failed, "", "submission of the vote failed")
 	//w.sysErr <- ErrFatalTx
 }
 
@@ -345,7 +472,7 @@ func (w *writer) executeProposal(m msg.Message, data []byte, dataHash [32]byte)
 				return
 			}
 
-			_, err = w.bridgeContract.ExecuteProposal(
+			tx, err := w.bridgeContract.ExecuteProposal(
 				w.conn.Opts(),
 				uint8(m.Source),
 				uint64(m.DepositNonce),
@@ -358,6 +485,10 @@ func (w *writer) executeProposal(m msg.Message, data []byte, dataHash [32]byte)
 				w.log.Info(substrate.LineLog, "src", m.Source, "dst", m.Destination, "nonce", m.DepositNonce)
 				w.log.Info("Issue...Submitted proposal execution", "src", m.Source, "dst", m.Destination, "nonce", m.DepositNonce)
 				w.log.Info(substrate.LineLog, "src", m.Source, "dst", m.Destination, "nonce", m.DepositNonce)
+				e := webhooks.NewEvent(webhooks.TransferExecuted, m)
+				e.TxHash = tx.Hash().Hex()
+				w.notifier.Notify(e)
+				w.record(m, lifecycle.Executed, e.TxHash, "")
 				return
 			} else if err.Error() == ErrNonceTooLow.Error() || err.Error() == ErrTxUnderpriced.Error() {
 				w.log.Error("Nonce too low, will retry")
@@ -371,10 +502,14 @@ func (w *writer) executeProposal(m msg.Message, data []byte, dataHash [32]byte)
 			// but there is no need to retry
 			if w.proposalIsFinalized(m.Source, m.DepositNonce, dataHash) {
 				w.log.Info("Proposal finalized on chain", "src", m.Source, "dst", m.Destination, "nonce", m.DepositNonce)
+				w.notifier.Notify(webhooks.NewEvent(webhooks.TransferExecuted, m))
+				w.record(m, lifecycle.Executed, "", "")
 				return
 			}
 		}
 	}
 	w.log.Error("Submission of Execute transaction failed", "source", m.Source, "dest", m.Destination, "depositNonce", m.DepositNonce)
+	w.notifier.Notify(webhooks.NewEvent(webhooks.TransferFailed, m).SetReason("submission of the execution failed"))
+	w.record(m, lifecycle.Failed, "", "submission of the execution failed")
 	//w.sysErr <- ErrFatalTx
 }
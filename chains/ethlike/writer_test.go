@@ -5,6 +5,7 @@ import (
 	"github.com/ChainSafe/log15"
 	"github.com/Platdot-network/Platdot/bindings/Bridge"
 	"github.com/Platdot-network/Platdot/chains/chainset"
+	"github.com/Platdot-network/Platdot/config"
 	utils "github.com/Platdot-network/Platdot/shared/ethlike"
 	ethtest "github.com/Platdot-network/Platdot/shared/ethlike/testing"
 	eth "github.com/hacpy/go-ethereum"
@@ -33,7 +34,7 @@ func createTestWriter(t *testing.T, cfg *Config, errs chan<- error) (*writer, fu
 
 	bc := chainset.NewChainCore(cfg.name)
 
-	writer := NewWriter(conn, cfg, newTestLogger(cfg.name), *AliceKp, stop, errs, nil, bc)
+	writer := NewWriter(conn, cfg, newTestLogger(cfg.name), stop, errs, nil, bc)
 
 	bridge, err := Bridge.NewBridge(cfg.bridgeContract, conn.Client())
 	if err != nil {
@@ -114,7 +115,7 @@ func TestWriter_start_stop(t *testing.T) {
 	stop := make(chan int)
 
 	bc := chainset.NewChainCore(aliceTestConfig.name)
-	writer := NewWriter(conn, aliceTestConfig, TestLogger, *AliceKp, stop, nil, nil, bc)
+	writer := NewWriter(conn, aliceTestConfig, TestLogger, stop, nil, nil, bc)
 
 	err := writer.start()
 	if err != nil {
@@ -126,7 +127,7 @@ func TestWriter_start_stop(t *testing.T) {
 }
 
 func TestCreateAndExecuteErc20DepositProposal(t *testing.T) {
-	client := ethtest.NewClient(t, TestEndpoint, AliceKp)
+	client := ethtest.NewClient(t, TestEndpoint[config.InitialEndPointId], AliceKp)
 	contracts := deployTestContracts(t, client, TestChainId)
 	writerA, writerB, stopA, stopB, errA, errB := createWriters(t, client, contracts)
 
@@ -153,7 +154,7 @@ func TestCreateAndExecuteErc20DepositProposal(t *testing.T) {
 }
 
 func TestDuplicateMessage(t *testing.T) {
-	client := ethtest.NewClient(t, TestEndpoint, AliceKp)
+	client := ethtest.NewClient(t, TestEndpoint[config.InitialEndPointId], AliceKp)
 	contracts := deployTestContracts(t, client, TestChainId)
 	writerA, writerB, stopA, stopB, errA, errB := createWriters(t, client, contracts)
 
@@ -185,11 +186,11 @@ func TestDuplicateMessage(t *testing.T) {
 	ethtest.Erc20AssertBalance(t, client, amount, erc20Address, recipient)
 
 	// Capture nonces
-	nonceAPre, err := writerA.conn.Client().PendingNonceAt(context.Background(), writerA.conn.Keypair().CommonAddress())
+	nonceAPre, err := writerA.conn.Client().PendingNonceAt(context.Background(), writerA.conn.Address())
 	if err != nil {
 		t.Fatal(err)
 	}
-	nonceBPre, err := writerA.conn.Client().PendingNonceAt(context.Background(), writerB.conn.Keypair().CommonAddress())
+	nonceBPre, err := writerA.conn.Client().PendingNonceAt(context.Background(), writerB.conn.Address())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -211,11 +212,11 @@ func TestDuplicateMessage(t *testing.T) {
 	}
 
 	// Capture new nonces
-	nonceAPost, err := writerA.conn.Client().PendingNonceAt(context.Background(), writerA.conn.Keypair().CommonAddress())
+	nonceAPost, err := writerA.conn.Client().PendingNonceAt(context.Background(), writerA.conn.Address())
 	if err != nil {
 		t.Fatal(err)
 	}
-	nonceBPost, err := writerA.conn.Client().PendingNonceAt(context.Background(), writerB.conn.Keypair().CommonAddress())
+	nonceBPost, err := writerA.conn.Client().PendingNonceAt(context.Background(), writerB.conn.Address())
 	if err != nil {
 		t.Fatal(err)
 	}
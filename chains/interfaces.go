@@ -4,9 +4,58 @@
 package chains
 
 import (
+	"errors"
+	"math/big"
+
 	"github.com/rjman-ljm/platdot-utils/msg"
 )
 
+// ErrNotIssued is returned for the supply of a resource without wrapped token on the chain
+var ErrNotIssued = errors.New("resource not issued by the chain")
+
 type Router interface {
 	Send(message msg.Message) error
 }
+
+// Replayer runs the listener of a chain over a block range in isolation, the blockstore is left untouched
+type Replayer interface {
+	Replay(start uint64, end uint64, r Router) error
+}
+
+// Resolver resolves messages on their destination chain
+type Resolver interface {
+	ResolveMessage(m msg.Message) bool
+	// IsComplete returns whether the message was already resolved on-chain
+	IsComplete(m msg.Message) (bool, error)
+}
+
+// Issuer issues the wrapped tokens of the assets locked on another chain
+type Issuer interface {
+	Id() msg.ChainId
+	// Supply returns the total supply of the wrapped token of a resource
+	Supply(rId msg.ResourceId) (*big.Int, error)
+}
+
+// Custodian locks the assets backing the wrapped tokens of an issuer, and checks they cover the supply
+type Custodian interface {
+	// IssuerId returns the chain issuing the wrapped tokens, if their supply is checked
+	IssuerId() (msg.ChainId, bool)
+	SetIssuer(i Issuer)
+}
+
+// Execution is a deposit executed on its destination chain
+type Execution struct {
+	Source    msg.ChainId
+	Nonce     msg.Nonce
+	Block     uint64
+	Tx        string   // Transaction which executed the deposit
+	Recipient []byte   // Recipient of the transfer, empty for other kinds of deposits
+	Amount    *big.Int // Amount received by the recipient, nil for other kinds of deposits
+}
+
+// Auditor lists the deposits executed on a chain, to reconcile them with the deposits of their source chain
+type Auditor interface {
+	Executions(start uint64, end uint64) ([]Execution, error)
+	// ExpectedAmount returns the amount the recipient of a message should receive on the chain
+	ExpectedAmount(m msg.Message) (*big.Int, error)
+}
@@ -0,0 +1,293 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+/*
+Package lifecycle records the state of every transfer, from its deposit to its execution.
+
+A transfer is identified by its source chain, its destination chain and its deposit nonce. The listener of the source
+chain records it as Observed, then the writer of the destination chain moves it through the states below, recording
+every transition with its time, the chain recording it and the hash of the transaction which caused it, if any.
+
+	Observed  -> Validated, Parked, Voted, Passed, Executed, Failed
+	Validated -> Voted, Passed, Executed, Parked, Failed
+	Parked    -> Validated, Failed
+	Voted     -> Passed, Executed, Refunded, Failed
+	Passed    -> Executed, Failed
+	Failed    -> Validated, Voted, Refunded
+	Executed and Refunded are final.
+
+A deposit rejected by a substrate listener is recorded with its own chain as destination, as its refund is.
+
+Each transfer is persisted in its own file of the lifecycle directory, shared by the chains of a relayer. A transfer
+first seen in a later state, such as a transfer in flight when the relayer was upgraded, starts in that state.
+*/
+package lifecycle
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Platdot-network/Platdot/chains/persist"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+// Chain config option of the lifecycle directory
+const DirOpt = "lifecycleDir"
+
+// State of a transfer
+type State string
+
+const (
+	Observed  State = "Observed"  // Deposit seen by the listener of the source chain
+	Validated State = "Validated" // Passed the screening, the approvals and the limits of the destination writer
+	Voted     State = "Voted"     // Vote or multisig approval submitted by this relayer
+	Passed    State = "Passed"    // Proposal reached the relayer threshold
+	Executed  State = "Executed"
+	Failed    State = "Failed"
+	Refunded  State = "Refunded" // Rejected deposit returned to its depositor
+	Parked    State = "Parked"   // Held by the screening, the approvals or the limits
+)
+
+var transitions = map[State][]State{
+	Observed:  {Validated, Parked, Voted, Passed, Executed, Failed},
+	Validated: {Voted, Passed, Executed, Parked, Failed},
+	Parked:    {Validated, Failed},
+	Voted:     {Passed, Executed, Refunded, Failed},
+	Passed:    {Executed, Failed},
+	Failed:    {Validated, Voted, Refunded},
+}
+
+var ErrInvalidTransition = errors.New("invalid transition")
+
+// CanTransition returns whether a transfer moves from a state to another
+func CanTransition(from State, to State) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Key identifies a transfer
+type Key struct {
+	Source      msg.ChainId
+	Destination msg.ChainId
+	Nonce       msg.Nonce
+}
+
+func KeyOf(m msg.Message) Key {
+	return Key{Source: m.Source, Destination: m.Destination, Nonce: m.DepositNonce}
+}
+
+func (k Key) fileName() string {
+	return fmt.Sprintf("%d-%d-%d.json", k.Source, k.Destination, k.Nonce)
+}
+
+// Transition is a change of the state of a transfer
+type Transition struct {
+	State  State     `json:"state"`
+	Time   time.Time `json:"time"`
+	Chain  string    `json:"chain"` // Chain recording the transition
+	TxHash string    `json:"txHash,omitempty"`
+	Note   string    `json:"note,omitempty"` // Detail of the transition, such as why the transfer failed or is parked
+}
+
+// Transfer is the persisted lifecycle of a transfer
+type Transfer struct {
+	Source      msg.ChainId   `json:"source"`
+	Destination msg.ChainId   `json:"destination"`
+	Nonce       msg.Nonce     `json:"nonce"`
+	ResourceId  string        `json:"resourceId"`
+	Amount      string        `json:"amount,omitempty"` // Amount of the first message recorded, in its payload units
+	State       State         `json:"state"`
+	Transitions []*Transition `json:"transitions"`
+}
+
+// Updated returns the time of the last transition
+func (t *Transfer) Updated() time.Time {
+	return t.Transitions[len(t.Transitions)-1].Time
+}
+
+// DefaultDir returns the lifecycle directory of a blockstore
+func DefaultDir(blockstorePath string) (string, error) {
+	blockstorePath, err := persist.BlockstoreDir(blockstorePath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(blockstorePath, "lifecycle"), nil
+}
+
+// ParseDir returns the lifecycle directory of the chain options, which defaults to a directory of the blockstore
+func ParseDir(opts map[string]string, blockstorePath string) (string, error) {
+	if dir := opts[DirOpt]; dir != "" {
+		return dir, nil
+	}
+	return DefaultDir(blockstorePath)
+}
+
+// Store persists the transfers of a directory
+type Store struct {
+	dir  string
+	lock sync.Mutex
+	now  func() time.Time
+}
+
+var stores = struct {
+	lock sync.Mutex
+	dirs map[string]*Store
+}{
+	dirs: make(map[string]*Store),
+}
+
+// Open returns the store of a directory. The chains sharing a directory share its store, so that the listener of
+// the source chain and the writer of the destination chain never overwrite each other's transitions.
+func Open(dir string) *Store {
+	dir = filepath.Clean(dir)
+	stores.lock.Lock()
+	defer stores.lock.Unlock()
+	s, ok := stores.dirs[dir]
+	if !ok {
+		s = &Store{dir: dir, now: time.Now}
+		stores.dirs[dir] = s
+	}
+	return s
+}
+
+// Record moves the transfer of a message to a state, recorded by the chain, and returns whether it moved. Recording the
+// current state again does nothing, so that the callers report a transition once however often they resolve the
+// transfer. A nil store records nothing and reports every transition as new, so that the listeners replaying blocks
+// don't need to check for one.
+func (s *Store) Record(m msg.Message, to State, chain string, txHash string, note string) (bool, error) {
+	return s.record(m, to, chain, txHash, note, false)
+}
+
+// RecordValidated moves the transfer of a message to Validated, unless it is past it already. The writers validate a
+// transfer again every time they resolve it, which must not move it back.
+func (s *Store) RecordValidated(m msg.Message, chain string) (bool, error) {
+	return s.record(m, Validated, chain, "", "", true)
+}
+
+// record moves a transfer to a state, ignoring an invalid transition if skipInvalid is set
+func (s *Store) record(m msg.Message, to State, chain string, txHash string, note string, skipInvalid bool) (bool, error) {
+	if s == nil {
+		return true, nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := KeyOf(m)
+	t, err := s.load(key)
+	if err != nil {
+		return true, err
+	}
+	if t == nil {
+		t = &Transfer{
+			Source:      m.Source,
+			Destination: m.Destination,
+			Nonce:       m.DepositNonce,
+			ResourceId:  m.ResourceId.Hex(),
+			Amount:      amountOf(m),
+		}
+	} else if t.State == to || (skipInvalid && !CanTransition(t.State, to)) {
+		return false, nil
+	} else if !CanTransition(t.State, to) {
+		return false, fmt.Errorf("%w of transfer %d-%d-%d from %s to %s", ErrInvalidTransition, key.Source, key.Destination, key.Nonce, t.State, to)
+	}
+
+	t.State = to
+	t.Transitions = append(t.Transitions, &Transition{
+		State:  to,
+		Time:   s.now().UTC(),
+		Chain:  chain,
+		TxHash: txHash,
+		Note:   note,
+	})
+	return true, s.save(key, t)
+}
+
+// Get returns a transfer, nil if it was never recorded
+func (s *Store) Get(key Key) (*Transfer, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.load(key)
+}
+
+// amountOf returns the amount of a fungible transfer
+func amountOf(m msg.Message) string {
+	if len(m.Payload) == 0 || m.Type == msg.NonFungibleTransfer || m.Type == msg.GenericTransfer {
+		return ""
+	}
+	if amount, ok := m.Payload[0].([]byte); ok {
+		return new(big.Int).SetBytes(amount).String()
+	}
+	return ""
+}
+
+// load reads a transfer, lock must be held
+func (s *Store) load(key Key) (*Transfer, error) {
+	return readTransfer(filepath.Join(s.dir, key.fileName()))
+}
+
+// save persists a transfer, lock must be held
+func (s *Store) save(key Key, t *Transfer) error {
+	return persist.WriteJSON(filepath.Join(s.dir, key.fileName()), t)
+}
+
+func readTransfer(path string) (*Transfer, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var t Transfer
+	err = json.Unmarshal(data, &t)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transfer %s: %w", filepath.Base(path), err)
+	}
+	if len(t.Transitions) == 0 {
+		return nil, fmt.Errorf("invalid transfer %s: no transition", filepath.Base(path))
+	}
+	return &t, nil
+}
+
+// LoadTransfers reads the transfers of a lifecycle directory, by source, destination and nonce
+func LoadTransfers(dir string) ([]*Transfer, error) {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var res []*Transfer
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		t, err := readTransfer(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, t)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Source != res[j].Source {
+			return res[i].Source < res[j].Source
+		}
+		if res[i].Destination != res[j].Destination {
+			return res[i].Destination < res[j].Destination
+		}
+		return res[i].Nonce < res[j].Nonce
+	})
+	return res, nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package lifecycle
+
+import (
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+func testMessage(nonce msg.Nonce) msg.Message {
+	return msg.NewFungibleTransfer(1, 2, nonce, big.NewInt(10), msg.ResourceIdFromSlice([]byte{1}), []byte("0x01"))
+}
+
+func TestTransitions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lifecycle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := Open(dir)
+	m := testMessage(42)
+
+	for i, state := range []State{Observed, Validated, Voted, Voted, Passed, Executed} {
+		moved, err := s.Record(m, state, "chain", "0xabc", "")
+		if err != nil {
+			t.Fatalf("Recording %s: %v", state, err)
+		}
+		// The repeated vote is reported once
+		if moved != (i != 3) {
+			t.Fatalf("Recording %s: got moved %t", state, moved)
+		}
+	}
+	if _, err = s.Record(m, Failed, "chain", "", "too late"); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("Got %v, expected an invalid transition", err)
+	}
+	// A transfer resolved again isn't validated again
+	if moved, err := s.RecordValidated(m, "chain"); err != nil || moved {
+		t.Fatalf("Got %t %v, expected no transition", moved, err)
+	}
+
+	tr, err := s.Get(KeyOf(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The repeated vote is recorded once
+	if tr.State != Executed || len(tr.Transitions) != 5 || tr.Amount != "10" || tr.Transitions[2].TxHash != "0xabc" {
+		t.Fatalf("Unexpected transfer: %+v", tr)
+	}
+
+	// A transfer first seen in a later state starts in it
+	if _, err = s.Record(testMessage(43), Failed, "chain", "", "vote failed"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Record(testMessage(43), Refunded, "chain", "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// The chains sharing a directory share its store
+	if Open(dir+"/") != s {
+		t.Fatal("Expected the store of the directory")
+	}
+
+	transfers, err := LoadTransfers(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transfers) != 2 || transfers[0].Nonce != 42 || transfers[1].State != Refunded || transfers[1].Transitions[0].Note != "vote failed" {
+		t.Fatalf("Unexpected transfers: %+v", transfers)
+	}
+	if tr, _ = s.Get(Key{Source: 1, Destination: 2, Nonce: 44}); tr != nil {
+		t.Fatalf("Unexpected transfer: %+v", tr)
+	}
+}
+
+func TestNilStore(t *testing.T) {
+	var s *Store
+	if _, err := s.Record(testMessage(1), Observed, "chain", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RecordValidated(testMessage(1), "chain"); err != nil {
+		t.Fatal(err)
+	}
+}
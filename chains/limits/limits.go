@@ -0,0 +1,365 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+/*
+Package limits caps the outflow of a chain over a rolling window.
+
+Transfers are limited per asset, by the total outflow of the asset within the window, the outflow to a single
+recipient within the window and the amount of a single transfer. Amounts are in the smallest unit of the asset on the
+destination chain, as released by its writer: the amount of the proposal of an ethlike writer, which the substrate
+listeners convert to the units of the destination, and the redeemed amount of a substrate writer, fee deducted. A
+transfer over a limit is parked until it fits within the window again, a transfer over the single transfer limit stays
+parked until the relayer is restarted with a higher limit. The transfers of a paused asset are parked until it is
+resumed. The outflows within the window and the parked transfers are persisted in the blockstore directory, so that a
+restart neither resets the window nor loses the parked transfers.
+*/
+package limits
+
+import (
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/ChainSafe/log15"
+	"github.com/Platdot-network/Platdot/chains/persist"
+	"github.com/hacpy/go-ethereum/common"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+// Chain config options of the limits
+const (
+	WindowOpt       = "limitWindow"
+	TotalOpt        = "limitTotal"
+	PerRecipientOpt = "limitPerRecipient"
+	SingleOpt       = "limitSingle"
+)
+
+// Resource id applying a limit to all assets without a limit of their own
+const AnyResource = "*"
+
+// Default length of the rolling window, in seconds
+const DefaultWindow = 24 * 60 * 60
+
+// Time between two attempts to resolve the parked transfers
+var RetryInterval = time.Minute
+
+// Kinds of limits
+const (
+	KindTotal        = "total"
+	KindPerRecipient = "recipient"
+	KindSingle       = "single"
+)
+
+// Limits of an asset, nil limits are unlimited
+type Limits struct {
+	Total        *big.Int
+	PerRecipient *big.Int
+	Single       *big.Int
+}
+
+type Config struct {
+	Window  time.Duration
+	Assets  map[msg.ResourceId]Limits
+	Default Limits // Limits of the assets without limits of their own
+	Path    string // File of the outflows and the parked transfers, kept in memory only if empty
+}
+
+// ParseConfig parses the limits of the chain options. The limits are lists of `resourceId:amount` separated by
+// commas, the resource id `*` applies to all other assets.
+func ParseConfig(opts map[string]string) (*Config, error) {
+	cfg := &Config{Window: DefaultWindow * time.Second, Assets: make(map[msg.ResourceId]Limits)}
+	if window, ok := opts[WindowOpt]; ok {
+		res, err := strconv.ParseUint(window, 10, 32)
+		if err != nil || res == 0 {
+			return nil, fmt.Errorf("invalid %s: %s", WindowOpt, window)
+		}
+		cfg.Window = time.Duration(res) * time.Second
+	}
+
+	for opt, set := range map[string]func(l *Limits, amount *big.Int){
+		TotalOpt:        func(l *Limits, amount *big.Int) { l.Total = amount },
+		PerRecipientOpt: func(l *Limits, amount *big.Int) { l.PerRecipient = amount },
+		SingleOpt:       func(l *Limits, amount *big.Int) { l.Single = amount },
+	} {
+		assets, any, err := ParseAmounts(opt, opts[opt])
+		if err != nil {
+			return nil, err
+		}
+		if any != nil {
+			set(&cfg.Default, any)
+		}
+		for rId, amount := range assets {
+			limits := cfg.Assets[rId]
+			set(&limits, amount)
+			cfg.Assets[rId] = limits
+		}
+	}
+	return cfg, nil
+}
+
+// StatePath returns the file persisting the outflows and the parked transfers of a chain, in the blockstore directory
+func StatePath(blockstorePath string, chain msg.ChainId) (string, error) {
+	dir, err := persist.BlockstoreDir(blockstorePath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, fmt.Sprintf("limits-%d.json", chain)), nil
+}
+
+// ParseAmounts parses the value of an option listing amounts per asset as `resourceId:amount` separated by commas. The
+// amount of the resource id `*` is returned apart, nil if it isn't listed.
+func ParseAmounts(opt string, value string) (map[msg.ResourceId]*big.Int, *big.Int, error) {
+	assets := make(map[msg.ResourceId]*big.Int)
+	var any *big.Int
+	if value == "" {
+		return assets, nil, nil
+	}
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) != 2 {
+			return nil, nil, fmt.Errorf("invalid %s: %s", opt, entry)
+		}
+		amount, ok := new(big.Int).SetString(parts[1], 10)
+		if !ok || amount.Sign() < 0 {
+			return nil, nil, fmt.Errorf("invalid %s amount: %s", opt, parts[1])
+		}
+		if parts[0] == AnyResource {
+			any = amount
+			continue
+		}
+		rId := common.FromHex(parts[0])
+		if len(rId) != 32 {
+			return nil, nil, fmt.Errorf("invalid %s resource id: %s", opt, parts[0])
+		}
+		assets[msg.ResourceIdFromSlice(rId)] = amount
+	}
+	return assets, any, nil
+}
+
+// limitsOf returns the limits of an asset, falling back to the default limits
+func (c *Config) limitsOf(rId msg.ResourceId) Limits {
+	limits, ok := c.Assets[rId]
+	if !ok {
+		return c.Default
+	}
+	if limits.Total == nil {
+		limits.Total = c.Default.Total
+	}
+	if limits.PerRecipient == nil {
+		limits.PerRecipient = c.Default.PerRecipient
+	}
+	if limits.Single == nil {
+		limits.Single = c.Default.Single
+	}
+	return limits
+}
+
+// ExceededError reports a transfer over a limit
+type ExceededError struct {
+	Kind  string
+	Limit *big.Int
+	Used  *big.Int // Outflow within the window, without the transfer
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("%s limit %s exceeded, %s already used", e.Kind, e.Limit, e.Used)
+}
+
+type outflow struct {
+	Source    msg.ChainId `json:"source"`
+	Nonce     msg.Nonce   `json:"nonce"`
+	Time      time.Time   `json:"time"`
+	Resource  string      `json:"resourceId"`
+	Recipient string      `json:"recipient"`
+	Amount    *big.Int    `json:"amount"`
+	rId       msg.ResourceId
+}
+
+func (o *outflow) key() persist.Key {
+	return persist.Key{Source: o.Source, Nonce: o.Nonce}
+}
+
+// state is the persisted state of a limiter
+type state struct {
+	Outflows []*outflow       `json:"outflows"`
+	Parked   *persist.Parking `json:"parked"`
+}
+
+type Limiter struct {
+	cfg      *Config
+	lock     sync.Mutex
+	outflows []*outflow       // Allowed transfers within the window, oldest first
+	parked   *persist.Parking // Transfers over a limit
+	log      log.Logger
+	metrics  *Metrics
+	now      func() time.Time
+}
+
+// NewLimiter returns a limiter of the configured limits, restoring the state persisted by a previous run. metrics may
+// be nil.
+func NewLimiter(cfg *Config, log log.Logger, m *Metrics) (*Limiter, error) {
+	saved := state{Parked: persist.NewParking()}
+	if cfg.Path != "" {
+		err := persist.ReadJSON(cfg.Path, &saved)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, o := range saved.Outflows {
+		o.rId = msg.ResourceIdFromSlice(common.FromHex(o.Resource))
+	}
+	l := &Limiter{
+		cfg:      cfg,
+		outflows: saved.Outflows,
+		parked:   saved.Parked,
+		log:      log,
+		metrics:  m,
+		now:      time.Now,
+	}
+	l.updateMetrics()
+	return l, nil
+}
+
+// Allow records the outflow of the transfer of a message if it fits within the limits of its asset. Otherwise the
+// message is parked and an *ExceededError is returned, or a *PausedError if its asset is paused. A transfer already
+// allowed is allowed again without counting it twice.
+func (l *Limiter) Allow(m msg.Message, amount *big.Int) error {
+	key := persist.KeyOf(m)
+	recipient := fmt.Sprintf("%x", m.Payload[1])
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.expire()
+	for _, o := range l.outflows {
+		if o.key() == key {
+			return nil
+		}
+	}
+
+	if reason, ok := pausedReason(m); ok {
+		l.park(m)
+		return &PausedError{Reason: reason}
+	}
+	err := l.check(m.ResourceId, recipient, amount)
+	if err != nil {
+		l.park(m)
+		if l.metrics != nil {
+			l.metrics.Exceeded.WithLabelValues(err.Kind).Inc()
+		}
+		return err
+	}
+
+	l.parked.Remove(key)
+	l.outflows = append(l.outflows, &outflow{
+		Source:    m.Source,
+		Nonce:     m.DepositNonce,
+		Time:      l.now(),
+		Resource:  m.ResourceId.Hex(),
+		Recipient: recipient,
+		Amount:    new(big.Int).Set(amount),
+		rId:       m.ResourceId,
+	})
+	l.save()
+	l.updateMetrics()
+	return nil
+}
+
+// park keeps the message until it is resolved again, lock must be held
+func (l *Limiter) park(m msg.Message) {
+	l.parked.Park(m)
+	l.save()
+	l.updateMetrics()
+}
+
+// save persists the state of the limiter, lock must be held. A failure is only logged, the limiter keeps its state
+// in memory.
+func (l *Limiter) save() {
+	if l.cfg.Path == "" {
+		return
+	}
+	err := persist.WriteJSON(l.cfg.Path, &state{Outflows: l.outflows, Parked: l.parked})
+	if err != nil {
+		l.log.Error("Failed to persist the limits", "path", l.cfg.Path, "err", err)
+	}
+}
+
+// check returns the limit the transfer exceeds, lock must be held
+func (l *Limiter) check(rId msg.ResourceId, recipient string, amount *big.Int) *ExceededError {
+	limits := l.cfg.limitsOf(rId)
+	if limits.Single != nil && amount.Cmp(limits.Single) > 0 {
+		return &ExceededError{Kind: KindSingle, Limit: limits.Single, Used: big.NewInt(0)}
+	}
+
+	total := big.NewInt(0)
+	toRecipient := big.NewInt(0)
+	for _, o := range l.outflows {
+		if o.rId != rId {
+			continue
+		}
+		total.Add(total, o.Amount)
+		if o.Recipient == recipient {
+			toRecipient.Add(toRecipient, o.Amount)
+		}
+	}
+	if limits.Total != nil && new(big.Int).Add(total, amount).Cmp(limits.Total) > 0 {
+		return &ExceededError{Kind: KindTotal, Limit: limits.Total, Used: total}
+	}
+	if limits.PerRecipient != nil && new(big.Int).Add(toRecipient, amount).Cmp(limits.PerRecipient) > 0 {
+		return &ExceededError{Kind: KindPerRecipient, Limit: limits.PerRecipient, Used: toRecipient}
+	}
+	return nil
+}
+
+// expire forgets the outflows older than the window, lock must be held
+func (l *Limiter) expire() {
+	oldest := l.now().Add(-l.cfg.Window)
+	i := 0
+	for i < len(l.outflows) && !l.outflows[i].Time.After(oldest) {
+		i++
+	}
+	l.outflows = l.outflows[i:]
+}
+
+// Parked returns the parked messages, by source and nonce
+func (l *Limiter) Parked() []msg.Message {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.parked.Messages()
+}
+
+// Run resolves the parked messages every RetryInterval until stop is closed, resolve is expected to call Allow again
+func (l *Limiter) Run(resolve func(m msg.Message), stop <-chan int) {
+	persist.Run(RetryInterval, func() []msg.Message {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		l.expire()
+		l.updateMetrics()
+		return l.parked.Messages()
+	}, resolve, stop)
+}
+
+// updateMetrics exposes the outflows and parked transfers, lock must be held
+func (l *Limiter) updateMetrics() {
+	if l.metrics == nil {
+		return
+	}
+	l.metrics.Parked.Set(float64(l.parked.Len()))
+	outflows := make(map[msg.ResourceId]*big.Int)
+	for rId := range l.cfg.Assets {
+		outflows[rId] = big.NewInt(0)
+	}
+	for _, o := range l.outflows {
+		if outflows[o.rId] == nil {
+			outflows[o.rId] = big.NewInt(0)
+		}
+		outflows[o.rId].Add(outflows[o.rId], o.Amount)
+	}
+	for rId, total := range outflows {
+		value, _ := new(big.Float).SetInt(total).Float64()
+		l.metrics.Outflow.WithLabelValues(rId.Hex()).Set(value)
+	}
+}
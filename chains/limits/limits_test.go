@@ -0,0 +1,183 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package limits
+
+import (
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ChainSafe/log15"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+var testResource = msg.ResourceIdFromSlice([]byte{1})
+var otherResource = msg.ResourceIdFromSlice([]byte{2})
+
+func newTestMessage(rId msg.ResourceId, nonce msg.Nonce, recipient string) msg.Message {
+	return msg.NewMultiSigTransfer(1, 2, nonce, big.NewInt(0), rId, []byte(recipient))
+}
+
+func newTestLimiter(t *testing.T, opts map[string]string) (*Limiter, *time.Time) {
+	cfg, err := ParseConfig(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1600000000, 0)
+	l, err := NewLimiter(cfg, log15.New(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func assertExceeded(t *testing.T, err error, kind string) {
+	var exceeded *ExceededError
+	if !errors.As(err, &exceeded) {
+		t.Fatalf("expected %s limit exceeded, got %v", kind, err)
+	}
+	if exceeded.Kind != kind {
+		t.Fatalf("expected %s limit exceeded, got %s", kind, exceeded.Kind)
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := ParseConfig(map[string]string{
+		WindowOpt:       "3600",
+		TotalOpt:        testResource.Hex() + ":1000, *:10",
+		PerRecipientOpt: testResource.Hex() + ":100",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Window != time.Hour {
+		t.Fatalf("expected a window of an hour, got %s", cfg.Window)
+	}
+
+	limits := cfg.limitsOf(testResource)
+	if limits.Total.Int64() != 1000 || limits.PerRecipient.Int64() != 100 || limits.Single != nil {
+		t.Fatalf("unexpected limits %+v", limits)
+	}
+	limits = cfg.limitsOf(otherResource)
+	if limits.Total.Int64() != 10 || limits.PerRecipient != nil || limits.Single != nil {
+		t.Fatalf("unexpected default limits %+v", limits)
+	}
+
+	for _, opts := range []map[string]string{
+		{WindowOpt: "0"},
+		{TotalOpt: "1000"},
+		{SingleOpt: "0x01:1000"},
+		{PerRecipientOpt: "*:-1"},
+	} {
+		if _, err := ParseConfig(opts); err == nil {
+			t.Fatalf("expected %v to be refused", opts)
+		}
+	}
+}
+
+func TestAllow(t *testing.T) {
+	l, now := newTestLimiter(t, map[string]string{
+		TotalOpt:        "*:100",
+		PerRecipientOpt: "*:60",
+		SingleOpt:       "*:50",
+	})
+
+	err := l.Allow(newTestMessage(testResource, 1, "alice"), big.NewInt(51))
+	assertExceeded(t, err, KindSingle)
+	err = l.Allow(newTestMessage(testResource, 2, "alice"), big.NewInt(40))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Allow(newTestMessage(testResource, 3, "alice"), big.NewInt(30))
+	assertExceeded(t, err, KindPerRecipient)
+	err = l.Allow(newTestMessage(testResource, 4, "bob"), big.NewInt(50))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Allow(newTestMessage(testResource, 5, "charlie"), big.NewInt(20))
+	assertExceeded(t, err, KindTotal)
+
+	// Other assets have limits of their own
+	err = l.Allow(newTestMessage(otherResource, 6, "alice"), big.NewInt(50))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A transfer already allowed is not counted twice
+	err = l.Allow(newTestMessage(testResource, 2, "alice"), big.NewInt(40))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parked := l.Parked()
+	if len(parked) != 3 || parked[0].DepositNonce != 1 || parked[1].DepositNonce != 3 || parked[2].DepositNonce != 5 {
+		t.Fatalf("unexpected parked transfers %v", parked)
+	}
+
+	// Once the window moves on, the parked transfers fit again, except the one over the single transfer limit
+	*now = now.Add(DefaultWindow * time.Second)
+	for _, m := range parked {
+		_ = l.Allow(m, big.NewInt(map[msg.Nonce]int64{1: 51, 3: 30, 5: 20}[m.DepositNonce]))
+	}
+	parked = l.Parked()
+	if len(parked) != 1 || parked[0].DepositNonce != 1 {
+		t.Fatalf("unexpected parked transfers %v", parked)
+	}
+}
+
+func TestParkedPayloadIsCopied(t *testing.T) {
+	l, _ := newTestLimiter(t, map[string]string{SingleOpt: "*:0"})
+
+	m := newTestMessage(testResource, 1, "alice")
+	assertExceeded(t, l.Allow(m, big.NewInt(1)), KindSingle)
+	m.Payload[1] = []byte("bob")
+
+	parked := l.Parked()
+	if string(parked[0].Payload[1].([]byte)) != "alice" {
+		t.Fatalf("expected the payload as received, got %s", parked[0].Payload[1])
+	}
+}
+
+func TestRestoreState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "limits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	opts := map[string]string{TotalOpt: "*:100"}
+	newLimiter := func() *Limiter {
+		cfg, err := ParseConfig(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.Path = filepath.Join(dir, "limits-1.json")
+		l, err := NewLimiter(cfg, log15.New(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+
+	l := newLimiter()
+	if err = l.Allow(newTestMessage(testResource, 1, "alice"), big.NewInt(80)); err != nil {
+		t.Fatal(err)
+	}
+	assertExceeded(t, l.Allow(newTestMessage(testResource, 2, "bob"), big.NewInt(30)), KindTotal)
+
+	// A restart neither resets the window nor loses the parked transfers
+	l = newLimiter()
+	parked := l.Parked()
+	if len(parked) != 1 || parked[0].DepositNonce != 2 || string(parked[0].Payload[1].([]byte)) != "bob" {
+		t.Fatalf("unexpected parked transfers %v", parked)
+	}
+	assertExceeded(t, l.Allow(parked[0], big.NewInt(30)), KindTotal)
+	if err = l.Allow(newTestMessage(testResource, 1, "alice"), big.NewInt(80)); err != nil {
+		t.Fatal(err)
+	}
+}
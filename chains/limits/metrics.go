@@ -0,0 +1,40 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package limits
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics exposes the outflow of a chain within the window and the transfers parked over a limit
+type Metrics struct {
+	Outflow  *prometheus.GaugeVec
+	Parked   prometheus.Gauge
+	Exceeded *prometheus.CounterVec
+}
+
+func NewMetrics(chain string) *Metrics {
+	metrics := &Metrics{
+		Outflow: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: fmt.Sprintf("%s_limit_outflow", chain),
+			Help: "Outflow of the asset within the window of the limits",
+		}, []string{"resource"}),
+		Parked: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: fmt.Sprintf("%s_limit_parked", chain),
+			Help: "Number of transfers parked over a limit",
+		}),
+		Exceeded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: fmt.Sprintf("%s_limit_exceeded", chain),
+			Help: "Number of times a transfer exceeded a limit, by kind of limit",
+		}, []string{"limit"}),
+	}
+
+	prometheus.MustRegister(metrics.Outflow)
+	prometheus.MustRegister(metrics.Parked)
+	prometheus.MustRegister(metrics.Exceeded)
+
+	return metrics
+}
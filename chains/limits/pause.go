@@ -0,0 +1,71 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package limits
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+// pauseKey is an asset bridged between two chains, in any direction
+type pauseKey struct {
+	rId    msg.ResourceId
+	chainA msg.ChainId
+	chainB msg.ChainId
+}
+
+func newPauseKey(rId msg.ResourceId, a, b msg.ChainId) pauseKey {
+	if a > b {
+		a, b = b, a
+	}
+	return pauseKey{rId, a, b}
+}
+
+// The assets paused across all writers, with the reason they were paused
+var paused = struct {
+	lock   sync.RWMutex
+	assets map[pauseKey]string
+}{
+	assets: make(map[pauseKey]string),
+}
+
+// PausedError reports a transfer of a paused asset
+type PausedError struct {
+	Reason string
+}
+
+func (e *PausedError) Error() string {
+	return fmt.Sprintf("asset paused: %s", e.Reason)
+}
+
+// Pause stops the writers of both chains from transferring the asset until it is resumed, the transfers are parked
+// meanwhile. It returns whether the asset was running.
+func Pause(rId msg.ResourceId, a, b msg.ChainId, reason string) bool {
+	paused.lock.Lock()
+	defer paused.lock.Unlock()
+	key := newPauseKey(rId, a, b)
+	_, ok := paused.assets[key]
+	paused.assets[key] = reason
+	return !ok
+}
+
+// Resume lets the writers transfer the asset again, it returns whether the asset was paused
+func Resume(rId msg.ResourceId, a, b msg.ChainId) bool {
+	paused.lock.Lock()
+	defer paused.lock.Unlock()
+	key := newPauseKey(rId, a, b)
+	_, ok := paused.assets[key]
+	delete(paused.assets, key)
+	return ok
+}
+
+// pausedReason returns why the asset of the message is paused, if it is
+func pausedReason(m msg.Message) (string, bool) {
+	paused.lock.RLock()
+	defer paused.lock.RUnlock()
+	reason, ok := paused.assets[newPauseKey(m.ResourceId, m.Source, m.Destination)]
+	return reason, ok
+}
@@ -0,0 +1,164 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package persist
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hacpy/go-ethereum/common"
+	"github.com/hacpy/go-ethereum/common/hexutil"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+// Key identifies a transfer to a chain by its source and its deposit nonce
+type Key struct {
+	Source msg.ChainId
+	Nonce  msg.Nonce
+}
+
+// KeyOf returns the key of the transfer of a message
+func KeyOf(m msg.Message) Key {
+	return Key{Source: m.Source, Nonce: m.DepositNonce}
+}
+
+// Message is the persisted form of the message of a transfer, whose payload only holds bytes
+type Message struct {
+	Source      msg.ChainId      `json:"source"`
+	Destination msg.ChainId      `json:"destination"`
+	Nonce       msg.Nonce        `json:"nonce"`
+	Type        msg.TransferType `json:"type"`
+	ResourceId  string           `json:"resourceId"`
+	Payload     []hexutil.Bytes  `json:"payload"`
+}
+
+func NewMessage(m msg.Message) (*Message, error) {
+	res := &Message{
+		Source:      m.Source,
+		Destination: m.Destination,
+		Nonce:       m.DepositNonce,
+		Type:        m.Type,
+		ResourceId:  m.ResourceId.Hex(),
+	}
+	for _, p := range m.Payload {
+		b, ok := p.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("unsupported payload of a %s", m.Type)
+		}
+		res.Payload = append(res.Payload, append([]byte(nil), b...))
+	}
+	return res, nil
+}
+
+// Message returns the message of the transfer
+func (m *Message) Message() msg.Message {
+	payload := make([]interface{}, len(m.Payload))
+	for i, p := range m.Payload {
+		payload[i] = []byte(p)
+	}
+	return msg.Message{
+		Source:       m.Source,
+		Destination:  m.Destination,
+		Type:         m.Type,
+		DepositNonce: m.Nonce,
+		ResourceId:   msg.ResourceIdFromSlice(common.FromHex(m.ResourceId)),
+		Payload:      payload,
+	}
+}
+
+// Parking holds the messages of the transfers held back by a writer, with their payload as received. It is not safe
+// for concurrent use, its owner guards it with its own lock.
+type Parking struct {
+	messages map[Key]msg.Message
+}
+
+func NewParking() *Parking {
+	return &Parking{messages: make(map[Key]msg.Message)}
+}
+
+// Park keeps the message of a transfer until it is removed, replacing the message parked for the transfer if any
+func (p *Parking) Park(m msg.Message) {
+	// The writers rewrite the payload in place, park a copy as received
+	m.Payload = append([]interface{}(nil), m.Payload...)
+	p.messages[KeyOf(m)] = m
+}
+
+// Get returns the message parked for a transfer
+func (p *Parking) Get(key Key) (msg.Message, bool) {
+	m, ok := p.messages[key]
+	return m, ok
+}
+
+// Remove releases a transfer, it returns whether it was parked
+func (p *Parking) Remove(key Key) bool {
+	_, ok := p.messages[key]
+	delete(p.messages, key)
+	return ok
+}
+
+func (p *Parking) Len() int {
+	return len(p.messages)
+}
+
+// Messages returns the parked messages, by source and nonce
+func (p *Parking) Messages() []msg.Message {
+	res := make([]msg.Message, 0, len(p.messages))
+	for _, m := range p.messages {
+		res = append(res, m)
+	}
+	SortMessages(res)
+	return res
+}
+
+// MarshalJSON encodes the parked messages by source and nonce, see Message
+func (p *Parking) MarshalJSON() ([]byte, error) {
+	messages := make([]*Message, 0, len(p.messages))
+	for _, m := range p.Messages() {
+		saved, err := NewMessage(m)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, saved)
+	}
+	return json.Marshal(messages)
+}
+
+func (p *Parking) UnmarshalJSON(data []byte) error {
+	var messages []*Message
+	err := json.Unmarshal(data, &messages)
+	if err != nil {
+		return err
+	}
+	p.messages = make(map[Key]msg.Message)
+	for _, m := range messages {
+		p.Park(m.Message())
+	}
+	return nil
+}
+
+// SortMessages sorts messages by source and nonce
+func SortMessages(messages []msg.Message) {
+	sort.Slice(messages, func(i, j int) bool {
+		if messages[i].Source != messages[j].Source {
+			return messages[i].Source < messages[j].Source
+		}
+		return messages[i].DepositNonce < messages[j].DepositNonce
+	})
+}
+
+// Run polls every interval until stop is closed, and resolves the messages returned by poll
+func Run(interval time.Duration, poll func() []msg.Message, resolve func(m msg.Message), stop <-chan int) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+			for _, m := range poll() {
+				resolve(m)
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+/*
+Package persist holds the helpers shared by the stores of the relayer.
+
+The stores keep their files in the blockstore directory by default, see BlockstoreDir, and replace them with
+WriteFile so that a crash never leaves a file partially written. The writers park the transfers they hold back in a
+Parking, which they resolve again with Run.
+*/
+package persist
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/rjman-ljm/platdot-utils/blockstore"
+)
+
+// BlockstoreDir returns the blockstore directory, which defaults to a directory of the home of the user
+func BlockstoreDir(blockstorePath string) (string, error) {
+	if blockstorePath != "" {
+		return blockstorePath, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, blockstore.PathPostfix), nil
+}
+
+// WriteFile replaces a file at once, so that readers never see it partially written. The data and the directory are
+// synced before the rename and the directory again after it, so that a crash leaves either the previous or the new
+// content.
+func WriteFile(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = syncDir(dir)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// WriteJSON replaces a file with the indented JSON of v, see WriteFile
+func WriteJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFile(path, data)
+}
+
+// ReadJSON decodes the JSON of a file into v, it leaves v as it is if the file doesn't exist
+func ReadJSON(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
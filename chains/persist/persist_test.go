@@ -0,0 +1,59 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package persist
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "persist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "store", "records.json")
+
+	for _, content := range []string{"first", "second"} {
+		if err = WriteFile(path, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Fatalf("Got %s, expected %s", data, content)
+		}
+	}
+	if _, err = os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("Expected the temporary file to be renamed, got %v", err)
+	}
+}
+
+func TestParking(t *testing.T) {
+	p := NewParking()
+	rId := msg.ResourceIdFromSlice([]byte{1})
+	second := msg.NewMultiSigTransfer(2, 1, 1, big.NewInt(10), rId, []byte("bob"))
+	first := msg.NewMultiSigTransfer(1, 2, 7, big.NewInt(10), rId, []byte("alice"))
+	p.Park(second)
+	p.Park(first)
+
+	// The parked payload is kept as received
+	first.Payload[1] = []byte("rewritten")
+	parked := p.Messages()
+	if len(parked) != 2 || parked[0].Source != 1 || string(parked[0].Payload[1].([]byte)) != "alice" {
+		t.Fatalf("Unexpected parked messages: %v", parked)
+	}
+
+	if !p.Remove(KeyOf(second)) || p.Remove(KeyOf(second)) || p.Len() != 1 {
+		t.Fatalf("Unexpected parking after a removal: %v", p.Messages())
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package refunds
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics exposes the rejected deposits
+type Metrics struct {
+	Rejected  prometheus.Gauge
+	Refunding prometheus.Gauge
+}
+
+func NewMetrics(chain string) *Metrics {
+	metrics := &Metrics{
+		Rejected: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: fmt.Sprintf("%s_refunds_rejected", chain),
+			Help: "Number of rejected deposits kept in the multiSig",
+		}),
+		Refunding: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: fmt.Sprintf("%s_refunds_pending", chain),
+			Help: "Number of rejected deposits awaiting their refund",
+		}),
+	}
+
+	prometheus.MustRegister(metrics.Rejected, metrics.Refunding)
+
+	return metrics
+}
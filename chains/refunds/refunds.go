@@ -0,0 +1,340 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+/*
+Package refunds records the deposits a listener rejects, and returns them to their depositor if configured.
+
+A deposit below the minimum of its asset, or too low to pay the handling fee, is not relayed. It is recorded in the
+refund directory of the chain instead, with the reason it was rejected. If refunds are enabled, the deposit minus the
+network cost of its asset is sent back to the depositor through the multiSig, as a RefundTransfer message resolved by
+the writer of the chain it was made on.
+
+A deposit whose remark is malformed or names an unsupported destination is refunded after a grace period, counted in
+blocks so that every relayer releases the refund at the same height.
+
+Every relayer builds the refunds from its own config, so the minimums and the costs must be the same on all of them.
+*/
+package refunds
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Platdot-network/Platdot/chains/limits"
+	"github.com/Platdot-network/Platdot/chains/persist"
+	"github.com/hacpy/go-ethereum/common"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+// Chain config options of the refunds
+const (
+	MinimumOpt = "minDeposit"
+	RefundOpt  = "refund"
+	CostOpt    = "refundCost"
+	DirOpt     = "refundDir"
+	GraceOpt   = "refundGraceBlocks"
+)
+
+// Blocks a deposit with an invalid remark waits before its refund, about a day with 6s blocks
+const DefaultGraceBlocks uint64 = 14400
+
+// RefundTransfer returns a rejected deposit to its depositor, on the chain it was made on
+var RefundTransfer msg.TransferType = "RefundTransfer"
+
+// Reasons of a rejection
+const (
+	ReasonBelowMinimum = "below the minimum deposit"
+	ReasonFee          = "too low to pay the handling fee"
+	ReasonRemark       = "malformed remark"
+	ReasonDestination  = "unsupported destination or resource"
+)
+
+// needsGrace returns whether the refund of a deposit rejected for the reason waits for the grace period
+func needsGrace(reason string) bool {
+	return reason == ReasonRemark || reason == ReasonDestination
+}
+
+// Status of a rejected deposit
+const (
+	StatusRejected  = "rejected"  // Kept in the multiSig
+	StatusWaiting   = "waiting"   // Refunded once the grace period is over
+	StatusRefunding = "refunding" // Refund submitted to the writer
+	StatusRefunded  = "refunded"
+)
+
+const recordsFile = "refunds.json"
+
+type Config struct {
+	Dir            string                      // Directory of the records
+	Minimums       map[msg.ResourceId]*big.Int // Deposits below the minimum of their asset are rejected
+	DefaultMinimum *big.Int                    // Minimum of the assets without minimum of their own
+	Refund         bool                        // Whether rejected deposits are refunded
+	Costs          map[msg.ResourceId]*big.Int // Network cost deducted from the refunds of an asset
+	DefaultCost    *big.Int                    // Cost of the assets without cost of their own
+	GraceBlocks    uint64                      // Blocks a deposit with an invalid remark waits before its refund
+}
+
+// ParseConfig parses the minimums and the refund options of the chain, see limits.ParseAmounts for the format of the
+// amounts, given in the units of the chain. The refund directory defaults to a directory of the blockstore.
+func ParseConfig(opts map[string]string, blockstorePath string, chain msg.ChainId) (*Config, error) {
+	cfg := &Config{GraceBlocks: DefaultGraceBlocks}
+	var err error
+	if value := opts[MinimumOpt]; value != "" {
+		cfg.Minimums, cfg.DefaultMinimum, err = limits.ParseAmounts(MinimumOpt, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if value := opts[CostOpt]; value != "" {
+		cfg.Costs, cfg.DefaultCost, err = limits.ParseAmounts(CostOpt, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if value := opts[RefundOpt]; value != "" {
+		cfg.Refund, err = strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", RefundOpt, value)
+		}
+	}
+	if value := opts[GraceOpt]; value != "" {
+		cfg.GraceBlocks, err = strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", GraceOpt, value)
+		}
+	}
+
+	cfg.Dir = opts[DirOpt]
+	if cfg.Dir == "" {
+		blockstorePath, err = persist.BlockstoreDir(blockstorePath)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Dir = filepath.Join(blockstorePath, fmt.Sprintf("refunds-%d", chain))
+	}
+	return cfg, nil
+}
+
+// Minimum returns the minimum deposit of an asset, nil if there is none
+func (c *Config) Minimum(rId msg.ResourceId) *big.Int {
+	if minimum, ok := c.Minimums[rId]; ok {
+		return minimum
+	}
+	return c.DefaultMinimum
+}
+
+// cost returns the network cost deducted from the refunds of an asset
+func (c *Config) cost(rId msg.ResourceId) *big.Int {
+	if cost, ok := c.Costs[rId]; ok {
+		return cost
+	}
+	if c.DefaultCost != nil {
+		return c.DefaultCost
+	}
+	return big.NewInt(0)
+}
+
+// Record is a rejected deposit
+type Record struct {
+	Nonce      msg.Nonce  `json:"nonce"` // Deposit nonce of the rejected deposit
+	Block      uint64     `json:"block"`
+	Index      int        `json:"index"` // Index of the extrinsic in the block
+	Depositor  string     `json:"depositor"`
+	ResourceId string     `json:"resourceId"`
+	Amount     string     `json:"amount"`           // Deposited amount
+	Refund     string     `json:"refund,omitempty"` // Refunded amount, network cost deducted
+	Reason     string     `json:"reason"`
+	Status     string     `json:"status"`
+	Due        uint64     `json:"due,omitempty"` // Block from which a waiting refund is released
+	Observed   time.Time  `json:"observed"`
+	Refunded   *time.Time `json:"refunded,omitempty"`
+}
+
+// Message returns the refund of a record, on the chain the deposit was made on
+func (r *Record) Message(chain msg.ChainId) msg.Message {
+	refund, _ := new(big.Int).SetString(r.Refund, 10)
+	return msg.Message{
+		Source:       chain,
+		Destination:  chain,
+		Type:         RefundTransfer,
+		DepositNonce: r.Nonce,
+		ResourceId:   msg.ResourceIdFromSlice(common.FromHex(r.ResourceId)),
+		Payload:      []interface{}{refund.Bytes(), []byte(r.Depositor)},
+	}
+}
+
+// LoadRecords reads the records of a refund directory, by nonce
+func LoadRecords(dir string) ([]*Record, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, recordsFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var records []*Record
+	err = json.Unmarshal(data, &records)
+	if err != nil {
+		return nil, fmt.Errorf("invalid refund records: %w", err)
+	}
+	return records, nil
+}
+
+// Store persists the rejected deposits of a chain, shared by its listener and its writer
+type Store struct {
+	cfg     *Config
+	lock    sync.Mutex
+	records map[msg.Nonce]*Record
+	metrics *Metrics
+	now     func() time.Time
+}
+
+// NewStore loads the records of the refund directory. metrics may be nil.
+func NewStore(cfg *Config, m *Metrics) (*Store, error) {
+	records, err := LoadRecords(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{
+		cfg:     cfg,
+		records: make(map[msg.Nonce]*Record),
+		metrics: m,
+		now:     time.Now,
+	}
+	for _, r := range records {
+		s.records[r.Nonce] = r
+	}
+	s.updateMetrics()
+	return s, nil
+}
+
+// Minimum returns the minimum deposit of an asset, nil if there is none
+func (s *Store) Minimum(rId msg.ResourceId) *big.Int {
+	return s.cfg.Minimum(rId)
+}
+
+// Reject records a rejected deposit. If refunds are enabled and the deposit covers the network cost, the deposit is
+// to be refunded, after the grace period if the reason needs one. It returns the record, and whether it is new.
+func (s *Store) Reject(nonce msg.Nonce, block uint64, index int, depositor string, rId msg.ResourceId, amount *big.Int, reason string) (*Record, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if r, ok := s.records[nonce]; ok {
+		return r, false, nil
+	}
+
+	r := &Record{
+		Nonce:      nonce,
+		Block:      block,
+		Index:      index,
+		Depositor:  depositor,
+		ResourceId: rId.Hex(),
+		Amount:     amount.String(),
+		Reason:     reason,
+		Status:     StatusRejected,
+		Observed:   s.now(),
+	}
+	if s.cfg.Refund {
+		refund := new(big.Int).Sub(amount, s.cfg.cost(rId))
+		if refund.Sign() > 0 {
+			r.Refund = refund.String()
+			r.Status = StatusRefunding
+			if needsGrace(reason) {
+				r.Status = StatusWaiting
+				r.Due = block + s.cfg.GraceBlocks
+			}
+		}
+	}
+	s.records[nonce] = r
+	err := s.save()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to record the rejected deposit: %w", err)
+	}
+	return r, true, nil
+}
+
+// Refunding returns the records whose refund is not executed yet, by nonce
+func (s *Store) Refunding() []*Record {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var res []*Record
+	for _, r := range s.sorted() {
+		if r.Status == StatusRefunding {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// Release returns the waiting refunds whose grace period is over at the block, now to be refunded
+func (s *Store) Release(block uint64) ([]*Record, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var res []*Record
+	for _, r := range s.sorted() {
+		if r.Status == StatusWaiting && r.Due <= block {
+			r.Status = StatusRefunding
+			res = append(res, r)
+		}
+	}
+	if len(res) == 0 {
+		return nil, nil
+	}
+	return res, s.save()
+}
+
+// Refunded marks the refund of a deposit as executed
+func (s *Store) Refunded(nonce msg.Nonce) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	r, ok := s.records[nonce]
+	if !ok || r.Status != StatusRefunding {
+		return nil
+	}
+	now := s.now()
+	r.Refunded = &now
+	r.Status = StatusRefunded
+	return s.save()
+}
+
+// sorted returns the records by nonce, lock must be held
+func (s *Store) sorted() []*Record {
+	res := make([]*Record, 0, len(s.records))
+	for _, r := range s.records {
+		res = append(res, r)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Nonce < res[j].Nonce
+	})
+	return res
+}
+
+// save persists the records, lock must be held
+func (s *Store) save() error {
+	s.updateMetrics()
+	return persist.WriteJSON(filepath.Join(s.cfg.Dir, recordsFile), s.sorted())
+}
+
+// updateMetrics exposes the rejected deposits and the pending refunds, lock must be held
+func (s *Store) updateMetrics() {
+	if s.metrics == nil {
+		return
+	}
+	rejected, refunding := 0, 0
+	for _, r := range s.records {
+		switch r.Status {
+		case StatusRejected:
+			rejected++
+		case StatusWaiting, StatusRefunding:
+			refunding++
+		}
+	}
+	s.metrics.Rejected.Set(float64(rejected))
+	s.metrics.Refunding.Set(float64(refunding))
+}
@@ -0,0 +1,133 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package refunds
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+var testResource = msg.ResourceIdFromSlice([]byte{1})
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := ParseConfig(map[string]string{}, "/tmp/blockstore", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Dir != "/tmp/blockstore/refunds-1" || cfg.Refund || cfg.Minimum(testResource) != nil {
+		t.Fatalf("Unexpected config: %+v", cfg)
+	}
+
+	cfg, err = ParseConfig(map[string]string{MinimumOpt: testResource.Hex() + ":10,*:5", RefundOpt: "true", CostOpt: "*:2"}, "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Refund || cfg.Minimum(testResource).Int64() != 10 || cfg.Minimum(msg.ResourceId{}).Int64() != 5 || cfg.cost(testResource).Int64() != 2 {
+		t.Fatalf("Unexpected config: %+v", cfg)
+	}
+
+	if _, err = ParseConfig(map[string]string{RefundOpt: "maybe"}, "", 1); err == nil {
+		t.Fatal("Expected an error for an invalid refund option")
+	}
+}
+
+func TestRefunds(t *testing.T) {
+	dir, err := ioutil.TempDir("", "refunds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &Config{Dir: dir, Refund: true, Costs: map[msg.ResourceId]*big.Int{testResource: big.NewInt(3)}}
+	s, err := NewStore(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, added, err := s.Reject(42, 4, 2, "0x01", testResource, big.NewInt(10), ReasonFee)
+	if err != nil || !added {
+		t.Fatalf("Got %v %v, expected a new record", added, err)
+	}
+	if r.Status != StatusRefunding || r.Refund != "7" {
+		t.Fatalf("Unexpected record: %+v", r)
+	}
+	m := r.Message(2)
+	if m.Type != RefundTransfer || m.Source != 2 || m.Destination != 2 || m.DepositNonce != 42 || m.ResourceId != testResource ||
+		big.NewInt(0).SetBytes(m.Payload[0].([]byte)).Int64() != 7 || string(m.Payload[1].([]byte)) != "0x01" {
+		t.Fatalf("Unexpected refund: %+v", m)
+	}
+
+	// A deposit which doesn't cover the network cost is kept
+	r, _, err = s.Reject(43, 4, 3, "0x02", testResource, big.NewInt(3), ReasonBelowMinimum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Status != StatusRejected || r.Refund != "" {
+		t.Fatalf("Unexpected record: %+v", r)
+	}
+
+	// A deposit seen again is not recorded twice
+	if _, added, _ = s.Reject(42, 4, 2, "0x01", testResource, big.NewInt(10), ReasonFee); added {
+		t.Fatal("Expected the deposit to be known")
+	}
+
+	// The records survive a restart
+	s, err = NewStore(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refunding := s.Refunding()
+	if len(refunding) != 1 || refunding[0].Nonce != 42 {
+		t.Fatalf("Unexpected refunds: %+v", refunding)
+	}
+	if err = s.Refunded(42); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Refunding()) != 0 {
+		t.Fatal("Expected no refund left")
+	}
+
+	records, err := LoadRecords(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Status != StatusRefunded || records[0].Refunded == nil || records[1].Status != StatusRejected {
+		t.Fatalf("Unexpected records: %+v", records)
+	}
+}
+
+func TestGracePeriod(t *testing.T) {
+	dir, err := ioutil.TempDir("", "refunds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := NewStore(&Config{Dir: dir, Refund: true, GraceBlocks: 10}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, _, err := s.Reject(42, 4, 2, "0x01", testResource, big.NewInt(10), ReasonRemark)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Status != StatusWaiting || r.Due != 14 || len(s.Refunding()) != 0 {
+		t.Fatalf("Unexpected record: %+v", r)
+	}
+
+	released, err := s.Release(13)
+	if err != nil || len(released) != 0 {
+		t.Fatalf("Got %v %v, expected no refund before the end of the grace period", released, err)
+	}
+	released, err = s.Release(14)
+	if err != nil || len(released) != 1 || released[0].Status != StatusRefunding {
+		t.Fatalf("Got %v %v, expected the refund to be released", released, err)
+	}
+	if released, _ = s.Release(15); len(released) != 0 {
+		t.Fatal("Expected the refund to be released once")
+	}
+}
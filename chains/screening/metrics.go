@@ -0,0 +1,34 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package screening
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics exposes the transfers parked by the screening
+type Metrics struct {
+	Parked  prometheus.Gauge
+	Flagged *prometheus.CounterVec
+}
+
+func NewMetrics(chain string) *Metrics {
+	metrics := &Metrics{
+		Parked: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: fmt.Sprintf("%s_screening_parked", chain),
+			Help: "Number of transfers parked for manual review",
+		}),
+		Flagged: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: fmt.Sprintf("%s_screening_flagged", chain),
+			Help: "Number of transfers flagged by the screening, by flagged party",
+		}, []string{"party"}),
+	}
+
+	prometheus.MustRegister(metrics.Parked)
+	prometheus.MustRegister(metrics.Flagged)
+
+	return metrics
+}
@@ -0,0 +1,348 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+/*
+Package screening holds back the transfers of blocked parties for manual review.
+
+The writers screen the recipient and the depositor of a transfer against a blocklist, and the recipient against an
+optional allowlist, before voting for it. The lists are files of addresses in SS58, hex or bech32 form, one per line,
+reloaded when they change. The depositor is carried by the message of the transfer, see WithDepositor, and a transfer
+without a depositor is flagged when a blocklist is configured. A flagged transfer is parked until a reload of the
+lists clears its parties, so that an operator reviews it by editing the lists.
+*/
+package screening
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/ChainSafe/log15"
+	"github.com/Platdot-network/Platdot/chains/persist"
+	"github.com/hacpy/go-ethereum/common"
+	"github.com/rjman-ljm/go-substrate-crypto/ss58"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+// Chain config options of the screening
+const (
+	BlocklistOpt = "screenBlocklist"
+	AllowlistOpt = "screenAllowlist"
+)
+
+// Time between two checks of the lists for changes
+var ReloadInterval = 30 * time.Second
+
+// Parties of a transfer
+const (
+	PartyRecipient = "recipient"
+	PartyDepositor = "depositor"
+)
+
+// Paths of the lists, an empty path disables the list
+type Config struct {
+	Blocklist string
+	Allowlist string
+	Path      string // File of the parked transfers, kept in memory only if empty
+}
+
+// ParseConfig parses the screening lists of the chain options, it returns nil if no list is configured
+func ParseConfig(opts map[string]string) *Config {
+	cfg := &Config{Blocklist: opts[BlocklistOpt], Allowlist: opts[AllowlistOpt]}
+	if cfg.Blocklist == "" && cfg.Allowlist == "" {
+		return nil
+	}
+	return cfg
+}
+
+// StatePath returns the file persisting the parked transfers of a chain, in the blockstore directory
+func StatePath(blockstorePath string, chain msg.ChainId) (string, error) {
+	dir, err := persist.BlockstoreDir(blockstorePath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, fmt.Sprintf("screening-%d.json", chain)), nil
+}
+
+// Normalize returns the account of an address in SS58, hex or bech32 form as lowercase hex, without prefix
+func Normalize(address string) (string, error) {
+	address = strings.TrimSpace(address)
+	if strings.HasPrefix(address, "0x") || strings.HasPrefix(address, "0X") {
+		b, err := hex.DecodeString(address[2:])
+		if err != nil || len(b) == 0 {
+			return "", fmt.Errorf("invalid hex address: %s", address)
+		}
+		return hex.EncodeToString(b), nil
+	}
+	if b, err := common.PlatonToEth(address); err == nil {
+		return hex.EncodeToString(b), nil
+	}
+	if data, err := ss58.Decode(address); err == nil && ss58.VerityAddress(address, data[:1]) == nil {
+		pub, _ := ss58.DecodeToPub(address)
+		return hex.EncodeToString(pub), nil
+	}
+	return "", fmt.Errorf("unknown address format: %s", address)
+}
+
+// partyKey returns the account of a party of a message, which is either an address as text or the raw account
+func partyKey(party []byte) string {
+	if key, err := Normalize(string(party)); err == nil {
+		return key
+	}
+	return hex.EncodeToString(party)
+}
+
+// List is a set of accounts read from a file
+type List struct {
+	path     string
+	modTime  time.Time
+	accounts map[string]bool
+}
+
+// LoadList reads the addresses of a file, blank lines and lines starting with # are ignored
+func LoadList(path string) (*List, error) {
+	l := &List{path: path}
+	_, err := l.reload()
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// reload reads the file again if it changed since the last read. On error the list is kept as it was.
+func (l *List) reload() (bool, error) {
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return false, err
+	}
+	if l.accounts != nil && info.ModTime().Equal(l.modTime) {
+		return false, nil
+	}
+	content, err := ioutil.ReadFile(l.path)
+	if err != nil {
+		return false, err
+	}
+
+	accounts := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, err := Normalize(text)
+		if err != nil {
+			return false, fmt.Errorf("%s line %d: %w", l.path, line, err)
+		}
+		accounts[key] = true
+	}
+	l.accounts = accounts
+	l.modTime = info.ModTime()
+	return true, nil
+}
+
+func (l *List) contains(key string) bool {
+	return l.accounts[key]
+}
+
+// FlaggedError reports a transfer with a blocked party
+type FlaggedError struct {
+	Party   string
+	Account string
+	Reason  string
+}
+
+func (e *FlaggedError) Error() string {
+	return fmt.Sprintf("%s %s %s", e.Party, e.Account, e.Reason)
+}
+
+// WithDepositor returns the message of a transfer carrying the account of its depositor after its recipient, so that
+// the depositor is screened even if the transfer is resumed or parked by another writer
+func WithDepositor(m msg.Message, account []byte) msg.Message {
+	m.Payload = append(m.Payload[:len(m.Payload):len(m.Payload)], append([]byte(nil), account...))
+	return m
+}
+
+// DepositorOf returns the depositor carried by the message of a transfer, nil if it carries none
+func DepositorOf(m msg.Message) []byte {
+	if len(m.Payload) < 3 || (m.Type != msg.MultiSigTransfer && m.Type != msg.FungibleTransfer && m.Type != msg.NativeTransfer) {
+		return nil
+	}
+	account, _ := m.Payload[2].([]byte)
+	return account
+}
+
+type Screener struct {
+	blocklist *List
+	allowlist *List
+	lock      sync.Mutex
+	path      string
+	parked    *persist.Parking     // Flagged transfers
+	cleared   map[persist.Key]bool // Parked transfers cleared by a reload, until they are screened again
+	log       log.Logger
+	metrics   *Metrics
+}
+
+// NewScreener loads the lists of the config and the transfers parked by a previous run, metrics may be nil
+func NewScreener(cfg *Config, log log.Logger, m *Metrics) (*Screener, error) {
+	s := &Screener{
+		path:    cfg.Path,
+		parked:  persist.NewParking(),
+		cleared: make(map[persist.Key]bool),
+		log:     log,
+		metrics: m,
+	}
+	var err error
+	if s.path != "" {
+		err = persist.ReadJSON(s.path, s.parked)
+		if err != nil {
+			return nil, err
+		}
+		s.updateMetrics()
+	}
+	if cfg.Blocklist != "" {
+		s.blocklist, err = LoadList(cfg.Blocklist)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Allowlist != "" {
+		s.allowlist, err = LoadList(cfg.Allowlist)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Screen checks the parties of the transfer of a message. A flagged message is parked and a *FlaggedError is returned.
+func (s *Screener) Screen(m msg.Message) error {
+	key := persist.KeyOf(m)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.cleared[key] {
+		delete(s.cleared, key)
+		return nil
+	}
+	if p, ok := s.parked.Get(key); ok {
+		if err := s.check(p); err != nil {
+			return err
+		}
+		s.parked.Remove(key)
+		s.save()
+		return nil
+	}
+
+	err := s.check(m)
+	if err != nil {
+		s.parked.Park(m)
+		if s.metrics != nil {
+			s.metrics.Flagged.WithLabelValues(err.Party).Inc()
+		}
+		s.save()
+		return err
+	}
+	return nil
+}
+
+// check returns the first flagged party of a transfer, lock must be held. A transfer without a depositor is flagged if
+// a blocklist is configured, as its depositor can't be cleared.
+func (s *Screener) check(m msg.Message) *FlaggedError {
+	recipient := partyKey(m.Payload[1].([]byte))
+	if s.blocklist != nil && s.blocklist.contains(recipient) {
+		return &FlaggedError{Party: PartyRecipient, Account: recipient, Reason: "is blocked"}
+	}
+	if s.allowlist != nil && !s.allowlist.contains(recipient) {
+		return &FlaggedError{Party: PartyRecipient, Account: recipient, Reason: "is not allowed"}
+	}
+	if s.blocklist != nil {
+		depositor := DepositorOf(m)
+		if depositor == nil {
+			return &FlaggedError{Party: PartyDepositor, Account: "unknown", Reason: "is not known"}
+		}
+		account := partyKey(depositor)
+		if s.blocklist.contains(account) {
+			return &FlaggedError{Party: PartyDepositor, Account: account, Reason: "is blocked"}
+		}
+	}
+	return nil
+}
+
+// Parked returns the parked messages, by source and nonce
+func (s *Screener) Parked() []msg.Message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.parked.Messages()
+}
+
+// reload reads the changed lists again and returns the parked messages they clear
+func (s *Screener) reload() []msg.Message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	changed := false
+	for _, l := range []*List{s.blocklist, s.allowlist} {
+		if l == nil {
+			continue
+		}
+		ok, err := l.reload()
+		if err != nil {
+			s.log.Error("Failed to reload a screening list, keeping the previous one", "path", l.path, "err", err)
+			continue
+		}
+		if ok {
+			s.log.Info("Reloaded a screening list", "path", l.path, "accounts", len(l.accounts))
+		}
+		changed = changed || ok
+	}
+	if !changed {
+		return nil
+	}
+
+	var res []msg.Message
+	for _, m := range s.parked.Messages() {
+		if s.check(m) == nil {
+			key := persist.KeyOf(m)
+			s.parked.Remove(key)
+			s.cleared[key] = true
+			res = append(res, m)
+		}
+	}
+	s.save()
+	return res
+}
+
+// Run reloads the lists every ReloadInterval until stop is closed, and resolves the parked messages they clear.
+// resolve is expected to call Screen again.
+func (s *Screener) Run(resolve func(m msg.Message), stop <-chan int) {
+	persist.Run(ReloadInterval, s.reload, func(m msg.Message) {
+		s.log.Info("Screening cleared a parked transfer", "src", m.Source, "nonce", m.DepositNonce)
+		resolve(m)
+	}, stop)
+}
+
+// save persists the parked transfers and exposes them, lock must be held. A failure is only logged, the screener keeps
+// them in memory.
+func (s *Screener) save() {
+	s.updateMetrics()
+	if s.path == "" {
+		return
+	}
+	err := persist.WriteJSON(s.path, s.parked)
+	if err != nil {
+		s.log.Error("Failed to persist the parked transfers", "path", s.path, "err", err)
+	}
+}
+
+// updateMetrics exposes the parked transfers, lock must be held
+func (s *Screener) updateMetrics() {
+	if s.metrics != nil {
+		s.metrics.Parked.Set(float64(s.parked.Len()))
+	}
+}
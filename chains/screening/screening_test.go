@@ -0,0 +1,173 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package screening
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ChainSafe/log15"
+	"github.com/hacpy/go-ethereum/common"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+const aliceSS58 = "5GrwvaEF5zXb26Fz9rcQpDWS57CtERHpNehXCPcNoHGKutQY"
+const alicePub = "d43593c715fdd31c61141abd04a99fd6822c8558854ccde39a5684e7a56da27d"
+
+var ethAccount = common.FromHex("0xff93B45308FD417dF303D6515aB04D9e89a750Ca")
+
+func TestNormalize(t *testing.T) {
+	bech32, err := common.EthToPlaton("atp", ethAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for address, expected := range map[string]string{
+		aliceSS58:       alicePub,
+		"0x" + alicePub: alicePub,
+		"0xFF93B45308FD417DF303D6515AB04D9E89A750CA": "ff93b45308fd417df303d6515ab04d9e89a750ca",
+		bech32: "ff93b45308fd417df303d6515ab04d9e89a750ca",
+	} {
+		key, err := Normalize(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != expected {
+			t.Errorf("%s: Got: %s Expected: %s", address, key, expected)
+		}
+	}
+
+	if _, err := Normalize("alice"); err == nil {
+		t.Fatal("Expected an error for an unknown address")
+	}
+}
+
+func writeList(t *testing.T, path string, content string, modTime time.Time) {
+	err := ioutil.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chtimes(path, modTime, modTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScreen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "screening")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "blocklist")
+	writeList(t, path, "# Blocked accounts\n"+aliceSS58+"\n\n", time.Unix(1000, 0))
+
+	s, err := NewScreener(&Config{Blocklist: path}, log15.New(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rId := msg.ResourceIdFromSlice([]byte{1})
+
+	// Recipient as text, as sent by the ethlike listeners
+	var flagged *FlaggedError
+	m := WithDepositor(msg.NewMultiSigTransfer(1, 2, 1, big.NewInt(10), rId, []byte("0x"+alicePub)), ethAccount)
+	if err := s.Screen(m); !errors.As(err, &flagged) || flagged.Party != PartyRecipient {
+		t.Fatalf("Expected the recipient to be flagged, got %v", err)
+	}
+
+	// Raw depositor, as carried by the transfers of the substrate listeners
+	m = WithDepositor(msg.NewMultiSigTransfer(3, 2, 2, big.NewInt(10), rId, ethAccount), common.FromHex(alicePub))
+	if err := s.Screen(m); !errors.As(err, &flagged) || flagged.Party != PartyDepositor {
+		t.Fatalf("Expected the depositor to be flagged, got %v", err)
+	}
+
+	// A transfer of an unknown depositor fails closed
+	unknown := msg.NewMultiSigTransfer(3, 2, 4, big.NewInt(10), rId, ethAccount)
+	if err := s.Screen(unknown); !errors.As(err, &flagged) || flagged.Party != PartyDepositor {
+		t.Fatalf("Expected the unknown depositor to be flagged, got %v", err)
+	}
+
+	other := WithDepositor(msg.NewMultiSigTransfer(1, 2, 3, big.NewInt(10), rId, ethAccount), ethAccount)
+	if err := s.Screen(other); err != nil {
+		t.Fatal(err)
+	}
+	if parked := s.Parked(); len(parked) != 3 {
+		t.Fatalf("Got %d parked transfers, expected 3", len(parked))
+	}
+
+	// An invalid list is refused and the previous one kept
+	writeList(t, path, "alice\n", time.Unix(2000, 0))
+	if cleared := s.reload(); len(cleared) != 0 {
+		t.Fatalf("Got %d cleared transfers, expected none", len(cleared))
+	}
+
+	writeList(t, path, "", time.Unix(3000, 0))
+	if cleared := s.reload(); len(cleared) != 2 {
+		t.Fatalf("Got %d cleared transfers, expected 2", len(cleared))
+	}
+	if parked := s.Parked(); len(parked) != 1 || parked[0].DepositNonce != unknown.DepositNonce {
+		t.Fatalf("Expected the transfer of the unknown depositor to stay parked, got %v", parked)
+	}
+	if err := s.Screen(m); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "screening")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "allowlist")
+	writeList(t, path, aliceSS58+"\n", time.Unix(1000, 0))
+
+	s, err := NewScreener(&Config{Allowlist: path}, log15.New(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rId := msg.ResourceIdFromSlice([]byte{1})
+	if err := s.Screen(msg.NewMultiSigTransfer(1, 2, 1, big.NewInt(10), rId, common.FromHex(alicePub))); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Screen(msg.NewMultiSigTransfer(1, 2, 2, big.NewInt(10), rId, ethAccount)); err == nil {
+		t.Fatal("Expected a recipient out of the allowlist to be flagged")
+	}
+}
+
+func TestRestoreParked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "screening")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "blocklist")
+	writeList(t, path, aliceSS58+"\n", time.Unix(1000, 0))
+	cfg := &Config{Blocklist: path, Path: filepath.Join(dir, "screening-2.json")}
+
+	s, err := NewScreener(cfg, log15.New(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := WithDepositor(msg.NewMultiSigTransfer(3, 2, 1, big.NewInt(10), msg.ResourceIdFromSlice([]byte{1}), ethAccount), common.FromHex(alicePub))
+	if err = s.Screen(m); err == nil {
+		t.Fatal("Expected the depositor to be flagged")
+	}
+
+	// The parked transfer is restored with its depositor, and cleared by a reload of the lists
+	s, err = NewScreener(cfg, log15.New(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeList(t, path, "", time.Unix(2000, 0))
+	cleared := s.reload()
+	if len(cleared) != 1 || !bytes.Equal(DepositorOf(cleared[0]), common.FromHex(alicePub)) {
+		t.Fatalf("Unexpected cleared transfers %v", cleared)
+	}
+}
@@ -0,0 +1,569 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package substrate
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Platdot-Network/go-substrate-rpc-client/v3/types"
+	"github.com/Platdot-network/Platdot/chains/lifecycle"
+	"github.com/Platdot-network/Platdot/chains/refunds"
+	"github.com/Platdot-network/Platdot/chains/webhooks"
+	utils "github.com/Platdot-network/Platdot/shared/substrate"
+	"github.com/rjman-ljm/platdot-utils/msg"
+	"golang.org/x/crypto/blake2b"
+)
+
+const DefaultBatchWindow = time.Second * 30
+const DefaultMaxBatchSize = 16
+
+// depositKey identifies a deposit on its source chain
+type depositKey struct {
+	Source msg.ChainId
+	Nonce  msg.Nonce
+}
+
+func (k depositKey) String() string {
+	return strconv.FormatUint(uint64(k.Source), 10) + ":" + strconv.FormatUint(uint64(k.Nonce), 10)
+}
+
+// redeemItem is a single redemption carried by a batch
+type redeemItem struct {
+	m      msg.Message
+	amount *big.Int // Amount sent on the substrate side, fee deducted
+	call   types.Call
+}
+
+func (i *redeemItem) key() depositKey {
+	return depositKey{Source: i.m.Source, Nonce: i.m.DepositNonce}
+}
+
+// redeemBatch is a set of redemptions proposed through the multisig as one Utility.batch_all call
+type redeemBatch struct {
+	items    []*redeemItem
+	call     types.Call
+	callHash types.Hash
+	when     *types.TimePoint // Timepoint of the multisig once it is created on chain
+	waited   int              // Rounds waited for approvals after this relayer voted
+}
+
+// sortRedemptions orders messages by source chain and deposit nonce, so every relayer
+// builds the identical batch from the same set of messages.
+func sortRedemptions(ms []msg.Message) {
+	sort.SliceStable(ms, func(i, j int) bool {
+		if ms[i].Source != ms[j].Source {
+			return ms[i].Source < ms[j].Source
+		}
+		return ms[i].DepositNonce < ms[j].DepositNonce
+	})
+}
+
+// batchRemark lists the deposits covered by a batch, e.g. "1:42,1:43", followed by the retry round if any, e.g.
+// "1:42,1:43;1234". The round gives the retries of a closed batch another call hash.
+func batchRemark(keys []depositKey, round uint32) string {
+	s := make([]string, len(keys))
+	for i, k := range keys {
+		s[i] = k.String()
+	}
+	remark := strings.Join(s, ",")
+	if round != 0 {
+		remark += ";" + strconv.FormatUint(uint64(round), 10)
+	}
+	return remark
+}
+
+// parseBatchRemark returns the deposits listed by the remark of a batch, and its retry round
+func parseBatchRemark(remark string) ([]depositKey, uint32, error) {
+	var round uint64
+	if i := strings.LastIndex(remark, ";"); i >= 0 {
+		var err error
+		round, err = strconv.ParseUint(remark[i+1:], 10, 32)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid round %q in batch remark", remark[i+1:])
+		}
+		remark = remark[:i]
+	}
+	var keys []depositKey
+	for _, s := range strings.Split(remark, ",") {
+		parts := strings.Split(s, ":")
+		if len(parts) != 2 {
+			return nil, 0, fmt.Errorf("invalid deposit %q in batch remark", s)
+		}
+		source, err := strconv.ParseUint(parts[0], 10, 8)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid source of deposit %q in batch remark", s)
+		}
+		nonce, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid nonce of deposit %q in batch remark", s)
+		}
+		keys = append(keys, depositKey{Source: msg.ChainId(source), Nonce: msg.Nonce(nonce)})
+	}
+	return keys, uint32(round), nil
+}
+
+// nextBatchFlush returns the time until the end of the current batch window. Windows are aligned
+// to the wall clock so that relayers collect redemptions over the same period.
+func nextBatchFlush(now time.Time, window time.Duration) time.Duration {
+	return now.Truncate(window).Add(window).Sub(now)
+}
+
+// newRedeemBatch builds the batch call for the given messages. Messages whose amount can't pay
+// the handling fee are dropped from the batch.
+func (w *writer) newRedeemBatch(ms []msg.Message) (*redeemBatch, error) {
+	return w.buildRedeemBatch(ms, w.redemptions.round)
+}
+
+// buildRedeemBatch builds the batch call for the given messages, round returns the retry round of the deposits kept
+func (w *writer) buildRedeemBatch(ms []msg.Message, round func(keys []depositKey) uint32) (*redeemBatch, error) {
+	sortRedemptions(ms)
+
+	b := &redeemBatch{}
+	var calls []types.Call
+	var keys []depositKey
+	for _, m := range ms {
+		item, err := w.newRedeemItem(m)
+		if err != nil {
+			return nil, err
+		}
+		if item == nil {
+			continue
+		}
+		b.items = append(b.items, item)
+		calls = append(calls, item.call)
+		keys = append(keys, item.key())
+	}
+	if len(b.items) == 0 {
+		return nil, fmt.Errorf("no redeemable message in batch")
+	}
+
+	/// Record the covered deposits on chain
+	remark, err := types.NewCall(w.conn.getMetadata(), string(utils.SystemRemark),
+		types.NewBytes([]byte(batchRemark(keys, round(keys)))))
+	if err != nil {
+		return nil, err
+	}
+	calls = append(calls, remark)
+
+	c, err := types.NewCall(w.conn.getMetadata(), string(utils.UtilityBatchAll), calls)
+	if err != nil {
+		return nil, err
+	}
+	b.call = c
+	b.callHash = types.NewHash(hashCall(c))
+	return b, nil
+}
+
+// newRedeemItem builds the transfer of a message, nil if it can't be redeemed. Refunds send their amount back as is.
+func (w *writer) newRedeemItem(m msg.Message) (*redeemItem, error) {
+	if m.Type == refunds.RefundTransfer {
+		c, amount, err := w.getRefundCall(m)
+		if err != nil {
+			w.log.Error(NewRefundCallError, "DepositNonce", m.DepositNonce, "ResourceId", m.ResourceId.Hex(), "Error", err)
+			return nil, nil
+		}
+		return &redeemItem{m: m, amount: amount, call: c}, nil
+	}
+
+	assetId, err := w.getRedeemAssetId(m)
+	if err != nil {
+		w.log.Error(UnknownRedeemAsset, "DepositNonce", m.DepositNonce, "ResourceId", m.ResourceId.Hex(), "Error", err)
+		return nil, nil
+	}
+	amount, err := w.chainCore.GetAmountToSub(m.Payload[0].([]byte), assetId)
+	if err != nil {
+		w.log.Error(RedeemNegAmountError, "DepositNonce", m.DepositNonce, "Error", err)
+		return nil, nil
+	}
+	c, err := w.getCall(m)
+	if err != nil {
+		return nil, err
+	}
+	return &redeemItem{m: m, amount: amount, call: c}, nil
+}
+
+// hashCall returns the blake2_256 hash of the encoded call, as used by the Multisig pallet
+func hashCall(c types.Call) []byte {
+	return hashBytes(EncodeCall(c))
+}
+
+// hashBytes returns the blake2_256 hash of data
+func hashBytes(data []byte) []byte {
+	h := blake2b.Sum256(data)
+	return h[:]
+}
+
+// round returns the value used to pick the relayer that proposes the batch
+func (b *redeemBatch) round() uint64 {
+	return uint64(b.items[0].m.DepositNonce)
+}
+
+// maxWeight scales the configured weight of a single transfer to the batch
+func (b *redeemBatch) maxWeight(single uint64) types.Weight {
+	return types.Weight(single * uint64(len(b.items)))
+}
+
+func (b *redeemBatch) keys() []depositKey {
+	keys := make([]depositKey, len(b.items))
+	for i, item := range b.items {
+		keys[i] = item.key()
+	}
+	return keys
+}
+
+func (b *redeemBatch) nonces() []msg.Nonce {
+	nonces := make([]msg.Nonce, len(b.items))
+	for i, item := range b.items {
+		nonces[i] = item.m.DepositNonce
+	}
+	return nonces
+}
+
+// multisigInfo is the `Multisig.Multisigs` storage entry of an ongoing multisig operation
+type multisigInfo struct {
+	When      types.TimePoint
+	Deposit   types.U128
+	Depositor types.AccountID
+	Approvals []types.AccountID
+}
+
+// queueRedemption registers a message to be redeemed by a later batch
+func (w *writer) queueRedemption(m msg.Message) {
+	if !w.redemptions.add(m) {
+		w.log.Info(MeetARepeatTx, "DepositNonce", m.DepositNonce, "Source", m.Source)
+		return
+	}
+	w.log.Info(QueueRedemption, "DepositNonce", m.DepositNonce, "Source", m.Source)
+}
+
+// batchLoop rebuilds the batch at the end of every window and drives it through the multisig each round.
+// It sleeps while there is nothing to redeem, and reacts at once to the multisig events seen by the listener.
+func (w *writer) batchLoop() {
+	var current *redeemBatch
+	var window <-chan time.Time
+	round := time.NewTicker(RoundInterval)
+	defer round.Stop()
+
+	for {
+		select {
+		case <-w.listener.stop:
+			return
+		case <-w.redemptions.added():
+			if window == nil {
+				window = time.After(nextBatchFlush(time.Now(), w.batchWindow))
+			}
+			continue
+		case <-window:
+			current = w.nextBatch(current)
+			window = nil
+			if current != nil || len(w.redemptions.pending(1)) > 0 {
+				window = time.After(nextBatchFlush(time.Now(), w.batchWindow))
+			}
+			continue
+		case <-w.listener.multisigUpdated():
+		case <-round.C:
+		}
+
+		w.sweepExecuted()
+		if current == nil || current.when == nil {
+			if b := w.adoptBatch(); b != nil {
+				current = b
+			}
+		}
+		if current == nil {
+			continue
+		}
+		status := w.redeemBatch(current)
+		if status == IsExecuted || status == UnKnownError {
+			current = nil
+			if window == nil && len(w.redemptions.pending(1)) > 0 {
+				window = time.After(nextBatchFlush(time.Now(), w.batchWindow))
+			}
+		}
+	}
+}
+
+// nextBatch builds the batch for the pending messages. A batch that is already on chain is kept
+// until it is executed or cancelled, so its approvals are never abandoned.
+func (w *writer) nextBatch(current *redeemBatch) *redeemBatch {
+	if current != nil && current.when != nil {
+		return current
+	}
+
+	ms := w.redemptions.pending(w.maxBatchSize)
+	if len(ms) == 0 {
+		return nil
+	}
+
+	b, err := w.newRedeemBatch(ms)
+	if err != nil {
+		w.logErr(NewBatchCallError, err)
+		return current
+	}
+	if current != nil && current.callHash == b.callHash {
+		return current
+	}
+
+	w.log.Info(NewRedeemBatch, "CallHash", b.callHash.Hex(), "Items", len(b.items), "DepositNonces", b.nonces())
+	return b
+}
+
+// adoptBatch returns the batch of a live multisig operation whose deposits were all seen by this relayer and whose
+// call this relayer builds as well, oldest first. The relayers whose pending redemptions differ, having seen the
+// deposits at different times, thus approve the batch proposed on chain rather than proposing their own.
+func (w *writer) adoptBatch() *redeemBatch {
+	for _, s := range w.listener.pendingBatches() {
+		keys, round, err := parseBatchRemark(s.Remark)
+		if err != nil {
+			continue
+		}
+		ms, ok := w.redemptions.messages(keys)
+		if !ok {
+			continue
+		}
+		b, err := w.buildRedeemBatch(ms, func([]depositKey) uint32 { return round })
+		if err != nil || b.callHash != s.CallHash {
+			w.log.Debug(BatchNotAdopted, "CallHash", s.CallHash.Hex(), "Remark", s.Remark, "err", err)
+			continue
+		}
+		when := s.TimePoint
+		b.when = &when
+		w.log.Info(AdoptRedeemBatch, "CallHash", b.callHash.Hex(), "Items", len(b.items), "DepositNonces", b.nonces())
+		return b
+	}
+	return nil
+}
+
+// proposedElsewhere returns whether a deposit of the batch is carried by a live multisig operation with another call.
+// The batch is not proposed then, until that operation is adopted, executed or cancelled.
+func (w *writer) proposedElsewhere(b *redeemBatch) bool {
+	keys := make(map[depositKey]bool, len(b.items))
+	for _, key := range b.keys() {
+		keys[key] = true
+	}
+	for _, s := range w.listener.pendingBatches() {
+		if s.CallHash == b.callHash {
+			continue
+		}
+		others, _, err := parseBatchRemark(s.Remark)
+		if err != nil {
+			continue
+		}
+		for _, key := range others {
+			if keys[key] {
+				w.log.Info(DepositProposedInOtherBatch, "DepositNonce", key.Nonce, "Source", key.Source, "CallHash", s.CallHash.Hex())
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// redeemBatch proposes or approves the batch in the relayer's round, and reports its status
+func (w *writer) redeemBatch(b *redeemBatch) RedeemStatusCode {
+	switch w.listener.multisigOutcome(b.callHash) {
+	case multisigExecuted:
+		w.finishBatch(b)
+		return IsExecuted
+	case multisigFailed:
+		/// batch_all reverted as a whole, none of the deposits was redeemed. The deposits are retried by a batch with
+		/// another call hash, a transfer is only reported as failed the first time.
+		w.log.Error(RedeemBatchFailed, "CallHash", b.callHash.Hex(), "DepositNonces", b.nonces())
+		first := make(map[depositKey]bool)
+		for _, key := range w.redemptions.fail(b.keys()) {
+			first[key] = true
+		}
+		for _, item := range b.items {
+			if !first[item.key()] {
+				continue
+			}
+			reason := "redeem batch failed, retried by a later batch"
+			if w.record(item.m, lifecycle.Failed, "", reason) {
+				e := w.redemptionEvent(webhooks.TransferFailed, item.m).SetReason(reason)
+				e.CallHash = b.callHash.Hex()
+				w.notifier.Notify(e)
+			}
+		}
+		w.releaseBatch(b, w.retryRound(b))
+		return UnKnownError
+	case multisigCancelled:
+		w.log.Warn(RedeemBatchCancelled, "CallHash", b.callHash.Hex(), "DepositNonces", b.nonces())
+		w.releaseBatch(b, w.retryRound(b))
+		return UnKnownError
+	}
+
+	var info multisigInfo
+	exists, err := w.conn.queryStorage(utils.MultisigStoragePrefix, "Multisigs", w.listener.multiSigAddr[:], b.callHash[:], &info)
+	if err != nil {
+		w.logErr(GetStorageLatestError, err)
+		return NotExecuted
+	}
+	if !exists && b.when != nil {
+		/// Executed or cancelled, wait for the listener to catch up
+		return NotExecuted
+	}
+
+	/// Approvals seen by the listener may not be in the latest state yet
+	relayer := types.NewAccountID(w.relayer.signer.PublicKey())
+	if state, ok := w.listener.multisigState(b.callHash); ok && !exists && containsVote(state.Approvals, relayer) {
+		return YesVoted
+	}
+	if exists {
+		b.when = &info.When
+		if containsVote(info.Approvals, relayer) {
+			b.waited++
+			if b.waited > RedeemRetryLimit && info.Depositor == relayer {
+				w.cancelBatch(b)
+			}
+			return YesVoted
+		}
+	}
+
+	if !w.canVote(b) {
+		return NotExecuted
+	}
+
+	/// A new multisig is only created by the relayer of the current round, the others approve it
+	var maybeTimePoint interface{}
+	maxWeight := types.Weight(0)
+	if exists {
+		maybeTimePoint = TimePointSafe32{
+			Height: types.NewOptionU32(info.When.Height),
+			Index:  info.When.Index,
+		}
+		maxWeight = b.maxWeight(w.relayer.maxWeight)
+		w.log.Info(TryToApproveMultiSigTx, "#Block", info.When.Height, "Index", info.When.Index, "CallHash", b.callHash.Hex())
+	} else {
+		processRound := (w.relayer.relayerId + b.round()) % w.relayer.totalRelayers
+		round, _ := w.getRound()
+		if round.blockRound.Uint64() != processRound || w.proposedElsewhere(b) {
+			return NotExecuted
+		}
+		maybeTimePoint = []byte{}
+		w.log.Info(TryToMakeNewMultiSigTx, "CallHash", b.callHash.Hex(), "DepositNonces", b.nonces())
+	}
+
+	mc, err := types.NewCall(
+		w.conn.getMetadata(),
+		string(utils.MultisigAsMulti),
+		w.relayer.multiSigThreshold,
+		w.relayer.otherSignatories,
+		maybeTimePoint,
+		EncodeCall(b.call),
+		false,
+		maxWeight,
+	)
+	if err != nil {
+		w.logErr(NewMultiCallError, err)
+		return UnKnownError
+	}
+
+	/// Record the vote before submitting, a deposit must never be voted in two live batches
+	w.redemptions.vote(b.keys(), b.callHash)
+
+	if txHash, ok := w.submitTx(mc); ok {
+		for _, item := range b.items {
+			e := w.redemptionEvent(webhooks.VoteSubmitted, item.m)
+			e.TxHash = txHash.Hex()
+			e.CallHash = b.callHash.Hex()
+			w.notifier.Notify(e)
+			w.record(item.m, lifecycle.Voted, txHash.Hex(), "")
+		}
+	}
+	return NotExecuted
+}
+
+// canVote checks that no deposit of the batch was voted by this relayer in another batch which is
+// still alive. Otherwise both could reach the threshold and redeem the deposit twice.
+func (w *writer) canVote(b *redeemBatch) bool {
+	for _, item := range b.items {
+		h, ok := w.redemptions.votedIn(item.key())
+		if !ok || h == b.callHash {
+			continue
+		}
+		if outcome := w.listener.multisigOutcome(h); outcome != multisigCancelled && outcome != multisigFailed {
+			w.log.Info(DepositVotedInOtherBatch, "DepositNonce", item.m.DepositNonce, "CallHash", h.Hex())
+			return false
+		}
+	}
+	return true
+}
+
+// cancelBatch cancels a batch created by this relayer which didn't get enough approvals
+func (w *writer) cancelBatch(b *redeemBatch) {
+	w.log.Warn(CancelRedeemBatch, "CallHash", b.callHash.Hex(), "DepositNonces", b.nonces())
+	mc, err := types.NewCall(
+		w.conn.getMetadata(),
+		string(utils.MultisigCancelAsMulti),
+		w.relayer.multiSigThreshold,
+		w.relayer.otherSignatories,
+		*b.when,
+		b.callHash,
+	)
+	if err != nil {
+		w.logErr(NewMultiCallError, err)
+		return
+	}
+	w.submitTx(mc)
+}
+
+// sweepExecuted completes the deposits of every executed batch this relayer voted for
+func (w *writer) sweepExecuted() {
+	for h, keys := range w.redemptions.voted() {
+		if w.listener.multisigOutcome(h) != multisigExecuted {
+			continue
+		}
+		for _, m := range w.redemptions.execute(keys, h) {
+			w.redeemed(m, h)
+		}
+	}
+}
+
+// finishBatch completes the deposits of an executed batch
+func (w *writer) finishBatch(b *redeemBatch) {
+	for _, m := range w.redemptions.execute(b.keys(), b.callHash) {
+		w.redeemed(m, b.callHash)
+	}
+	w.log.Info(FinishARedeemBatch, "CallHash", b.callHash.Hex(), "Items", len(b.items))
+}
+
+// redeemed completes a message executed by a batch, the refunds are recorded as refunded
+func (w *writer) redeemed(m msg.Message, callHash types.Hash) {
+	e := w.redemptionEvent(webhooks.TransferExecuted, m)
+	e.CallHash = callHash.Hex()
+	w.notifier.Notify(e)
+	if m.Type != refunds.RefundTransfer {
+		w.record(m, lifecycle.Executed, "", "multisig call "+callHash.Hex())
+		w.log.Info(MultiSigExtrinsicExecuted, "DepositNonce", m.DepositNonce, "Source", m.Source, "CallHash", callHash.Hex())
+		return
+	}
+	w.log.Info(RefundExecuted, "DepositNonce", m.DepositNonce, "CallHash", callHash.Hex())
+	w.record(m, lifecycle.Refunded, "", "multisig call "+callHash.Hex())
+	if w.refunds != nil {
+		err := w.refunds.Refunded(m.DepositNonce)
+		if err != nil {
+			w.logErr(RecordRejectedDepositError, err)
+		}
+	}
+}
+
+// releaseBatch forgets the votes of a cancelled or failed batch, its deposits are redeemed by a later batch of the
+// given round
+func (w *writer) releaseBatch(b *redeemBatch, round uint32) {
+	w.redemptions.release(b.keys(), b.callHash, round)
+}
+
+// retryRound returns the round of the batches retrying the deposits of a closed batch: the height of its timepoint,
+// which every relayer sees in the events of the multisig
+func (w *writer) retryRound(b *redeemBatch) uint32 {
+	if state, ok := w.listener.multisigState(b.callHash); ok {
+		return uint32(state.TimePoint.Height)
+	}
+	return 0
+}
@@ -0,0 +1,74 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package substrate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+func TestSortRedemptions(t *testing.T) {
+	ms := []msg.Message{
+		{Source: 2, DepositNonce: 1},
+		{Source: 1, DepositNonce: 7},
+		{Source: 1, DepositNonce: 3},
+		{Source: 2, DepositNonce: 0},
+	}
+	sortRedemptions(ms)
+
+	expected := []depositKey{{1, 3}, {1, 7}, {2, 0}, {2, 1}}
+	for i, m := range ms {
+		if m.Source != expected[i].Source || m.DepositNonce != expected[i].Nonce {
+			t.Fatalf("Position %d: Got: %d:%d Expected: %s", i, m.Source, m.DepositNonce, expected[i])
+		}
+	}
+}
+
+func TestBatchRemark(t *testing.T) {
+	remark := batchRemark([]depositKey{{1, 42}, {1, 43}, {2, 9}}, 0)
+	if remark != "1:42,1:43,2:9" {
+		t.Fatalf("Got: %s Expected: %s", remark, "1:42,1:43,2:9")
+	}
+
+	keys, round, err := parseBatchRemark(remark)
+	if err != nil || round != 0 {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 || keys[2] != (depositKey{2, 9}) {
+		t.Fatalf("Got: %v Expected: %s", keys, remark)
+	}
+	if _, _, err := parseBatchRemark("1:42,hello"); err == nil {
+		t.Fatal("Expected an error for an invalid remark")
+	}
+
+	// The retry of a closed batch has another remark, and so another call hash
+	retry := batchRemark([]depositKey{{1, 42}, {1, 43}, {2, 9}}, 1234)
+	if retry != remark+";1234" {
+		t.Fatalf("Got: %s Expected: %s", retry, remark+";1234")
+	}
+	keys, round, err = parseBatchRemark(retry)
+	if err != nil || len(keys) != 3 || keys[0] != (depositKey{1, 42}) || round != 1234 {
+		t.Fatalf("Got: %v %d %v Expected: %s", keys, round, err, retry)
+	}
+	if _, _, err := parseBatchRemark("1:42;round"); err == nil {
+		t.Fatal("Expected an error for an invalid round")
+	}
+}
+
+func TestNextBatchFlush(t *testing.T) {
+	window := 30 * time.Second
+	now := time.Unix(1000, 0) // 990 + 10s
+
+	wait := nextBatchFlush(now, window)
+	if wait != 20*time.Second {
+		t.Fatalf("Got: %s Expected: %s", wait, 20*time.Second)
+	}
+
+	// Relayers observing the same window flush at the same instant
+	if now.Add(wait) != now.Add(5*time.Second).Add(nextBatchFlush(now.Add(5*time.Second), window)) {
+		t.Fatal("Flush time should be aligned to the window")
+	}
+}
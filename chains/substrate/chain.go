@@ -26,8 +26,17 @@ As the writer receives messages from the router, it constructs proposals. If a p
 import (
 	"fmt"
 	"github.com/ChainSafe/log15"
+	"github.com/Platdot-network/Platdot/chains"
+	"github.com/Platdot-network/Platdot/chains/approvals"
 	"github.com/Platdot-network/Platdot/chains/chainset"
-	"github.com/Platdot-network/Platdot/config"
+	"github.com/Platdot-network/Platdot/chains/lifecycle"
+	"github.com/Platdot-network/Platdot/chains/limits"
+	"github.com/Platdot-network/Platdot/chains/refunds"
+	"github.com/Platdot-network/Platdot/chains/screening"
+	"github.com/Platdot-network/Platdot/chains/webhooks"
+	"github.com/Platdot-network/Platdot/chains/supply"
+	"github.com/Platdot-network/Platdot/connections/pool"
+	"github.com/Platdot-network/Platdot/connections/signer"
 	"github.com/Platdot-Network/go-substrate-rpc-client/v3/signature"
 	"github.com/Platdot-Network/go-substrate-rpc-client/v3/types"
 	"github.com/rjman-ljm/go-substrate-crypto/ss58"
@@ -40,12 +49,15 @@ import (
 )
 
 var _ core.Chain = &Chain{}
+var _ chains.Replayer = &Chain{}
+var _ chains.Custodian = &Chain{}
 
 type Chain struct {
 	cfg      *core.ChainConfig // The config of the chain
 	conn     *Connection       // THe chains connection
 	listener *listener         // The listener of this chain
 	writer   *writer           // The writer of the chain
+	supply   *supply.Checker   // Checks the supply of the wrapped tokens of the multiSig, if configured
 	stop     chan<- int
 }
 
@@ -64,9 +76,8 @@ func checkBlockstore(bs *blockstore.Blockstore, startBlock uint64) (uint64, erro
 	}
 }
 
-func InitializeChain(cfg *core.ChainConfig, logger log15.Logger, sysErr chan<- error, m *metrics.ChainMetrics) (*Chain, error) {
-	stop := make(chan int)
-	/// Load keypair
+/// loadKeypair loads the relayer key of the chain from the keystore
+func loadKeypair(cfg *core.ChainConfig) (*sr25519.Keypair, error) {
 	fromPubKey, _ := ss58.DecodeToPub(cfg.From)
 	pwdCache := cfg.KeystorePath + "/.cache"
 	kp, err := keystore.KeypairFromAddress(
@@ -81,11 +92,51 @@ func InitializeChain(cfg *core.ChainConfig, logger log15.Logger, sysErr chan<- e
 		fmt.Printf("keystore not found, addr is %v\n", cfg)
 		return nil, err
 	}
+	return kp.(*sr25519.Keypair), nil
+}
 
-	krp := kp.(*sr25519.Keypair).AsKeyringPair()
+/// loadSigner returns the signer of the relayer key and the address of the key, the key is held by the signing service
+/// if one is configured and loaded from the keystore otherwise
+func loadSigner(cfg *core.ChainConfig) (signer.Signer, string, error) {
+	if remote := parseSigner(cfg); remote != nil {
+		s, err := signer.NewRemote(remote, signer.Sr25519, cfg.From)
+		return s, cfg.From, err
+	}
+	kp, err := loadKeypair(cfg)
+	if err != nil {
+		return nil, "", err
+	}
+	return signer.NewSr25519(signature.KeyringPair(*kp.AsKeyringPair())), kp.Address(), nil
+}
+
+/// ApprovalSigner returns the signer of the approval decisions of the chain, with the relayer key
+func ApprovalSigner(cfg *core.ChainConfig) (approvals.Signer, error) {
+	s, _, err := loadSigner(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return approvals.NewSr25519Signer(s), nil
+}
+
+/// LocalKey returns the relayer key of the chain loaded from the keystore, as served by a signing service
+func LocalKey(cfg *core.ChainConfig) (signer.Key, error) {
+	kp, err := loadKeypair(cfg)
+	if err != nil {
+		return signer.Key{}, err
+	}
+	return signer.Key{Address: cfg.From, Signer: signer.NewSr25519(signature.KeyringPair(*kp.AsKeyringPair()))}, nil
+}
+
+func InitializeChain(cfg *core.ChainConfig, logger log15.Logger, sysErr chan<- error, m *metrics.ChainMetrics) (*Chain, error) {
+	stop := make(chan int)
+	/// Load the relayer key, or connect to the signing service holding it
+	s, address, err := loadSigner(cfg)
+	if err != nil {
+		return nil, err
+	}
 
 	/// Attempt to load latest block
-	bs, err := blockstore.NewBlockstore(cfg.BlockstorePath, cfg.Id, kp.Address())
+	bs, err := blockstore.NewBlockstore(cfg.BlockstorePath, cfg.Id, address)
 	if err != nil {
 		return nil, err
 	}
@@ -95,7 +146,11 @@ func InitializeChain(cfg *core.ChainConfig, logger log15.Logger, sysErr chan<- e
 	lostAddress := parseLostAddress(cfg)
 
 	/// Setup connection
-	conn := NewConnection(cfg.Endpoint[config.InitialEndPointId], cfg.Endpoint, cfg.Name, (*signature.KeyringPair)(krp), logger, stop, sysErr)
+	var pm *pool.Metrics
+	if m != nil {
+		pm = pool.NewMetrics(cfg.Name)
+	}
+	conn := NewConnection(cfg.Endpoint, cfg.Name, s, logger, stop, sysErr, parseMaxEndpointLag(cfg), pm)
 	err = conn.Connect()
 	if err != nil {
 		return nil, err
@@ -103,7 +158,7 @@ func InitializeChain(cfg *core.ChainConfig, logger log15.Logger, sysErr chan<- e
 
 	//if cfg.LatestBlock {
 	if startBlock == 0 {
-		curr, err := conn.api.RPC.Chain.GetHeaderLatest()
+		curr, err := conn.API().RPC.Chain.GetHeaderLatest()
 		if err != nil {
 			return nil, err
 		}
@@ -119,26 +174,116 @@ func InitializeChain(cfg *core.ChainConfig, logger log15.Logger, sysErr chan<- e
 	multiSigAddress := parsemultiSigAddress(cfg)
 	total, relayerId, threshold := parseMultiSigConfig(cfg)
 	weight := parseMaxWeight(cfg)
+	batchWindow := parseBatchWindow(cfg)
+	maxBatchSize := parseMaxBatchSize(cfg)
+	fetchConcurrency := parseFetchConcurrency(cfg)
+	depositQuorum := parseDepositQuorum(cfg)
+	destId := parseDestId(cfg)
+	limitsCfg := parseLimits(cfg)
 
 	/// Set relayer parameters
-	relayer := NewRelayer(signature.KeyringPair(*krp), otherRelayers, total, threshold, relayerId, weight)
+	relayer := NewRelayer(s, otherRelayers, total, threshold, relayerId, weight)
 
 	bc := chainset.NewChainCore(cfg.Name)
-	bc.InitializeClientPrefix(conn.cli)
+	bc.InitializeClientPrefix(conn.Client())
+
+	/// Refuse to start if the decimals of the currencies are not the configured ones
+	err = conn.discoverDecimals(bc)
+	if err != nil {
+		return nil, err
+	}
 
 	//log15.Debug("Initialize ChainInfo", "Prefix", conn.cli.Prefix, "Name", conn.cli.Name, "Id", cfg.Id)
 	//fmt.Printf("chain: %v\n", bc.ChainInfo)
 
+	/// Rejected deposits are recorded by the listener and refunded by the writer
+	var rm *refunds.Metrics
+	if m != nil {
+		rm = refunds.NewMetrics(cfg.Name)
+	}
+	rejected, err := refunds.NewStore(parseRefunds(cfg), rm)
+	if err != nil {
+		return nil, err
+	}
+
+	/// The listener and the writer share the notifier of the webhooks
+	var notifier *webhooks.Notifier
+	if webhooksCfg := parseWebhooks(cfg); webhooksCfg != nil {
+		var wm *webhooks.Metrics
+		if m != nil {
+			wm = webhooks.NewMetrics(cfg.Name)
+		}
+		notifier = webhooks.NewNotifier(webhooksCfg, cfg.Name, logger, wm)
+	}
+
+	/// Shared with the other chains recording to the same directory
+	transfers := lifecycle.Open(parseLifecycleDir(cfg))
+
 	/// Setup listener & writer
 	l := NewListener(conn, cfg.Name, cfg.Id, startBlock, endBlock, lostAddress,
-		logger, bs, stop, sysErr, m, multiSigAddress, relayer, bc)
-	w := NewWriter(conn, l, logger, sysErr, m, useExtended, relayer, bc)
+		logger, bs, stop, sysErr, m, multiSigAddress, relayer, bc, fetchConcurrency, depositQuorum, destId, rejected,
+		notifier, transfers)
+	var lm *limits.Metrics
+	if m != nil {
+		lm = limits.NewMetrics(cfg.Name)
+	}
+	var screener *screening.Screener
+	if screenCfg := screening.ParseConfig(cfg.Opts); screenCfg != nil {
+		screenCfg.Path, err = screening.StatePath(cfg.BlockstorePath, cfg.Id)
+		if err != nil {
+			return nil, err
+		}
+		var scm *screening.Metrics
+		if m != nil {
+			scm = screening.NewMetrics(cfg.Name)
+		}
+		screener, err = screening.NewScreener(screenCfg, logger, scm)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var queue *approvals.Queue
+	if approvalsCfg := parseApprovals(cfg); approvalsCfg != nil {
+		var am *approvals.Metrics
+		if m != nil {
+			am = approvals.NewMetrics(cfg.Name)
+		}
+		queue, err = approvals.NewQueue(approvalsCfg, cfg.Id, approvals.NewSr25519Signer(s), logger, am)
+		if err != nil {
+			return nil, err
+		}
+	}
+	limiter, err := limits.NewLimiter(limitsCfg, logger, lm)
+	if err != nil {
+		return nil, err
+	}
+	redemptionsPath, err := registryPath(cfg.BlockstorePath, cfg.Id)
+	if err != nil {
+		return nil, err
+	}
+	redemptions, err := newRegistry(redemptionsPath, logger)
+	if err != nil {
+		return nil, err
+	}
+	w := NewWriter(conn, l, logger, sysErr, m, useExtended, relayer, bc, redemptions, batchWindow, maxBatchSize,
+		limiter, screener, queue, rejected, notifier, transfers)
+
+	var checker *supply.Checker
+	if issuer, ok, interval, tolerance := parseSupplyCheck(cfg); ok {
+		var sm *supply.Metrics
+		if m != nil {
+			sm = supply.NewMetrics(cfg.Name)
+		}
+		checker = supply.NewChecker(cfg.Id, issuer, conn.lockedFunc(bc, multiSigAddress), custodyAssets(bc),
+			tolerance, interval, logger, sm)
+	}
 
 	return &Chain{
 		cfg:      cfg,
 		conn:     conn,
 		listener: l,
 		writer:   w,
+		supply:   checker,
 		stop:     stop,
 	}, nil
 }
@@ -148,6 +293,10 @@ func (c *Chain) Start() error {
 	if err != nil {
 		return err
 	}
+	c.writer.start()
+	if c.supply != nil {
+		go c.supply.Run(c.listener.stop)
+	}
 	c.conn.log.Debug("Successfully started chain", "chainId", c.cfg.Id)
 	log15.Info("Successfully started chain", "chainId", c.cfg.Id)
 	return nil
@@ -158,6 +307,16 @@ func (c *Chain) SetRouter(r *core.Router) {
 	c.listener.setRouter(r)
 }
 
+// Replay runs the listener over the blocks from start to end, routing the deposits found to r
+func (c *Chain) Replay(start uint64, end uint64, r chains.Router) error {
+	return c.listener.replay(start, end, r)
+}
+
+// Resolver returns the writer of the chain
+func (c *Chain) Resolver() chains.Resolver {
+	return c.writer
+}
+
 func (c *Chain) LatestBlock() metrics.LatestBlock {
 	return c.listener.latestBlock
 }
@@ -4,8 +4,18 @@
 package substrate
 
 import (
+	"fmt"
+	"time"
+
 	log "github.com/ChainSafe/log15"
 	"github.com/Platdot-Network/go-substrate-rpc-client/v3/types"
+	"github.com/Platdot-network/Platdot/chains/approvals"
+	"github.com/Platdot-network/Platdot/chains/lifecycle"
+	"github.com/Platdot-network/Platdot/chains/limits"
+	"github.com/Platdot-network/Platdot/chains/refunds"
+	"github.com/Platdot-network/Platdot/chains/webhooks"
+	"github.com/Platdot-network/Platdot/connections/pool"
+	"github.com/Platdot-network/Platdot/connections/signer"
 	"github.com/hacpy/go-ethereum/common"
 	"github.com/rjman-ljm/go-substrate-crypto/ss58"
 	"github.com/rjman-ljm/platdot-utils/msg"
@@ -28,6 +38,14 @@ var (
 	DestIdOpt             = "destId"
 	ResourceIdOpt         = "resourceId"
 	MultiSigThresholdOpt  = "multiSigThreshold"
+	BatchWindowOpt        = "batchWindow"
+	MaxBatchSizeOpt       = "maxBatchSize"
+	FetchConcurrencyOpt   = "fetchConcurrency"
+	MaxEndpointLagOpt     = "maxEndpointLag"
+	DepositQuorumOpt      = "depositQuorum"
+	SupplyChainOpt        = "supplyChain"
+	SupplyIntervalOpt     = "supplyCheckInterval"
+	SupplyToleranceOpt    = "supplyTolerance"
 
 	OtherRelayerOpt       = "otherRelayer"
 )
@@ -148,6 +166,154 @@ func parseMaxWeight(cfg *core.ChainConfig) uint64 {
 	return 2269800000
 }
 
+// parseBatchWindow returns the batch window in seconds
+func parseBatchWindow(cfg *core.ChainConfig) time.Duration {
+	if window, ok := cfg.Opts[BatchWindowOpt]; ok {
+		res, err := strconv.ParseUint(window, 10, 32)
+		if err != nil || res == 0 {
+			panic(fmt.Errorf("invalid %s: %s", BatchWindowOpt, window))
+		}
+		return time.Duration(res) * time.Second
+	}
+	return DefaultBatchWindow
+}
+
+func parseMaxBatchSize(cfg *core.ChainConfig) int {
+	if size, ok := cfg.Opts[MaxBatchSizeOpt]; ok {
+		res, err := strconv.ParseUint(size, 10, 32)
+		if err != nil || res == 0 {
+			panic(fmt.Errorf("invalid %s: %s", MaxBatchSizeOpt, size))
+		}
+		return int(res)
+	}
+	return DefaultMaxBatchSize
+}
+
+// parseFetchConcurrency returns the number of blocks the listener fetches in parallel
+func parseFetchConcurrency(cfg *core.ChainConfig) int {
+	if concurrency, ok := cfg.Opts[FetchConcurrencyOpt]; ok {
+		res, err := strconv.ParseUint(concurrency, 10, 32)
+		if err != nil || res == 0 {
+			panic(fmt.Errorf("invalid %s: %s", FetchConcurrencyOpt, concurrency))
+		}
+		return int(res)
+	}
+	return DefaultFetchConcurrency
+}
+
+// parseMaxEndpointLag returns the number of blocks an endpoint may lag behind the others before it is avoided
+func parseMaxEndpointLag(cfg *core.ChainConfig) uint64 {
+	if lag, ok := cfg.Opts[MaxEndpointLagOpt]; ok {
+		res, err := strconv.ParseUint(lag, 10, 64)
+		if err != nil {
+			panic(fmt.Errorf("invalid %s: %s", MaxEndpointLagOpt, lag))
+		}
+		return res
+	}
+	return pool.DefaultMaxLag
+}
+
+// parseDepositQuorum returns the number of endpoints which must agree on a block before its deposits are routed
+func parseDepositQuorum(cfg *core.ChainConfig) int {
+	if quorum, ok := cfg.Opts[DepositQuorumOpt]; ok {
+		res, err := strconv.ParseUint(quorum, 10, 32)
+		if err != nil || int(res) > len(cfg.Endpoint) {
+			panic(fmt.Errorf("invalid %s: %s, %d endpoints configured", DepositQuorumOpt, quorum, len(cfg.Endpoint)))
+		}
+		return int(res)
+	}
+	return 0
+}
+
+// parseSupplyCheck returns the chain issuing the wrapped tokens of the multiSig, the interval in seconds between two
+// checks of their supply and its tolerance in basis points. The supply is checked only if the chain is set.
+func parseSupplyCheck(cfg *core.ChainConfig) (msg.ChainId, bool, time.Duration, uint64) {
+	id, ok := cfg.Opts[SupplyChainOpt]
+	if !ok {
+		return 0, false, 0, 0
+	}
+	issuer, err := strconv.ParseUint(id, 10, 8)
+	if err != nil {
+		panic(fmt.Errorf("invalid %s: %s", SupplyChainOpt, id))
+	}
+
+	interval := DefaultSupplyInterval
+	if value, ok := cfg.Opts[SupplyIntervalOpt]; ok {
+		res, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || res == 0 {
+			panic(fmt.Errorf("invalid %s: %s", SupplyIntervalOpt, value))
+		}
+		interval = time.Duration(res) * time.Second
+	}
+
+	var tolerance uint64
+	if value, ok := cfg.Opts[SupplyToleranceOpt]; ok {
+		tolerance, err = strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			panic(fmt.Errorf("invalid %s: %s", SupplyToleranceOpt, value))
+		}
+	}
+	return msg.ChainId(issuer), true, interval, tolerance
+}
+
+// parseLimits returns the limits of the redemptions, see the limits package for the options
+func parseLimits(cfg *core.ChainConfig) *limits.Config {
+	res, err := limits.ParseConfig(cfg.Opts)
+	if err != nil {
+		panic(err)
+	}
+	res.Path, err = limits.StatePath(cfg.BlockstorePath, cfg.Id)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// parseApprovals returns the approval thresholds of the redemptions, see the approvals package for the options
+func parseApprovals(cfg *core.ChainConfig) *approvals.Config {
+	res, err := approvals.ParseConfig(cfg.Opts, cfg.BlockstorePath, cfg.Id)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// parseRefunds returns the minimum deposits and the refund options, see the refunds package for the options
+func parseRefunds(cfg *core.ChainConfig) *refunds.Config {
+	res, err := refunds.ParseConfig(cfg.Opts, cfg.BlockstorePath, cfg.Id)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// parseWebhooks returns the endpoints notified of the transfers, see the webhooks package for the options
+func parseWebhooks(cfg *core.ChainConfig) *webhooks.Config {
+	res, err := webhooks.ParseConfig(cfg.Opts)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// parseLifecycleDir returns the directory recording the state of the transfers, see the lifecycle package
+func parseLifecycleDir(cfg *core.ChainConfig) string {
+	res, err := lifecycle.ParseDir(cfg.Opts, cfg.BlockstorePath)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// parseSigner returns the signing service holding the relayer key, nil to use the keystore
+func parseSigner(cfg *core.ChainConfig) *signer.Config {
+	res, err := signer.ParseConfig(cfg.Opts)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
 func parseDestId(cfg *core.ChainConfig) msg.ChainId {
 	if id, ok := cfg.Opts[DestIdOpt]; ok {
 		res, err := strconv.ParseUint(id, 10, 32)
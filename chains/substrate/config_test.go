@@ -5,6 +5,7 @@ package substrate
 
 import (
 	"testing"
+	"time"
 
 	"github.com/rjman-ljm/platdot-utils/core"
 )
@@ -28,3 +29,48 @@ func TestParseStartBlock(t *testing.T) {
 		t.Fatalf("Got: %d Expected: %d", blk, 0)
 	}
 }
+
+func TestParseBatchConfig(t *testing.T) {
+	cfg := &core.ChainConfig{Opts: map[string]string{BatchWindowOpt: "12", MaxBatchSizeOpt: "4"}}
+	if window := parseBatchWindow(cfg); window != 12*time.Second {
+		t.Fatalf("Got: %s Expected: %s", window, 12*time.Second)
+	}
+	if size := parseMaxBatchSize(cfg); size != 4 {
+		t.Fatalf("Got: %d Expected: %d", size, 4)
+	}
+
+	cfg = &core.ChainConfig{Opts: map[string]string{}}
+	if window := parseBatchWindow(cfg); window != DefaultBatchWindow {
+		t.Fatalf("Got: %s Expected: %s", window, DefaultBatchWindow)
+	}
+	if size := parseMaxBatchSize(cfg); size != DefaultMaxBatchSize {
+		t.Fatalf("Got: %d Expected: %d", size, DefaultMaxBatchSize)
+	}
+}
+
+func TestParseFetchConcurrency(t *testing.T) {
+	cfg := &core.ChainConfig{Opts: map[string]string{FetchConcurrencyOpt: "16"}}
+	if concurrency := parseFetchConcurrency(cfg); concurrency != 16 {
+		t.Fatalf("Got: %d Expected: %d", concurrency, 16)
+	}
+
+	cfg = &core.ChainConfig{Opts: map[string]string{}}
+	if concurrency := parseFetchConcurrency(cfg); concurrency != DefaultFetchConcurrency {
+		t.Fatalf("Got: %d Expected: %d", concurrency, DefaultFetchConcurrency)
+	}
+}
+
+func TestParseDepositQuorum(t *testing.T) {
+	cfg := &core.ChainConfig{Endpoint: []string{"a", "b", "c"}, Opts: map[string]string{DepositQuorumOpt: "2"}}
+	if quorum := parseDepositQuorum(cfg); quorum != 2 {
+		t.Fatalf("Got: %d Expected: %d", quorum, 2)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("A quorum above the number of endpoints should be rejected")
+		}
+	}()
+	cfg.Opts[DepositQuorumOpt] = "4"
+	parseDepositQuorum(cfg)
+}
@@ -9,7 +9,8 @@ import (
 
 	"github.com/Platdot-Network/substrate-go/client"
 	"github.com/Platdot-network/Platdot/chains/chainset"
-	"github.com/hacpy/go-ethereum/log"
+	"github.com/Platdot-network/Platdot/connections/pool"
+	"github.com/Platdot-network/Platdot/connections/signer"
 
 	"github.com/ChainSafe/log15"
 	gsrpc "github.com/Platdot-Network/go-substrate-rpc-client/v3"
@@ -17,7 +18,6 @@ import (
 
 	utils "github.com/Platdot-network/Platdot/shared/substrate"
 	"github.com/Platdot-Network/go-substrate-rpc-client/v3/rpc/author"
-	"github.com/Platdot-Network/go-substrate-rpc-client/v3/signature"
 	"github.com/Platdot-Network/go-substrate-rpc-client/v3/types"
 )
 
@@ -26,12 +26,14 @@ type Connection struct {
 	api         *gsrpc.SubstrateAPI
 	log         log15.Logger
 	url         string                 // API endpoint
-	endpoint    []string               // Backup endpoint
+	urlLock     sync.RWMutex           // Locks url, cli, api and genesisHash while switching endpoints
+	pool        *pool.Pool             // Health of the configured endpoints
+	clients     *endpointClients       // Clients to all endpoints
+	startOnce   sync.Once
 	name        string                 // Chain name
-	meta        types.Metadata         // Latest chain metadata
-	metaLock    sync.RWMutex           // Lock metadata for updates, allows concurrent reads
+	runtimes    *runtimeCache          // Runtimes by spec version, with the current one
 	genesisHash types.Hash             // Chain genesis hash
-	key         *signature.KeyringPair // Keyring used for signing
+	signer      signer.Signer          // Signs the extrinsics with the relayer key
 	nonce       types.U32              // Latest account nonce
 	nonceLock   sync.Mutex             // Locks nonce for updates
 	stop        <-chan int             // Signals system shutdown, should be observed in all selects and loops
@@ -39,100 +41,170 @@ type Connection struct {
 	prefix      []byte                 // the prefix of token
 }
 
-func NewConnection(url string, endpoint []string, name string, key *signature.KeyringPair, log log15.Logger, stop <-chan int, sysErr chan<- error) *Connection {
-	return &Connection{url: url, endpoint: endpoint, name: name, key: key, log: log, stop: stop, sysErr: sysErr}
+// NewConnection returns an uninitialized connection, must call Connection.Connect() before using.
+// Endpoints lagging more than maxLag blocks behind the others are avoided, m may be nil.
+// The extrinsics are signed by s, which holds the relayer key or asks a signing service for the signatures.
+func NewConnection(endpoints []string, name string, s signer.Signer, log log15.Logger, stop <-chan int, sysErr chan<- error, maxLag uint64, m *pool.Metrics) *Connection {
+	c := &Connection{name: name, signer: s, log: log, stop: stop, sysErr: sysErr, runtimes: newRuntimeCache()}
+	if len(endpoints) != 0 {
+		c.url = endpoints[0]
+	}
+	c.clients = newEndpointClients()
+	c.pool = pool.NewPool(endpoints, c.clients.probe, maxLag, m)
+	return c
+}
+
+// getMetadata returns the metadata of the current runtime
+func (c *Connection) getMetadata() *types.Metadata {
+	return c.runtimes.latest().meta
 }
 
-func (c *Connection) getMetadata() (meta types.Metadata) {
-	c.metaLock.RLock()
-	meta = c.meta
-	c.metaLock.RUnlock()
-	return meta
+// runtime returns the current runtime
+func (c *Connection) runtime() *runtimeInfo {
+	return c.runtimes.latest()
 }
 
-func (c *Connection) updateMetatdata() error {
-	c.metaLock.Lock()
-	meta, err := c.api.RPC.State.GetMetadataLatest()
+// refreshRuntime checks for a runtime upgrade, the metadata is only fetched for a new spec version
+func (c *Connection) refreshRuntime() error {
+	rt, changed, err := c.runtimes.refresh(apiSource{c.API()})
 	if err != nil {
-		c.metaLock.Unlock()
 		return err
 	}
-	c.meta = *meta
-	c.metaLock.Unlock()
+	if changed {
+		c.log.Info("Runtime upgraded", "SpecVersion", rt.specVersion, "TransactionVersion", rt.transactionVersion)
+	}
 	return nil
 }
 
-func (c *Connection) getAnotherEndPoint() string {
-	curEndPoint := c.url
-	limit := len(c.endpoint) - 1
-	if limit < 0 {
-		log.Error("cfg `EndPoint set Error`, can't switch endpoint")
-	}
+// runtimeAt returns the runtime which executed the block with the given parent, the runtime held in the state of
+// the parent
+func (c *Connection) runtimeAt(parent types.Hash, parentNumber uint64) (*runtimeInfo, error) {
+	return c.runtimes.atBlock(apiSource{c.API()}, parent, parentNumber)
+}
 
-	for i, url := range c.endpoint {
-		if url == curEndPoint && i < limit {
-			return c.endpoint[i+1]
-		}
+// anchorRuntime looks up the runtime held in the state of a finalized block, so that the runtimes of the blocks up
+// to it are known without asking the node for each of them
+func (c *Connection) anchorRuntime(number uint64) error {
+	if c.runtimes.cached(number) != nil {
+		return nil
+	}
+	api := c.API()
+	hash, err := api.RPC.Chain.GetBlockHash(number)
+	if err != nil {
+		return err
 	}
+	_, err = c.runtimes.atBlock(apiSource{api}, hash, number)
+	return err
+}
+
+// Endpoint returns the endpoint currently connected to
+func (c *Connection) Endpoint() string {
+	c.urlLock.RLock()
+	defer c.urlLock.RUnlock()
+	return c.url
+}
+
+// API returns the api of the current endpoint, which resolves the events
+func (c *Connection) API() *gsrpc.SubstrateAPI {
+	c.urlLock.RLock()
+	defer c.urlLock.RUnlock()
+	return c.api
+}
+
+// Client returns the client of the current endpoint, which resolves the extrinsics
+func (c *Connection) Client() *client.Client {
+	c.urlLock.RLock()
+	defer c.urlLock.RUnlock()
+	return c.cli
+}
+
+// GenesisHash returns the genesis hash of the chain, as returned by the current endpoint
+func (c *Connection) GenesisHash() types.Hash {
+	c.urlLock.RLock()
+	defer c.urlLock.RUnlock()
+	return c.genesisHash
+}
 
-	return c.endpoint[0]
+// EndpointStatus returns the health of the configured endpoints
+func (c *Connection) EndpointStatus() []pool.Status {
+	return c.pool.Status()
 }
 
+// Reconnect connects to the best endpoint other than the current one
 func (c *Connection) Reconnect() error {
-	c.url = c.getAnotherEndPoint()
-	err := c.Connect()
+	return c.switchTo(c.pool.Failover(c.Endpoint()))
+}
+
+// Connect checks the health of the endpoints and connects to the best one. The endpoints are checked in the
+// background afterwards, and the connection switches away from unhealthy or lagging endpoints.
+func (c *Connection) Connect() error {
+	c.pool.Check()
+	url, ok := c.pool.Best()
+	if !ok {
+		url = c.Endpoint()
+	}
+	err := c.switchTo(url)
 	if err != nil {
 		return err
 	}
+
+	c.startOnce.Do(func() {
+		go c.pool.Run(c.stop)
+		go c.pool.Follow(c.Endpoint, c.switchTo, c.stop)
+	})
 	return nil
 }
 
-func (c *Connection) Connect() error {
-	c.log.Info("Connecting to substrate chain...", "url", c.url)
+// switchTo connects to the endpoint and fetches its metadata and genesis hash
+func (c *Connection) switchTo(url string) error {
+	c.log.Info("Connecting to substrate chain...", "url", url)
 	/// Initialize api to resolve events
-	api, err := gsrpc.NewSubstrateAPI(c.url)
+	api, err := gsrpc.NewSubstrateAPI(url)
 	if err != nil {
 		return err
 	}
-	c.api = api
 
 	/// Initialize api to resolve extrinsic
-	cli, err := client.New(c.url)
+	cli, err := client.New(url)
 	if err != nil {
 		return err
 	}
-	c.cli = cli
 	bc := chainset.NewChainCore(c.name)
 	bc.InitializeClientPrefix(cli)
 
-	// Fetch metadata
-	meta, err := api.RPC.State.GetMetadataLatest()
+	// Fetch the current runtime, its metadata is only fetched if it isn't cached yet
+	rt, _, err := c.runtimes.refresh(apiSource{api})
 	if err != nil {
 		return err
 	}
-	c.meta = *meta
-	c.log.Debug("Fetched substrate metadata")
+	c.log.Debug("Fetched substrate runtime", "SpecVersion", rt.specVersion)
 
 	// Fetch genesis hash
-	genesisHash, err := c.api.RPC.Chain.GetBlockHash(0)
+	genesisHash, err := api.RPC.Chain.GetBlockHash(0)
 	if err != nil {
 		return err
 	}
-	c.genesisHash = genesisHash
 	c.log.Debug("Fetched substrate genesis hash", "hash", genesisHash.Hex())
+
+	c.urlLock.Lock()
+	c.api = api
+	c.cli = cli
+	c.genesisHash = genesisHash
+	c.url = url
+	c.urlLock.Unlock()
 	return nil
 }
 
 // SubmitTx constructs and submits an extrinsic to call the method with the given arguments.
 // All args are passed directly into GSRPC. GSRPC types are recommended to avoid serialization inconsistencies.
 func (c *Connection) SubmitTx(method utils.Method, args ...interface{}) error {
-	c.log.Debug("Submitting substrate call...", "method", method, "sender", c.key.Address)
+	c.log.Debug("Submitting substrate call...", "method", method, "sender", types.HexEncodeToString(c.signer.PublicKey()))
 
-	meta := c.getMetadata()
+	rt := c.runtime()
 
 	// Create call and extrinsic
 	call, err := types.NewCall(
-		&meta,
+		rt.meta,
 		string(method),
 		args...,
 	)
@@ -141,12 +213,6 @@ func (c *Connection) SubmitTx(method utils.Method, args ...interface{}) error {
 	}
 	ext := types.NewExtrinsic(call)
 
-	// Get latest runtime version
-	rv, err := c.api.RPC.State.GetRuntimeVersionLatest()
-	if err != nil {
-		return err
-	}
-
 	c.nonceLock.Lock()
 	latestNonce, err := c.getLatestNonce()
 	if err != nil {
@@ -158,24 +224,25 @@ func (c *Connection) SubmitTx(method utils.Method, args ...interface{}) error {
 	}
 
 	// Sign the extrinsic
+	genesisHash := c.GenesisHash()
 	o := types.SignatureOptions{
-		BlockHash:          c.genesisHash,
+		BlockHash:          genesisHash,
 		Era:                types.ExtrinsicEra{IsMortalEra: false},
-		GenesisHash:        c.genesisHash,
+		GenesisHash:        genesisHash,
 		Nonce:              types.NewUCompactFromUInt(uint64(c.nonce)),
-		SpecVersion:        rv.SpecVersion,
+		SpecVersion:        rt.specVersion,
 		Tip:                types.NewUCompactFromUInt(0),
-		TransactionVersion: 1,
+		TransactionVersion: rt.transactionVersion,
 	}
 
-	err = ext.Sign(*c.key, o)
+	err = signExtrinsic(&ext, c.signer, o)
 	if err != nil {
 		c.nonceLock.Unlock()
 		return err
 	}
 
 	// Submit and watch the extrinsic
-	sub, err := c.api.RPC.Author.SubmitAndWatchExtrinsic(ext)
+	sub, err := c.API().RPC.Author.SubmitAndWatchExtrinsic(ext)
 	c.nonce++
 	c.nonceLock.Unlock()
 	if err != nil {
@@ -214,12 +281,11 @@ func (c *Connection) watchSubmission(sub *author.ExtrinsicStatusSubscription) er
 // queryStorage performs a storage lookup. Arguments may be nil, result must be a pointer.
 func (c *Connection) queryStorage(prefix, method string, arg1, arg2 []byte, result interface{}) (bool, error) {
 	// Fetch account nonce
-	data := c.getMetadata()
-	key, err := types.CreateStorageKey(&data, prefix, method, arg1, arg2)
+	key, err := types.CreateStorageKey(c.getMetadata(), prefix, method, arg1, arg2)
 	if err != nil {
 		return false, err
 	}
-	return c.api.RPC.State.GetStorageLatest(key, result)
+	return c.API().RPC.State.GetStorageLatest(key, result)
 }
 
 // TODO: Add this to GSRPC
@@ -237,8 +303,7 @@ func getConst(meta *types.Metadata, prefix, name string, res interface{}) error
 }
 
 func (c *Connection) getConst(prefix, name string, res interface{}) error {
-	meta := c.getMetadata()
-	return getConst(&meta, prefix, name, res)
+	return getConst(c.getMetadata(), prefix, name, res)
 }
 
 func (c *Connection) checkChainId(expected msg.ChainId) error {
@@ -257,7 +322,7 @@ func (c *Connection) checkChainId(expected msg.ChainId) error {
 
 func (c *Connection) getLatestNonce() (types.U32, error) {
 	var acct types.AccountInfo
-	exists, err := c.queryStorage("System", "Account", c.key.PublicKey, nil, &acct)
+	exists, err := c.queryStorage("System", "Account", c.signer.PublicKey(), nil, &acct)
 	if err != nil {
 		return 0, err
 	}
@@ -1,7 +1,8 @@
 package substrate
 
 import (
-	"github.com/Platdot-network/Platdot/config"
+	"github.com/Platdot-network/Platdot/connections/pool"
+	"github.com/Platdot-network/Platdot/connections/signer"
 	"github.com/Platdot-Network/go-substrate-rpc-client/v3/types"
 	"testing"
 )
@@ -9,7 +10,7 @@ import (
 func TestConnect_QueryStorage(t *testing.T) {
 	// Create connection with Alice key
 	errs := make(chan error)
-	conn := NewConnection(TestEndpoint[config.InitialEndPointId], TestEndpoint, "Alice", AliceKey, AliceTestLogger, make(chan int), errs)
+	conn := NewConnection(TestEndpoint, "Alice", signer.NewSr25519(*AliceKey), AliceTestLogger, make(chan int), errs, pool.DefaultMaxLag, nil)
 	err := conn.Connect()
 	if err != nil {
 		t.Fatal(err)
@@ -18,7 +19,7 @@ func TestConnect_QueryStorage(t *testing.T) {
 
 	// Query storage
 	var data types.AccountInfo
-	_, err = conn.queryStorage("System", "Account", conn.key.PublicKey, nil, &data)
+	_, err = conn.queryStorage("System", "Account", conn.signer.PublicKey(), nil, &data)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -35,7 +36,7 @@ func TestConnect_QueryStorage(t *testing.T) {
 func TestConnect_CheckChainId(t *testing.T) {
 	// Create connection with Alice key
 	errs := make(chan error)
-	conn := NewConnection(TestEndpoint[config.InitialEndPointId], TestEndpoint, "Alice", AliceKey, AliceTestLogger, make(chan int), errs)
+	conn := NewConnection(TestEndpoint, "Alice", signer.NewSr25519(*AliceKey), AliceTestLogger, make(chan int), errs, pool.DefaultMaxLag, nil)
 	err := conn.Connect()
 	if err != nil {
 		t.Fatal(err)
@@ -59,7 +60,7 @@ func TestConnect_CheckChainId(t *testing.T) {
 func TestConnect_SubmitTx(t *testing.T) {
 	// Create connection with Alice key
 	errs := make(chan error)
-	conn := NewConnection(TestEndpoint[config.InitialEndPointId], TestEndpoint, "Alice", AliceKey, AliceTestLogger, make(chan int), errs)
+	conn := NewConnection(TestEndpoint, "Alice", signer.NewSr25519(*AliceKey), AliceTestLogger, make(chan int), errs, pool.DefaultMaxLag, nil)
 	err := conn.Connect()
 	if err != nil {
 		t.Fatal(err)
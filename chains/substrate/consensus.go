@@ -0,0 +1,106 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package substrate
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Platdot-Network/go-substrate-rpc-client/v3/client"
+	"github.com/Platdot-Network/go-substrate-rpc-client/v3/types"
+	"github.com/Platdot-Network/substrate-go/models"
+	"github.com/Platdot-network/Platdot/connections/pool"
+	"golang.org/x/crypto/blake2b"
+)
+
+// hasDeposits returns whether messages may be routed from the block
+func (b *fetchedBlock) hasDeposits() bool {
+	return len(b.exts) > 0 ||
+		len(b.evts.ChainBridge_FungibleTransfer) > 0 ||
+		len(b.evts.ChainBridge_NonFungibleTransfer) > 0 ||
+		len(b.evts.ChainBridge_GenericTransfer) > 0
+}
+
+// confirmBlock checks a block with deposits on the other endpoints before its messages are routed. The digest of the
+// block as fetched, its hash, events and extrinsics, must be returned by depositQuorum endpoints for the block at its
+// height. With a depositQuorum of 0 or 1, the block is trusted as returned by the endpoint connected to.
+func (l *listener) confirmBlock(block *fetchedBlock) error {
+	if l.depositQuorum <= 1 || !block.hasDeposits() {
+		return nil
+	}
+
+	key, err := types.CreateStorageKey(l.conn.getMetadata(), "System", "Events", nil, nil)
+	if err != nil {
+		return err
+	}
+
+	err = l.conn.Confirm(l.depositQuorum, block.digest, fetchBlockDigest(block.number, key))
+	var disagreement *pool.DisagreementError
+	if errors.As(err, &disagreement) {
+		l.log.Error(EndpointsDisagreeOnDeposits, "block", block.number, "source", disagreement.Source, "disagreeing", disagreement.Endpoint)
+	} else if err != nil {
+		l.log.Error(DepositsNotConfirmed, "block", block.number, "quorum", l.depositQuorum, "err", err)
+	}
+	return err
+}
+
+// fetchBlockDigest returns the digest of the block at a height as known by an endpoint, see blockDigest
+func fetchBlockDigest(number uint64, eventsKey types.StorageKey) func(cli client.Client) ([]byte, error) {
+	return func(cli client.Client) ([]byte, error) {
+		var hex string
+		err := cli.Call(&hex, "chain_getBlockHash", number)
+		if err != nil {
+			return nil, err
+		}
+		if hex == "" {
+			return nil, fmt.Errorf("block %d not found", number)
+		}
+		hash, err := types.NewHashFromHexString(hex)
+		if err != nil {
+			return nil, err
+		}
+
+		var block models.SignedBlock
+		err = cli.Call(&block, "chain_getBlock", hex)
+		if err != nil {
+			return nil, err
+		}
+
+		var events string
+		err = cli.Call(&events, "state_getStorage", eventsKey.Hex(), hex)
+		if err != nil {
+			return nil, err
+		}
+		var records []byte
+		if events != "" {
+			records, err = types.HexDecodeString(events)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return blockDigest(hash, records, block.Block.Extrinsics), nil
+	}
+}
+
+// blockDigest returns the hash of a block, its encoded events and its extrinsics
+func blockDigest(hash types.Hash, events []byte, extrinsics []string) []byte {
+	d, _ := blake2b.New256(nil)
+	d.Write(hash[:])
+	writeBytes(d, events)
+	for _, ext := range extrinsics {
+		writeBytes(d, []byte(ext))
+	}
+	return d.Sum(nil)
+}
+
+// writeBytes writes b prefixed by its length
+func writeBytes(w io.Writer, b []byte) {
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], uint64(len(b)))
+	_, _ = w.Write(size[:])
+	_, _ = w.Write(b)
+}
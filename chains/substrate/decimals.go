@@ -0,0 +1,135 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package substrate
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Platdot-Network/go-substrate-rpc-client/v3/types"
+	"github.com/Platdot-Network/substrate-go/expand/chainx/xevents"
+	"github.com/Platdot-network/Platdot/chains/chainset"
+)
+
+// chainProperties are the properties of the chain spec, tokenDecimals is a number, or a list on multi-token chains
+type chainProperties struct {
+	TokenDecimals json.RawMessage `json:"tokenDecimals"`
+}
+
+// nativeDecimals returns the decimals of the native token, the first token on multi-token chains
+func (p chainProperties) nativeDecimals() (uint8, bool) {
+	var decimals uint8
+	if json.Unmarshal(p.TokenDecimals, &decimals) == nil {
+		return decimals, true
+	}
+	var list []uint8
+	if json.Unmarshal(p.TokenDecimals, &list) == nil && len(list) > 0 {
+		return list[0], true
+	}
+	return 0, false
+}
+
+// assetDecimals is the stored description of an asset
+type assetDecimals interface {
+	decimals() uint8
+}
+
+// assetInfo is the info of an asset registered on ChainX
+type assetInfo struct {
+	Token     types.Bytes
+	TokenName types.Bytes
+	Chain     types.U8
+	Decimals  types.U8
+	Desc      types.Bytes
+}
+
+func (a *assetInfo) decimals() uint8 {
+	return uint8(a.Decimals)
+}
+
+// assetMetadata is the metadata of an asset of the Assets pallet
+type assetMetadata struct {
+	Deposit  types.U128
+	Name     types.Bytes
+	Symbol   types.Bytes
+	Decimals types.U8
+	IsFrozen bool
+}
+
+func (a *assetMetadata) decimals() uint8 {
+	return uint8(a.Decimals)
+}
+
+// The assets whose decimals are discovered, on ChainX and on chains with the Assets pallet
+var discoveredXAssets = []xevents.AssetId{chainset.AssetXBTC, chainset.XAssetId}
+var discoveredAssets = []xevents.AssetId{chainset.AssetUSDT}
+
+// discoverDecimals reads the decimals of the native token from the chain properties, and of the assets from their
+// registered info or metadata, and records them for the currencies of the chain. It fails if they differ from the
+// declared decimals of a currency, currencies unknown to the chain are skipped.
+func (c *Connection) discoverDecimals(bc *chainset.ChainCore) error {
+	currency, err := bc.GetCurrencyByAssetId(chainset.OriginAsset)
+	if err != nil {
+		c.log.Warn("Native token has no currency, its decimals are not checked", "token", bc.ChainInfo.NativeToken)
+	} else {
+		var props chainProperties
+		err = c.API().Client.Call(&props, "system_properties")
+		if err != nil {
+			return fmt.Errorf("failed to fetch chain properties: %w", err)
+		}
+		decimals, ok := props.nativeDecimals()
+		if !ok {
+			return fmt.Errorf("chain properties have no token decimals")
+		}
+		err = c.setDecimals(currency.Name, decimals)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch bc.ChainInfo.Type {
+	case chainset.ChainXAssetLike, chainset.ChainXAssetV1Like:
+		return c.discoverAssetDecimals(bc, discoveredXAssets, "XAssetsRegistrar", "AssetInfoOf", func() assetDecimals { return &assetInfo{} })
+	case chainset.AssetsLike:
+		return c.discoverAssetDecimals(bc, discoveredAssets, "Assets", "Metadata", func() assetDecimals { return &assetMetadata{} })
+	}
+	return nil
+}
+
+// discoverAssetDecimals reads the decimals of the assets from the storage map `prefix.method`, keyed by asset id
+func (c *Connection) discoverAssetDecimals(bc *chainset.ChainCore, assetIds []xevents.AssetId, prefix, method string, newInfo func() assetDecimals) error {
+	for _, assetId := range assetIds {
+		currency, err := bc.GetCurrencyByAssetId(assetId)
+		if err != nil {
+			continue
+		}
+		key, err := types.EncodeToBytes(types.U32(assetId))
+		if err != nil {
+			return err
+		}
+		info := newInfo()
+		exists, err := c.queryStorage(prefix, method, key, nil, info)
+		if err != nil {
+			return fmt.Errorf("failed to fetch the info of asset %d: %w", assetId, err)
+		}
+		if !exists {
+			c.log.Warn("Asset not registered, its decimals are not checked", "asset", assetId, "token", currency.Name)
+			continue
+		}
+		err = c.setDecimals(currency.Name, info.decimals())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Connection) setDecimals(token string, decimals uint8) error {
+	err := chainset.SetSubLikeDecimals(token, decimals)
+	if err != nil {
+		return err
+	}
+	c.log.Info("Discovered token decimals", "token", token, "decimals", decimals)
+	return nil
+}
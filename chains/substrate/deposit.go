@@ -0,0 +1,338 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package substrate
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"github.com/Platdot-Network/go-substrate-rpc-client/v3/scale"
+	"github.com/Platdot-Network/go-substrate-rpc-client/v3/types"
+	"github.com/Platdot-Network/substrate-go/expand/chainx/xevents"
+	"github.com/Platdot-network/Platdot/chains/chainset"
+	utils "github.com/Platdot-network/Platdot/shared/substrate"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+// MaxBatchDeposits is the maximum number of deposits in one batch extrinsic. The pair index takes 8 bits of the nonce,
+// room for 256 deposits, but larger batches are refused.
+const MaxBatchDeposits = 100
+
+// batchCall is a call of a Utility.batch extrinsic
+type batchCall struct {
+	transfer bool            // Balances.transfer, Balances.transfer_keep_alive, XAssets.transfer or Assets.transfer
+	remark   bool            // System.remark
+	dest     types.AccountID // Recipient of the transfer
+	amount   *big.Int
+	assetId  xevents.AssetId
+	data     string // Content of the remark
+}
+
+// depositExtrinsic is a signed Utility.batch or Utility.batch_all extrinsic
+type depositExtrinsic struct {
+	index  int
+	hash   types.Hash // Hash of the extrinsic
+	signer types.AccountID
+	calls  []batchCall
+}
+
+// batchDeposit is a transfer into the multisig paired with the remark which follows it
+type batchDeposit struct {
+	transfer int // Index of the transfer in the batch
+	remark   int // Index of the remark in the batch
+	amount   *big.Int
+	assetId  xevents.AssetId
+	data     string
+}
+
+// callIndices are the indices of the calls a deposit batch is made of
+type callIndices struct {
+	batch, batchAll             types.CallIndex
+	transfer, transferKeepAlive types.CallIndex
+	remark                      types.CallIndex
+	xTransfer                   types.CallIndex
+	hasXAssets                  bool
+	assetsTransfer              types.CallIndex
+	assetsTransferKeepAlive     types.CallIndex
+	hasAssets                   bool
+	asMulti                     types.CallIndex
+	hasMultisig                 bool
+	useAddress                  bool // Accounts are encoded as `Address` instead of `MultiAddress`
+}
+
+func newCallIndices(meta *types.Metadata, useAddress bool) (*callIndices, error) {
+	var err error
+	c := &callIndices{useAddress: useAddress}
+	for method, index := range map[utils.Method]*types.CallIndex{
+		utils.UtilityBatch:                    &c.batch,
+		utils.UtilityBatchAll:                 &c.batchAll,
+		utils.BalancesTransferMethod:          &c.transfer,
+		utils.BalancesTransferKeepAliveMethod: &c.transferKeepAlive,
+		utils.SystemRemark:                    &c.remark,
+	} {
+		*index, err = meta.FindCallIndex(string(method))
+		if err != nil {
+			return nil, err
+		}
+	}
+	c.xTransfer, err = meta.FindCallIndex(string(utils.XAssetsTransferMethod))
+	c.hasXAssets = err == nil
+	c.assetsTransfer, err = meta.FindCallIndex(string(utils.AssetsTransferMethod))
+	if err == nil {
+		c.assetsTransferKeepAlive, err = meta.FindCallIndex(string(utils.AssetsTransferKeepAliveMethod))
+	}
+	c.hasAssets = err == nil
+	c.asMulti, err = meta.FindCallIndex(string(utils.MultisigAsMulti))
+	c.hasMultisig = err == nil
+	return c, nil
+}
+
+// decodeSignedExtrinsic decodes the header of a signed extrinsic up to its call index. It returns a nil decoder if the
+// extrinsic isn't signed. The extrinsic is decoded by hand, since the signer of ChainX V1 is still encoded as `Address`.
+func decodeSignedExtrinsic(raw []byte, c *callIndices) (*scale.Decoder, types.AccountID, types.CallIndex, error) {
+	decoder := scale.NewDecoder(bytes.NewReader(raw))
+	_, err := decoder.DecodeUintCompact()
+	if err != nil {
+		return nil, types.AccountID{}, types.CallIndex{}, err
+	}
+
+	var version byte
+	err = decoder.Decode(&version)
+	if err != nil {
+		return nil, types.AccountID{}, types.CallIndex{}, err
+	}
+	if version&types.ExtrinsicBitSigned == 0 {
+		return nil, types.AccountID{}, types.CallIndex{}, nil
+	}
+	if version&types.ExtrinsicUnmaskVersion != types.ExtrinsicVersion4 {
+		return nil, types.AccountID{}, types.CallIndex{}, fmt.Errorf("unsupported extrinsic version: %d", version&types.ExtrinsicUnmaskVersion)
+	}
+
+	signer, err := c.decodeDest(decoder)
+	if err != nil {
+		return nil, types.AccountID{}, types.CallIndex{}, err
+	}
+	var signature types.MultiSignature
+	var era types.ExtrinsicEra
+	var nonce, tip types.UCompact
+	for _, field := range []interface{}{&signature, &era, &nonce, &tip} {
+		err = decoder.Decode(field)
+		if err != nil {
+			return nil, types.AccountID{}, types.CallIndex{}, err
+		}
+	}
+
+	var callIndex types.CallIndex
+	err = decoder.Decode(&callIndex)
+	if err != nil {
+		return nil, types.AccountID{}, types.CallIndex{}, err
+	}
+	return decoder, signer, callIndex, nil
+}
+
+// decodeDepositExtrinsic decodes a signed batch extrinsic. It returns nil if the extrinsic isn't a batch.
+func decodeDepositExtrinsic(raw []byte, index int, c *callIndices) (*depositExtrinsic, error) {
+	decoder, signer, callIndex, err := decodeSignedExtrinsic(raw, c)
+	if err != nil || decoder == nil {
+		return nil, err
+	}
+	if callIndex != c.batch && callIndex != c.batchAll {
+		return nil, nil
+	}
+
+	calls, err := c.decodeBatchCalls(decoder, len(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	return &depositExtrinsic{
+		index:  index,
+		hash:   types.NewHash(hashBytes(raw)),
+		signer: signer,
+		calls:  calls,
+	}, nil
+}
+
+// decodeBatchCalls decodes the calls of a batch, rawLen bounds their number
+func (c *callIndices) decodeBatchCalls(decoder *scale.Decoder, rawLen int) ([]batchCall, error) {
+	n, err := decoder.DecodeUintCompact()
+	if err != nil {
+		return nil, err
+	}
+	if n.Uint64() > uint64(rawLen) {
+		return nil, fmt.Errorf("invalid batch length %d", n.Uint64())
+	}
+	calls := make([]batchCall, 0, n.Uint64())
+	for i := uint64(0); i < n.Uint64(); i++ {
+		call, err := c.decodeBatchCall(decoder)
+		if err != nil {
+			return nil, fmt.Errorf("call %d of the batch: %w", i, err)
+		}
+		calls = append(calls, call)
+	}
+	return calls, nil
+}
+
+// decodeBatchCall decodes a call of a batch. Only the calls a deposit is made of can be decoded, since
+// the length of the arguments of other calls is unknown.
+func (c *callIndices) decodeBatchCall(decoder *scale.Decoder) (batchCall, error) {
+	var index types.CallIndex
+	err := decoder.Decode(&index)
+	if err != nil {
+		return batchCall{}, err
+	}
+
+	switch {
+	case index == c.transfer || index == c.transferKeepAlive:
+		dest, err := c.decodeDest(decoder)
+		if err != nil {
+			return batchCall{}, err
+		}
+		var value types.UCompact
+		err = decoder.Decode(&value)
+		if err != nil {
+			return batchCall{}, err
+		}
+		return batchCall{transfer: true, dest: dest, amount: big.NewInt(0).Set((*big.Int)(&value)), assetId: chainset.OriginAsset}, nil
+	case c.hasXAssets && index == c.xTransfer:
+		dest, err := c.decodeDest(decoder)
+		if err != nil {
+			return batchCall{}, err
+		}
+		var id, value types.UCompact
+		err = decoder.Decode(&id)
+		if err != nil {
+			return batchCall{}, err
+		}
+		err = decoder.Decode(&value)
+		if err != nil {
+			return batchCall{}, err
+		}
+		assetId := xevents.AssetId((*big.Int)(&id).Uint64())
+		return batchCall{transfer: true, dest: dest, amount: big.NewInt(0).Set((*big.Int)(&value)), assetId: assetId}, nil
+	case c.hasAssets && (index == c.assetsTransfer || index == c.assetsTransferKeepAlive):
+		/// The asset id comes before the recipient
+		var id, value types.UCompact
+		err = decoder.Decode(&id)
+		if err != nil {
+			return batchCall{}, err
+		}
+		dest, err := c.decodeDest(decoder)
+		if err != nil {
+			return batchCall{}, err
+		}
+		err = decoder.Decode(&value)
+		if err != nil {
+			return batchCall{}, err
+		}
+		assetId := xevents.AssetId((*big.Int)(&id).Uint64())
+		return batchCall{transfer: true, dest: dest, amount: big.NewInt(0).Set((*big.Int)(&value)), assetId: assetId}, nil
+	case index == c.remark:
+		var data types.Bytes
+		err = decoder.Decode(&data)
+		if err != nil {
+			return batchCall{}, err
+		}
+		return batchCall{remark: true, data: string(data)}, nil
+	default:
+		return batchCall{}, fmt.Errorf("unsupported call %d.%d", index.SectionIndex, index.MethodIndex)
+	}
+}
+
+// decodeDest decodes an account encoded as `Address` or `MultiAddress`
+func (c *callIndices) decodeDest(decoder *scale.Decoder) (types.AccountID, error) {
+	if c.useAddress {
+		var address types.Address
+		err := decoder.Decode(&address)
+		if err != nil {
+			return types.AccountID{}, err
+		}
+		if !address.IsAccountID {
+			return types.AccountID{}, fmt.Errorf("recipient is not an account id")
+		}
+		return address.AsAccountID, nil
+	}
+
+	var address types.MultiAddress
+	err := decoder.Decode(&address)
+	if err != nil {
+		return types.AccountID{}, err
+	}
+	if !address.IsID {
+		return types.AccountID{}, fmt.Errorf("recipient is not an account id")
+	}
+	return address.AsID, nil
+}
+
+// pairBatchCalls pairs every transfer into the multisig with the remark directly following it. Calls from
+// `executed` on were not dispatched, since the batch was interrupted. The batch is rejected when a transfer
+// into the multisig isn't followed by a remark, or a remark doesn't follow such a transfer.
+func pairBatchCalls(calls []batchCall, multiSig types.AccountID, executed int) ([]batchDeposit, error) {
+	var deposits []batchDeposit
+	strayRemark := -1
+	for i := 0; i < len(calls); i++ {
+		call := calls[i]
+		if call.remark && strayRemark < 0 {
+			strayRemark = i
+		}
+		if !call.transfer || call.dest != multiSig {
+			continue
+		}
+		if i+1 >= len(calls) || !calls[i+1].remark {
+			return nil, fmt.Errorf("transfer %d into the multisig isn't followed by a remark", i)
+		}
+		if i < executed {
+			deposits = append(deposits, batchDeposit{
+				transfer: i,
+				remark:   i + 1,
+				amount:   call.amount,
+				assetId:  call.assetId,
+				data:     calls[i+1].data,
+			})
+		}
+		i++
+	}
+
+	if len(deposits) > 0 && strayRemark >= 0 {
+		return nil, fmt.Errorf("remark %d doesn't follow a transfer into the multisig", strayRemark)
+	}
+	if len(deposits) > MaxBatchDeposits {
+		return nil, fmt.Errorf("too many deposits in the batch: %d", len(deposits))
+	}
+	return deposits, nil
+}
+
+// Bits of a batch deposit nonce taken by the extrinsic index and by the index of the deposit in the extrinsic, the
+// block number taking the bits below batchNonceFlag
+const (
+	nonceExtrinsicBits = 12
+	nonceDepositBits   = 8
+	batchNonceFlag     = 1 << 63
+)
+
+// batchDepositNonce returns the nonce of the deposit at index deposit among the deposits of an extrinsic.
+//
+// An extrinsic carrying a single deposit keeps the nonce the relayers have always given it, the decimal block number
+// followed by the decimal extrinsic index, so that the deposits of blocks scanned again after an upgrade (blockstore
+// reset, --fresh or replay) are proposed with the nonce they were executed with and are not minted a second time.
+// The deposits of an extrinsic carrying several of them, which older relayers didn't decode, are numbered
+// batchNonceFlag | block<<20 | extrinsic<<8 | deposit. The decimal nonces never reach the flag bit, so that the two
+// forms can't collide, and the packed form can't collide with itself as the decimal one did.
+func batchDepositNonce(block uint64, extrinsic int, deposit int, deposits int) (msg.Nonce, error) {
+	if deposits == 1 {
+		nonce, err := strconv.ParseInt(strconv.FormatUint(block, 10)+strconv.Itoa(extrinsic), 10, 64)
+		if err != nil || extrinsic < 0 || deposit != 0 {
+			return 0, fmt.Errorf("deposit of extrinsic %d in block %d doesn't fit a nonce", extrinsic, block)
+		}
+		return msg.Nonce(nonce), nil
+	}
+	if block >= 1<<(63-nonceExtrinsicBits-nonceDepositBits) || extrinsic < 0 || extrinsic >= 1<<nonceExtrinsicBits ||
+		deposit < 0 || deposit >= 1<<nonceDepositBits {
+		return 0, fmt.Errorf("deposit %d of extrinsic %d in block %d doesn't fit a nonce", deposit, extrinsic, block)
+	}
+	nonce := batchNonceFlag | block<<(nonceExtrinsicBits+nonceDepositBits) | uint64(extrinsic)<<nonceDepositBits | uint64(deposit)
+	return msg.Nonce(nonce), nil
+}
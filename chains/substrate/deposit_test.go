@@ -0,0 +1,235 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package substrate
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/Platdot-Network/go-substrate-rpc-client/v3/types"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+var testCallIndices = &callIndices{
+	batch:             types.CallIndex{SectionIndex: 1, MethodIndex: 0},
+	batchAll:          types.CallIndex{SectionIndex: 1, MethodIndex: 2},
+	transfer:          types.CallIndex{SectionIndex: 2, MethodIndex: 0},
+	transferKeepAlive: types.CallIndex{SectionIndex: 2, MethodIndex: 3},
+	remark:            types.CallIndex{SectionIndex: 0, MethodIndex: 1},
+}
+
+var testAssetsCallIndices = &callIndices{
+	batch:                   testCallIndices.batch,
+	batchAll:                testCallIndices.batchAll,
+	transfer:                testCallIndices.transfer,
+	transferKeepAlive:       testCallIndices.transferKeepAlive,
+	remark:                  testCallIndices.remark,
+	assetsTransfer:          types.CallIndex{SectionIndex: 50, MethodIndex: 8},
+	assetsTransferKeepAlive: types.CallIndex{SectionIndex: 50, MethodIndex: 9},
+	hasAssets:               true,
+}
+
+func encodeTestBatch(t *testing.T, callIndex types.CallIndex, calls ...types.Call) []byte {
+	args, err := types.EncodeToBytes(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ext := types.Extrinsic{
+		Version: types.ExtrinsicVersion4 | types.ExtrinsicBitSigned,
+		Signature: types.ExtrinsicSignatureV4{
+			Signer:    types.NewMultiAddressFromAccountID(AliceKey.PublicKey),
+			Signature: types.MultiSignature{IsSr25519: true},
+			Era:       types.ExtrinsicEra{IsImmortalEra: true},
+			Nonce:     types.NewUCompactFromUInt(7),
+			Tip:       types.NewUCompactFromUInt(0),
+		},
+		Method: types.Call{CallIndex: callIndex, Args: args},
+	}
+	raw, err := types.EncodeToBytes(ext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func encodeTestCall(t *testing.T, callIndex types.CallIndex, args ...interface{}) types.Call {
+	var encoded []byte
+	for _, arg := range args {
+		b, err := types.EncodeToBytes(arg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded = append(encoded, b...)
+	}
+	return types.Call{CallIndex: callIndex, Args: encoded}
+}
+
+func TestDecodeDepositExtrinsic(t *testing.T) {
+	multiSig := types.NewAccountID([]byte{1})
+	raw := encodeTestBatch(t, testCallIndices.batchAll,
+		encodeTestCall(t, testCallIndices.transfer, types.NewMultiAddressFromAccountID(multiSig[:]), types.NewUCompactFromUInt(1000)),
+		encodeTestCall(t, testCallIndices.remark, types.NewBytes([]byte("0x01"))),
+		encodeTestCall(t, testCallIndices.transferKeepAlive, types.NewMultiAddressFromAccountID(multiSig[:]), types.NewUCompactFromUInt(2000)),
+		encodeTestCall(t, testCallIndices.remark, types.NewBytes([]byte("0x02"))),
+	)
+
+	e, err := decodeDepositExtrinsic(raw, 3, testCallIndices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e == nil || len(e.calls) != 4 {
+		t.Fatalf("Unexpected extrinsic: %+v", e)
+	}
+	if e.index != 3 || e.signer != types.NewAccountID(AliceKey.PublicKey) {
+		t.Fatalf("Got: %d %x Expected: 3 %x", e.index, e.signer, AliceKey.PublicKey)
+	}
+	if !e.calls[2].transfer || e.calls[2].dest != multiSig || e.calls[2].amount.Cmp(big.NewInt(2000)) != 0 {
+		t.Fatalf("Unexpected transfer: %+v", e.calls[2])
+	}
+	if !e.calls[3].remark || e.calls[3].data != "0x02" {
+		t.Fatalf("Unexpected remark: %+v", e.calls[3])
+	}
+
+	// Not a batch
+	raw = encodeTestBatch(t, testCallIndices.transfer)
+	e, err = decodeDepositExtrinsic(raw, 3, testCallIndices)
+	if err != nil || e != nil {
+		t.Fatalf("Got: %v %v Expected: nil nil", e, err)
+	}
+
+	// A batch with a call a deposit isn't made of
+	raw = encodeTestBatch(t, testCallIndices.batch, types.Call{CallIndex: types.CallIndex{SectionIndex: 9, MethodIndex: 9}})
+	_, err = decodeDepositExtrinsic(raw, 3, testCallIndices)
+	if err == nil {
+		t.Fatal("Expected an error for an unsupported call")
+	}
+}
+
+func TestDecodeAssetsDeposit(t *testing.T) {
+	multiSig := types.NewAccountID([]byte{1})
+	raw := encodeTestBatch(t, testAssetsCallIndices.batchAll,
+		encodeTestCall(t, testAssetsCallIndices.assetsTransfer, types.NewUCompactFromUInt(1984), types.NewMultiAddressFromAccountID(multiSig[:]), types.NewUCompactFromUInt(1000)),
+		encodeTestCall(t, testAssetsCallIndices.remark, types.NewBytes([]byte("0x01"))),
+		encodeTestCall(t, testAssetsCallIndices.assetsTransferKeepAlive, types.NewUCompactFromUInt(8), types.NewMultiAddressFromAccountID(multiSig[:]), types.NewUCompactFromUInt(2000)),
+		encodeTestCall(t, testAssetsCallIndices.remark, types.NewBytes([]byte("0x02"))),
+	)
+
+	e, err := decodeDepositExtrinsic(raw, 1, testAssetsCallIndices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deposits, err := pairBatchCalls(e.calls, multiSig, len(e.calls))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deposits) != 2 || deposits[0].assetId != 1984 || deposits[0].amount.Cmp(big.NewInt(1000)) != 0 ||
+		deposits[1].assetId != 8 || deposits[1].data != "0x02" {
+		t.Fatalf("Unexpected deposits: %+v", deposits)
+	}
+
+	// Assets calls aren't decoded on chains without the Assets pallet
+	_, err = decodeDepositExtrinsic(raw, 1, testCallIndices)
+	if err == nil {
+		t.Fatal("Expected an error for an unsupported call")
+	}
+}
+
+func TestPairBatchCalls(t *testing.T) {
+	multiSig := types.NewAccountID([]byte{1})
+	other := types.NewAccountID([]byte{2})
+	transfer := func(dest types.AccountID, amount int64) batchCall {
+		return batchCall{transfer: true, dest: dest, amount: big.NewInt(amount)}
+	}
+	remark := func(data string) batchCall {
+		return batchCall{remark: true, data: data}
+	}
+
+	// Two deposits and an unrelated transfer
+	calls := []batchCall{transfer(multiSig, 1), remark("a"), transfer(other, 5), transfer(multiSig, 2), remark("b")}
+	deposits, err := pairBatchCalls(calls, multiSig, len(calls))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deposits) != 2 || deposits[0].data != "a" || deposits[1].data != "b" || deposits[1].amount.Int64() != 2 {
+		t.Fatalf("Unexpected deposits: %+v", deposits)
+	}
+
+	// Interrupted before the second deposit
+	deposits, err = pairBatchCalls(calls, multiSig, 3)
+	if err != nil || len(deposits) != 1 {
+		t.Fatalf("Got: %d %v Expected: 1 deposit", len(deposits), err)
+	}
+
+	for name, calls := range map[string][]batchCall{
+		"missing remark": {transfer(multiSig, 1), transfer(multiSig, 2), remark("b")},
+		"trailing":       {remark("a"), transfer(multiSig, 1)},
+		"stray remark":   {transfer(multiSig, 1), remark("a"), remark("b")},
+		"leading remark": {remark("a"), transfer(multiSig, 1), remark("b")},
+	} {
+		if _, err := pairBatchCalls(calls, multiSig, len(calls)); err == nil {
+			t.Fatalf("%s: expected the batch to be rejected", name)
+		}
+	}
+
+	// Remarks without deposits are not our business
+	deposits, err = pairBatchCalls([]batchCall{transfer(other, 1), remark("a")}, multiSig, 2)
+	if err != nil || len(deposits) != 0 {
+		t.Fatalf("Got: %d %v Expected: no deposit", len(deposits), err)
+	}
+}
+
+func TestBatchDepositNonce(t *testing.T) {
+	// A single deposit keeps the nonce the relayers gave it before batches were decoded
+	if nonce, err := batchDepositNonce(1234, 2, 0, 1); err != nil || nonce != msg.Nonce(12342) {
+		t.Fatalf("Got: %d %v Expected: %d", nonce, err, 12342)
+	}
+	if nonce, err := batchDepositNonce(1234, 2, 0, 8); err != nil || nonce != msg.Nonce(1<<63|1234<<20|2<<8) {
+		t.Fatalf("Got: %d %v Expected: %d", nonce, err, uint64(1<<63|1234<<20|2<<8))
+	}
+	if nonce, err := batchDepositNonce(1234, 2, 7, 8); err != nil || nonce != msg.Nonce(1<<63|1234<<20|2<<8|7) {
+		t.Fatalf("Got: %d %v Expected: %d", nonce, err, uint64(1<<63|1234<<20|2<<8|7))
+	}
+	for _, args := range [][3]int{{1234, 4096, 0}, {1234, 2, 256}, {1 << 43, 0, 0}} {
+		if _, err := batchDepositNonce(uint64(args[0]), args[1], args[2], 2); err == nil {
+			t.Fatalf("Expected an error for %v", args)
+		}
+	}
+	if _, err := batchDepositNonce(1<<62, 10, 0, 1); err == nil {
+		t.Fatal("Expected an error for a decimal nonce overflowing")
+	}
+}
+
+func TestBatchDepositNonceCollision(t *testing.T) {
+	// The decimal form gave the same nonce to the deposit 23 of the extrinsic 1 of the block 12 and to the single
+	// deposit of the extrinsic 123 of the block 12, or of the extrinsic 3 of the block 1212
+	seen := make(map[msg.Nonce][3]int)
+	blocks := []int{1, 12, 121, 1212, 12121212}
+	extrinsics := []int{0, 1, 2, 3, 12, 23, 123, 1023, 4095}
+	for _, block := range blocks {
+		for _, extrinsic := range extrinsics {
+			for deposit := 0; deposit < MaxBatchDeposits; deposit++ {
+				nonce, err := batchDepositNonce(uint64(block), extrinsic, deposit, MaxBatchDeposits)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if other, ok := seen[nonce]; ok {
+					t.Fatalf("Deposits %v and %v have the same nonce %d", other, [3]int{block, extrinsic, deposit}, nonce)
+				}
+				seen[nonce] = [3]int{block, extrinsic, deposit}
+			}
+		}
+	}
+	// Nor do they collide with the nonces of single deposits
+	for _, block := range blocks {
+		for _, extrinsic := range extrinsics {
+			nonce, err := batchDepositNonce(uint64(block), extrinsic, 0, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if other, ok := seen[nonce]; ok {
+				t.Fatalf("Deposits %v and %v have the same nonce %d", other, [3]int{block, extrinsic, 0}, nonce)
+			}
+		}
+	}
+}
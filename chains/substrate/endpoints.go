@@ -0,0 +1,86 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package substrate
+
+import (
+	"sync"
+
+	"github.com/Platdot-Network/go-substrate-rpc-client/v3/client"
+	"github.com/Platdot-Network/go-substrate-rpc-client/v3/types"
+)
+
+// endpointClients are clients to every configured endpoint, used for the health checks and to confirm deposits.
+// Clients failing a call are dialed again on the next use.
+type endpointClients struct {
+	lock    sync.Mutex
+	clients map[string]client.Client
+}
+
+func newEndpointClients() *endpointClients {
+	return &endpointClients{clients: make(map[string]client.Client)}
+}
+
+func (e *endpointClients) get(url string) (client.Client, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if cli, ok := e.clients[url]; ok {
+		return cli, nil
+	}
+
+	cli, err := client.Connect(url)
+	if err != nil {
+		return nil, err
+	}
+	e.clients[url] = cli
+	return cli, nil
+}
+
+func (e *endpointClients) drop(url string, cli client.Client) {
+	e.lock.Lock()
+	if e.clients[url] == cli {
+		delete(e.clients, url)
+	}
+	e.lock.Unlock()
+	if closer, ok := cli.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+// call runs f with the client of the endpoint
+func (e *endpointClients) call(url string, f func(cli client.Client) ([]byte, error)) ([]byte, error) {
+	cli, err := e.get(url)
+	if err != nil {
+		return nil, err
+	}
+	res, err := f(cli)
+	if err != nil {
+		e.drop(url, cli)
+		return nil, err
+	}
+	return res, nil
+}
+
+// probe returns the latest head of the endpoint
+func (e *endpointClients) probe(url string) (uint64, error) {
+	var number types.BlockNumber
+	_, err := e.call(url, func(cli client.Client) ([]byte, error) {
+		var header types.Header
+		err := cli.Call(&header, "chain_getHeader")
+		number = header.Number
+		return nil, err
+	})
+	return uint64(number), err
+}
+
+// Confirm checks that at least quorum endpoints, counting the current one, return expected for fetch. expected is
+// computed by the caller from the data it acts on, see pool.ConfirmWithSource. It returns a pool.DisagreementError if
+// any endpoint returns another value.
+func (c *Connection) Confirm(quorum int, expected []byte, fetch func(cli client.Client) ([]byte, error)) error {
+	if quorum <= 1 {
+		return nil
+	}
+	return c.pool.ConfirmWithSource(c.Endpoint(), quorum, expected, func(url string) ([]byte, error) {
+		return c.clients.call(url, fetch)
+	})
+}
@@ -0,0 +1,180 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package substrate
+
+import (
+	"github.com/Platdot-Network/go-substrate-rpc-client/v3/types"
+	"github.com/Platdot-Network/substrate-go/expand/chainx"
+	"github.com/Platdot-Network/substrate-go/models"
+	"github.com/Platdot-network/Platdot/chains/chainset"
+)
+
+// DefaultFetchConcurrency is the number of blocks fetched ahead of the listener
+const DefaultFetchConcurrency = 4
+
+// fetchedBlock is a finalized block with its decoded events and deposit extrinsics
+type fetchedBlock struct {
+	number    uint64
+	hash      types.Hash
+	evts      *chainx.ChainXEventRecords
+	exts      []*depositExtrinsic
+	redeems   []*redeemExtrinsic // Multisig operations carrying a redeem batch
+	undecoded map[int]error // Extrinsics which couldn't be decoded, keyed by index
+	runtime   *runtimeInfo  // Runtime which executed the block
+	digest    []byte        // Digest of the block as fetched, confirmed on the other endpoints
+}
+
+type fetchResult struct {
+	block *fetchedBlock
+	err   error
+}
+
+// blockFetcher fetches blocks ahead of the listener, with at most `concurrency` fetches in flight.
+// The blocks are handed back strictly in order by result.
+type blockFetcher struct {
+	fetch    func(number uint64) (*fetchedBlock, error)
+	sem      chan struct{}                // Bounds the fetches in flight, including the dropped ones
+	inflight map[uint64]chan fetchResult // Scheduled blocks, keyed by number
+	next     uint64                      // Next block to schedule
+}
+
+func newBlockFetcher(fetch func(number uint64) (*fetchedBlock, error), concurrency int, start uint64) *blockFetcher {
+	return &blockFetcher{
+		fetch:    fetch,
+		sem:      make(chan struct{}, concurrency),
+		inflight: make(map[uint64]chan fetchResult, concurrency),
+		next:     start,
+	}
+}
+
+// schedule starts fetching the blocks up to last, as long as less than `concurrency` blocks are waiting
+func (f *blockFetcher) schedule(last uint64) {
+	for f.next <= last && len(f.inflight) < cap(f.sem) {
+		res := make(chan fetchResult, 1)
+		f.inflight[f.next] = res
+		go func(number uint64) {
+			f.sem <- struct{}{}
+			block, err := f.fetch(number)
+			<-f.sem
+			res <- fetchResult{block: block, err: err}
+		}(f.next)
+		f.next++
+	}
+}
+
+// result waits for a scheduled block. On error, the caller should reset the fetcher to the block.
+func (f *blockFetcher) result(number uint64) (*fetchedBlock, error) {
+	res, ok := f.inflight[number]
+	if !ok {
+		f.reset(number)
+		f.schedule(number)
+		res = f.inflight[number]
+	}
+	r := <-res
+	delete(f.inflight, number)
+	return r.block, r.err
+}
+
+// reset drops the blocks in flight, fetching starts again from the given block
+func (f *blockFetcher) reset(from uint64) {
+	f.inflight = make(map[uint64]chan fetchResult, cap(f.sem))
+	f.next = from
+}
+
+// rawBlock is a finalized block with its decoded events, and the call indices of the runtime which executed it
+type rawBlock struct {
+	number     uint64
+	hash       types.Hash
+	extrinsics []string
+	evts       *chainx.ChainXEventRecords
+	indices    *callIndices
+	runtime    *runtimeInfo
+	digest     []byte // Digest of the hash, events and extrinsics as fetched
+}
+
+// fetchRawBlock fetches a finalized block and its events. The block is decoded with the metadata of the runtime
+// which executed it, the runtime of its parent.
+func (l *listener) fetchRawBlock(number uint64) (*rawBlock, error) {
+	hash, err := l.conn.API().RPC.Chain.GetBlockHash(number)
+	if err != nil {
+		return nil, err
+	}
+
+	var block models.SignedBlock
+	err = l.conn.API().Client.Call(&block, "chain_getBlock", hash.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	parent, parentNumber := hash, number
+	if number > 0 {
+		parent, err = types.NewHashFromHexString(block.Block.Header.ParentHash)
+		if err != nil {
+			return nil, err
+		}
+		parentNumber = number - 1
+	}
+	rt, err := l.conn.runtimeAt(parent, parentNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	evts, records, err := l.fetchEvents(hash, rt.meta)
+	if err != nil {
+		return nil, err
+	}
+
+	chainType := l.chainCore.ChainInfo.Type
+	indices, err := newCallIndices(rt.meta, chainType == chainset.ChainXV1Like || chainType == chainset.ChainXAssetV1Like)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rawBlock{
+		number:     number,
+		hash:       hash,
+		extrinsics: block.Block.Extrinsics,
+		evts:       evts,
+		indices:    indices,
+		runtime:    rt,
+		digest:     blockDigest(hash, records, block.Block.Extrinsics),
+	}, nil
+}
+
+// fetchBlock fetches a finalized block, its events and its deposit extrinsics
+func (l *listener) fetchBlock(number uint64) (*fetchedBlock, error) {
+	raw, err := l.fetchRawBlock(number)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &fetchedBlock{
+		number:    number,
+		hash:      raw.hash,
+		evts:      raw.evts,
+		exts:      make([]*depositExtrinsic, 0, len(raw.extrinsics)),
+		undecoded: make(map[int]error),
+		runtime:   raw.runtime,
+		digest:    raw.digest,
+	}
+	for i, hex := range raw.extrinsics {
+		ext, err := types.HexDecodeString(hex)
+		if err == nil {
+			var e *depositExtrinsic
+			e, err = decodeDepositExtrinsic(ext, i, raw.indices)
+			if e != nil {
+				b.exts = append(b.exts, e)
+			} else if err == nil {
+				/// Redeem batches are only decoded to learn their deposits, the other multisig operations are ignored
+				if r, _ := decodeRedeemExtrinsic(ext, i, raw.indices); r != nil {
+					b.redeems = append(b.redeems, r)
+				}
+			}
+		}
+		if err != nil {
+			b.undecoded[i] = err
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package substrate
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Platdot-Network/go-substrate-rpc-client/v3/types"
+)
+
+func TestBlockFetcherInOrder(t *testing.T) {
+	var running, maxRunning int32
+	var lock sync.Mutex
+	failed := make(map[uint64]bool)
+	fetch := func(number uint64) (*fetchedBlock, error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+				break
+			}
+		}
+		time.Sleep(time.Duration(rand.Intn(3)) * time.Millisecond)
+
+		// Block 13 fails once
+		lock.Lock()
+		defer lock.Unlock()
+		if number == 13 && !failed[number] {
+			failed[number] = true
+			return nil, errors.New("timeout")
+		}
+		return &fetchedBlock{number: number}, nil
+	}
+
+	f := newBlockFetcher(fetch, 4, 10)
+	var processed []uint64
+	for current := uint64(10); current <= 30; {
+		f.schedule(30)
+		block, err := f.result(current)
+		if err != nil {
+			f.reset(current)
+			continue
+		}
+		processed = append(processed, block.number)
+		current++
+	}
+
+	for i, number := range processed {
+		if number != uint64(10+i) {
+			t.Fatalf("Position %d: Got: %d Expected: %d", i, number, 10+i)
+		}
+	}
+	if len(processed) != 21 || !failed[13] {
+		t.Fatalf("Got %d blocks, failure of block 13 seen: %v", len(processed), failed[13])
+	}
+	if maxRunning > 4 {
+		t.Fatalf("Got: %d fetches in flight Expected: at most %d", maxRunning, 4)
+	}
+}
+
+func TestBlockFetcherBounds(t *testing.T) {
+	fetch := func(number uint64) (*fetchedBlock, error) {
+		return &fetchedBlock{number: number}, nil
+	}
+	f := newBlockFetcher(fetch, 8, 5)
+
+	// Never schedule past the last finalized block
+	f.schedule(7)
+	if len(f.inflight) != 3 || f.next != 8 {
+		t.Fatalf("Got: %d in flight, next %d Expected: 3 in flight, next 8", len(f.inflight), f.next)
+	}
+
+	// A block which isn't scheduled is fetched on demand
+	block, err := f.result(20)
+	if err != nil || block.number != 20 {
+		t.Fatalf("Got: %v %v Expected: block 20", block, err)
+	}
+}
+
+func TestBlockDigest(t *testing.T) {
+	hash := types.NewHash([]byte{1})
+	digest := blockDigest(hash, []byte{2, 3}, []string{"0x04", "0x05"})
+	if !bytes.Equal(blockDigest(hash, []byte{2, 3}, []string{"0x04", "0x05"}), digest) {
+		t.Fatal("Expected the same digest for the same block")
+	}
+
+	for _, other := range [][]byte{
+		blockDigest(types.NewHash([]byte{9}), []byte{2, 3}, []string{"0x04", "0x05"}),
+		blockDigest(hash, []byte{2}, []string{"0x04", "0x05"}),
+		blockDigest(hash, []byte{2, 3}, []string{"0x04"}),
+		// The extrinsics are not merely concatenated
+		blockDigest(hash, []byte{2, 3}, []string{"0x0", "40x05"}),
+	} {
+		if bytes.Equal(other, digest) {
+			t.Fatal("Expected another digest for another block")
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package substrate
+
+import (
+	"sync"
+	"time"
+)
+
+// Time between two attempts to subscribe to the finalized heads
+var SubscriptionRetryInterval = time.Minute
+
+// finalizedHeads is the finalized head of the chain, as notified by the `chain_subscribeFinalizedHeads`
+// subscription. Notifications may skip blocks, the listener fetches the whole range up to the head.
+type finalizedHeads struct {
+	lock    sync.RWMutex
+	number  uint64
+	live    bool          // Whether the subscription is running
+	updated chan struct{} // Signals a new finalized head
+}
+
+func newFinalizedHeads() *finalizedHeads {
+	return &finalizedHeads{updated: make(chan struct{}, 1)}
+}
+
+// latest returns the latest finalized head, and whether the subscription is running
+func (h *finalizedHeads) latest() (uint64, bool) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.number, h.live
+}
+
+// set records a notified head, heads never go backwards
+func (h *finalizedHeads) set(number uint64) {
+	h.lock.Lock()
+	if number > h.number {
+		h.number = number
+	}
+	h.lock.Unlock()
+
+	select {
+	case h.updated <- struct{}{}:
+	default:
+	}
+}
+
+func (h *finalizedHeads) setLive(live bool) {
+	h.lock.Lock()
+	h.live = live
+	h.lock.Unlock()
+}
+
+// wait blocks until a new head is notified, timeout elapsed or stop is closed
+func (h *finalizedHeads) wait(timeout time.Duration, stop <-chan int) {
+	select {
+	case <-h.updated:
+	case <-time.After(timeout):
+	case <-stop:
+	}
+}
+
+// followFinalizedHeads keeps a finalized heads subscription running until the listener stops. While the
+// subscription is unavailable, as over http endpoints, or dropped, the listener polls the finalized head.
+func (l *listener) followFinalizedHeads() {
+	for {
+		sub, err := l.conn.API().RPC.Chain.SubscribeFinalizedHeads()
+		if err != nil {
+			l.log.Debug("Finalized heads subscription unavailable, polling instead", "err", err)
+		} else {
+			l.log.Debug("Subscribed to finalized heads")
+			l.heads.setLive(true)
+
+		follow:
+			for {
+				select {
+				case <-l.stop:
+					l.heads.setLive(false)
+					sub.Unsubscribe()
+					return
+				case header, ok := <-sub.Chan():
+					if !ok {
+						l.log.Warn("Finalized heads subscription closed, polling instead")
+						break follow
+					}
+					l.heads.set(uint64(header.Number))
+				case err := <-sub.Err():
+					l.log.Warn("Finalized heads subscription dropped, polling instead", "err", err)
+					break follow
+				}
+			}
+
+			l.heads.setLive(false)
+			sub.Unsubscribe()
+		}
+
+		select {
+		case <-l.stop:
+			return
+		case <-time.After(SubscriptionRetryInterval):
+		}
+	}
+}
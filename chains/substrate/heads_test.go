@@ -0,0 +1,37 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package substrate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFinalizedHeads(t *testing.T) {
+	h := newFinalizedHeads()
+	if _, live := h.latest(); live {
+		t.Fatal("Subscription should not be live before subscribing")
+	}
+
+	h.setLive(true)
+	h.set(10)
+	h.set(8) // Notified late, heads never go backwards
+	if head, live := h.latest(); head != 10 || !live {
+		t.Fatalf("Got: %d %v Expected: 10 true", head, live)
+	}
+
+	// A pending notification wakes up the waiter immediately
+	start := time.Now()
+	h.wait(time.Minute, make(chan int))
+	if time.Since(start) > time.Second {
+		t.Fatal("Waiter should be woken up by the notification")
+	}
+
+	// No notification, the waiter falls back to the timeout
+	start = time.Now()
+	h.wait(10*time.Millisecond, make(chan int))
+	if time.Since(start) < 10*time.Millisecond {
+		t.Fatal("Waiter should wait for the timeout")
+	}
+}
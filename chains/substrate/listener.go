@@ -7,11 +7,15 @@ import (
 	"errors"
 	"fmt"
 	"github.com/Platdot-Network/substrate-go/expand/chainx"
-	"github.com/Platdot-Network/substrate-go/models"
 	"github.com/Platdot-network/Platdot/chains/chainset"
+	"github.com/Platdot-network/Platdot/chains/lifecycle"
+	"github.com/Platdot-network/Platdot/chains/refunds"
+	"github.com/Platdot-network/Platdot/chains/webhooks"
+	"github.com/Platdot-network/Platdot/connections/pool"
 
 	"github.com/Platdot-Network/go-substrate-rpc-client/v3/types"
 	"math/big"
+	"sync"
 	"time"
 
 	"github.com/ChainSafe/log15"
@@ -22,25 +26,33 @@ import (
 )
 
 type listener struct {
-	name          string
-	chainId       msg.ChainId
-	startBlock    uint64
-	endBlock      uint64
-	lostAddress   string
-	blockStore    blockstore.Blockstorer
-	conn          *Connection
-	subscriptions map[eventName]eventHandler // Handlers for specific events
-	router        chains.Router
-	log           log15.Logger
-	stop          <-chan int
-	sysErr        chan<- error
-	latestBlock   metrics.LatestBlock
-	metrics       *metrics.ChainMetrics
-	multiSigAddr  types.AccountID
-	curTx         multiSigTx
-	asMulti       map[multiSigTx]MultiSigAsMulti
-	relayer       Relayer
-	chainCore     *chainset.ChainCore
+	name             string
+	chainId          msg.ChainId
+	startBlock       uint64
+	endBlock         uint64
+	lostAddress      string
+	blockStore       blockstore.Blockstorer
+	conn             *Connection
+	subscriptions    map[eventName]eventHandler // Handlers for specific events
+	router           chains.Router
+	log              log15.Logger
+	stop             <-chan int
+	sysErr           chan<- error
+	latestBlock      metrics.LatestBlock
+	metrics          *metrics.ChainMetrics
+	multiSigAddr     types.AccountID
+	relayer          Relayer
+	chainCore        *chainset.ChainCore
+	multisigs        map[types.Hash]*multisigState // Multisig operations of multiSigAddr, keyed by call hash
+	multisigLock     sync.RWMutex
+	multisigDone     chan struct{}      // Signals that multisig operations were executed or cancelled
+	fetchConcurrency int                // Number of blocks fetched in parallel
+	heads            *finalizedHeads    // Finalized head notified by the subscription, if running
+	depositQuorum    int                // Endpoints which must agree on a block before its deposits are routed
+	destId           msg.ChainId        // Destination of the deposits, any Eth-Like chain of the multisig bridge if zero
+	refunds          *refunds.Store     // Records the rejected deposits and refunds them
+	notifier         *webhooks.Notifier // Notifies the webhooks of the deposits, if configured
+	lifecycle        *lifecycle.Store   // Records the deposits as observed
 }
 
 var ErrBlockNotReady = errors.New("required result to be 32 bytes, but got 0")
@@ -52,28 +64,41 @@ var BlockRetryInterval = time.Second * 5
 var BlockRetryLimit = 15
 var RedeemRetryLimit = 15
 
+// Number of blocks the executed, failed or cancelled multisig operations are remembered, so that the writer sees the
+// outcome of the batches it voted. About a day of 6 seconds blocks.
+var MultisigRetention uint64 = 14400
+
 func NewListener(
 	conn *Connection, name string, id msg.ChainId, startBlock uint64, endBlock uint64, lostAddress string,
 	log log15.Logger, bs blockstore.Blockstorer, stop <-chan int, sysErr chan<- error, m *metrics.ChainMetrics,
-	multiSigAddress types.AccountID, relayer Relayer, bc *chainset.ChainCore) *listener {
+	multiSigAddress types.AccountID, relayer Relayer, bc *chainset.ChainCore, fetchConcurrency int, depositQuorum int,
+	destId msg.ChainId, refunds *refunds.Store, notifier *webhooks.Notifier, lifecycle *lifecycle.Store) *listener {
 	return &listener{
-		name:          name,
-		chainId:       id,
-		startBlock:    startBlock,
-		endBlock:      endBlock,
-		lostAddress:   lostAddress,
-		blockStore:    bs,
-		conn:          conn,
-		subscriptions: make(map[eventName]eventHandler),
-		log:           log,
-		stop:          stop,
-		sysErr:        sysErr,
-		latestBlock:   metrics.LatestBlock{LastUpdated: time.Now()},
-		metrics:       m,
-		multiSigAddr:  multiSigAddress,
-		asMulti:       make(map[multiSigTx]MultiSigAsMulti, InitCapacity),
-		relayer:       relayer,
-		chainCore:     bc,
+		name:             name,
+		chainId:          id,
+		startBlock:       startBlock,
+		endBlock:         endBlock,
+		lostAddress:      lostAddress,
+		blockStore:       bs,
+		conn:             conn,
+		subscriptions:    make(map[eventName]eventHandler),
+		log:              log,
+		stop:             stop,
+		sysErr:           sysErr,
+		latestBlock:      metrics.LatestBlock{LastUpdated: time.Now()},
+		metrics:          m,
+		multiSigAddr:     multiSigAddress,
+		relayer:          relayer,
+		chainCore:        bc,
+		multisigs:        make(map[types.Hash]*multisigState, InitCapacity),
+		multisigDone:     make(chan struct{}, 1),
+		fetchConcurrency: fetchConcurrency,
+		heads:            newFinalizedHeads(),
+		depositQuorum:    depositQuorum,
+		destId:           destId,
+		refunds:          refunds,
+		notifier:         notifier,
+		lifecycle:        lifecycle,
 	}
 }
 
@@ -84,7 +109,7 @@ func (l *listener) setRouter(r chains.Router) {
 // start creates the initial subscription for all events
 func (l *listener) start() error {
 	// Check whether latest is less than starting block
-	header, err := l.conn.cli.Api.RPC.Chain.GetHeaderLatest()
+	header, err := l.conn.Client().Api.RPC.Chain.GetHeaderLatest()
 	if err != nil {
 		return err
 	}
@@ -99,6 +124,8 @@ func (l *listener) start() error {
 		}
 	}
 
+	go l.followFinalizedHeads()
+
 	go func() {
 		l.connect()
 	}()
@@ -106,6 +133,27 @@ func (l *listener) start() error {
 	return nil
 }
 
+// replay polls the blocks from start to end and routes their deposits to r, without writing to the blockstore
+func (l *listener) replay(start uint64, end uint64, r chains.Router) error {
+	for _, sub := range Subscriptions {
+		err := l.registerEventHandler(sub.name, sub.handler)
+		if err != nil {
+			return err
+		}
+	}
+
+	l.router = r
+	l.blockStore = &blockstore.EmptyStore{}
+	/// The rejected deposits are recorded and refunded, the webhooks notified and the transfers recorded by the
+	/// running relayer only
+	l.refunds = nil
+	l.notifier = nil
+	l.lifecycle = nil
+	l.startBlock = start
+	l.endBlock = end
+	return l.pollBlocks()
+}
+
 // registerEventHandler enables a handler for a given event. This cannot be used after Start is called.
 func (l *listener) registerEventHandler(name eventName, handler eventHandler) error {
 	if l.subscriptions[name] != nil {
@@ -129,15 +177,15 @@ func (l *listener) reconnect() {
 	for {
 		if ClientRetryLimit == 0 {
 			err := l.conn.Reconnect()
-			l.log.Info("Connecting to another endpoint", "EndPoint", l.conn.url)
+			l.log.Info("Connecting to another endpoint", "EndPoint", l.conn.Endpoint())
 			if err != nil {
-				l.log.Error("Reconnect Error", "EndPoint", l.conn.url)
+				l.log.Error("Reconnect Error", "EndPoint", l.conn.Endpoint(), "err", err)
 			}
 			ClientRetryLimit = BlockRetryLimit
 		}
 
 		// Check whether latest is less than starting block
-		header, err := l.conn.cli.Api.RPC.Chain.GetHeaderLatest()
+		header, err := l.conn.Client().Api.RPC.Chain.GetHeaderLatest()
 		if err != nil {
 			time.Sleep(BlockRetryInterval)
 			ClientRetryLimit--
@@ -145,7 +193,7 @@ func (l *listener) reconnect() {
 		}
 		l.startBlock = uint64(header.Number)
 
-		_, err = l.conn.cli.Api.RPC.Chain.GetFinalizedHead()
+		_, err = l.conn.Client().Api.RPC.Chain.GetFinalizedHead()
 		if err != nil {
 			time.Sleep(BlockRetryInterval)
 			ClientRetryLimit--
@@ -160,6 +208,12 @@ func (l *listener) reconnect() {
 	}
 }
 
+// alertUnconfirmed reports a block held back until its deposits are confirmed by enough endpoints
+func (l *listener) alertUnconfirmed(block uint64, err error) {
+	l.log.Crit(DepositsHeldBack, "block", block, "err", err)
+	l.notifier.Notify(webhooks.BlockEvent(webhooks.DepositUnconfirmed, l.chainId, block, err.Error()))
+}
+
 func (l *listener) logBlock(currentBlock uint64) {
 	message := l.name + " listening..."
 	l.log.Debug(message, "Block", currentBlock)
@@ -169,13 +223,18 @@ func (l *listener) logBlock(currentBlock uint64) {
 }
 
 // pollBlocks will poll for the latest block and proceed to parse the associated events as it sees new blocks.
-// Polling begins at the block defined in `l.startBlock`. Failed attempts to fetch the latest block or parse
-// a block will be retried up to BlockRetryLimit times before returning with an error.
+// Polling begins at the block defined in `l.startBlock`. Up to `l.fetchConcurrency` finalized blocks are fetched
+// in parallel, but they are processed and stored strictly in order. Failed attempts to fetch the latest block or
+// parse a block will be retried up to BlockRetryLimit times before returning with an error. A block whose deposits
+// are not confirmed by enough endpoints is retried with a backoff until they are, see confirmBlock.
 func (l *listener) pollBlocks() error {
-	l.log.Info("Polling Blocks...", "ChainId", l.chainId, "Chain", l.name)
+	l.log.Info("Polling Blocks...", "ChainId", l.chainId, "Chain", l.name, "FetchConcurrency", l.fetchConcurrency)
 	var currentBlock = l.startBlock
 	var endBlock = l.endBlock
+	var finalized uint64
 	var retry = BlockRetryLimit
+	var backoff time.Duration // Delay before confirming the current block again
+	fetcher := newBlockFetcher(l.fetchBlock, l.fetchConcurrency, currentBlock)
 	for {
 		select {
 		case <-l.stop:
@@ -186,32 +245,50 @@ func (l *listener) pollBlocks() error {
 				return fmt.Errorf("polling retries exceeded (chain=%d, name=%s)", l.chainId, l.name)
 			}
 
-			/// Get finalized block hash
-			finalizedHash, err := l.conn.cli.Api.RPC.Chain.GetFinalizedHead()
-			if err != nil {
-				l.log.Error("Failed to fetch finalized hash", "err", err)
-				retry--
-				time.Sleep(BlockRetryInterval)
-				continue
-			}
-
-			// Get finalized block header
-			finalizedHeader, err := l.conn.cli.Api.RPC.Chain.GetHeader(finalizedHash)
-			if err != nil {
-				l.log.Error("Failed to fetch finalized header", "err", err)
-				retry--
-				time.Sleep(BlockRetryInterval)
-				continue
-			}
-
-			if l.metrics != nil {
-				l.metrics.LatestKnownBlock.Set(float64(finalizedHeader.Number))
+			/// Only look for the finalized head once the prefetch window reaches it
+			if finalized < currentBlock+uint64(l.fetchConcurrency) {
+				if head, live := l.heads.latest(); live {
+					if head > finalized {
+						finalized = head
+					}
+				} else {
+					/// Get finalized block hash
+					finalizedHash, err := l.conn.Client().Api.RPC.Chain.GetFinalizedHead()
+					if err != nil {
+						l.log.Error("Failed to fetch finalized hash", "err", err)
+						retry--
+						time.Sleep(BlockRetryInterval)
+						continue
+					}
+
+					// Get finalized block header
+					finalizedHeader, err := l.conn.Client().Api.RPC.Chain.GetHeader(finalizedHash)
+					if err != nil {
+						l.log.Error("Failed to fetch finalized header", "err", err)
+						retry--
+						time.Sleep(BlockRetryInterval)
+						continue
+					}
+					finalized = uint64(finalizedHeader.Number)
+				}
+
+				if l.metrics != nil {
+					l.metrics.LatestKnownBlock.Set(float64(finalized))
+				}
+
+				/// While catching up, the runtime of the finalized head spares a runtime lookup for every block
+				if finalized >= currentBlock+uint64(l.fetchConcurrency) {
+					err := l.conn.anchorRuntime(finalized)
+					if err != nil {
+						l.log.Debug("Failed to look up the runtime of the finalized head", "block", finalized, "err", err)
+					}
+				}
 			}
 
-			// Sleep if the block we want comes after the most recently finalized block
-			if currentBlock > uint64(finalizedHeader.Number) {
-				l.log.Trace(BlockNotYetFinalized, "target", currentBlock, "latest", finalizedHeader.Number)
-				time.Sleep(BlockRetryInterval)
+			// Wait if the block we want comes after the most recently finalized block
+			if currentBlock > finalized {
+				l.log.Trace(BlockNotYetFinalized, "target", currentBlock, "latest", finalized)
+				l.heads.wait(BlockRetryInterval, l.stop)
 				continue
 			}
 
@@ -222,26 +299,60 @@ func (l *listener) pollBlocks() error {
 				return nil
 			}
 
-			/// Listen Native Transfer
-			l.processBlockExtrinsic(int64(currentBlock))
+			last := finalized
+			if endBlock != 0 && last > endBlock {
+				last = endBlock
+			}
+			fetcher.schedule(last)
 
-			/// Listen Erc20/Erc721/Generic Transfer
-			// Get hash for latest block, sleep and retry if not ready
-			hash, err := l.conn.api.RPC.Chain.GetBlockHash(currentBlock)
+			// Sleep and retry if the block is not ready yet, without consuming a retry
+			block, err := fetcher.result(currentBlock)
 			if err != nil && err.Error() == ErrBlockNotReady.Error() {
+				fetcher.reset(currentBlock)
 				time.Sleep(BlockRetryInterval)
 				continue
 			} else if err != nil {
-				l.log.Error("Failed to query current block", "block", currentBlock, "err", err)
+				l.log.Error("Failed to fetch block", "block", currentBlock, "err", err)
+				fetcher.reset(currentBlock)
 				retry--
 				time.Sleep(BlockRetryInterval)
 				continue
 			}
 
-			/// Deal cross-chain tx
-			err = l.processEvents(hash)
-			if err != nil {
-				l.log.Error("Failed to process events in block", "block", currentBlock, "err", err)
+			/// Refuse the deposits until enough endpoints agree on the block, holding it back however long it takes
+			err = l.confirmBlock(block)
+			if pool.Unconfirmed(err) {
+				if backoff == 0 {
+					l.alertUnconfirmed(currentBlock, err)
+				}
+				backoff = pool.Backoff(backoff, BlockRetryInterval)
+				fetcher.reset(currentBlock)
+				select {
+				case <-l.stop:
+					return errors.New("terminated")
+				case <-time.After(backoff):
+				}
+				continue
+			} else if err != nil {
+				fetcher.reset(currentBlock)
+				retry--
+				time.Sleep(BlockRetryInterval)
+				continue
+			}
+
+			/// Listen Native Transfer, deposits are only valid if their extrinsics succeeded
+			l.dealBlockTx(block.exts, block.evts, currentBlock)
+			l.releaseRefunds(currentBlock)
+			l.warnUndecodedDeposits(block.undecoded, block.evts, currentBlock)
+
+			/// Listen Erc20/Erc721/Generic Transfer, deal cross-chain tx
+			l.handleEvents(*block.evts, currentBlock)
+			l.recordBatchRemarks(block.redeems)
+			l.log.Trace("Finished processing events", "block", block.hash.Hex())
+
+			/// Without an upgrade, the next block is executed by the runtime which executed this one
+			if len(block.evts.System_CodeUpdated) == 0 {
+				l.conn.runtimes.record(block.runtime, currentBlock)
 			}
 
 			// Write to blockStore
@@ -256,6 +367,7 @@ func (l *listener) pollBlocks() error {
 			}
 
 			currentBlock++
+			backoff = 0
 			l.latestBlock.Height = big.NewInt(0).SetUint64(currentBlock)
 			l.latestBlock.LastUpdated = time.Now()
 
@@ -265,62 +377,33 @@ func (l *listener) pollBlocks() error {
 	}
 }
 
-func (l *listener) processBlockExtrinsic(currentBlock int64) {
-	retryTimes := BlockRetryLimit
-	for {
-		if retryTimes == 0 {
-			/// Maybe due to unresolved events
-			break
-		}
-
-		resp, err := l.conn.cli.GetBlockByNumber(currentBlock)
-		if err != nil {
-			if retryTimes == BlockRetryLimit/2 {
-				l.log.Error(GetBlockByNumberError, "Error", err, "block", currentBlock)
-			}
-			time.Sleep(time.Second)
-			retryTimes--
-			continue
-		}
-
-		/// Deal each extrinsic
-		l.dealBlockTx(resp, currentBlock)
-
-		break
-	}
-}
-
-// processEvents fetches a block and parses out the events, calling Listener.handleEvents()
-func (l *listener) processEvents(hash types.Hash) error {
+// fetchEvents fetches the events of a block and decodes them with the metadata of the runtime which executed it. The
+// events are returned with their encoding as stored.
+func (l *listener) fetchEvents(hash types.Hash, meta *types.Metadata) (*chainx.ChainXEventRecords, types.EventRecordsRaw, error) {
 	l.log.Trace("Fetching block for events", "hash", hash.Hex())
 
-	meta := l.conn.getMetadata()
-
-	key, err := types.CreateStorageKey(&meta, "System", "Events", nil, nil)
+	key, err := types.CreateStorageKey(meta, "System", "Events", nil, nil)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	var records types.EventRecordsRaw
-	_, err = l.conn.api.RPC.State.GetStorage(key, &records, hash)
+	_, err = l.conn.API().RPC.State.GetStorage(key, &records, hash)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	e := chainx.ChainXEventRecords{}
-	err = records.DecodeEventRecords(&meta, &e)
+	err = records.DecodeEventRecords(meta, &e)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	l.handleEvents(e)
-	l.log.Trace("Finished processing events", "block", hash.Hex())
-
-	return nil
+	return &e, records, nil
 }
 
 // handleEvents calls the associated handler for all registered event types
-func (l *listener) handleEvents(evts chainx.ChainXEventRecords) {
+func (l *listener) handleEvents(evts chainx.ChainXEventRecords, block uint64) {
 	if l.subscriptions[FungibleTransfer] != nil {
 		for _, evt := range evts.ChainBridge_FungibleTransfer {
 			l.log.Trace("Handling FungibleTransfer event")
@@ -340,9 +423,11 @@ func (l *listener) handleEvents(evts chainx.ChainXEventRecords) {
 		}
 	}
 
+	l.handleMultisigEvents(evts, block)
+
 	if len(evts.System_CodeUpdated) > 0 {
 		l.log.Trace("Received CodeUpdated event")
-		err := l.conn.updateMetatdata()
+		err := l.conn.refreshRuntime()
 		if err != nil {
 			l.log.Error("Unable to update Metadata", "error", err)
 		}
@@ -363,6 +448,15 @@ func (l *listener) submitMessage(m msg.Message, err error) {
 	}
 }
 
+// record moves the transfer of a message to a state and returns whether it moved, see the lifecycle package
+func (l *listener) record(m msg.Message, to lifecycle.State, txHash string, note string) bool {
+	moved, err := l.lifecycle.Record(m, to, l.name, txHash, note)
+	if err != nil {
+		l.log.Warn(RecordTransferStateError, "DepositNonce", m.DepositNonce, "Source", m.Source, "State", to, "Error", err)
+	}
+	return moved
+}
+
 func (l *listener) logInfo(msg string, block int64) {
 	l.log.Info(msg, "Block", block, "chain", l.name)
 }
@@ -377,7 +471,7 @@ func (l *listener) logCrossChainTx(tokenX string, tokenY string, amount *big.Int
 	l.log.Info(message, "Amount", amount, "Fee", fee, actualTitle, actualAmount)
 }
 
-func (l *listener) logReadyToSend(amount *big.Int, recipient []byte, e *models.ExtrinsicResponse) {
+func (l *listener) logReadyToSend(amount *big.Int, recipient []byte) {
 	sendMessage := "Send to " + l.chainCore.ShortenAddress(string(recipient)) + "..."
 	l.log.Info(LineLog, "Amount", amount, "FromId", l.chainId)
 	l.log.Info(sendMessage, "Amount", amount, "FromId", l.chainId)
@@ -3,13 +3,18 @@ package substrate
 import (
 	"fmt"
 	"math/big"
+	"sort"
 	"strconv"
+	"strings"
 
 	log "github.com/ChainSafe/log15"
-	"github.com/Platdot-Network/substrate-go/expand"
-	"github.com/Platdot-Network/substrate-go/expand/base"
-	"github.com/Platdot-Network/substrate-go/models"
+	"github.com/Platdot-Network/substrate-go/expand/chainx"
+	"github.com/Platdot-Network/substrate-go/expand/chainx/xevents"
 	"github.com/Platdot-network/Platdot/chains/chainset"
+	"github.com/Platdot-network/Platdot/chains/lifecycle"
+	"github.com/Platdot-network/Platdot/chains/refunds"
+	"github.com/Platdot-network/Platdot/chains/screening"
+	"github.com/Platdot-network/Platdot/chains/webhooks"
 	"github.com/Platdot-Network/go-substrate-rpc-client/v3/types"
 	"github.com/hacpy/go-ethereum/common"
 	"github.com/rjman-ljm/go-substrate-crypto/ss58"
@@ -18,95 +23,142 @@ import (
 
 const HexPrefix = "hex"
 
-type multiSigTxId uint64
-type BlockNumber int64
-type OtherSignatories []string
-
-type multiSigTx struct {
-	Block BlockNumber
-	TxId  multiSigTxId
+// multisigState is the state of a multisig operation of multiSigAddr, built from the Multisig pallet events
+type multisigState struct {
+	CallHash  types.Hash
+	TimePoint types.TimePoint
+	Approvals []types.AccountID
+	Executed  bool
+	Cancelled bool
+	Result    types.DispatchResult // Result of the call dispatched on execution
+	Remark    string               // Remark of the redeem batch listing its deposits, once the call was seen
+	Closed    uint64               // Block which executed or cancelled the operation, 0 while pending
 }
 
-type MultiSigAsMulti struct {
-	OriginMsTx     multiSigTx
-	Executed       bool
-	Threshold      uint16
-	Others         []OtherSignatories
-	MaybeTimePoint expand.TimePointSafe32
-	DestAddress    string
-	DestAmount     string
-	StoreCall      bool
-	MaxWeight      uint64
-	DepositNonce   msg.Nonce
-	YesVote        []types.AccountID
+type multisigOutcome int
+
+const (
+	multisigPending multisigOutcome = iota
+	multisigExecuted
+	multisigFailed
+	multisigCancelled
+)
+
+func (s *multisigState) outcome() multisigOutcome {
+	switch {
+	case s.Executed && s.Result.Ok:
+		return multisigExecuted
+	case s.Executed:
+		return multisigFailed
+	case s.Cancelled:
+		return multisigCancelled
+	default:
+		return multisigPending
+	}
 }
 
-func (l *listener) dealBlockTx(resp *models.BlockResponse, currentBlock int64) {
-	for _, e := range resp.Extrinsic {
-		if e.Status == "fail" {
-			continue
+// dealBlockTx generates a message for every deposit carried by the batch extrinsics of a block
+func (l *listener) dealBlockTx(exts []*depositExtrinsic, evts *chainx.ChainXEventRecords, currentBlock uint64) {
+	failed := make(map[uint32]bool)
+	for _, evt := range evts.System_ExtrinsicFailed {
+		if evt.Phase.IsApplyExtrinsic {
+			failed[evt.Phase.AsApplyExtrinsic] = true
 		}
-
-		// Current Extrinsic { Block, Index }
-		l.curTx.Block = BlockNumber(currentBlock)
-		l.curTx.TxId = multiSigTxId(e.ExtrinsicIndex)
-		msTx := MultiSigAsMulti{
-			DestAddress: e.MultiSigAsMulti.DestAddress,
-			DestAmount:  e.MultiSigAsMulti.DestAmount,
+	}
+	interrupted := make(map[uint32]int)
+	for _, evt := range evts.Utility_BatchInterrupted {
+		if evt.Phase.IsApplyExtrinsic {
+			interrupted[evt.Phase.AsApplyExtrinsic] = int(evt.Index)
 		}
-		fromAddressValid := l.checkFromAddress(e)
-		toAddressValid := l.checkToAddress(e)
+	}
 
-		if e.Type == base.AsMultiNew && fromAddressValid {
-			//l.logInfo(FindNewMultiSigTx, currentBlock)
-			/// Make a new multiSigTransfer record
-			l.makeNewMultiSigRecord(e)
+	for _, e := range exts {
+		if failed[uint32(e.index)] {
+			continue
 		}
 
-		if e.Type == base.AsMultiApprove && fromAddressValid {
-			//l.logInfo(FindApproveMultiSigTx, currentBlock)
-			/// Vote(Approve) for the existed MultiSigTransfer record
-			l.voteMultiSigRecord(msTx, e)
+		/// Only the calls before the interrupted one were dispatched
+		executed := len(e.calls)
+		if index, ok := interrupted[uint32(e.index)]; ok {
+			executed = index
 		}
 
-		if e.Type == base.AsMultiExecuted && fromAddressValid {
-			//l.logInfo(FindExecutedMultiSigTx, currentBlock)
-			/// Vote and execute the existed MultiSigTransfer record
-			l.voteMultiSigRecord(msTx, e)
-			l.executeMultiSigRecord(msTx)
+		deposits, err := pairBatchCalls(e.calls, l.multiSigAddr, executed)
+		if err != nil {
+			l.log.Error(RejectAmbiguousBatch, "Block", currentBlock, "Index", e.index, "Signer", types.HexEncodeToString(e.signer[:]), "err", err)
+			continue
+		}
+		if len(deposits) == 0 {
+			continue
+		}
+		if l.findLostTxByAddress(currentBlock, e) {
+			continue
 		}
 
-		if e.Type == base.UtilityBatch && toAddressValid {
-			//l.logInfo(FindBatchMultiSigTx, currentBlock)
-			if l.findLostTxByAddress(currentBlock, e) {
+		for i, d := range deposits {
+			depositNonce, err := batchDepositNonce(currentBlock, e.index, i, len(deposits))
+			if err != nil {
+				l.log.Error(DepositWithoutNonce, "Block", currentBlock, "Index", e.index, "Signer", types.HexEncodeToString(e.signer[:]), "err", err)
+				break
+			}
+			if reason := l.rejectReason(d.amount, d.assetId); reason != "" && d.data != "" {
+				l.rejectDeposit(e, d, currentBlock, depositNonce, reason)
 				continue
 			}
-
-			sendAmount, ok := l.getSendAmount(e)
+			sendAmount, ok := l.getSendAmount(d.amount, d.assetId)
 			/// if `chainId wrong`, `amount is negative` or `not cross-chain tx`
-			if !ok || e.Recipient == "" {
+			if !ok || d.data == "" {
 				continue
 			}
 
-			destId, rId, recipient, err := l.parseRemark(e.Recipient)
+			destId, rId, recipient, err := l.parseRemark(d.data)
 			if err != nil {
 				l.log.Error("parse remark error", "err", err)
+				l.rejectDeposit(e, d, currentBlock, depositNonce, refunds.ReasonRemark)
 				continue
 			}
-			if l.checkRemark(destId, rId, recipient) {
-				depositNonce, _ := strconv.ParseInt(strconv.FormatInt(currentBlock, 10)+strconv.FormatInt(int64(e.ExtrinsicIndex), 10), 10, 64)
-
-				m := msg.NewMultiSigTransfer(
-					l.chainId,
-					destId,
-					msg.Nonce(depositNonce),
-					sendAmount,
-					rId,
-					recipient[:],
-				)
-				l.logReadyToSend(sendAmount, recipient, e)
-				l.submitMessage(m, nil)
+			var passed bool
+			if l.chainCore.ChainInfo.Type == chainset.AssetsLike {
+				passed = l.checkAssetRemark(destId, d.assetId, rId, recipient)
+			} else {
+				passed = l.checkRemark(destId, rId, recipient)
+			}
+			if !passed {
+				l.rejectDeposit(e, d, currentBlock, depositNonce, refunds.ReasonDestination)
+				continue
 			}
+			m := screening.WithDepositor(msg.NewMultiSigTransfer(
+				l.chainId,
+				destId,
+				depositNonce,
+				sendAmount,
+				rId,
+				recipient[:],
+			), e.signer[:])
+			l.logReadyToSend(sendAmount, recipient)
+			l.record(m, lifecycle.Observed, e.hash.Hex(), "")
+			l.submitMessage(m, nil)
+			l.notifyDeposit(e, d, m)
+		}
+	}
+}
+
+// warnUndecodedDeposits reports transfers into the multisig made by extrinsics which couldn't be decoded
+func (l *listener) warnUndecodedDeposits(undecoded map[int]error, evts *chainx.ChainXEventRecords, currentBlock uint64) {
+	for _, evt := range evts.Balances_Transfer {
+		if err, ok := undecoded[int(evt.Phase.AsApplyExtrinsic)]; ok && evt.Phase.IsApplyExtrinsic && evt.To == l.multiSigAddr {
+			l.log.Error(UndecodedDeposit, "Block", currentBlock, "Index", evt.Phase.AsApplyExtrinsic, "Amount", evt.Value, "err", err)
+		}
+	}
+	/// The sender and the recipient of the Assets pallet event are decoded in swapped fields
+	for _, evt := range evts.Assets_Transferred {
+		if err, ok := undecoded[int(evt.Phase.AsApplyExtrinsic)]; ok && evt.Phase.IsApplyExtrinsic && evt.From == l.multiSigAddr {
+			l.log.Error(UndecodedDeposit, "Block", currentBlock, "Index", evt.Phase.AsApplyExtrinsic, "AssetId", evt.AssetID, "Amount", evt.Balance, "err", err)
+		}
+	}
+	for _, evt := range evts.XAssets_Moved {
+		if err, ok := undecoded[int(evt.Phase.AsApplyExtrinsic)]; ok && evt.Phase.IsApplyExtrinsic && evt.To == l.multiSigAddr {
+			l.log.Error(UndecodedDeposit, "Block", currentBlock, "Index", evt.Phase.AsApplyExtrinsic, "Amount", evt.Balance, "err", err)
 		}
 	}
 }
@@ -140,7 +192,7 @@ func (l *listener) parseRemark(res string) (msg.ChainId, msg.ResourceId, []byte,
 
 	recipient := []byte(address)
 
-	if address[:3] == HexPrefix {
+	if strings.HasPrefix(address, HexPrefix) {
 		recipientAccount := types.NewAccountID(common.FromHex(address[3:]))
 		recipient = recipientAccount[:]
 	}
@@ -157,14 +209,43 @@ func (l *listener) checkRemark(destId msg.ChainId, rId msg.ResourceId, recipient
 	return alayaPass || platonPass
 }
 
-func (l *listener) findLostTxByAddress(currentBlock int64, e *models.ExtrinsicResponse) bool {
-	sendPubAddress, _ := ss58.DecodeToPub(e.FromAddress)
+// checkAssetRemark checks the destination of the remark, and that its resource is the one of the deposited asset. The
+// native token of an Assets pallet chain is the asset OriginAsset.
+func (l *listener) checkAssetRemark(destId msg.ChainId, assetId xevents.AssetId, rId msg.ResourceId, recipient []byte) bool {
+	if !l.checkDestination(destId) {
+		l.log.Error("Remark destination is not served by the multisig", "AssetId", assetId, "Dest", destId)
+		return false
+	}
+	currency, err := l.chainCore.GetCurrencyByAssetId(assetId)
+	if err != nil {
+		l.log.Error("Deposit of an unknown asset", "AssetId", assetId, "err", err)
+		return false
+	}
+	if l.chainCore.ConvertStringToResourceId(currency.ResourceId) != rId {
+		l.log.Error("Remark resource is not the one of the deposited asset", "AssetId", assetId, "RId", rId.Shorten())
+		return false
+	}
+
+	log.Info("Parameter check passed", "Dest", destId, "AssetId", assetId, "RId", rId.Shorten(), "Recipient", l.chainCore.ShortenAddress(string(recipient)))
+	return true
+}
+
+// checkDestination checks that the deposits can be sent to the chain, the configured destination or else another
+// Eth-Like chain of the multisig bridge
+func (l *listener) checkDestination(destId msg.ChainId) bool {
+	if l.destId != 0 {
+		return destId == l.destId
+	}
+	return destId != 0 && destId != l.chainId && chainset.IsMultiSigTransfer(destId)
+}
+
+func (l *listener) findLostTxByAddress(currentBlock uint64, e *depositExtrinsic) bool {
 	lostPubAddress, _ := ss58.DecodeToPub(l.lostAddress)
 
 	if l.lostAddress != "" {
 		/// Find the lost transaction
-		if string(sendPubAddress) == string(lostPubAddress[:]) {
-			l.logInfo(FindLostMultiSigTx, currentBlock)
+		if string(e.signer[:]) == string(lostPubAddress[:]) {
+			l.logInfo(FindLostMultiSigTx, int64(currentBlock))
 		}
 		return true
 	} else {
@@ -172,15 +253,14 @@ func (l *listener) findLostTxByAddress(currentBlock int64, e *models.ExtrinsicRe
 	}
 }
 
-func (l *listener) getSendAmount(e *models.ExtrinsicResponse) (*big.Int, bool) {
+func (l *listener) getSendAmount(amount *big.Int, assetId xevents.AssetId) (*big.Int, bool) {
 	// Construct parameters of message
-	amount, ok := big.NewInt(0).SetString(e.Amount, 10)
-	if !ok || amount.Uint64() == 0 {
+	if amount.Sign() <= 0 {
 		fmt.Printf("parse transfer amount %v, amount.string %v\n", amount, amount.String())
 		return nil, false
 	}
 
-	sendAmount, err := l.chainCore.GetAmountToEth(amount.Bytes(), e.AssetId)
+	sendAmount, err := l.chainCore.GetAmountToEth(amount.Bytes(), assetId)
 	if err != nil {
 		return nil, false
 	}
@@ -188,62 +268,239 @@ func (l *listener) getSendAmount(e *models.ExtrinsicResponse) (*big.Int, bool) {
 	return sendAmount, true
 }
 
-func (l *listener) checkToAddress(e *models.ExtrinsicResponse) bool {
-	/// Validate whether a cross-chain transaction
-	toPubAddress, _ := ss58.DecodeToPub(e.ToAddress)
-	toAddress := types.NewAddressFromAccountID(toPubAddress)
-	return toAddress.AsAccountID == l.multiSigAddr
+// rejectReason returns why a deposit of a known asset is rejected, or an empty string if it is relayed
+func (l *listener) rejectReason(amount *big.Int, assetId xevents.AssetId) string {
+	if l.refunds == nil || amount.Sign() <= 0 {
+		return ""
+	}
+	currency, err := l.chainCore.GetCurrencyByAssetId(assetId)
+	if err != nil {
+		return ""
+	}
+	minimum := l.refunds.Minimum(l.chainCore.ConvertStringToResourceId(currency.ResourceId))
+	if minimum != nil && amount.Cmp(minimum) < 0 {
+		return refunds.ReasonBelowMinimum
+	}
+	_, err = l.chainCore.GetAmountToEth(amount.Bytes(), assetId)
+	if err != nil {
+		return refunds.ReasonFee
+	}
+	return ""
 }
 
-func (l *listener) checkFromAddress(e *models.ExtrinsicResponse) bool {
-	fromPubAddress, _ := ss58.DecodeToPub(e.FromAddress)
-	fromAddress := types.NewAddressFromAccountID(fromPubAddress)
-	currentRelayer := types.NewAddressFromAccountID(l.relayer.kr.PublicKey)
-	if currentRelayer.AsAccountID == fromAddress.AsAccountID {
-		return true
+// rejectDeposit records a rejected deposit of a known asset, and submits its refund to the writer if it is to be
+// refunded now
+func (l *listener) rejectDeposit(e *depositExtrinsic, d batchDeposit, block uint64, nonce msg.Nonce, reason string) {
+	if l.refunds == nil {
+		return
+	}
+	currency, err := l.chainCore.GetCurrencyByAssetId(d.assetId)
+	if err != nil {
+		return
 	}
-	for _, r := range l.relayer.otherSignatories {
-		if types.AccountID(r) == fromAddress.AsAccountID {
-			return true
+	rId := l.chainCore.ConvertStringToResourceId(currency.ResourceId)
+	depositor := types.HexEncodeToString(e.signer[:])
+
+	r, added, err := l.refunds.Reject(nonce, block, e.index, depositor, rId, d.amount, reason)
+	if err != nil {
+		l.log.Error(RecordRejectedDepositError, "DepositNonce", nonce, "err", err)
+		return
+	}
+	if !added {
+		return
+	}
+	l.log.Warn(RejectDeposit, "Block", block, "DepositNonce", nonce, "Depositor", depositor,
+		"AssetId", d.assetId, "Amount", d.amount, "Reason", reason, "Status", r.Status)
+	/// Recorded with its own chain as destination, as its refund is
+	rejected := msg.NewMultiSigTransfer(l.chainId, l.chainId, nonce, d.amount, rId, []byte(depositor))
+	l.record(rejected, lifecycle.Observed, e.hash.Hex(), "")
+	l.record(rejected, lifecycle.Failed, "", reason)
+	l.notifier.Notify(&webhooks.Event{
+		Kind:        webhooks.TransferFailed,
+		Source:      l.chainId,
+		Destination: l.chainId,
+		Nonce:       nonce,
+		ResourceId:  rId.Hex(),
+		Amount:      d.amount.String(),
+		TxHash:      e.hash.Hex(),
+		Reason:      reason,
+	})
+	if r.Status == refunds.StatusRefunding {
+		l.submitMessage(r.Message(l.chainId), nil)
+	}
+}
+
+// notifyDeposit notifies the webhooks of a deposit routed as m, with its deposited amount and its fee
+func (l *listener) notifyDeposit(e *depositExtrinsic, d batchDeposit, m msg.Message) {
+	m.Source = l.chainId
+	evt := webhooks.NewEvent(webhooks.DepositObserved, m).SetAmount(d.amount)
+	if fee, err := l.chainCore.GetFeeToEth(d.amount.Bytes(), d.assetId); err == nil {
+		evt.SetFee(fee)
+	}
+	evt.TxHash = e.hash.Hex()
+	l.notifier.Notify(evt)
+}
+
+// releaseRefunds submits the refunds whose grace period is over at the block to the writer
+func (l *listener) releaseRefunds(block uint64) {
+	if l.refunds == nil {
+		return
+	}
+	released, err := l.refunds.Release(block)
+	if err != nil {
+		l.logErr(RecordRejectedDepositError, err)
+	}
+	for _, r := range released {
+		l.log.Info(ReleaseRefund, "Block", block, "DepositNonce", r.Nonce, "Depositor", r.Depositor, "Reason", r.Reason)
+		l.submitMessage(r.Message(l.chainId), nil)
+	}
+}
+
+// handleMultisigEvents updates the state of the multisig operations of multiSigAddr
+func (l *listener) handleMultisigEvents(evts chainx.ChainXEventRecords, block uint64) {
+	l.multisigLock.Lock()
+	defer l.multisigLock.Unlock()
+	done := false
+
+	for _, evt := range evts.Multisig_NewMultisig {
+		if evt.ID != l.multiSigAddr {
+			continue
+		}
+		/// The timepoint of a new multisig is the extrinsic which created it
+		l.multisigs[evt.CallHash] = &multisigState{
+			CallHash:  evt.CallHash,
+			TimePoint: types.TimePoint{Height: types.U32(block), Index: types.U32(evt.Phase.AsApplyExtrinsic)},
+			Approvals: []types.AccountID{evt.Who},
+		}
+		l.log.Info(FindNewMultiSigTx, "Block", block, "CallHash", evt.CallHash.Hex(), "Who", types.HexEncodeToString(evt.Who[:]))
+	}
+	for _, evt := range evts.Multisig_MultisigApproval {
+		if evt.ID != l.multiSigAddr {
+			continue
+		}
+		s := l.multisigEntry(evt.CallHash, evt.TimePoint)
+		s.Approvals = append(s.Approvals, evt.Who)
+		l.log.Info(FindApproveMultiSigTx, "Block", block, "CallHash", evt.CallHash.Hex(), "Who", types.HexEncodeToString(evt.Who[:]))
+	}
+	for _, evt := range evts.Multisig_MultisigExecuted {
+		if evt.ID != l.multiSigAddr {
+			continue
+		}
+		s := l.multisigEntry(evt.CallHash, evt.TimePoint)
+		s.Approvals = append(s.Approvals, evt.Who)
+		s.Executed = true
+		s.Result = evt.Result
+		s.Closed = block
+		done = true
+		if evt.Result.Ok {
+			l.log.Info(FindExecutedMultiSigTx, "Block", block, "CallHash", evt.CallHash.Hex())
+		} else {
+			l.log.Error(FindFailedMultiSigTx, "Block", block, "CallHash", evt.CallHash.Hex(),
+				"Module", evt.Result.Error.Module, "Error", evt.Result.Error.Error)
+		}
+	}
+	for _, evt := range evts.Multisig_MultisigCancelled {
+		if evt.ID != l.multiSigAddr {
+			continue
+		}
+		s := l.multisigEntry(evt.CallHash, evt.TimePoint)
+		s.Cancelled = true
+		s.Closed = block
+		done = true
+		l.log.Warn(FindCancelledMultiSigTx, "Block", block, "CallHash", evt.CallHash.Hex(), "Who", types.HexEncodeToString(evt.Who[:]))
+	}
+
+	l.pruneMultisigs(block)
+
+	if done {
+		select {
+		case l.multisigDone <- struct{}{}:
+		default:
 		}
 	}
-	return false
 }
 
-func (l *listener) makeNewMultiSigRecord(e *models.ExtrinsicResponse) {
-	msTx := MultiSigAsMulti{
-		Executed:       false,
-		Threshold:      e.MultiSigAsMulti.Threshold,
-		MaybeTimePoint: e.MultiSigAsMulti.MaybeTimePoint,
-		DestAddress:    e.MultiSigAsMulti.DestAddress,
-		DestAmount:     e.MultiSigAsMulti.DestAmount,
-		Others:         nil,
-		StoreCall:      e.MultiSigAsMulti.StoreCall,
-		MaxWeight:      e.MultiSigAsMulti.MaxWeight,
-		OriginMsTx:     l.curTx,
+// pruneMultisigs forgets the operations closed more than MultisigRetention blocks before block, lock must be held
+func (l *listener) pruneMultisigs(block uint64) {
+	if block < MultisigRetention {
+		return
+	}
+	for h, s := range l.multisigs {
+		if s.Closed != 0 && s.Closed < block-MultisigRetention {
+			delete(l.multisigs, h)
+		}
 	}
-	/// Mark voted
-	msTx.Others = append(msTx.Others, e.MultiSigAsMulti.OtherSignatories)
-	l.asMulti[l.curTx] = msTx
 }
 
-func (l *listener) voteMultiSigRecord(msTx MultiSigAsMulti, e *models.ExtrinsicResponse) {
-	for k, ms := range l.asMulti {
-		if !ms.Executed && ms.DestAddress == msTx.DestAddress && ms.DestAmount == msTx.DestAmount {
-			//l.log.Info("relayer succeed vote", "Address", e.FromAddress)
-			voteMsTx := l.asMulti[k]
-			voteMsTx.Others = append(voteMsTx.Others, e.MultiSigAsMulti.OtherSignatories)
-			l.asMulti[k] = voteMsTx
+// recordBatchRemarks records the remarks of the redeem batches carried by the multisig operations of a block
+func (l *listener) recordBatchRemarks(redeems []*redeemExtrinsic) {
+	l.multisigLock.Lock()
+	defer l.multisigLock.Unlock()
+	for _, e := range redeems {
+		s, ok := l.multisigs[e.callHash]
+		if !ok || s.Remark != "" || len(e.calls) == 0 || !e.calls[len(e.calls)-1].remark {
+			continue
 		}
+		s.Remark = e.calls[len(e.calls)-1].data
 	}
 }
 
-func (l *listener) executeMultiSigRecord(msTx MultiSigAsMulti) {
-	for k, ms := range l.asMulti {
-		if !ms.Executed && ms.DestAddress == msTx.DestAddress && ms.DestAmount == msTx.DestAmount {
-			exeMsTx := l.asMulti[k]
-			exeMsTx.Executed = true
-			l.asMulti[k] = exeMsTx
+// pendingBatches returns the live multisig operations of multiSigAddr whose redeem batch is known, oldest first
+func (l *listener) pendingBatches() []multisigState {
+	l.multisigLock.RLock()
+	defer l.multisigLock.RUnlock()
+	var res []multisigState
+	for _, s := range l.multisigs {
+		if s.Remark == "" || s.outcome() != multisigPending {
+			continue
 		}
+		state := *s
+		state.Approvals = append([]types.AccountID(nil), s.Approvals...)
+		res = append(res, state)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].TimePoint.Height != res[j].TimePoint.Height {
+			return res[i].TimePoint.Height < res[j].TimePoint.Height
+		}
+		return res[i].TimePoint.Index < res[j].TimePoint.Index
+	})
+	return res
+}
+
+// multisigUpdated returns a channel which is signalled when multisig operations are executed or cancelled
+func (l *listener) multisigUpdated() <-chan struct{} {
+	return l.multisigDone
+}
+
+// multisigEntry returns the state of an operation, creating it if its creation was before the start block
+func (l *listener) multisigEntry(callHash types.Hash, when types.TimePoint) *multisigState {
+	s, ok := l.multisigs[callHash]
+	if !ok || s.TimePoint != when {
+		s = &multisigState{CallHash: callHash, TimePoint: when}
+		l.multisigs[callHash] = s
+	}
+	return s
+}
+
+// multisigState returns a copy of the state of a multisig operation of multiSigAddr
+func (l *listener) multisigState(callHash types.Hash) (multisigState, bool) {
+	l.multisigLock.RLock()
+	defer l.multisigLock.RUnlock()
+	s, ok := l.multisigs[callHash]
+	if !ok {
+		return multisigState{}, false
+	}
+	res := *s
+	res.Approvals = append([]types.AccountID(nil), s.Approvals...)
+	return res, true
+}
+
+// multisigOutcome returns whether a multisig operation of multiSigAddr is pending, executed, failed or cancelled
+func (l *listener) multisigOutcome(callHash types.Hash) multisigOutcome {
+	l.multisigLock.RLock()
+	defer l.multisigLock.RUnlock()
+	if s, ok := l.multisigs[callHash]; ok {
+		return s.outcome()
 	}
+	return multisigPending
 }
@@ -0,0 +1,311 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package substrate
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/Platdot-Network/go-substrate-rpc-client/v3/types"
+	"github.com/Platdot-Network/substrate-go/expand/chainx"
+	"github.com/Platdot-Network/substrate-go/expand/chainx/xevents"
+	"github.com/Platdot-network/Platdot/chains/chainset"
+	"github.com/Platdot-network/Platdot/chains/refunds"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+func newMultisigTestListener(multiSigAddr types.AccountID) *listener {
+	return &listener{
+		log:          AliceTestLogger,
+		multiSigAddr: multiSigAddr,
+		multisigs:    make(map[types.Hash]*multisigState),
+	}
+}
+
+func TestHandleMultisigEvents(t *testing.T) {
+	multiSigAddr := types.NewAccountID([]byte{1})
+	alice := types.NewAccountID(AliceKey.PublicKey)
+	bob := types.NewAccountID(BobKey.PublicKey)
+	executedHash := types.NewHash([]byte{0xaa})
+	failedHash := types.NewHash([]byte{0xbb})
+	otherHash := types.NewHash([]byte{0xcc})
+	l := newMultisigTestListener(multiSigAddr)
+
+	// Block 10: two new multisigs, one of them for another multisig account
+	var evts chainx.ChainXEventRecords
+	evts.Multisig_NewMultisig = []types.EventMultisigNewMultisig{
+		{Phase: types.Phase{IsApplyExtrinsic: true, AsApplyExtrinsic: 2}, Who: alice, ID: multiSigAddr, CallHash: executedHash},
+		{Phase: types.Phase{IsApplyExtrinsic: true, AsApplyExtrinsic: 3}, Who: alice, ID: multiSigAddr, CallHash: failedHash},
+		{Phase: types.Phase{IsApplyExtrinsic: true, AsApplyExtrinsic: 4}, Who: alice, ID: bob, CallHash: otherHash},
+	}
+	l.handleMultisigEvents(evts, 10)
+
+	state, ok := l.multisigState(executedHash)
+	if !ok {
+		t.Fatal("New multisig not recorded")
+	}
+	expected := types.TimePoint{Height: 10, Index: 2}
+	if state.TimePoint != expected {
+		t.Fatalf("Got: %v Expected: %v", state.TimePoint, expected)
+	}
+	if !containsVote(state.Approvals, alice) || l.multisigOutcome(executedHash) != multisigPending {
+		t.Fatalf("Unexpected state: %+v", state)
+	}
+	if _, ok := l.multisigState(otherHash); ok {
+		t.Fatal("Multisig of another account should be ignored")
+	}
+
+	// Block 11: both are executed, one of them with an error
+	evts = chainx.ChainXEventRecords{}
+	evts.Multisig_MultisigExecuted = []types.EventMultisigExecuted{
+		{Who: bob, TimePoint: expected, ID: multiSigAddr, CallHash: executedHash, Result: types.DispatchResult{Ok: true}},
+		{Who: bob, TimePoint: types.TimePoint{Height: 10, Index: 3}, ID: multiSigAddr, CallHash: failedHash,
+			Result: types.DispatchResult{Error: types.DispatchError{HasModule: true, Module: 5, Error: 2}}},
+	}
+	l.handleMultisigEvents(evts, 11)
+
+	if outcome := l.multisigOutcome(executedHash); outcome != multisigExecuted {
+		t.Fatalf("Got: %d Expected: %d", outcome, multisigExecuted)
+	}
+	if outcome := l.multisigOutcome(failedHash); outcome != multisigFailed {
+		t.Fatalf("Got: %d Expected: %d", outcome, multisigFailed)
+	}
+	state, _ = l.multisigState(executedHash)
+	if !containsVote(state.Approvals, bob) {
+		t.Fatal("Executing approval not recorded")
+	}
+}
+
+func TestPendingBatches(t *testing.T) {
+	multiSigAddr := types.NewAccountID([]byte{1})
+	alice := types.NewAccountID(AliceKey.PublicKey)
+	first := types.NewHash([]byte{0xaa})
+	second := types.NewHash([]byte{0xbb})
+	unknown := types.NewHash([]byte{0xcc})
+	l := newMultisigTestListener(multiSigAddr)
+
+	var evts chainx.ChainXEventRecords
+	evts.Multisig_NewMultisig = []types.EventMultisigNewMultisig{
+		{Phase: types.Phase{IsApplyExtrinsic: true, AsApplyExtrinsic: 3}, Who: alice, ID: multiSigAddr, CallHash: second},
+		{Phase: types.Phase{IsApplyExtrinsic: true, AsApplyExtrinsic: 2}, Who: alice, ID: multiSigAddr, CallHash: first},
+		{Phase: types.Phase{IsApplyExtrinsic: true, AsApplyExtrinsic: 4}, Who: alice, ID: multiSigAddr, CallHash: unknown},
+	}
+	l.handleMultisigEvents(evts, 10)
+	transfer := batchCall{transfer: true, amount: big.NewInt(1)}
+	l.recordBatchRemarks([]*redeemExtrinsic{
+		{index: 3, callHash: second, calls: []batchCall{transfer, {remark: true, data: "1:43"}}},
+		{index: 2, callHash: first, calls: []batchCall{transfer, {remark: true, data: "1:42"}}},
+		/// Not a redeem batch
+		{index: 4, callHash: unknown, calls: []batchCall{transfer}},
+	})
+
+	pending := l.pendingBatches()
+	if len(pending) != 2 || pending[0].CallHash != first || pending[0].Remark != "1:42" || pending[1].CallHash != second {
+		t.Fatalf("Unexpected batches: %+v", pending)
+	}
+
+	evts = chainx.ChainXEventRecords{}
+	evts.Multisig_MultisigExecuted = []types.EventMultisigExecuted{
+		{Who: alice, TimePoint: types.TimePoint{Height: 10, Index: 2}, ID: multiSigAddr, CallHash: first, Result: types.DispatchResult{Ok: true}},
+	}
+	l.handleMultisigEvents(evts, 11)
+	if pending := l.pendingBatches(); len(pending) != 1 || pending[0].CallHash != second {
+		t.Fatalf("Unexpected batches: %+v", pending)
+	}
+}
+
+func TestHandleMultisigCancelled(t *testing.T) {
+	multiSigAddr := types.NewAccountID([]byte{1})
+	callHash := types.NewHash([]byte{0xaa})
+	l := newMultisigTestListener(multiSigAddr)
+
+	// Created before the listener started, only the cancellation is seen
+	var evts chainx.ChainXEventRecords
+	evts.Multisig_MultisigCancelled = []types.EventMultisigCancelled{
+		{TimePoint: types.TimePoint{Height: 5, Index: 1}, ID: multiSigAddr, CallHash: callHash},
+	}
+	l.handleMultisigEvents(evts, 12)
+
+	if outcome := l.multisigOutcome(callHash); outcome != multisigCancelled {
+		t.Fatalf("Got: %d Expected: %d", outcome, multisigCancelled)
+	}
+}
+
+func TestPruneMultisigs(t *testing.T) {
+	multiSigAddr := types.NewAccountID([]byte{1})
+	alice := types.NewAccountID(AliceKey.PublicKey)
+	executedHash := types.NewHash([]byte{0xaa})
+	pendingHash := types.NewHash([]byte{0xbb})
+	l := newMultisigTestListener(multiSigAddr)
+
+	var evts chainx.ChainXEventRecords
+	evts.Multisig_NewMultisig = []types.EventMultisigNewMultisig{
+		{Phase: types.Phase{IsApplyExtrinsic: true, AsApplyExtrinsic: 2}, Who: alice, ID: multiSigAddr, CallHash: executedHash},
+		{Phase: types.Phase{IsApplyExtrinsic: true, AsApplyExtrinsic: 3}, Who: alice, ID: multiSigAddr, CallHash: pendingHash},
+	}
+	l.handleMultisigEvents(evts, 10)
+	evts = chainx.ChainXEventRecords{}
+	evts.Multisig_MultisigExecuted = []types.EventMultisigExecuted{
+		{Who: alice, TimePoint: types.TimePoint{Height: 10, Index: 2}, ID: multiSigAddr, CallHash: executedHash, Result: types.DispatchResult{Ok: true}},
+	}
+	l.handleMultisigEvents(evts, 11)
+
+	// The outcome is kept over the retention, the pending operations are kept until they are closed
+	l.handleMultisigEvents(chainx.ChainXEventRecords{}, 11+MultisigRetention)
+	if outcome := l.multisigOutcome(executedHash); outcome != multisigExecuted {
+		t.Fatalf("Got: %d Expected: %d", outcome, multisigExecuted)
+	}
+	l.handleMultisigEvents(chainx.ChainXEventRecords{}, 12+MultisigRetention)
+	if _, ok := l.multisigState(executedHash); ok {
+		t.Fatal("Executed multisig should be pruned after the retention")
+	}
+	if _, ok := l.multisigState(pendingHash); !ok {
+		t.Fatal("Pending multisig should not be pruned")
+	}
+}
+
+func TestRejectDeposit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "refunds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg, err := refunds.ParseConfig(map[string]string{
+		refunds.MinimumOpt: "*:1000000000",
+		refunds.RefundOpt:  "true",
+		refunds.CostOpt:    "*:100000000",
+		refunds.DirOpt:     dir,
+	}, "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := refunds.NewStore(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := &mockRouter{msgs: make(chan msg.Message, 1)}
+	l := &listener{
+		chainId:   1,
+		log:       AliceTestLogger,
+		router:    router,
+		chainCore: chainset.NewChainCore(chainset.NameKusama),
+		refunds:   store,
+	}
+
+	if reason := l.rejectReason(big.NewInt(500000000), chainset.OriginAsset); reason != refunds.ReasonBelowMinimum {
+		t.Fatalf("Got: %q Expected: %q", reason, refunds.ReasonBelowMinimum)
+	}
+	/// The fixed fee of KSM is 0.01 KSM
+	if reason := l.rejectReason(big.NewInt(5000000000), chainset.OriginAsset); reason != refunds.ReasonFee {
+		t.Fatalf("Got: %q Expected: %q", reason, refunds.ReasonFee)
+	}
+	if reason := l.rejectReason(big.NewInt(20000000000), chainset.OriginAsset); reason != "" {
+		t.Fatalf("Got: %q Expected no rejection", reason)
+	}
+
+	e := &depositExtrinsic{index: 2, signer: types.NewAccountID(AliceKey.PublicKey)}
+	d := batchDeposit{amount: big.NewInt(5000000000), assetId: chainset.OriginAsset, data: "2,000,0x01"}
+	l.rejectDeposit(e, d, 10, 102, refunds.ReasonFee)
+
+	m := <-router.msgs
+	if m.Type != refunds.RefundTransfer || m.Destination != 1 || m.DepositNonce != 102 ||
+		big.NewInt(0).SetBytes(m.Payload[0].([]byte)).Int64() != 4900000000 ||
+		string(m.Payload[1].([]byte)) != types.HexEncodeToString(AliceKey.PublicKey) {
+		t.Fatalf("Unexpected refund: %+v", m)
+	}
+
+	/// The deposit seen again, e.g. on a replay, is not refunded twice
+	l.rejectDeposit(e, d, 10, 102, refunds.ReasonFee)
+	select {
+	case m := <-router.msgs:
+		t.Fatalf("Unexpected message: %+v", m)
+	default:
+	}
+}
+
+func TestRefundMalformedRemark(t *testing.T) {
+	dir, err := ioutil.TempDir("", "refunds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg, err := refunds.ParseConfig(map[string]string{refunds.RefundOpt: "true", refunds.GraceOpt: "5", refunds.DirOpt: dir}, "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := refunds.NewStore(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := &mockRouter{msgs: make(chan msg.Message, 1)}
+	l := &listener{
+		chainId:   1,
+		log:       AliceTestLogger,
+		router:    router,
+		chainCore: chainset.NewChainCore(chainset.NameKusama),
+		refunds:   store,
+	}
+
+	/// A remark without recipient must not panic
+	if _, _, _, err := l.parseRemark("2,000,"); err != nil {
+		t.Fatal(err)
+	}
+
+	e := &depositExtrinsic{index: 2, signer: types.NewAccountID(AliceKey.PublicKey)}
+	d := batchDeposit{amount: big.NewInt(20000000000), assetId: chainset.OriginAsset, data: "2;000;0x01"}
+	l.rejectDeposit(e, d, 10, 102, refunds.ReasonRemark)
+	l.releaseRefunds(14)
+	select {
+	case m := <-router.msgs:
+		t.Fatalf("Unexpected message during the grace period: %+v", m)
+	default:
+	}
+
+	l.releaseRefunds(15)
+	m := <-router.msgs
+	if m.Type != refunds.RefundTransfer || m.DepositNonce != 102 || big.NewInt(0).SetBytes(m.Payload[0].([]byte)).Int64() != 20000000000 {
+		t.Fatalf("Unexpected refund: %+v", m)
+	}
+}
+
+func TestCheckAssetRemark(t *testing.T) {
+	l := &listener{
+		chainId:   5,
+		log:       AliceTestLogger,
+		chainCore: chainset.NewChainCore(chainset.NameStatemine),
+		destId:    2,
+	}
+	usdt := l.chainCore.ConvertStringToResourceId(chainset.ResourceIdUSDT)
+	origin := l.chainCore.ConvertStringToResourceId(chainset.ResourceIdOrigin)
+	recipient := []byte("0x0000000000000000000000000000000000000001")
+
+	for _, c := range []struct {
+		destId  msg.ChainId
+		assetId xevents.AssetId
+		rId     msg.ResourceId
+		passed  bool
+	}{
+		{2, chainset.AssetUSDT, usdt, true},
+		{4, chainset.AssetUSDT, usdt, false},
+		{2, chainset.AssetUSDT, origin, false},
+		/// The native token is deposited as the asset OriginAsset
+		{2, chainset.OriginAsset, origin, true},
+		{4, chainset.OriginAsset, origin, false},
+		{2, chainset.OriginAsset, usdt, false},
+	} {
+		if passed := l.checkAssetRemark(c.destId, c.assetId, c.rId, recipient); passed != c.passed {
+			t.Fatalf("Dest %d, asset %d: Got: %v Expected: %v", c.destId, c.assetId, passed, c.passed)
+		}
+	}
+
+	/// Without a configured destination, any other Eth-Like chain of the multisig bridge is served
+	l.destId = 0
+	for destId, passed := range map[msg.ChainId]bool{0: false, 2: true, 4: true, 5: false, 200: false} {
+		if l.checkAssetRemark(destId, chainset.OriginAsset, origin, recipient) != passed {
+			t.Fatalf("Dest %d: Expected: %v", destId, passed)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package substrate
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+
+	"github.com/Platdot-Network/go-substrate-rpc-client/v3/scale"
+	"github.com/Platdot-Network/go-substrate-rpc-client/v3/types"
+	"github.com/Platdot-network/Platdot/chains"
+	"github.com/rjman-ljm/platdot-utils/msg"
+	"golang.org/x/crypto/blake2b"
+)
+
+var _ chains.Auditor = &Chain{}
+
+// redeemExtrinsic is a Multisig.as_multi extrinsic carrying a redeem batch
+type redeemExtrinsic struct {
+	index    int
+	callHash types.Hash
+	calls    []batchCall
+}
+
+// decodeRedeemExtrinsic decodes a signed Multisig.as_multi extrinsic whose call is a batch. It returns nil if the
+// extrinsic isn't such a multisig operation.
+func decodeRedeemExtrinsic(raw []byte, index int, c *callIndices) (*redeemExtrinsic, error) {
+	if !c.hasMultisig {
+		return nil, nil
+	}
+	decoder, _, callIndex, err := decodeSignedExtrinsic(raw, c)
+	if err != nil || decoder == nil || callIndex != c.asMulti {
+		return nil, err
+	}
+
+	var threshold types.U16
+	var signatories []types.AccountID
+	var hasTimePoint byte
+	for _, field := range []interface{}{&threshold, &signatories, &hasTimePoint} {
+		err = decoder.Decode(field)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if hasTimePoint == 1 {
+		var when types.TimePoint
+		err = decoder.Decode(&when)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var call types.Bytes
+	err = decoder.Decode(&call)
+	if err != nil {
+		return nil, err
+	}
+
+	inner := scale.NewDecoder(bytes.NewReader(call))
+	err = inner.Decode(&callIndex)
+	if err != nil || (callIndex != c.batch && callIndex != c.batchAll) {
+		return nil, err
+	}
+	calls, err := c.decodeBatchCalls(inner, len(call))
+	if err != nil {
+		return nil, err
+	}
+	return &redeemExtrinsic{
+		index:    index,
+		callHash: blake2b.Sum256(call),
+		calls:    calls,
+	}, nil
+}
+
+// redeemExecutions pairs the transfers of an executed redeem batch with the deposits listed by its remark
+func redeemExecutions(e *redeemExtrinsic, block uint64) ([]chains.Execution, error) {
+	if len(e.calls) == 0 || !e.calls[len(e.calls)-1].remark {
+		return nil, fmt.Errorf("redeem batch doesn't end with a remark")
+	}
+	keys, _, err := parseBatchRemark(e.calls[len(e.calls)-1].data)
+	if err != nil {
+		return nil, err
+	}
+	transfers := e.calls[:len(e.calls)-1]
+	if len(transfers) != len(keys) {
+		return nil, fmt.Errorf("redeem batch has %d transfers for %d deposits", len(transfers), len(keys))
+	}
+
+	res := make([]chains.Execution, len(keys))
+	for i, key := range keys {
+		if !transfers[i].transfer {
+			return nil, fmt.Errorf("call %d of the redeem batch is not a transfer", i)
+		}
+		res[i] = chains.Execution{
+			Source:    key.Source,
+			Nonce:     key.Nonce,
+			Block:     block,
+			Tx:        fmt.Sprintf("%d-%d", block, e.index),
+			Recipient: transfers[i].dest[:],
+			Amount:    transfers[i].amount,
+		}
+	}
+	return res, nil
+}
+
+// executions returns the deposits redeemed by the batches the multiSig executed between the blocks start and end
+func (l *listener) executions(start uint64, end uint64) ([]chains.Execution, error) {
+	var res []chains.Execution
+	for number := start; number <= end; number++ {
+		block, err := l.fetchRawBlock(number)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch block %d: %w", number, err)
+		}
+
+		for _, evt := range block.evts.Multisig_MultisigExecuted {
+			if evt.ID != l.multiSigAddr || !evt.Result.Ok || !evt.Phase.IsApplyExtrinsic {
+				continue
+			}
+			index := int(evt.Phase.AsApplyExtrinsic)
+			if index >= len(block.extrinsics) {
+				return nil, fmt.Errorf("block %d has no extrinsic %d", number, index)
+			}
+			raw, err := types.HexDecodeString(block.extrinsics[index])
+			if err != nil {
+				return nil, err
+			}
+			e, err := decodeRedeemExtrinsic(raw, index, block.indices)
+			if err != nil || e == nil || e.callHash != evt.CallHash {
+				l.log.Warn("Executed multiSig operation is not a redeem batch", "Block", number, "Index", index,
+					"CallHash", evt.CallHash.Hex(), "err", err)
+				continue
+			}
+			executions, err := redeemExecutions(e, number)
+			if err != nil {
+				l.log.Warn("Failed to pair the redeem batch with its deposits", "Block", number, "Index", index, "err", err)
+				continue
+			}
+			res = append(res, executions...)
+		}
+	}
+	return res, nil
+}
+
+// Executions returns the deposits redeemed through the multiSig between the blocks start and end
+func (c *Chain) Executions(start uint64, end uint64) ([]chains.Execution, error) {
+	return c.listener.executions(start, end)
+}
+
+// ExpectedAmount returns the amount a redemption sends from the multiSig, fee deducted
+func (c *Chain) ExpectedAmount(m msg.Message) (*big.Int, error) {
+	assetId, err := c.writer.getRedeemAssetId(m)
+	if err != nil {
+		return nil, err
+	}
+	return c.writer.chainCore.GetAmountToSub(m.Payload[0].([]byte), assetId)
+}
@@ -0,0 +1,40 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package substrate
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/Platdot-Network/go-substrate-rpc-client/v3/types"
+)
+
+func TestRedeemExecutions(t *testing.T) {
+	e := &redeemExtrinsic{
+		index: 2,
+		calls: []batchCall{
+			{transfer: true, dest: types.NewAccountID([]byte{1}), amount: big.NewInt(10)},
+			{transfer: true, dest: types.NewAccountID([]byte{2}), amount: big.NewInt(20)},
+			{remark: true, data: "1:42,2:9"},
+		},
+	}
+
+	executions, err := redeemExecutions(e, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(executions) != 2 {
+		t.Fatalf("Got %d executions, expected 2", len(executions))
+	}
+	second := executions[1]
+	if second.Source != 2 || second.Nonce != 9 || second.Amount.Int64() != 20 || second.Tx != "100-2" {
+		t.Fatalf("Unexpected execution: %+v", second)
+	}
+
+	// Without remark the transfers can't be paired with deposits
+	e.calls = e.calls[:2]
+	if _, err := redeemExecutions(e, 100); err == nil {
+		t.Fatal("Expected an error for a batch without remark")
+	}
+}
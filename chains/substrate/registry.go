@@ -0,0 +1,340 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package substrate
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ChainSafe/log15"
+	"github.com/Platdot-Network/go-substrate-rpc-client/v3/types"
+	"github.com/Platdot-network/Platdot/chains/persist"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+// Time the executed deposits are remembered, a deposit routed again afterwards is no longer known as executed
+var ExecutedRetention = 7 * 24 * time.Hour
+
+type redemptionStatus int
+
+const (
+	redemptionPending redemptionStatus = iota // Waiting for a batch
+	redemptionVoted                           // Voted by this relayer in a live batch
+)
+
+// redemption is a deposit to be redeemed through the multisig
+type redemption struct {
+	m        msg.Message
+	status   redemptionStatus
+	callHash types.Hash // Batch voted by this relayer, set once voted
+	round    uint32     // Retry round, the height of the timepoint of the last batch of the deposit which was closed
+	failed   bool       // Whether a batch of the deposit failed, the failure is only reported once
+}
+
+// execution is the batch which executed a deposit
+type execution struct {
+	callHash types.Hash
+	time     time.Time
+}
+
+// ballot is the persisted bookkeeping of a redemption, restored when the deposit is routed again after a restart
+type ballot struct {
+	Source   msg.ChainId `json:"source"`
+	Nonce    msg.Nonce   `json:"nonce"`
+	CallHash string      `json:"callHash,omitempty"`
+	Round    uint32      `json:"round,omitempty"`
+	Failed   bool        `json:"failed,omitempty"`
+}
+
+// registry tracks the redemptions of the writer, keyed by source chain and deposit nonce.
+// It is shared by the router goroutines and the batch loop. The redemptions are evicted once executed, only the
+// batch which executed them is remembered for ExecutedRetention so that a deposit routed again is not redeemed twice.
+//
+// The batches voted by this relayer and the retry rounds are persisted, so that after a restart the relayer neither
+// votes a deposit in a second live batch nor proposes again a batch which was closed.
+type registry struct {
+	lock     sync.Mutex
+	entries  map[depositKey]*redemption
+	executed map[depositKey]execution   // Batch which executed each deposit, within the retention
+	restored map[depositKey]*redemption // Bookkeeping persisted before a restart, of deposits not routed again yet
+	notify   chan struct{}              // Signals that redemptions were added
+	path     string                     // File of the bookkeeping, not persisted if empty
+	log      log15.Logger
+	now      func() time.Time
+}
+
+// registryPath returns the file of the bookkeeping of the redemptions to a chain, in the blockstore directory
+func registryPath(blockstorePath string, chain msg.ChainId) (string, error) {
+	dir, err := persist.BlockstoreDir(blockstorePath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, fmt.Sprintf("redemptions-%d.json", chain)), nil
+}
+
+// newRegistry returns a registry, restoring the bookkeeping persisted at path if any
+func newRegistry(path string, log log15.Logger) (*registry, error) {
+	r := &registry{
+		entries:  make(map[depositKey]*redemption, InitCapacity),
+		executed: make(map[depositKey]execution, InitCapacity),
+		restored: make(map[depositKey]*redemption),
+		notify:   make(chan struct{}, 1),
+		path:     path,
+		log:      log,
+		now:      time.Now,
+	}
+	if path == "" {
+		return r, nil
+	}
+
+	var ballots []ballot
+	err := persist.ReadJSON(path, &ballots)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range ballots {
+		e := &redemption{status: redemptionPending, round: b.Round, failed: b.Failed}
+		if b.CallHash != "" {
+			e.callHash, err = types.NewHashFromHexString(b.CallHash)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", path, err)
+			}
+			e.status = redemptionVoted
+		}
+		r.restored[depositKey{Source: b.Source, Nonce: b.Nonce}] = e
+	}
+	return r, nil
+}
+
+// add registers a redemption, it returns false if the deposit is already known
+func (r *registry) add(m msg.Message) bool {
+	key := depositKey{Source: m.Source, Nonce: m.DepositNonce}
+
+	r.lock.Lock()
+	_, known := r.entries[key]
+	_, executed := r.executed[key]
+	if known || executed {
+		r.lock.Unlock()
+		return false
+	}
+	e := &redemption{m: m, status: redemptionPending}
+	if restored, ok := r.restored[key]; ok {
+		e.status, e.callHash, e.round, e.failed = restored.status, restored.callHash, restored.round, restored.failed
+		delete(r.restored, key)
+	}
+	r.entries[key] = e
+	r.lock.Unlock()
+
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// added returns a channel which is signalled when redemptions are added
+func (r *registry) added() <-chan struct{} {
+	return r.notify
+}
+
+// pending returns at most max redemptions which are not executed, lowest deposits first
+func (r *registry) pending(max int) []msg.Message {
+	r.lock.Lock()
+	ms := make([]msg.Message, 0, len(r.entries))
+	for _, e := range r.entries {
+		ms = append(ms, e.m)
+	}
+	r.lock.Unlock()
+
+	sortRedemptions(ms)
+	if max > 0 && len(ms) > max {
+		ms = ms[:max]
+	}
+	return ms
+}
+
+// messages returns the messages of the deposits, and false unless they are all pending redemptions
+func (r *registry) messages(keys []depositKey) ([]msg.Message, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	ms := make([]msg.Message, 0, len(keys))
+	for _, key := range keys {
+		e, ok := r.entries[key]
+		if !ok {
+			return nil, false
+		}
+		ms = append(ms, e.m)
+	}
+	return ms, true
+}
+
+// vote records that the deposits were voted in the batch with the given call hash
+func (r *registry) vote(keys []depositKey, callHash types.Hash) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, key := range keys {
+		if e, ok := r.entries[key]; ok {
+			e.status = redemptionVoted
+			e.callHash = callHash
+		}
+	}
+	r.save()
+}
+
+// votedIn returns the call hash of the batch a deposit was voted in
+func (r *registry) votedIn(key depositKey) (types.Hash, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if e, ok := r.entries[key]; ok && e.status != redemptionPending {
+		return e.callHash, true
+	}
+	if e, ok := r.restored[key]; ok && e.status != redemptionPending {
+		return e.callHash, true
+	}
+	if e, ok := r.executed[key]; ok {
+		return e.callHash, true
+	}
+	return types.Hash{}, false
+}
+
+// voted returns the call hashes of all batches with deposits voted but not yet executed
+func (r *registry) voted() map[types.Hash][]depositKey {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	res := make(map[types.Hash][]depositKey)
+	for key, e := range r.entries {
+		if e.status == redemptionVoted {
+			res[e.callHash] = append(res[e.callHash], key)
+		}
+	}
+	return res
+}
+
+// release forgets the votes for a batch which was closed without redeeming its deposits, they are pending again. The
+// deposits are retried by batches of the given round, so that the retries don't have the hash of the closed batch.
+func (r *registry) release(keys []depositKey, callHash types.Hash, round uint32) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, key := range keys {
+		e, ok := r.entries[key]
+		if !ok {
+			continue
+		}
+		if e.status == redemptionVoted && e.callHash == callHash {
+			e.status = redemptionPending
+			e.callHash = types.Hash{}
+		}
+		if round > e.round {
+			e.round = round
+		}
+	}
+	r.save()
+}
+
+// fail records that a batch of the deposits failed, it returns the deposits which never failed before
+func (r *registry) fail(keys []depositKey) []depositKey {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var res []depositKey
+	for _, key := range keys {
+		if e, ok := r.entries[key]; ok && !e.failed {
+			e.failed = true
+			res = append(res, key)
+		}
+	}
+	if len(res) > 0 {
+		r.save()
+	}
+	return res
+}
+
+// round returns the retry round of a batch of the deposits, the latest round of any of them
+func (r *registry) round(keys []depositKey) uint32 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var res uint32
+	for _, key := range keys {
+		if e, ok := r.entries[key]; ok && e.round > res {
+			res = e.round
+		}
+	}
+	return res
+}
+
+// execute evicts the deposits redeemed by the batch. It returns the messages which were not executed before.
+func (r *registry) execute(keys []depositKey, callHash types.Hash) []msg.Message {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.prune()
+	var executed []msg.Message
+	for _, key := range keys {
+		e, ok := r.entries[key]
+		if !ok {
+			continue
+		}
+		delete(r.entries, key)
+		r.executed[key] = execution{callHash: callHash, time: r.now()}
+		executed = append(executed, e.m)
+	}
+	if len(executed) > 0 {
+		r.save()
+	}
+	return executed
+}
+
+// prune forgets the deposits executed more than ExecutedRetention ago, it must be called with the lock held
+func (r *registry) prune() {
+	for key, e := range r.executed {
+		if r.now().Sub(e.time) > ExecutedRetention {
+			delete(r.executed, key)
+		}
+	}
+}
+
+// isExecuted returns whether a deposit was redeemed
+func (r *registry) isExecuted(key depositKey) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	_, ok := r.executed[key]
+	return ok
+}
+
+// save persists the votes and the retry rounds of the redemptions which are not executed, it must be called with the
+// lock held
+func (r *registry) save() {
+	if r.path == "" {
+		return
+	}
+	ballots := make([]ballot, 0)
+	add := func(key depositKey, e *redemption) {
+		if e.status == redemptionPending && e.round == 0 && !e.failed {
+			return
+		}
+		b := ballot{Source: key.Source, Nonce: key.Nonce, Round: e.round, Failed: e.failed}
+		if e.status == redemptionVoted {
+			b.CallHash = e.callHash.Hex()
+		}
+		ballots = append(ballots, b)
+	}
+	for key, e := range r.entries {
+		add(key, e)
+	}
+	for key, e := range r.restored {
+		add(key, e)
+	}
+	sort.Slice(ballots, func(i, j int) bool {
+		if ballots[i].Source != ballots[j].Source {
+			return ballots[i].Source < ballots[j].Source
+		}
+		return ballots[i].Nonce < ballots[j].Nonce
+	})
+
+	err := persist.WriteJSON(r.path, ballots)
+	if err != nil {
+		r.log.Error("Failed to save the redemptions", "path", r.path, "err", err)
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package substrate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Platdot-Network/go-substrate-rpc-client/v3/types"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+// newTestRedemption returns a redemption, all of them with the same amount and recipient
+func newTestRedemption(source msg.ChainId, nonce msg.Nonce) msg.Message {
+	return msg.Message{
+		Source:       source,
+		Destination:  1,
+		Type:         msg.MultiSigTransfer,
+		DepositNonce: nonce,
+		Payload:      []interface{}{[]byte{1}, []byte("0x01")},
+	}
+}
+
+func TestRegistryConcurrentAdd(t *testing.T) {
+	r, _ := newRegistry("", nil)
+	total := 100
+	added := 0
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+
+	// Every deposit is routed by several goroutines
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < total; i++ {
+				if r.add(newTestRedemption(2, msg.Nonce(i))) {
+					lock.Lock()
+					added++
+					lock.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if added != total {
+		t.Fatalf("Got: %d Expected: %d", added, total)
+	}
+	if n := len(r.pending(0)); n != total {
+		t.Fatalf("Got: %d Expected: %d", n, total)
+	}
+	select {
+	case <-r.added():
+	default:
+		t.Fatal("Adding redemptions should be signalled")
+	}
+}
+
+func TestRegistryVoteRelease(t *testing.T) {
+	r, _ := newRegistry("", nil)
+	for i := 5; i > 0; i-- {
+		r.add(newTestRedemption(2, msg.Nonce(i)))
+	}
+
+	ms := r.pending(3)
+	if len(ms) != 3 || ms[0].DepositNonce != 1 || ms[2].DepositNonce != 3 {
+		t.Fatalf("Unexpected pending redemptions: %v", ms)
+	}
+
+	keys := []depositKey{{2, 1}, {2, 2}}
+	if ms, ok := r.messages(keys); !ok || len(ms) != 2 || ms[1].DepositNonce != 2 {
+		t.Fatalf("Unexpected redemptions: %v %v", ms, ok)
+	}
+	if _, ok := r.messages([]depositKey{{2, 1}, {2, 6}}); ok {
+		t.Fatal("Unknown deposit should not be found")
+	}
+	hash := types.NewHash([]byte{1})
+	r.vote(keys, hash)
+	if h, ok := r.votedIn(depositKey{2, 1}); !ok || h != hash {
+		t.Fatalf("Got: %v Expected: %v", h, hash)
+	}
+	if _, ok := r.votedIn(depositKey{2, 3}); ok {
+		t.Fatal("Deposit wasn't voted")
+	}
+	if voted := r.voted(); len(voted[hash]) != 2 {
+		t.Fatalf("Got: %v Expected: %v", voted[hash], keys)
+	}
+
+	// Releasing another batch doesn't change the votes
+	r.release(keys, types.NewHash([]byte{2}), 0)
+	if _, ok := r.votedIn(depositKey{2, 1}); !ok {
+		t.Fatal("Vote released by another batch")
+	}
+	r.release(keys, hash, 0)
+	if _, ok := r.votedIn(depositKey{2, 1}); ok {
+		t.Fatal("Vote not released")
+	}
+
+	r.vote(keys, hash)
+	if executed := r.execute(keys, hash); len(executed) != 2 {
+		t.Fatalf("Got: %d Expected: %d", len(executed), 2)
+	}
+	if executed := r.execute(keys, hash); len(executed) != 0 {
+		t.Fatal("Deposits executed twice")
+	}
+	if !r.isExecuted(depositKey{2, 1}) || r.isExecuted(depositKey{2, 3}) {
+		t.Fatal("Unexpected execution status")
+	}
+	if r.add(newTestRedemption(2, 1)) {
+		t.Fatal("Executed deposit registered again")
+	}
+	if ms := r.pending(0); len(ms) != 3 || ms[0].DepositNonce != 3 {
+		t.Fatalf("Unexpected pending redemptions: %v", ms)
+	}
+}
+
+func TestRegistryConcurrentExecute(t *testing.T) {
+	r, _ := newRegistry("", nil)
+	total := 20
+	keys := make([]depositKey, total)
+	for i := 0; i < total; i++ {
+		r.add(newTestRedemption(2, msg.Nonce(i)))
+		keys[i] = depositKey{2, msg.Nonce(i)}
+	}
+	hash := types.NewHash([]byte{1})
+	r.vote(keys, hash)
+
+	// The batch loop and the sweep of the executed batches complete the same deposits
+	results := make(chan int, 8)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- len(r.execute(keys, hash))
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	executed := 0
+	for n := range results {
+		executed += n
+	}
+	if executed != total {
+		t.Fatalf("Got: %d Expected: %d", executed, total)
+	}
+
+	// Executed deposits are evicted, but never registered again
+	if len(r.entries) != 0 || len(r.pending(0)) != 0 || len(r.voted()) != 0 {
+		t.Fatalf("Executed deposits not evicted: %d", len(r.entries))
+	}
+	if r.add(newTestRedemption(2, 1)) || !r.isExecuted(depositKey{2, 1}) {
+		t.Fatal("Executed deposit registered again")
+	}
+	if h, ok := r.votedIn(depositKey{2, 1}); !ok || h != hash {
+		t.Fatalf("Got: %v Expected: %v", h, hash)
+	}
+}
+
+func TestRegistryRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "redemptions-1.json")
+
+	r, err := newRegistry(path, AliceTestLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 4; i++ {
+		r.add(newTestRedemption(2, msg.Nonce(i)))
+	}
+	cancelled := types.NewHash([]byte{1})
+	live := types.NewHash([]byte{2})
+	r.vote([]depositKey{{2, 1}, {2, 2}}, cancelled)
+	r.release([]depositKey{{2, 1}, {2, 2}}, cancelled, 1234)
+	r.vote([]depositKey{{2, 3}}, live)
+	r.vote([]depositKey{{2, 4}}, live)
+	r.execute([]depositKey{{2, 4}}, live)
+
+	// After a restart, the votes and the rounds are known again once the deposits are routed again
+	r, err = newRegistry(path, AliceTestLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, ok := r.votedIn(depositKey{2, 3}); !ok || h != live {
+		t.Fatalf("Got: %v Expected: %v", h, live)
+	}
+	for i := 1; i <= 3; i++ {
+		r.add(newTestRedemption(2, msg.Nonce(i)))
+	}
+	if round := r.round([]depositKey{{2, 1}, {2, 3}}); round != 1234 {
+		t.Fatalf("Got: %d Expected: 1234", round)
+	}
+	if _, ok := r.votedIn(depositKey{2, 1}); ok {
+		t.Fatal("Released vote restored")
+	}
+	if h, ok := r.votedIn(depositKey{2, 3}); !ok || h != live {
+		t.Fatalf("Got: %v Expected: %v", h, live)
+	}
+	if voted := r.voted(); len(voted[live]) != 1 {
+		t.Fatalf("Got: %v Expected: the deposit 2:3", voted)
+	}
+}
+
+func TestRegistryFail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "redemptions-1.json")
+
+	r, err := newRegistry(path, AliceTestLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.add(newTestRedemption(2, 1))
+	r.add(newTestRedemption(2, 2))
+	if failed := r.fail([]depositKey{{2, 1}}); len(failed) != 1 {
+		t.Fatalf("Got: %v Expected: the deposit 2:1", failed)
+	}
+
+	// The retry failing as well only reports the deposits which never failed, even after a restart
+	r, err = newRegistry(path, AliceTestLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.add(newTestRedemption(2, 1))
+	r.add(newTestRedemption(2, 2))
+	if failed := r.fail([]depositKey{{2, 1}, {2, 2}}); len(failed) != 1 || failed[0] != (depositKey{2, 2}) {
+		t.Fatalf("Got: %v Expected: the deposit 2:2", failed)
+	}
+}
+
+func TestRegistryExecutedRetention(t *testing.T) {
+	r, _ := newRegistry("", nil)
+	now := time.Unix(1000, 0)
+	r.now = func() time.Time { return now }
+	hash := types.NewHash([]byte{1})
+	r.add(newTestRedemption(2, 1))
+	r.execute([]depositKey{{2, 1}}, hash)
+
+	// Executions are pruned once another batch is executed after the retention
+	now = now.Add(ExecutedRetention)
+	r.add(newTestRedemption(2, 2))
+	r.execute([]depositKey{{2, 2}}, hash)
+	if !r.isExecuted(depositKey{2, 1}) {
+		t.Fatal("Execution pruned within the retention")
+	}
+	now = now.Add(time.Second)
+	r.add(newTestRedemption(2, 3))
+	r.execute([]depositKey{{2, 3}}, hash)
+	if r.isExecuted(depositKey{2, 1}) || !r.isExecuted(depositKey{2, 2}) || len(r.executed) != 2 {
+		t.Fatalf("Unexpected executions: %v", r.executed)
+	}
+}
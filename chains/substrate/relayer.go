@@ -1,12 +1,12 @@
 package substrate
 
 import (
-	"github.com/Platdot-Network/go-substrate-rpc-client/v3/signature"
 	"github.com/Platdot-Network/go-substrate-rpc-client/v3/types"
+	"github.com/Platdot-network/Platdot/connections/signer"
 )
 
 type Relayer struct {
-	kr                signature.KeyringPair
+	signer            signer.Signer
 	otherSignatories  []types.AccountID
 	totalRelayers     uint64
 	multiSigThreshold uint16
@@ -14,10 +14,10 @@ type Relayer struct {
 	maxWeight         uint64
 }
 
-func NewRelayer(kr signature.KeyringPair, otherSignatories []types.AccountID, totalRelayers uint64,
+func NewRelayer(s signer.Signer, otherSignatories []types.AccountID, totalRelayers uint64,
 	multiSigThreshold uint16, relayerId uint64, maxWeight uint64) Relayer {
 	return Relayer{
-		kr:                kr,
+		signer:            s,
 		otherSignatories:  otherSignatories,
 		totalRelayers:     totalRelayers,
 		multiSigThreshold: multiSigThreshold,
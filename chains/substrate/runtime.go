@@ -0,0 +1,168 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package substrate
+
+import (
+	"fmt"
+	"sync"
+
+	gsrpc "github.com/Platdot-Network/go-substrate-rpc-client/v3"
+	"github.com/Platdot-Network/go-substrate-rpc-client/v3/types"
+)
+
+// runtimeInfo is a runtime of the chain with its metadata, identified by its spec version
+type runtimeInfo struct {
+	specVersion        types.U32
+	transactionVersion types.U32
+	meta               *types.Metadata
+}
+
+// runtimeSource fetches runtime versions and metadata, at the latest block if hash is nil
+type runtimeSource interface {
+	runtimeVersion(hash *types.Hash) (*types.RuntimeVersion, error)
+	metadata(hash *types.Hash) (*types.Metadata, error)
+}
+
+// apiSource fetches the runtimes through an api
+type apiSource struct {
+	api *gsrpc.SubstrateAPI
+}
+
+func (s apiSource) runtimeVersion(hash *types.Hash) (*types.RuntimeVersion, error) {
+	if hash == nil {
+		return s.api.RPC.State.GetRuntimeVersionLatest()
+	}
+	return s.api.RPC.State.GetRuntimeVersion(*hash)
+}
+
+func (s apiSource) metadata(hash *types.Hash) (*types.Metadata, error) {
+	if hash == nil {
+		return s.api.RPC.State.GetMetadataLatest()
+	}
+	return s.api.RPC.State.GetMetadata(*hash)
+}
+
+// blockSpan is a range of blocks whose state holds the same runtime
+type blockSpan struct {
+	first uint64
+	last  uint64
+}
+
+// runtimeCache caches the runtimes by spec version, the metadata of a runtime is only fetched once. The current
+// runtime is used to build and sign extrinsics, it is refreshed when a runtime upgrade is detected.
+//
+// The cache also remembers the blocks known to hold each runtime in their state. As the spec version only increases
+// along the chain, every block between two blocks holding a runtime holds it as well, and its runtime is known
+// without asking the node.
+type runtimeCache struct {
+	lock     sync.RWMutex
+	runtimes map[types.U32]*runtimeInfo
+	spans    map[types.U32]*blockSpan // Blocks known to hold each runtime, by spec version
+	current  *runtimeInfo
+}
+
+func newRuntimeCache() *runtimeCache {
+	return &runtimeCache{runtimes: make(map[types.U32]*runtimeInfo), spans: make(map[types.U32]*blockSpan)}
+}
+
+// latest returns the current runtime, nil before the first refresh
+func (r *runtimeCache) latest() *runtimeInfo {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.current
+}
+
+// refresh fetches the version of the latest runtime and makes it current, it returns whether the runtime changed
+func (r *runtimeCache) refresh(src runtimeSource) (*runtimeInfo, bool, error) {
+	rt, err := r.at(src, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	changed := r.current != rt
+	r.current = rt
+	return rt, changed, nil
+}
+
+// at returns the runtime active at the block, fetching its metadata if the runtime isn't cached yet
+func (r *runtimeCache) at(src runtimeSource, hash *types.Hash) (*runtimeInfo, error) {
+	version, err := src.runtimeVersion(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	r.lock.RLock()
+	rt, ok := r.runtimes[version.SpecVersion]
+	r.lock.RUnlock()
+	if ok {
+		return rt, nil
+	}
+
+	meta, err := src.metadata(hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch metadata of spec version %d: %w", version.SpecVersion, err)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	// Another caller may have fetched the runtime meanwhile
+	if rt, ok := r.runtimes[version.SpecVersion]; ok {
+		return rt, nil
+	}
+	rt = &runtimeInfo{
+		specVersion:        version.SpecVersion,
+		transactionVersion: version.TransactionVersion,
+		meta:               meta,
+	}
+	r.runtimes[version.SpecVersion] = rt
+	return rt, nil
+}
+
+// cached returns the runtime held in the state of the block if it is known, nil otherwise
+func (r *runtimeCache) cached(number uint64) *runtimeInfo {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for spec, span := range r.spans {
+		if span.first <= number && number <= span.last {
+			return r.runtimes[spec]
+		}
+	}
+	return nil
+}
+
+// record remembers that the state of the block holds the runtime
+func (r *runtimeCache) record(rt *runtimeInfo, number uint64) {
+	if rt == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	span, ok := r.spans[rt.specVersion]
+	if !ok {
+		r.spans[rt.specVersion] = &blockSpan{first: number, last: number}
+		return
+	}
+	if number < span.first {
+		span.first = number
+	}
+	if number > span.last {
+		span.last = number
+	}
+}
+
+// atBlock returns the runtime held in the state of the block with the given hash and number, the node is only asked
+// for its version if the runtime of the block isn't known yet
+func (r *runtimeCache) atBlock(src runtimeSource, hash types.Hash, number uint64) (*runtimeInfo, error) {
+	if rt := r.cached(number); rt != nil {
+		return rt, nil
+	}
+	rt, err := r.at(src, &hash)
+	if err != nil {
+		return nil, err
+	}
+	r.record(rt, number)
+	return rt, nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package substrate
+
+import (
+	"testing"
+
+	"github.com/Platdot-Network/go-substrate-rpc-client/v3/types"
+)
+
+// testRuntimeSource serves the spec version of each block, the latest block being the last one
+type testRuntimeSource struct {
+	specs         map[types.Hash]types.U32
+	latest        types.Hash
+	metadataCalls map[types.U32]int
+	versionCalls  int
+}
+
+func (s *testRuntimeSource) spec(hash *types.Hash) types.U32 {
+	if hash == nil {
+		return s.specs[s.latest]
+	}
+	return s.specs[*hash]
+}
+
+func (s *testRuntimeSource) runtimeVersion(hash *types.Hash) (*types.RuntimeVersion, error) {
+	s.versionCalls++
+	spec := s.spec(hash)
+	return &types.RuntimeVersion{SpecVersion: spec, TransactionVersion: spec + 100}, nil
+}
+
+func (s *testRuntimeSource) metadata(hash *types.Hash) (*types.Metadata, error) {
+	s.metadataCalls[s.spec(hash)]++
+	return types.NewMetadataV12(), nil
+}
+
+func TestRuntimeCache(t *testing.T) {
+	src := &testRuntimeSource{
+		specs:         map[types.Hash]types.U32{{1}: 1, {2}: 1, {3}: 2},
+		latest:        types.Hash{2},
+		metadataCalls: make(map[types.U32]int),
+	}
+	cache := newRuntimeCache()
+
+	rt, changed, err := cache.refresh(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed || rt.specVersion != 1 || rt.transactionVersion != 101 || cache.latest() != rt {
+		t.Fatalf("Unexpected runtime: %+v, changed: %v", rt, changed)
+	}
+
+	// Historical blocks of a known runtime don't fetch the metadata again
+	old, err := cache.at(src, &types.Hash{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old != rt || src.metadataCalls[1] != 1 {
+		t.Fatalf("Runtime 1 should be cached, metadata fetched %d times", src.metadataCalls[1])
+	}
+	if _, changed, _ := cache.refresh(src); changed {
+		t.Fatal("The runtime didn't change")
+	}
+
+	// A runtime upgrade
+	src.latest = types.Hash{3}
+	upgraded, changed, err := cache.refresh(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed || upgraded.specVersion != 2 || cache.latest() != upgraded {
+		t.Fatalf("Unexpected runtime after upgrade: %+v, changed: %v", upgraded, changed)
+	}
+
+	// Blocks before the upgrade are still decoded with the former metadata
+	old, err = cache.at(src, &types.Hash{2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old != rt || src.metadataCalls[1] != 1 || src.metadataCalls[2] != 1 {
+		t.Fatalf("Got: spec %d, metadata fetched %v", old.specVersion, src.metadataCalls)
+	}
+}
+
+func TestRuntimeCacheBlocks(t *testing.T) {
+	// Blocks 0 to 9 hold the runtime 1, blocks 10 to 19 the runtime 2
+	specs := make(map[types.Hash]types.U32)
+	for i := 0; i < 20; i++ {
+		specs[types.Hash{byte(i)}] = types.U32(1 + i/10)
+	}
+	src := &testRuntimeSource{specs: specs, metadataCalls: make(map[types.U32]int)}
+	cache := newRuntimeCache()
+
+	for _, number := range []uint64{2, 8, 12, 19} {
+		if _, err := cache.atBlock(src, types.Hash{byte(number)}, number); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if src.versionCalls != 4 {
+		t.Fatalf("Got: %d version calls Expected: 4", src.versionCalls)
+	}
+
+	// Blocks between two blocks holding a runtime hold it as well
+	for number := uint64(2); number <= 19; number++ {
+		rt, err := cache.atBlock(src, types.Hash{byte(number)}, number)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rt.specVersion != specs[types.Hash{byte(number)}] {
+			t.Fatalf("Block %d: Got: spec %d Expected: %d", number, rt.specVersion, specs[types.Hash{byte(number)}])
+		}
+	}
+	// Only the blocks 9 and 10, between the known blocks of each runtime, are looked up
+	if src.versionCalls != 6 {
+		t.Fatalf("Got: %d version calls Expected: 6", src.versionCalls)
+	}
+	if cache.cached(0) != nil || cache.cached(20) != nil {
+		t.Fatal("Blocks outside the known ones should be looked up")
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package substrate
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Platdot-Network/go-substrate-rpc-client/v3/signature"
+	"github.com/Platdot-Network/go-substrate-rpc-client/v3/types"
+	"github.com/Platdot-network/Platdot/connections/signer"
+)
+
+func TestSignExtrinsic(t *testing.T) {
+	call := types.Call{CallIndex: types.CallIndex{SectionIndex: 4, MethodIndex: 1}, Args: []byte{1, 2, 3}}
+	o := types.SignatureOptions{
+		BlockHash:          types.Hash{1},
+		Era:                types.ExtrinsicEra{IsMortalEra: false},
+		GenesisHash:        types.Hash{1},
+		Nonce:              types.NewUCompactFromUInt(7),
+		SpecVersion:        12,
+		Tip:                types.NewUCompactFromUInt(0),
+		TransactionVersion: 2,
+	}
+
+	expected := types.NewExtrinsic(call)
+	if err := expected.Sign(signature.TestKeyringPairAlice, o); err != nil {
+		t.Fatal(err)
+	}
+	ext := types.NewExtrinsic(call)
+	s := signer.NewSr25519(signature.TestKeyringPairAlice)
+	if err := signExtrinsic(&ext, s, o); err != nil {
+		t.Fatal(err)
+	}
+
+	// The sr25519 signatures are randomized, the rest of the extrinsic must match the one of gsrpc
+	got := ext.Signature
+	got.Signature = expected.Signature.Signature
+	if ext.Version != expected.Version || !reflect.DeepEqual(got, expected.Signature) {
+		t.Fatalf("Got %+v, expected %+v", ext, expected)
+	}
+	mb, err := types.EncodeToBytes(call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := types.EncodeToBytes(types.ExtrinsicPayloadV4{
+		ExtrinsicPayloadV3: types.ExtrinsicPayloadV3{
+			Method:      mb,
+			Era:         types.ExtrinsicEra{IsImmortalEra: true},
+			Nonce:       o.Nonce,
+			Tip:         o.Tip,
+			SpecVersion: o.SpecVersion,
+			GenesisHash: o.GenesisHash,
+			BlockHash:   o.BlockHash,
+		},
+		TransactionVersion: o.TransactionVersion,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !signer.Verify(signer.Sr25519, s.PublicKey(), payload, ext.Signature.Signature.AsSr25519[:]) {
+		t.Fatal("Expected a valid signature of the payload")
+	}
+}
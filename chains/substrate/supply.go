@@ -0,0 +1,132 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package substrate
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/Platdot-Network/go-substrate-rpc-client/v3/types"
+	"github.com/Platdot-Network/substrate-go/expand/chainx/xevents"
+	"github.com/Platdot-network/Platdot/chains"
+	"github.com/Platdot-network/Platdot/chains/chainset"
+	"github.com/Platdot-network/Platdot/chains/supply"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+// Time between two checks of the supply, if not configured
+const DefaultSupplyInterval = time.Minute
+
+// xAssetBalance is the balance of an account on ChainX, by type of balance (usable, reserved...)
+type xAssetBalance []struct {
+	Type    types.U8
+	Balance types.U128
+}
+
+// assetAccount is the balance of an account in the Assets pallet
+type assetAccount struct {
+	Balance    types.U128
+	IsFrozen   bool
+	Sufficient bool
+}
+
+// custodyAssets returns the resources of the assets locked in the multiSig of the chain
+func custodyAssets(bc *chainset.ChainCore) []msg.ResourceId {
+	assetIds := []xevents.AssetId{chainset.OriginAsset}
+	switch bc.ChainInfo.Type {
+	case chainset.ChainXAssetLike, chainset.ChainXAssetV1Like:
+		assetIds = append(assetIds, discoveredXAssets...)
+	case chainset.AssetsLike:
+		assetIds = append(assetIds, discoveredAssets...)
+	}
+
+	var res []msg.ResourceId
+	for _, assetId := range assetIds {
+		currency, err := bc.GetCurrencyByAssetId(assetId)
+		if err != nil {
+			continue
+		}
+		res = append(res, bc.ConvertStringToResourceId(currency.ResourceId))
+	}
+	return res
+}
+
+// lockedBalance returns the balance of an asset held by the account, in the smallest unit of the asset
+func (c *Connection) lockedBalance(bc *chainset.ChainCore, account types.AccountID, assetId xevents.AssetId) (*big.Int, error) {
+	if assetId == chainset.OriginAsset {
+		var acct types.AccountInfo
+		_, err := c.queryStorage("System", "Account", account[:], nil, &acct)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).Add(u128Int(acct.Data.Free), u128Int(acct.Data.Reserved)), nil
+	}
+
+	id, err := types.EncodeToBytes(types.U32(assetId))
+	if err != nil {
+		return nil, err
+	}
+	res := big.NewInt(0)
+	switch bc.ChainInfo.Type {
+	case chainset.ChainXAssetLike, chainset.ChainXAssetV1Like:
+		var balances xAssetBalance
+		_, err = c.queryStorage("XAssets", "AssetBalance", account[:], id, &balances)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range balances {
+			res.Add(res, u128Int(b.Balance))
+		}
+	case chainset.AssetsLike:
+		var balance assetAccount
+		_, err = c.queryStorage("Assets", "Account", id, account[:], &balance)
+		if err != nil {
+			return nil, err
+		}
+		res.Add(res, u128Int(balance.Balance))
+	default:
+		return nil, fmt.Errorf("asset %d is not held on chain type %d", assetId, bc.ChainInfo.Type)
+	}
+	return res, nil
+}
+
+// u128Int returns the value of a balance, zero for the balance of a missing storage entry
+func u128Int(u types.U128) *big.Int {
+	if u.Int == nil {
+		return big.NewInt(0)
+	}
+	return u.Int
+}
+
+// lockedFunc returns the balances locked in the multiSig, scaled to the wrapped tokens of the issuer
+func (c *Connection) lockedFunc(bc *chainset.ChainCore, multiSig types.AccountID) supply.LockedFunc {
+	return func(rId msg.ResourceId) (*big.Int, error) {
+		currency, err := bc.GetCurrencyByResourceId(rId)
+		if err != nil {
+			return nil, err
+		}
+		locked, err := c.lockedBalance(bc, multiSig, currency.AssetId)
+		if err != nil {
+			return nil, err
+		}
+		difference, err := currency.Difference()
+		if err != nil {
+			return nil, err
+		}
+		return locked.Mul(locked, difference), nil
+	}
+}
+
+// IssuerId returns the chain issuing the wrapped tokens of the multiSig, if their supply is checked
+func (c *Chain) IssuerId() (msg.ChainId, bool) {
+	if c.supply == nil {
+		return 0, false
+	}
+	return c.supply.IssuerId(), true
+}
+
+func (c *Chain) SetIssuer(i chains.Issuer) {
+	c.supply.SetIssuer(i)
+}
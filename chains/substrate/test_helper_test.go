@@ -2,8 +2,10 @@ package substrate
 
 import (
 	"github.com/ChainSafe/log15"
-	"github.com/Platdot-network/Platdot/config"
+	"github.com/Platdot-network/Platdot/connections/pool"
+	"github.com/Platdot-network/Platdot/connections/signer"
 	utils "github.com/Platdot-network/Platdot/shared/substrate"
+	"github.com/Platdot-Network/go-substrate-rpc-client/v3/signature"
 	"github.com/Platdot-Network/go-substrate-rpc-client/v3/types"
 	"github.com/rjman-ljm/platdot-utils/keystore"
 	"github.com/rjman-ljm/platdot-utils/msg"
@@ -14,8 +16,8 @@ var TestEndpoint = []string{"ws://127.0.0.1:9944"}
 const TestRelayerThreshold = 2
 const TestChainId = 1
 
-var AliceKey = keystore.TestKeyRing.SubstrateKeys[keystore.AliceKey].AsKeyringPair()
-var BobKey = keystore.TestKeyRing.SubstrateKeys[keystore.BobKey].AsKeyringPair()
+var AliceKey = (*signature.KeyringPair)(keystore.TestKeyRing.SubstrateKeys[keystore.AliceKey].AsKeyringPair())
+var BobKey = (*signature.KeyringPair)(keystore.TestKeyRing.SubstrateKeys[keystore.BobKey].AsKeyringPair())
 
 var TestLogLevel = log15.LvlTrace
 var AliceTestLogger = newTestLogger("Alice")
@@ -48,7 +50,7 @@ func newTestLogger(name string) log15.Logger {
 // createAliceConnection creates and starts a connection with the Alice keypair
 func createAliceConnection() (*Connection, chan error, error) {
 	sysErr := make(chan error)
-	alice := NewConnection(TestEndpoint[config.InitialEndPointId], TestEndpoint, "Alice", AliceKey, AliceTestLogger, make(chan int), sysErr)
+	alice := NewConnection(TestEndpoint, "Alice", signer.NewSr25519(*AliceKey), AliceTestLogger, make(chan int), sysErr, pool.DefaultMaxLag, nil)
 	err := alice.Connect()
 	if err != nil {
 		return nil, nil, err
@@ -63,7 +65,7 @@ func createAliceAndBobConnections() (*Connection, *Connection, chan error, error
 		return nil, nil, nil, err
 	}
 
-	bob := NewConnection(TestEndpoint[config.InitialEndPointId], TestEndpoint, "Bob", BobKey, AliceTestLogger, make(chan int), sysErr)
+	bob := NewConnection(TestEndpoint, "Bob", signer.NewSr25519(*BobKey), AliceTestLogger, make(chan int), sysErr, pool.DefaultMaxLag, nil)
 	err = bob.Connect()
 	if err != nil {
 		return nil, nil, nil, err
@@ -76,7 +78,7 @@ func createAliceAndBobConnections() (*Connection, *Connection, chan error, error
 func getFreeBalance(c *Connection, res *types.U128) {
 	var acct types.AccountInfo
 
-	ok, err := c.queryStorage("System", "Account", c.key.PublicKey, nil, &acct)
+	ok, err := c.queryStorage("System", "Account", c.signer.PublicKey(), nil, &acct)
 	if err != nil {
 		panic(err)
 	} else if !ok {
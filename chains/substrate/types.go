@@ -6,13 +6,13 @@ package substrate
 import (
 	"bytes"
 	"fmt"
+	"github.com/Platdot-network/Platdot/chains/lifecycle"
+	"github.com/Platdot-network/Platdot/chains/webhooks"
 	utils "github.com/Platdot-network/Platdot/shared/substrate"
 	"github.com/Platdot-Network/go-substrate-rpc-client/v3/scale"
 	"github.com/Platdot-Network/go-substrate-rpc-client/v3/types"
 	"github.com/rjman-ljm/platdot-utils/msg"
 	"math/big"
-	"sync"
-	"time"
 )
 
 type RedeemStatusCode int
@@ -24,16 +24,22 @@ const (
 	UnKnownError
 )
 
-func (code RedeemStatusCode)finished() bool {
-	return code == YesVoted || code == IsExecuted || code == UnKnownError
-}
-
 const (
-	FindNewMultiSigTx 						string = "Find a multiSig New extrinsic"
-	FindApproveMultiSigTx 					string = "Find a multiSig Approve extrinsic"
-	FindExecutedMultiSigTx 					string = "Find a multiSig Executed extrinsic"
+	FindNewMultiSigTx 						string = "Find a multiSig New event"
+	FindApproveMultiSigTx 					string = "Find a multiSig Approval event"
+	FindExecutedMultiSigTx 					string = "Find a multiSig Executed event"
+	FindFailedMultiSigTx 					string = "Find a multiSig Executed event, but the call failed"
+	FindCancelledMultiSigTx 				string = "Find a multiSig Cancelled event"
 	FindBatchMultiSigTx 					string = "Find a multiSig Batch Extrinsic"
 	FindFailedBatchMultiSigTx 				string = "But Batch Extrinsic Failed"
+	RejectAmbiguousBatch 					string = "Reject a batch whose transfers and remarks can't be paired"
+	UndecodedDeposit 						string = "Find a transfer to the multiSig in an undecodable extrinsic, check it"
+	DepositWithoutNonce 					string = "Find a deposit which doesn't fit a nonce, check it"
+	EndpointsDisagreeOnDeposits 			string = "Endpoints disagree on a block with deposits, refuse it"
+	DepositsNotConfirmed 					string = "Block with deposits not confirmed by enough endpoints, refuse it"
+	DepositsHeldBack 						string = "Deposits not confirmed, holding back the block until enough endpoints agree"
+	RejectDeposit 							string = "Reject a deposit, record it for a refund"
+	ReleaseRefund 							string = "Grace period of a rejected deposit over, refund it"
 
 	StartATx 								string = "Start a redeemTx..."
 	MeetARepeatTx 							string = "Meet a Repeat Transaction"
@@ -41,6 +47,22 @@ const (
 	TryToMakeNewMultiSigTx 					string = "Try to make a New multiSig Tx!"
 	TryToApproveMultiSigTx 					string = "Try to Approve a multiSigTx!"
 	FinishARedeemTx 						string = "Finish a redeemTx"
+	QueueRedemption 						string = "Queue a redemption for the next batch"
+	RedemptionOverLimit 					string = "Redemption over a limit or paused, park it until it is allowed"
+	RedemptionScreened 						string = "Redemption flagged by the screening, park it for manual review"
+	RedemptionHeld 							string = "Redemption held for a manual approval"
+	QueueRefund 							string = "Queue a refund of a rejected deposit for the next batch"
+	RefundExecuted 							string = "Refund of a rejected deposit executed!"
+	NewRedeemBatch 							string = "Build a new redeem batch"
+	FinishARedeemBatch 						string = "Finish a redeem batch"
+	CancelRedeemBatch 						string = "Redeem batch not approved in time, cancel it"
+	RedeemBatchCancelled 					string = "Redeem batch cancelled"
+	RedeemBatchFailed 						string = "Redeem batch executed with an error, redeem the deposits again"
+	DepositVotedInOtherBatch 				string = "Deposit voted in another live batch, wait for it"
+	DepositProposedInOtherBatch 			string = "Deposit proposed in another live batch, wait for it"
+	AdoptRedeemBatch 						string = "Approve the redeem batch proposed by another relayer"
+	BatchNotAdopted 						string = "Redeem batch proposed by another relayer differs from ours"
+	DepositAlreadyRedeemed 					string = "Deposit already redeemed, ignore it"
 	MultiSigExtrinsicExecuted 				string = "MultiSig extrinsic executed!"
 	BlockNotYetFinalized 					string = "Block not yet finalized"
 	SubListenerWorkFinished 				string = "Sub listener work is Finished"
@@ -53,11 +75,16 @@ const (
 	RedeemTxTryTooManyTimes               	string = "Redeem Tx failed, try too many times"
 	MultiSigExtrinsicError                	string = "MultiSig extrinsic err! UnknownError(amount、chainId...)"
 	RedeemNegAmountError                  	string = "Redeem a neg amount"
+	UnknownRedeemAsset                    	string = "Redeem an asset without currency"
+	RecordRejectedDepositError            	string = "Record a rejected deposit err"
+	NewRefundCallError                    	string = "New refund transfer err"
+	RecordTransferStateError              	string = "Record the state of a transfer err"
 	NewBalancesTransferCallError          	string = "New Balances.transfer err"
 	NewBalancesTransferKeepAliveCallError 	string = "New Balances.transferKeepAlive err"
 	NewXAssetsTransferCallError           	string = "New XAssets.Transfer err"
 	NewCrossChainTransferCallError          string = "New Cross-Chain Transfer err"
 	NewMultiCallError                     	string = "New MultiCall err"
+	NewBatchCallError                     	string = "New Utility.batch_all err"
 	NewApiError                           	string = "New api error"
 	SignmultiSigTxFailed                 	string = "Sign multiSigTx failed"
 	SubmitExtrinsicFailed                 	string = "Submit Extrinsic Failed"
@@ -80,24 +107,6 @@ type Round struct {
 	blockRound  *big.Int
 }
 
-type MsgStatus struct {
-	m 	msg.Message
-	ok 	bool
-}
-
-func NewMsgStatus(msg msg.Message) *MsgStatus {
-	return &MsgStatus{
-		m: msg,
-		ok: false,
-	}
-}
-
-
-type Dest struct {
-	DepositNonce msg.Nonce
-	DestAddress  string
-	DestAmount   string
-}
 
 func EncodeCall(call types.Call) []byte {
 	var buffer = bytes.Buffer{}
@@ -155,63 +164,129 @@ func (p *proposal) encode() ([]byte, error) {
 }
 
 func (w *writer) createMultiSigTx(m msg.Message) {
-	/// If there is a duplicate transaction, wait for it to complete
-	w.checkRepeat(m)
-
 	if m.Destination != w.listener.chainId {
 		return
 	}
-	w.processMessage(m)
-	go func()  {
-		// calculate spend time
-		start := time.Now()
-		defer func() {
-			cost := time.Since(start)
-			w.log.Info(LineLog, "DepositNonce", m.DepositNonce)
-			w.log.Info(RelayerFinishTheTx,"Relayer", w.relayer.relayerId, "DepositNonce", m.DepositNonce, "CostTime", cost)
-			w.log.Info(LineLog, "DepositNonce", m.DepositNonce)
-		}()
-		retryTimes := RedeemRetryLimit
-		message := NewMsgStatus(m)
-
-		for {
-			retryTimes--
-			// No more retries, stop RedeemTx
-			if retryTimes < RedeemRetryLimit / 2 {
-				w.log.Warn(MaybeAProblem, "RetryTimes", retryTimes)
-			}
-			if retryTimes == 0 {
-				w.logErr(RedeemTxTryTooManyTimes, nil)
-				break
-			}
-
-			redeemStatus, currentTx := w.redeemTx(message)
-
-			/// If curTx is UnKnownError
-			if redeemStatus == UnKnownError {
-				w.log.Error(MultiSigExtrinsicError, "DepositNonce", m.DepositNonce)
-				w.deleteMessage(m, currentTx)
-				break
-			}
-
-			/// If curTx is voted
-			if redeemStatus == YesVoted {
-				message.ok = true
-				time.Sleep(RoundInterval * time.Duration(w.relayer.totalRelayers) / 2)
-				continue
-			}
-			/// Executed or UnKnownError
-			if redeemStatus == IsExecuted {
-				w.log.Info(MultiSigExtrinsicExecuted, "DepositNonce", m.DepositNonce, "OriginBlock", currentTx.Block)
-				w.deleteMessage(m, currentTx)
-				break
-			}
-		}
-		w.log.Info(FinishARedeemTx, "DepositNonce", m.DepositNonce)
-	}()
+	if !w.screenRedemption(m) {
+		return
+	}
+	amount, ok := w.redemptionAmount(m)
+	if !ok || !w.approveRedemption(m, amount) || !w.allowRedemption(m, amount) {
+		return
+	}
+	w.recordValidated(m)
+	w.logStartTx(m)
+
+	/// Redeemed by the next batch, see batchLoop
+	w.queueRedemption(m)
 }
 
-func (w *writer) createFungibleProposal(m msg.Message) (*proposal, error) {
+// createRefundTx queues the refund of a deposit rejected by the listener. Refunds carry the amount to send back, so
+// they are not charged the fee nor held by the limits, the screening or the approvals.
+func (w *writer) createRefundTx(m msg.Message) {
+	if m.Destination != w.listener.chainId || m.Source != w.listener.chainId {
+		return
+	}
+	if !w.redemptions.add(m) {
+		w.log.Info(MeetARepeatTx, "DepositNonce", m.DepositNonce, "Source", m.Source)
+		return
+	}
+	w.log.Info(QueueRefund, "DepositNonce", m.DepositNonce, "Recipient", string(m.Payload[1].([]byte)))
+}
+
+// screenRedemption returns whether the parties of the redemption passed the screening. Otherwise the redemption is
+// parked until the screening lists clear it.
+func (w *writer) screenRedemption(m msg.Message) bool {
+	if w.screener == nil {
+		return true
+	}
+	err := w.screener.Screen(m)
+	if err != nil {
+		w.log.Warn(RedemptionScreened, "DepositNonce", m.DepositNonce, "Source", m.Source, "Error", err)
+		w.park(m, err)
+		return false
+	}
+	return true
+}
+
+// park records the redemption of a message as parked, and notifies it the first time it is parked
+func (w *writer) park(m msg.Message, reason error) {
+	if w.record(m, lifecycle.Parked, "", reason.Error()) {
+		w.notifier.Notify(w.redemptionEvent(webhooks.TransferParked, m).SetReason(reason.Error()))
+	}
+}
+
+// redemptionAmount returns the amount the redemption sends from the multiSig, fee deducted
+func (w *writer) redemptionAmount(m msg.Message) (*big.Int, bool) {
+	assetId, err := w.getRedeemAssetId(m)
+	if err != nil {
+		w.log.Error(UnknownRedeemAsset, "DepositNonce", m.DepositNonce, "Error", err)
+		w.notifier.Notify(webhooks.NewEvent(webhooks.TransferFailed, m).SetReason(err.Error()))
+		w.record(m, lifecycle.Failed, "", err.Error())
+		return nil, false
+	}
+	amount, err := w.chainCore.GetAmountToSub(m.Payload[0].([]byte), assetId)
+	if err != nil {
+		w.log.Error(RedeemNegAmountError, "DepositNonce", m.DepositNonce, "Error", err)
+		w.notifier.Notify(w.redemptionEvent(webhooks.TransferFailed, m).SetReason(err.Error()))
+		w.record(m, lifecycle.Failed, "", err.Error())
+		return nil, false
+	}
+	return amount, true
+}
+
+// approveRedemption returns whether the redemption doesn't need a manual approval or was approved. Otherwise the
+// redemption waits in the approval queue until an operator decides on it.
+func (w *writer) approveRedemption(m msg.Message, amount *big.Int) bool {
+	if w.approvals == nil {
+		return true
+	}
+	err := w.approvals.Hold(m, amount)
+	if err != nil {
+		w.log.Warn(RedemptionHeld, "DepositNonce", m.DepositNonce, "Source", m.Source,
+			"ResourceId", m.ResourceId.Hex(), "Amount", amount, "Error", err)
+		w.park(m, err)
+		return false
+	}
+	return true
+}
+
+// allowRedemption returns whether the redemption fits within the limits and its asset is not paused. Otherwise the
+// redemption is parked until the limiter resolves it again.
+func (w *writer) allowRedemption(m msg.Message, amount *big.Int) bool {
+	err := w.limiter.Allow(m, amount)
+	if err != nil {
+		w.log.Warn(RedemptionOverLimit, "DepositNonce", m.DepositNonce, "Source", m.Source,
+			"ResourceId", m.ResourceId.Hex(), "Amount", amount, "Error", err)
+		w.park(m, err)
+		return false
+	}
+	return true
+}
+
+// validateFungibleTransfer returns whether the transfer of a fungible proposal passed the screening, the approvals
+// and the limits, as the redemptions through the multiSig do. Otherwise the transfer is parked, or failed if its
+// amount can't be computed, and no vote is cast.
+func (w *writer) validateFungibleTransfer(m msg.Message) bool {
+	if !w.screenRedemption(m) {
+		return false
+	}
+	amount, err := w.fungibleAmount(m)
+	if err != nil {
+		w.log.Error(UnknownRedeemAsset, "DepositNonce", m.DepositNonce, "Error", err)
+		w.notifier.Notify(webhooks.NewEvent(webhooks.TransferFailed, m).SetReason(err.Error()))
+		w.record(m, lifecycle.Failed, "", err.Error())
+		return false
+	}
+	if !w.approveRedemption(m, amount) || !w.allowRedemption(m, amount) {
+		return false
+	}
+	w.recordValidated(m)
+	return true
+}
+
+// fungibleAmount returns the amount a fungible proposal mints, fee deducted
+func (w *writer) fungibleAmount(m msg.Message) (*big.Int, error) {
 	assetId, err := w.chainCore.ConvertResourceIdToAssetId(m.ResourceId)
 	if err != nil {
 		return nil, err
@@ -221,22 +296,25 @@ func (w *writer) createFungibleProposal(m msg.Message) (*proposal, error) {
 	if err != nil {
 		return nil, fmt.Errorf("create fungible proposal error, neg amount")
 	}
+	return sendAmount, nil
+}
 
-	recipient := w.chainCore.GetSubChainRecipient(m)
-	depositNonce := types.U64(m.DepositNonce)
-
-	err = w.conn.updateMetatdata()
+func (w *writer) createFungibleProposal(m msg.Message) (*proposal, error) {
+	sendAmount, err := w.fungibleAmount(m)
 	if err != nil {
 		return nil, err
 	}
 
+	recipient := w.chainCore.GetSubChainRecipient(m)
+	depositNonce := types.U64(m.DepositNonce)
+
 	method, err := w.resolveResourceId(m.ResourceId)
 	if err != nil {
 		return nil, err
 	}
 
 	call, err := types.NewCall(
-		&w.conn.meta,
+		w.conn.getMetadata(),
 		method,
 		recipient,
 		types.NewUCompact(sendAmount),
@@ -269,18 +347,13 @@ func (w *writer) createNonFungibleProposal(m msg.Message) (*proposal, error) {
 	metadata := types.Bytes(m.Payload[2].([]byte))
 	depositNonce := types.U64(m.DepositNonce)
 
-	err := w.conn.updateMetatdata()
-	if err != nil {
-		return nil, err
-	}
-
 	method, err := w.resolveResourceId(m.ResourceId)
 	if err != nil {
 		return nil, err
 	}
 
 	call, err := types.NewCall(
-		&w.conn.meta,
+		w.conn.getMetadata(),
 		method,
 		recipient,
 		tokenId,
@@ -307,18 +380,13 @@ func (w *writer) createNonFungibleProposal(m msg.Message) (*proposal, error) {
 }
 
 func (w *writer) createGenericProposal(m msg.Message) (*proposal, error) {
-	err := w.conn.updateMetatdata()
-	if err != nil {
-		return nil, err
-	}
-
 	method, err := w.resolveResourceId(m.ResourceId)
 	if err != nil {
 		return nil, err
 	}
 
 	call, err := types.NewCall(
-		&w.conn.meta,
+		w.conn.getMetadata(),
 		method,
 		types.NewHash(m.Payload[0].([]byte)),
 	)
@@ -358,16 +426,7 @@ func (w *writer) resolveResourceId(id [32]byte) (string, error) {
 // proposalValid asserts the state of a proposal. If the proposal is active and this relayer
 // has not voted, it will return true. Otherwise, it will return false with a reason string.
 func (w *writer) proposalValid(prop *proposal) (bool, string, error) {
-	var voteRes voteState
-	srcId, err := types.EncodeToBytes(prop.sourceId)
-	if err != nil {
-		return false, "", err
-	}
-	propBz, err := prop.encode()
-	if err != nil {
-		return false, "", err
-	}
-	exists, err := w.conn.queryStorage(utils.BridgeStoragePrefix, "Votes", srcId, propBz, &voteRes)
+	voteRes, exists, err := w.proposalState(prop)
 	if err != nil {
 		return false, "", err
 	}
@@ -375,8 +434,8 @@ func (w *writer) proposalValid(prop *proposal) (bool, string, error) {
 	if !exists {
 		return true, "", nil
 	} else if voteRes.Status.IsActive {
-		if containsVote(voteRes.VotesFor, types.NewAccountID(w.conn.key.PublicKey)) ||
-			containsVote(voteRes.VotesAgainst, types.NewAccountID(w.conn.key.PublicKey)) {
+		if containsVote(voteRes.VotesFor, types.NewAccountID(w.conn.signer.PublicKey())) ||
+			containsVote(voteRes.VotesAgainst, types.NewAccountID(w.conn.signer.PublicKey())) {
 			return false, "already voted", nil
 		} else {
 			return true, "", nil
@@ -386,6 +445,21 @@ func (w *writer) proposalValid(prop *proposal) (bool, string, error) {
 	}
 }
 
+// proposalState queries the votes of a proposal, it returns false if the proposal doesn't exist
+func (w *writer) proposalState(prop *proposal) (voteState, bool, error) {
+	var voteRes voteState
+	srcId, err := types.EncodeToBytes(prop.sourceId)
+	if err != nil {
+		return voteRes, false, err
+	}
+	propBz, err := prop.encode()
+	if err != nil {
+		return voteRes, false, err
+	}
+	exists, err := w.conn.queryStorage(utils.BridgeStoragePrefix, "Votes", srcId, propBz, &voteRes)
+	return voteRes, exists, err
+}
+
 func containsVote(votes []types.AccountID, voter types.AccountID) bool {
 	for _, v := range votes {
 		if bytes.Equal(v[:], voter[:]) {
@@ -395,34 +469,8 @@ func containsVote(votes []types.AccountID, voter types.AccountID) bool {
 	return false
 }
 
-func (w *writer) processMessage(m msg.Message) {
+func (w *writer) logStartTx(m msg.Message) {
 	w.log.Info(LineLog,"DepositNonce", m.DepositNonce, "From", m.Source, "To", m.Destination)
 	w.log.Info(StartATx, "DepositNonce", m.DepositNonce, "From", m.Source, "To", m.Destination)
 	w.log.Info(LineLog,"DepositNonce", m.DepositNonce, "From", m.Source, "To", m.Destination)
-
-	/// Mark isProcessing
-	destMessage := Dest{
-		DepositNonce: m.DepositNonce,
-		DestAddress:  string(m.Payload[1].([]byte)),
-		DestAmount:   string(m.Payload[0].([]byte)),
-	}
-	w.messages[destMessage] = true
-}
-
-func (w *writer) deleteMessage(m msg.Message, currentTx multiSigTx) {
-	var mutex sync.Mutex
-	mutex.Lock()
-
-	/// Delete Listener msTx
-	delete(w.listener.asMulti, currentTx)
-
-	/// Delete Message
-	dm := Dest{
-		DepositNonce: m.DepositNonce,
-		DestAddress:  string(m.Payload[1].([]byte)),
-		DestAmount:   string(m.Payload[0].([]byte)),
-	}
-	delete(w.messages, dm)
-
-	mutex.Unlock()
 }
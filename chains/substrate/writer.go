@@ -7,12 +7,18 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
-	"sync"
 	"time"
 
 	"github.com/ChainSafe/log15"
 	"github.com/Platdot-Network/substrate-go/expand/chainx/xevents"
+	"github.com/Platdot-network/Platdot/chains"
+	"github.com/Platdot-network/Platdot/chains/approvals"
 	"github.com/Platdot-network/Platdot/chains/chainset"
+	"github.com/Platdot-network/Platdot/chains/lifecycle"
+	"github.com/Platdot-network/Platdot/chains/limits"
+	"github.com/Platdot-network/Platdot/chains/refunds"
+	"github.com/Platdot-network/Platdot/chains/screening"
+	"github.com/Platdot-network/Platdot/chains/webhooks"
 	utils "github.com/Platdot-network/Platdot/shared/substrate"
 	gsrpc "github.com/Platdot-Network/go-substrate-rpc-client/v3"
 	"github.com/Platdot-Network/go-substrate-rpc-client/v3/types"
@@ -22,8 +28,10 @@ import (
 )
 
 var _ core.Writer = &writer{}
+var _ chains.Resolver = &writer{}
 var AcknowledgeProposal utils.Method = utils.BridgePalletName + ".acknowledge_proposal"
 var TerminatedError = errors.New("terminated")
+var ErrRedemptionLookup = errors.New("redemptions can't be looked up by deposit nonce on-chain")
 
 const genesisBlock = 0
 const RoundInterval = time.Second * 6
@@ -36,23 +44,64 @@ type writer struct {
 	metrics    *metrics.ChainMetrics
 	extendCall bool // Extend extrinsic calls to substrate with ResourceID.Used for backward compatibility with example pallet.
 	relayer    Relayer
-	messages   map[Dest]bool
 	chainCore  *chainset.ChainCore
+
+	redemptions  *registry           // Multisig transfers to redeem
+	batchWindow  time.Duration       // Period over which redemptions are collected into one batch
+	maxBatchSize int                 // Maximum number of redemptions in a batch
+	limiter      *limits.Limiter     // Caps the redemptions over a rolling window
+	screener     *screening.Screener // Holds back the redemptions of blocked parties, if configured
+	approvals    *approvals.Queue    // Holds the large redemptions until they are approved, if configured
+	refunds      *refunds.Store      // Rejected deposits, refunded along with the redemptions
+	notifier     *webhooks.Notifier  // Notifies the webhooks of the redemptions, if configured
+	lifecycle    *lifecycle.Store    // Records the state of the redemptions
 }
 
 func NewWriter(conn *Connection, listener *listener, log log15.Logger, sysErr chan<- error,
-	m *metrics.ChainMetrics, extendCall bool, relayer Relayer, bc *chainset.ChainCore) *writer {
+	m *metrics.ChainMetrics, extendCall bool, relayer Relayer, bc *chainset.ChainCore,
+	redemptions *registry, batchWindow time.Duration, maxBatchSize int, limiter *limits.Limiter, screener *screening.Screener,
+	approvals *approvals.Queue, refunds *refunds.Store, notifier *webhooks.Notifier, lifecycle *lifecycle.Store) *writer {
 
 	return &writer{
-		conn:       conn,
-		listener:   listener,
-		log:        log,
-		sysErr:     sysErr,
-		metrics:    m,
-		extendCall: extendCall,
-		relayer:    relayer,
-		messages:   make(map[Dest]bool, InitCapacity),
-		chainCore:  bc,
+		conn:         conn,
+		listener:     listener,
+		log:          log,
+		sysErr:       sysErr,
+		metrics:      m,
+		extendCall:   extendCall,
+		relayer:      relayer,
+		chainCore:    bc,
+		redemptions:  redemptions,
+		batchWindow:  batchWindow,
+		maxBatchSize: maxBatchSize,
+		limiter:      limiter,
+		screener:     screener,
+		approvals:    approvals,
+		refunds:      refunds,
+		notifier:     notifier,
+		lifecycle:    lifecycle,
+	}
+}
+
+// start launches the batch loop which redeems the queued multisig transfers and refunds, and the retries of the
+// transfers parked over a limit, by the screening or for an approval
+func (w *writer) start() {
+	/// Refunds submitted before a restart are not seen again by the listener
+	if w.refunds != nil {
+		for _, r := range w.refunds.Refunding() {
+			w.createRefundTx(r.Message(w.listener.chainId))
+		}
+	}
+	go w.batchLoop()
+	go w.limiter.Run(func(m msg.Message) { w.ResolveMessage(m) }, w.listener.stop)
+	if w.screener != nil {
+		go w.screener.Run(func(m msg.Message) { w.ResolveMessage(m) }, w.listener.stop)
+	}
+	if w.approvals != nil {
+		go w.approvals.Run(func(m msg.Message) { w.ResolveMessage(m) }, w.listener.stop)
+	}
+	if w.notifier != nil {
+		go w.notifier.Run(w.listener.stop)
 	}
 }
 
@@ -65,7 +114,13 @@ func (w *writer) ResolveMessage(m msg.Message) bool {
 	case msg.MultiSigTransfer:
 		w.createMultiSigTx(m)
 		return true
+	case refunds.RefundTransfer:
+		w.createRefundTx(m)
+		return true
 	case msg.FungibleTransfer:
+		if !w.validateFungibleTransfer(m) {
+			return true
+		}
 		w.log.Info(LineLog, "DepositNonce", m.DepositNonce)
 		w.log.Info("Start Deposit...", "DepositNonce", m.DepositNonce)
 		w.log.Info(LineLog, "DepositNonce", m.DepositNonce)
@@ -123,133 +178,45 @@ func (w *writer) ResolveMessage(m msg.Message) bool {
 	return true
 }
 
-func (w *writer) checkRepeat(m msg.Message) bool {
-	for {
-		isRepeat := false
-		/// Lock
-		var mutex sync.Mutex
-		mutex.Lock()
-		for dest := range w.messages {
-			if dest.DepositNonce != m.DepositNonce && dest.DestAmount == string(m.Payload[0].([]byte)) && dest.DestAddress == string(m.Payload[1].([]byte)) {
-				isRepeat = true
-			}
-		}
-		mutex.Unlock()
+// IsComplete returns whether the proposal of the message was approved or rejected. Redemptions through the
+// multiSig are not indexed by deposit nonce on-chain, so their completion can only be asserted for the redemptions
+// executed since the relayer started.
+func (w *writer) IsComplete(m msg.Message) (bool, error) {
+	var prop *proposal
+	var err error
 
-		/// Check Repeat
-		if isRepeat {
-			repeatTime := RoundInterval
-			w.log.Info(MeetARepeatTx, "DepositNonce", m.DepositNonce, "Waiting", repeatTime)
-			time.Sleep(repeatTime)
-		} else {
-			break
+	switch m.Type {
+	case msg.MultiSigTransfer, refunds.RefundTransfer:
+		if w.redemptions.isExecuted(depositKey{Source: m.Source, Nonce: m.DepositNonce}) {
+			return true, nil
 		}
+		return false, ErrRedemptionLookup
+	case msg.FungibleTransfer:
+		prop, err = w.createFungibleProposal(m)
+	case msg.NonFungibleTransfer:
+		prop, err = w.createNonFungibleProposal(m)
+	case msg.GenericTransfer:
+		prop, err = w.createGenericProposal(m)
+	default:
+		return false, fmt.Errorf("unrecognized message type %v", m.Type)
 	}
-	return true
-}
-
-func (w *writer) redeemTx(message *MsgStatus) (RedeemStatusCode, multiSigTx) {
-	//w.UpdateMetadata()
-	m := message.m
-	types.SetSerDeOptions(types.SerDeOptions{NoPalletIndices: true})
-
-	defer func() {
-		/// Single thread send one time each round
-		time.Sleep(RoundInterval)
-	}()
-
-	/// BEGIN: Create a call of multiSigTransfer
-	c, err := w.getCall(m)
 	if err != nil {
-		w.logErr("Get call err", err)
-		return UnKnownError, multiSigTx{}
+		return false, err
 	}
 
-	actualAmount, err := w.chainCore.GetAmountToSub(m.Payload[0].([]byte), w.getRedeemAssetId(m))
+	voteRes, exists, err := w.proposalState(prop)
 	if err != nil {
-		w.log.Error(RedeemNegAmountError, "Error", err)
-		return UnKnownError, multiSigTx{}
+		return false, err
 	}
+	return exists && !voteRes.Status.IsActive, nil
+}
 
-	for {
-		processRound := (w.relayer.relayerId + uint64(m.DepositNonce)) % w.relayer.totalRelayers
-		round, height := w.getRound()
-		blockRound := round.blockRound.Uint64()
-		if blockRound == processRound && !message.ok {
-			fmt.Printf("Relayer#%v solve %v in block#%v\n", w.relayer.relayerId, round, height)
-			// Try to find a exist multiSigTx
-			var maybeTimePoint interface{}
-			maxWeight := types.Weight(0)
-
-			// Traverse all of matched Tx, included New、Approve、Executed
-			for _, ms := range w.listener.asMulti {
-				// Validate parameter
-				fmt.Printf("verify %v\n", w.verifyRedeemAddress(m.Payload[1].([]byte), ms.DestAddress))
-				fmt.Printf("m.Payload[1].([]byte) is %v\n, ms.DestAddress is %v\n", m.Payload[1].([]byte), ms.DestAddress)
-				if w.verifyRedeemAddress(m.Payload[1].([]byte), ms.DestAddress) && ms.DestAmount == actualAmount.String() {
-					/// Once multiSig Extrinsic is executed, stop sending Extrinsic to Polkadot
-					status, executed := w.isFinish(ms, m)
-					if status.finished() {
-						return status, executed
-					}
-
-					/// Match the correct TimePoint
-					maybeTimePoint = TimePointSafe32{
-						Height: types.NewOptionU32(types.U32(ms.OriginMsTx.Block)),
-						Index:  types.U32(ms.OriginMsTx.TxId),
-					}
-					maxWeight = types.Weight(w.relayer.maxWeight)
-					break
-				} else {
-					maybeTimePoint = []byte{}
-				}
-			}
-			if len(w.listener.asMulti) == 0 {
-				maybeTimePoint = []byte{}
-			}
-
-			if maxWeight == 0 {
-				w.log.Info(TryToMakeNewMultiSigTx, "depositNonce", m.DepositNonce)
-			} else {
-				_, height := maybeTimePoint.(TimePointSafe32).Height.Unwrap()
-				w.log.Info(TryToApproveMultiSigTx, "#Block", height, "Index", maybeTimePoint.(TimePointSafe32).Index, "depositNonce", m.DepositNonce)
-			}
-
-			/// Create MultiSig.AsMulti
-			mc, err := types.NewCall(
-				&w.conn.meta,
-				string(utils.MultisigAsMulti),
-				w.relayer.multiSigThreshold,
-				w.relayer.otherSignatories,
-				maybeTimePoint,
-				EncodeCall(c),
-				false,
-				maxWeight,
-			)
-			if err != nil {
-				w.logErr(NewMultiCallError, err)
-				return UnKnownError, multiSigTx{}
-			}
-
-			w.submitTx(mc)
-			return NotExecuted, multiSigTx{}
-		} else {
-			if message.ok {
-				fmt.Printf("%v hasVote, check tx status\n", w.relayer.relayerId)
-			}
-
-			status, executed := w.checkRedeem(m, actualAmount)
-			if status.finished() {
-				return status, executed
-			} else {
-				///Round over, wait a RoundInterval
-				time.Sleep(RoundInterval)
-			}
-		}
+func (w *writer) getRedeemAssetId(m msg.Message) (xevents.AssetId, error) {
+	/// The assets of the Assets pallet are mapped to resource ids by the currencies
+	if w.chainCore.ChainInfo.Type == chainset.AssetsLike {
+		return w.chainCore.ConvertResourceIdToAssetId(m.ResourceId)
 	}
-}
 
-func (w *writer) getRedeemAssetId(m msg.Message) xevents.AssetId {
 	var assetId xevents.AssetId
 	/// GetResourceId <- AssetId
 	if m.Destination == chainset.IdChainXBTCV1 || m.Destination == chainset.IdChainXBTCV2 {
@@ -257,11 +224,15 @@ func (w *writer) getRedeemAssetId(m msg.Message) xevents.AssetId {
 	} else {
 		assetId = chainset.OriginAsset
 	}
-	return assetId
+	return assetId, nil
 }
 
 func (w *writer) getCall(m msg.Message) (types.Call, error) {
-	c, err := w.chainCore.MakeCrossChainTansferCall(m, &w.conn.meta, w.getRedeemAssetId(m))
+	assetId, err := w.getRedeemAssetId(m)
+	if err != nil {
+		return types.Call{}, err
+	}
+	c, err := w.chainCore.MakeCrossChainTansferCall(m, w.conn.getMetadata(), assetId)
 	if err != nil {
 		w.log.Error(NewCrossChainTransferCallError, "Error", err)
 		return types.Call{}, err
@@ -269,42 +240,25 @@ func (w *writer) getCall(m msg.Message) (types.Call, error) {
 	return c, nil
 }
 
-func (w *writer) checkRedeem(m msg.Message, actualAmount *big.Int) (RedeemStatusCode, multiSigTx) {
-	for _, ms := range w.listener.asMulti {
-		// Validate parameter
-		//var destAddress string
-		//if m.Source == chainset.IdBSC || m.Source == chainset.IdKovan || m.Source == chainset.IdHeco {
-		//	dest := types.NewAddressFromAccountID(m.Payload[1].([]byte)).AsAccountID
-		//	destAddress = utils2.BytesToHex(dest[:])
-		//} else {
-		//	destAddress = string(m.Payload[1].([]byte))[2:]
-		//}
-
-		if w.verifyRedeemAddress(m.Payload[1].([]byte), ms.DestAddress) && ms.DestAmount == actualAmount.String() {
-			/// Once multiSig Extrinsic is executed, stop sending Extrinsic to Polkadot
-			return w.isFinish(ms, m)
-		}
-	}
-	return NotExecuted, multiSigTx{}
-}
-
-func (w *writer) verifyRedeemAddress(msgAddress []byte, txAddress string) bool {
-	destAddress, err := types.NewMultiAddressFromHexAccountID(string(msgAddress))
+// getRefundCall returns the call of a refund and the amount it sends back, no fee deducted
+func (w *writer) getRefundCall(m msg.Message) (types.Call, *big.Int, error) {
+	assetId, err := w.chainCore.ConvertResourceIdToAssetId(m.ResourceId)
 	if err != nil {
-		fmt.Printf("parse msgAddress err")
-		return false
+		return types.Call{}, nil, err
 	}
-	sendAddress, err := types.NewMultiAddressFromHexAccountID("0x" + txAddress)
+	amount := big.NewInt(0).SetBytes(m.Payload[0].([]byte))
+	c, err := w.chainCore.MakeTransferCall(m, w.conn.getMetadata(), assetId, amount)
 	if err != nil {
-		fmt.Printf("parse msTx.DestAddress err")
-		return false
+		return types.Call{}, nil, err
 	}
-	return destAddress.AsID == sendAddress.AsID
+	return c, amount, nil
 }
 
-func (w *writer) submitTx(c types.Call) {
+// submitTx signs the call with the current runtime and submits it. If the submission is rejected after a
+// runtime upgrade, the call is signed again with the new runtime. It returns the hash of the submitted extrinsic.
+func (w *writer) submitTx(c types.Call) (types.Hash, bool) {
 	// BEGIN: Get the essential information first
-	api := w.conn.api
+	api := w.conn.API()
 
 	retryTimes := RedeemRetryLimit
 	for {
@@ -314,28 +268,8 @@ func (w *writer) submitTx(c types.Call) {
 			break
 		}
 
-		meta, err := api.RPC.State.GetMetadataLatest()
-		if err != nil {
-			w.logErr(GetMetadataError, err)
-			retryTimes--
-			continue
-		}
-
-		genesisHash, err := api.RPC.Chain.GetBlockHash(genesisBlock)
-		if err != nil {
-			w.logErr(GetBlockHashError, err)
-			retryTimes--
-			continue
-		}
-
-		rv, err := api.RPC.State.GetRuntimeVersionLatest()
-		if err != nil {
-			w.logErr(GetRuntimeVersionLatestError, err)
-			retryTimes--
-			continue
-		}
-
-		key, err := types.CreateStorageKey(meta, "System", "Account", w.relayer.kr.PublicKey)
+		rt := w.conn.runtime()
+		key, err := types.CreateStorageKey(rt.meta, "System", "Account", w.relayer.signer.PublicKey())
 		if err != nil {
 			w.logErr(CreateStorageKeyError, err)
 			retryTimes--
@@ -356,13 +290,13 @@ func (w *writer) submitTx(c types.Call) {
 
 		// Construct signature option
 		o := types.SignatureOptions{
-			BlockHash:          genesisHash,
+			BlockHash:          w.conn.GenesisHash(),
 			Era:                types.ExtrinsicEra{IsMortalEra: false},
-			GenesisHash:        genesisHash,
+			GenesisHash:        w.conn.GenesisHash(),
 			Nonce:              types.NewUCompactFromUInt(uint64(nonce)),
-			SpecVersion:        rv.SpecVersion,
+			SpecVersion:        rt.specVersion,
 			Tip:                types.NewUCompactFromUInt(0),
-			TransactionVersion: rv.TransactionVersion,
+			TransactionVersion: rt.transactionVersion,
 		}
 
 		// Create and Sign the multiSig
@@ -370,9 +304,9 @@ func (w *writer) submitTx(c types.Call) {
 
 		/// ChainX V1 still use `Address` type
 		if w.listener.chainId == chainset.IdChainXPCXV1 || w.listener.chainId == chainset.IdChainXBTCV1 {
-			err = ext.Sign(w.relayer.kr, o)
+			err = signExtrinsic(&ext, w.relayer.signer, o)
 		} else {
-			err = ext.Sign(w.relayer.kr, o)
+			err = signExtrinsic(&ext, w.relayer.signer, o)
 		}
 		if err != nil {
 			w.log.Error(SignmultiSigTxFailed, "Failed", err)
@@ -381,19 +315,47 @@ func (w *writer) submitTx(c types.Call) {
 
 		// Transfer and track the actual status
 		_, err = api.RPC.Author.SubmitAndWatchExtrinsic(ext)
-		w.checkErr(SubmitExtrinsicFailed, err)
-		break
+		if err != nil && w.runtimeUpgraded() {
+			retryTimes--
+			continue
+		}
+		if err != nil {
+			w.logErr(SubmitExtrinsicFailed, err)
+			break
+		}
+		return extrinsicHash(ext), true
 	}
+	return types.Hash{}, false
+}
+
+// extrinsicHash returns the blake2_256 hash of the encoded extrinsic, as reported by the explorers
+func extrinsicHash(ext types.Extrinsic) types.Hash {
+	encoded, err := types.EncodeToBytes(ext)
+	if err != nil {
+		return types.Hash{}
+	}
+	return types.NewHash(hashBytes(encoded))
+}
+
+// runtimeUpgraded refreshes the current runtime after a rejected submission, and returns whether it changed
+func (w *writer) runtimeUpgraded() bool {
+	previous := w.conn.runtime()
+	err := w.conn.refreshRuntime()
+	if err != nil {
+		w.logErr(GetRuntimeVersionLatestError, err)
+		return false
+	}
+	return w.conn.runtime() != previous
 }
 
 func (w *writer) getRound() (Round, uint64) {
-	finalizedHash, err := w.listener.conn.cli.Api.RPC.Chain.GetFinalizedHead()
+	finalizedHash, err := w.listener.conn.Client().Api.RPC.Chain.GetFinalizedHead()
 	if err != nil {
 		w.listener.log.Error("Writer Failed to fetch finalized hash", "err", err)
 	}
 
 	// Get finalized block header
-	finalizedHeader, err := w.listener.conn.cli.Api.RPC.Chain.GetHeader(finalizedHash)
+	finalizedHeader, err := w.listener.conn.Client().Api.RPC.Chain.GetHeader(finalizedHash)
 	if err != nil {
 		w.listener.log.Error("Failed to fetch finalized header", "err", err)
 	}
@@ -410,37 +372,10 @@ func (w *writer) getRound() (Round, uint64) {
 	return round, blockHeight.Uint64()
 }
 
-func (w *writer) isFinish(ms MultiSigAsMulti, m msg.Message) (RedeemStatusCode, multiSigTx) {
-	/// Check isExecuted
-	if ms.Executed {
-		return IsExecuted, ms.OriginMsTx
-	}
-
-	/// Check isVoted
-	/// if already voted, avoid sending duplicated Tx until being executed
-	for _, others := range ms.Others {
-		var isVote = true
-		for _, signatory := range others {
-			voter, _ := types.NewAddressFromHexAccountID(signatory)
-			relayer := types.NewAddressFromAccountID(w.relayer.kr.PublicKey)
-			if voter == relayer {
-				isVote = false
-			}
-		}
-
-		if isVote {
-			w.log.Info("relayer has vote, wait others!", "DepositNonce", m.DepositNonce, "Relayer", w.relayer.relayerId, "Block", ms.OriginMsTx.Block, "Index", ms.OriginMsTx.TxId)
-			return YesVoted, multiSigTx{}
-		}
-	}
-
-	return NotExecuted, multiSigTx{}
-}
-
 func (w *writer) getApi() (*gsrpc.SubstrateAPI, error) {
 	chainId := w.listener.chainId
 	if chainId == chainset.IdChainXPCXV1 || chainId == chainset.IdChainXPCXV2 || chainId == chainset.IdChainXBTCV1 || chainId == chainset.IdChainXBTCV2 {
-		api, err := gsrpc.NewSubstrateAPI(w.conn.url)
+		api, err := gsrpc.NewSubstrateAPI(w.conn.Endpoint())
 		if err != nil {
 			w.logErr(NewApiError, err)
 			return nil, err
@@ -448,7 +383,45 @@ func (w *writer) getApi() (*gsrpc.SubstrateAPI, error) {
 			return api, nil
 		}
 	} else {
-		return w.conn.api, nil
+		return w.conn.API(), nil
+	}
+}
+
+// redemptionEvent returns an event of a redemption, with the amount it sends and its fee in the units of the chain
+func (w *writer) redemptionEvent(kind string, m msg.Message) *webhooks.Event {
+	e := webhooks.NewEvent(kind, m)
+	if m.Type == refunds.RefundTransfer {
+		return e
+	}
+	assetId, err := w.getRedeemAssetId(m)
+	if err != nil {
+		return e
+	}
+	amount, err := w.chainCore.GetAmountToSub(m.Payload[0].([]byte), assetId)
+	if err == nil {
+		e.SetAmount(amount)
+	}
+	fee, err := w.chainCore.GetFeeToSub(m.Payload[0].([]byte), assetId)
+	if err == nil {
+		e.SetFee(fee)
+	}
+	return e
+}
+
+// record moves the transfer of a message to a state and returns whether it moved, see the lifecycle package
+func (w *writer) record(m msg.Message, to lifecycle.State, txHash string, note string) bool {
+	moved, err := w.lifecycle.Record(m, to, w.listener.name, txHash, note)
+	if err != nil {
+		w.log.Warn(RecordTransferStateError, "DepositNonce", m.DepositNonce, "Source", m.Source, "State", to, "Error", err)
+	}
+	return moved
+}
+
+// recordValidated records the redemption of a message as validated, the first time it passes the checks of the writer
+func (w *writer) recordValidated(m msg.Message) {
+	_, err := w.lifecycle.RecordValidated(m, w.listener.name)
+	if err != nil {
+		w.log.Warn(RecordTransferStateError, "DepositNonce", m.DepositNonce, "Source", m.Source, "State", lifecycle.Validated, "Error", err)
 	}
 }
 
@@ -2,10 +2,17 @@ package substrate
 
 import (
 	"github.com/Platdot-Network/go-substrate-rpc-client/v3/types"
+	"io/ioutil"
 	"math/big"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
+	"github.com/Platdot-network/Platdot/chains/approvals"
+	"github.com/Platdot-network/Platdot/chains/chainset"
+	"github.com/Platdot-network/Platdot/chains/limits"
+	"github.com/Platdot-network/Platdot/chains/screening"
 	utils "github.com/Platdot-network/Platdot/shared/substrate"
 	subtest "github.com/Platdot-network/Platdot/shared/substrate/testing"
 	message "github.com/rjman-ljm/platdot-utils/msg"
@@ -57,7 +64,7 @@ func TestWriter_ResolveMessage_FungibleProposal(t *testing.T) {
 	subtest.QueryConst(t, context.client, "Example", "NativeTokenId", &rId)
 	// Construct the message to initiate a vote
 	amount := big.NewInt(10000000)
-	m := message.NewFungibleTransfer(ForeignChain, ThisChain, 0, amount, rId, context.writerBob.conn.key.PublicKey)
+	m := message.NewFungibleTransfer(ForeignChain, ThisChain, 0, amount, rId, context.writerBob.conn.signer.PublicKey())
 	// Create a proposal to help us check results
 	prop, err := context.writerAlice.createFungibleProposal(m)
 	if err != nil {
@@ -75,7 +82,7 @@ func TestWriter_ResolveMessage_FungibleProposal(t *testing.T) {
 
 	// Now check if the assetTxProposal exists on chain
 	singleVoteState := &voteState{
-		VotesFor: []types.AccountID{types.NewAccountID(context.writerAlice.conn.key.PublicKey)},
+		VotesFor: []types.AccountID{types.NewAccountID(context.writerAlice.conn.signer.PublicKey())},
 		Status:   voteStatus{IsActive: true},
 	}
 	assertProposalState(t, context.writerAlice.conn, prop, singleVoteState, true)
@@ -89,8 +96,8 @@ func TestWriter_ResolveMessage_FungibleProposal(t *testing.T) {
 	// Check the vote was added
 	finalVoteState := &voteState{
 		VotesFor: []types.AccountID{
-			types.NewAccountID(context.writerAlice.conn.key.PublicKey),
-			types.NewAccountID(context.writerBob.conn.key.PublicKey),
+			types.NewAccountID(context.writerAlice.conn.signer.PublicKey()),
+			types.NewAccountID(context.writerBob.conn.signer.PublicKey()),
 		},
 		Status: voteStatus{IsApproved: true},
 	}
@@ -119,7 +126,7 @@ func TestWriter_ResolveMessage_NonFungibleProposal(t *testing.T) {
 	// Construct the message to initiate a vote
 	tokenId := big.NewInt(10000000)
 	context.latestInNonce++
-	m := message.NewNonFungibleTransfer(ForeignChain, ThisChain, context.latestInNonce, rId, tokenId, context.writerBob.conn.key.PublicKey, []byte{})
+	m := message.NewNonFungibleTransfer(ForeignChain, ThisChain, context.latestInNonce, rId, tokenId, context.writerBob.conn.signer.PublicKey(), []byte{})
 	// Create a proposal to help us check results
 	prop, err := context.writerAlice.createNonFungibleProposal(m)
 	if err != nil {
@@ -137,7 +144,7 @@ func TestWriter_ResolveMessage_NonFungibleProposal(t *testing.T) {
 
 	// Now check if the assetTxProposal exists on chain
 	singleVoteState := &voteState{
-		VotesFor: []types.AccountID{types.NewAccountID(context.writerAlice.conn.key.PublicKey)},
+		VotesFor: []types.AccountID{types.NewAccountID(context.writerAlice.conn.signer.PublicKey())},
 		Status:   voteStatus{IsActive: true},
 	}
 	assertProposalState(t, context.writerAlice.conn, prop, singleVoteState, true)
@@ -151,8 +158,8 @@ func TestWriter_ResolveMessage_NonFungibleProposal(t *testing.T) {
 	// Check the vote was added
 	finalVoteState := &voteState{
 		VotesFor: []types.AccountID{
-			types.NewAccountID(context.writerAlice.conn.key.PublicKey),
-			types.NewAccountID(context.writerBob.conn.key.PublicKey),
+			types.NewAccountID(context.writerAlice.conn.signer.PublicKey()),
+			types.NewAccountID(context.writerBob.conn.signer.PublicKey()),
 		},
 		Status: voteStatus{IsApproved: true},
 	}
@@ -195,7 +202,7 @@ func TestWriter_ResolveMessage_GenericProposal(t *testing.T) {
 
 	// Now check if the assetTxProposal exists on chain
 	singleVoteState := &voteState{
-		VotesFor: []types.AccountID{types.NewAccountID(context.writerAlice.conn.key.PublicKey)},
+		VotesFor: []types.AccountID{types.NewAccountID(context.writerAlice.conn.signer.PublicKey())},
 		Status:   voteStatus{IsActive: true},
 	}
 	assertProposalState(t, context.writerAlice.conn, prop, singleVoteState, true)
@@ -209,8 +216,8 @@ func TestWriter_ResolveMessage_GenericProposal(t *testing.T) {
 	// Check the vote was added
 	finalVoteState := &voteState{
 		VotesFor: []types.AccountID{
-			types.NewAccountID(context.writerAlice.conn.key.PublicKey),
-			types.NewAccountID(context.writerBob.conn.key.PublicKey),
+			types.NewAccountID(context.writerAlice.conn.signer.PublicKey()),
+			types.NewAccountID(context.writerBob.conn.signer.PublicKey()),
 		},
 		Status: voteStatus{IsApproved: true},
 	}
@@ -233,7 +240,7 @@ func TestWriter_ResolveMessage_Duplicate(t *testing.T) {
 	// Construct the message to initiate a vote
 	amount := big.NewInt(10000000)
 	context.latestInNonce++
-	m := message.NewFungibleTransfer(ForeignChain, ThisChain, context.latestInNonce, amount, rId, context.writerBob.conn.key.PublicKey)
+	m := message.NewFungibleTransfer(ForeignChain, ThisChain, context.latestInNonce, amount, rId, context.writerBob.conn.signer.PublicKey())
 	// Create a proposal to help us check results
 	prop, err := context.writerAlice.createFungibleProposal(m)
 	if err != nil {
@@ -251,7 +258,7 @@ func TestWriter_ResolveMessage_Duplicate(t *testing.T) {
 
 	// Now check if the proposal exists on chain
 	singleVoteState := &voteState{
-		VotesFor: []types.AccountID{types.NewAccountID(context.writerAlice.conn.key.PublicKey)},
+		VotesFor: []types.AccountID{types.NewAccountID(context.writerAlice.conn.signer.PublicKey())},
 		Status:   voteStatus{IsActive: true},
 	}
 	assertProposalState(t, context.writerAlice.conn, prop, singleVoteState, true)
@@ -272,3 +279,113 @@ func TestWriter_ResolveMessage_Duplicate(t *testing.T) {
 
 }
 
+// newCheckedTestWriter returns a writer without a connection to the chain, it can only resolve the messages parked by
+// its checks
+func newCheckedTestWriter(t *testing.T, limitOpts map[string]string) *writer {
+	cfg, err := limits.ParseConfig(limitOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter, err := limits.NewLimiter(cfg, AliceTestLogger, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &writer{
+		listener:  &listener{name: "kusama", chainId: ThisChain},
+		log:       AliceTestLogger,
+		chainCore: chainset.NewChainCore(chainset.NameKusama),
+		limiter:   limiter,
+	}
+}
+
+// newCheckedTransfer returns a fungible transfer of 1 KSM to Bob
+func newCheckedTransfer(w *writer, nonce message.Nonce) message.Message {
+	rId := w.chainCore.ConvertStringToResourceId(chainset.ResourceIdOrigin)
+	amount := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	return message.NewFungibleTransfer(ForeignChain, ThisChain, nonce, amount, rId, BobKey.PublicKey)
+}
+
+func TestWriter_ResolveMessage_FungibleOverLimit(t *testing.T) {
+	w := newCheckedTestWriter(t, map[string]string{limits.SingleOpt: "*:1000"})
+	m := newCheckedTransfer(w, 1)
+
+	// The proposal is not voted, the writer has no connection to vote it
+	if !w.ResolveMessage(m) {
+		t.Fatal("Expected the transfer to be resolved")
+	}
+	parked := w.limiter.Parked()
+	if len(parked) != 1 || parked[0].DepositNonce != m.DepositNonce {
+		t.Fatalf("Got: %v Expected the transfer parked", parked)
+	}
+}
+
+func TestWriter_ResolveMessage_FungiblePaused(t *testing.T) {
+	w := newCheckedTestWriter(t, map[string]string{})
+	m := newCheckedTransfer(w, 2)
+	limits.Pause(m.ResourceId, ForeignChain, ThisChain, "incident")
+	defer limits.Resume(m.ResourceId, ForeignChain, ThisChain)
+
+	if !w.ResolveMessage(m) {
+		t.Fatal("Expected the transfer to be resolved")
+	}
+	parked := w.limiter.Parked()
+	if len(parked) != 1 || parked[0].DepositNonce != m.DepositNonce {
+		t.Fatalf("Got: %v Expected the transfer parked", parked)
+	}
+}
+
+func TestWriter_ResolveMessage_FungibleScreened(t *testing.T) {
+	dir, err := ioutil.TempDir("", "screening")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "blocklist")
+	err = ioutil.WriteFile(path, []byte(types.HexEncodeToString(BobKey.PublicKey)+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newCheckedTestWriter(t, map[string]string{})
+	w.screener, err = screening.NewScreener(&screening.Config{Blocklist: path}, AliceTestLogger, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newCheckedTransfer(w, 3)
+
+	if !w.ResolveMessage(m) {
+		t.Fatal("Expected the transfer to be resolved")
+	}
+	parked := w.screener.Parked()
+	if len(parked) != 1 || parked[0].DepositNonce != m.DepositNonce {
+		t.Fatalf("Got: %v Expected the transfer parked", parked)
+	}
+}
+
+func TestWriter_ResolveMessage_FungibleHeldForApproval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "approvals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg, err := approvals.ParseConfig(map[string]string{approvals.ThresholdOpt: "*:1000", approvals.DirOpt: dir}, "", ThisChain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newCheckedTestWriter(t, map[string]string{})
+	w.approvals, err = approvals.NewQueue(cfg, ThisChain, nil, AliceTestLogger, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newCheckedTransfer(w, 4)
+
+	if !w.ResolveMessage(m) {
+		t.Fatal("Expected the transfer to be resolved")
+	}
+	entries, err := approvals.LoadEntries(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Nonce != m.DepositNonce || entries[0].Status != approvals.StatusPending {
+		t.Fatalf("Got: %v Expected the transfer pending approval", entries)
+	}
+}
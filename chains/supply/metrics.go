@@ -0,0 +1,40 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package supply
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics exposes the balances compared by the checker, and the assets paused by the breaker
+type Metrics struct {
+	Locked  *prometheus.GaugeVec
+	Supply  *prometheus.GaugeVec
+	Tripped *prometheus.GaugeVec
+}
+
+func NewMetrics(chain string) *Metrics {
+	metrics := &Metrics{
+		Locked: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: fmt.Sprintf("%s_supply_locked", chain),
+			Help: "Locked balance of the asset, in the smallest unit of its wrapped token",
+		}, []string{"resource"}),
+		Supply: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: fmt.Sprintf("%s_supply_issued", chain),
+			Help: "Total supply of the wrapped token of the asset",
+		}, []string{"resource"}),
+		Tripped: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: fmt.Sprintf("%s_supply_breaker_tripped", chain),
+			Help: "Whether the asset is paused because its supply is not covered",
+		}, []string{"resource"}),
+	}
+
+	prometheus.MustRegister(metrics.Locked)
+	prometheus.MustRegister(metrics.Supply)
+	prometheus.MustRegister(metrics.Tripped)
+
+	return metrics
+}
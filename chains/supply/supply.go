@@ -0,0 +1,154 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+/*
+Package supply checks that the assets locked by a custodian chain cover the wrapped tokens issued on another chain.
+
+The checker periodically compares the locked balance of every asset with the total supply of its wrapped token. When
+the supply exceeds the locked balance by more than the tolerance, the breaker trips: the asset is paused between both
+chains and the writers park its transfers. The asset is resumed once the locked balance covers the supply again.
+*/
+package supply
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ChainSafe/log15"
+	"github.com/Platdot-network/Platdot/chains"
+	"github.com/Platdot-network/Platdot/chains/limits"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+// Tolerances are expressed in basis points of the locked balance
+const BasisPoints = 10000
+
+// LockedFunc returns the locked balance of a resource, in the smallest unit of its wrapped token
+type LockedFunc func(rId msg.ResourceId) (*big.Int, error)
+
+// ViolationError reports a supply not covered by the locked balance
+type ViolationError struct {
+	Locked *big.Int
+	Supply *big.Int
+}
+
+func (e *ViolationError) Error() string {
+	return fmt.Sprintf("supply %s exceeds locked balance %s", e.Supply, e.Locked)
+}
+
+type Checker struct {
+	custodian msg.ChainId
+	issuerId  msg.ChainId
+	issuer    chains.Issuer
+	locked    LockedFunc
+	assets    []msg.ResourceId
+	tolerance uint64 // Basis points the supply may exceed the locked balance by
+	interval  time.Duration
+	log       log15.Logger
+	metrics   *Metrics
+}
+
+// NewChecker returns a checker of the assets locked on the custodian chain, the issuer must be set before it runs.
+// Metrics may be nil.
+func NewChecker(custodian, issuerId msg.ChainId, locked LockedFunc, assets []msg.ResourceId, tolerance uint64,
+	interval time.Duration, log log15.Logger, m *Metrics) *Checker {
+	return &Checker{
+		custodian: custodian,
+		issuerId:  issuerId,
+		locked:    locked,
+		assets:    assets,
+		tolerance: tolerance,
+		interval:  interval,
+		log:       log,
+		metrics:   m,
+	}
+}
+
+// IssuerId returns the chain issuing the wrapped tokens
+func (c *Checker) IssuerId() msg.ChainId {
+	return c.issuerId
+}
+
+func (c *Checker) SetIssuer(i chains.Issuer) {
+	c.issuer = i
+}
+
+// Check compares the locked balance of a resource with the supply of its wrapped token, a *ViolationError is
+// returned if it isn't covered. The resource is not checked if the issuer has no wrapped token for it.
+func (c *Checker) Check(rId msg.ResourceId) error {
+	supply, err := c.issuer.Supply(rId)
+	if err != nil {
+		return err
+	}
+	locked, err := c.locked(rId)
+	if err != nil {
+		return err
+	}
+	if c.metrics != nil {
+		value, _ := new(big.Float).SetInt(locked).Float64()
+		c.metrics.Locked.WithLabelValues(rId.Hex()).Set(value)
+		value, _ = new(big.Float).SetInt(supply).Float64()
+		c.metrics.Supply.WithLabelValues(rId.Hex()).Set(value)
+	}
+
+	allowed := new(big.Int).Mul(locked, new(big.Int).SetUint64(BasisPoints+c.tolerance))
+	if new(big.Int).Mul(supply, big.NewInt(BasisPoints)).Cmp(allowed) > 0 {
+		return &ViolationError{Locked: locked, Supply: supply}
+	}
+	return nil
+}
+
+// Run checks every asset each interval until stop is closed. An asset is paused when its supply is not covered,
+// and resumed when it is covered again. Assets which can't be read keep their state.
+func (c *Checker) Run(stop <-chan int) {
+	if c.issuer == nil {
+		c.log.Error("Supply issuer not set, the supply is not checked", "issuer", c.issuerId)
+		return
+	}
+	for {
+		c.checkAll()
+		select {
+		case <-stop:
+			return
+		case <-time.After(c.interval):
+		}
+	}
+}
+
+func (c *Checker) checkAll() {
+	for _, rId := range c.assets {
+		err := c.Check(rId)
+		var violation *ViolationError
+		switch {
+		case errors.Is(err, chains.ErrNotIssued):
+			continue
+		case errors.As(err, &violation):
+			c.setTripped(rId, true)
+			if limits.Pause(rId, c.custodian, c.issuerId, err.Error()) {
+				c.log.Error("Supply not covered by the locked balance, pausing the asset", "resource", rId.Hex(),
+					"locked", violation.Locked, "supply", violation.Supply, "issuer", c.issuerId)
+			}
+		case err != nil:
+			c.log.Warn("Failed to check the supply", "resource", rId.Hex(), "issuer", c.issuerId, "err", err)
+		default:
+			c.setTripped(rId, false)
+			if limits.Resume(rId, c.custodian, c.issuerId) {
+				c.log.Info("Supply covered by the locked balance again, resuming the asset", "resource", rId.Hex(),
+					"issuer", c.issuerId)
+			}
+		}
+	}
+}
+
+func (c *Checker) setTripped(rId msg.ResourceId, tripped bool) {
+	if c.metrics == nil {
+		return
+	}
+	value := 0.0
+	if tripped {
+		value = 1
+	}
+	c.metrics.Tripped.WithLabelValues(rId.Hex()).Set(value)
+}
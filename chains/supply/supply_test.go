@@ -0,0 +1,94 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package supply
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ChainSafe/log15"
+	"github.com/Platdot-network/Platdot/chains"
+	"github.com/Platdot-network/Platdot/chains/limits"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+var testResource = msg.ResourceIdFromSlice([]byte{1})
+var unissuedResource = msg.ResourceIdFromSlice([]byte{2})
+
+type testIssuer struct {
+	supply map[msg.ResourceId]*big.Int
+}
+
+func (i *testIssuer) Id() msg.ChainId {
+	return 2
+}
+
+func (i *testIssuer) Supply(rId msg.ResourceId) (*big.Int, error) {
+	supply, ok := i.supply[rId]
+	if !ok {
+		return nil, chains.ErrNotIssued
+	}
+	return supply, nil
+}
+
+func newTestChecker(locked *big.Int, supply *big.Int, tolerance uint64) *Checker {
+	c := NewChecker(1, 2, func(rId msg.ResourceId) (*big.Int, error) {
+		return locked, nil
+	}, []msg.ResourceId{testResource, unissuedResource}, tolerance, time.Second, log15.New(), nil)
+	c.SetIssuer(&testIssuer{supply: map[msg.ResourceId]*big.Int{testResource: supply}})
+	return c
+}
+
+func TestCheck(t *testing.T) {
+	var violation *ViolationError
+
+	if err := newTestChecker(big.NewInt(100), big.NewInt(100), 0).Check(testResource); err != nil {
+		t.Fatal(err)
+	}
+	if err := newTestChecker(big.NewInt(100), big.NewInt(101), 0).Check(testResource); !errors.As(err, &violation) {
+		t.Fatalf("expected a violation, got %v", err)
+	}
+
+	// 1% tolerance
+	if err := newTestChecker(big.NewInt(100), big.NewInt(101), 100).Check(testResource); err != nil {
+		t.Fatal(err)
+	}
+	if err := newTestChecker(big.NewInt(100), big.NewInt(102), 100).Check(testResource); !errors.As(err, &violation) {
+		t.Fatalf("expected a violation, got %v", err)
+	}
+
+	if err := newTestChecker(big.NewInt(100), big.NewInt(100), 0).Check(unissuedResource); !errors.Is(err, chains.ErrNotIssued) {
+		t.Fatalf("expected %v, got %v", chains.ErrNotIssued, err)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	locked := big.NewInt(100)
+	c := newTestChecker(locked, big.NewInt(150), 0)
+	limiter, err := limits.NewLimiter(&limits.Config{Window: time.Hour}, log15.New(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := msg.NewMultiSigTransfer(2, 1, 1, big.NewInt(10), testResource, []byte("alice"))
+
+	c.checkAll()
+	var paused *limits.PausedError
+	if err := limiter.Allow(m, big.NewInt(10)); !errors.As(err, &paused) {
+		t.Fatalf("expected the asset to be paused, got %v", err)
+	}
+
+	// Transfers of the asset between other chains are not paused
+	other := msg.NewMultiSigTransfer(3, 1, 1, big.NewInt(10), testResource, []byte("alice"))
+	if err := limiter.Allow(other, big.NewInt(10)); err != nil {
+		t.Fatal(err)
+	}
+
+	locked.SetInt64(150)
+	c.checkAll()
+	if err := limiter.Allow(m, big.NewInt(10)); err != nil {
+		t.Fatal(err)
+	}
+}
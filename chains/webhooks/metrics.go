@@ -0,0 +1,40 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package webhooks
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics exposes the deliveries of the webhooks
+type Metrics struct {
+	Delivered prometheus.Counter
+	Failed    prometheus.Counter
+	Dropped   prometheus.Counter
+}
+
+func NewMetrics(chain string) *Metrics {
+	metrics := &Metrics{
+		Delivered: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: fmt.Sprintf("%s_webhooks_delivered", chain),
+			Help: "Number of webhook events delivered to an endpoint",
+		}),
+		Failed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: fmt.Sprintf("%s_webhooks_failed", chain),
+			Help: "Number of webhook events not delivered to an endpoint after all retries",
+		}),
+		Dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: fmt.Sprintf("%s_webhooks_dropped", chain),
+			Help: "Number of webhook events dropped because the queue was full",
+		}),
+	}
+
+	prometheus.MustRegister(metrics.Delivered)
+	prometheus.MustRegister(metrics.Failed)
+	prometheus.MustRegister(metrics.Dropped)
+
+	return metrics
+}
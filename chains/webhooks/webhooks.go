@@ -0,0 +1,336 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+/*
+Package webhooks notifies HTTP endpoints of the lifecycle of the transfers.
+
+A chain configured with webhooks posts an event as JSON to each of its endpoints when its listener observes a deposit
+or holds back a block whose deposits are not confirmed by enough endpoints, and when its writer submits a vote or a
+multisig approval, executes a transfer, or fails or parks one. The events are delivered in the background, in order,
+and retried with an exponential backoff while an endpoint fails, so that a slow endpoint never holds back a transfer.
+Every endpoint has a queue of its own, so that a failing endpoint doesn't hold back the deliveries to the others.
+
+If a secret is configured, every request is signed: the X-Platdot-Signature header holds "sha256=" followed by the hex
+HMAC-SHA256 of the X-Platdot-Timestamp header, a dot and the body, keyed by the secret. A receiver recomputes it with
+Sign, and rejects old timestamps to avoid replays. The X-Platdot-Delivery header identifies the event across retries.
+*/
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	log "github.com/ChainSafe/log15"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+// Chain config options of the webhooks
+const (
+	URLsOpt    = "webhooks"
+	SecretOpt  = "webhookSecret"
+	RetriesOpt = "webhookRetries"
+)
+
+// Attempts of a delivery after the first one, unless configured
+const DefaultRetries = 5
+
+// Delay before the first retry of a delivery, doubled after every attempt
+var RetryInterval = 2 * time.Second
+
+// Timeout of a request to an endpoint
+var RequestTimeout = 10 * time.Second
+
+// Events waiting for their delivery, the events notified beyond are dropped
+var QueueSize = 1024
+
+// Kinds of the events
+const (
+	DepositObserved    = "deposit.observed"
+	DepositUnconfirmed = "deposit.unconfirmed" // The deposits of a block are held back, see BlockEvent
+	VoteSubmitted      = "vote.submitted"
+	TransferExecuted   = "transfer.executed"
+	TransferFailed     = "transfer.failed"
+	TransferParked     = "transfer.parked"
+)
+
+// Headers of the requests
+const (
+	EventHeader     = "X-Platdot-Event"
+	DeliveryHeader  = "X-Platdot-Delivery"
+	TimestampHeader = "X-Platdot-Timestamp"
+	SignatureHeader = "X-Platdot-Signature"
+)
+
+type Config struct {
+	URLs    []string // Endpoints notified of every event
+	Secret  string   // Key of the signatures, the requests are not signed if empty
+	Retries int      // Attempts of a delivery after the first one
+}
+
+// ParseConfig parses the webhook options of the chain, the endpoints are separated by commas. It returns nil if no
+// endpoint is configured.
+func ParseConfig(opts map[string]string) (*Config, error) {
+	cfg := &Config{Secret: opts[SecretOpt], Retries: DefaultRetries}
+	for _, url := range strings.Split(opts[URLsOpt], ",") {
+		url = strings.TrimSpace(url)
+		if url == "" {
+			continue
+		}
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			return nil, fmt.Errorf("invalid %s endpoint: %s", URLsOpt, url)
+		}
+		cfg.URLs = append(cfg.URLs, url)
+	}
+	if value := opts[RetriesOpt]; value != "" {
+		retries, err := strconv.Atoi(value)
+		if err != nil || retries < 0 {
+			return nil, fmt.Errorf("invalid %s: %s", RetriesOpt, value)
+		}
+		cfg.Retries = retries
+	}
+	if len(cfg.URLs) == 0 {
+		return nil, nil
+	}
+	return cfg, nil
+}
+
+// Event is the body of a notification. The amount is the deposited amount for an observed deposit, and the amount sent
+// to the recipient otherwise. The fee is the handling fee the bridge deducts from the transfer, when known. Both are in
+// the smallest unit of the asset on the chain reporting the event.
+type Event struct {
+	Id          string      `json:"id"`
+	Kind        string      `json:"event"`
+	Chain       string      `json:"chain"` // Chain reporting the event
+	Source      msg.ChainId `json:"source"`
+	Destination msg.ChainId `json:"destination"`
+	Nonce       msg.Nonce   `json:"nonce"`
+	ResourceId  string      `json:"resourceId"`
+	Amount      string      `json:"amount,omitempty"`
+	Fee         string      `json:"fee,omitempty"`
+	Recipient   string      `json:"recipient,omitempty"`
+	TxHash      string      `json:"txHash,omitempty"`
+	CallHash    string      `json:"callHash,omitempty"` // Multisig call executing the transfer
+	Reason      string      `json:"reason,omitempty"`   // Why the transfer failed or is parked
+	Time        time.Time   `json:"time"`
+}
+
+// NewEvent returns an event of the transfer of a message. The amount and the recipient are read from the payload of
+// the fungible transfers.
+func NewEvent(kind string, m msg.Message) *Event {
+	e := &Event{
+		Kind:        kind,
+		Source:      m.Source,
+		Destination: m.Destination,
+		Nonce:       m.DepositNonce,
+		ResourceId:  m.ResourceId.Hex(),
+	}
+	if len(m.Payload) < 2 || m.Type == msg.NonFungibleTransfer || m.Type == msg.GenericTransfer {
+		return e
+	}
+	if amount, ok := m.Payload[0].([]byte); ok {
+		e.Amount = new(big.Int).SetBytes(amount).String()
+	}
+	if recipient, ok := m.Payload[1].([]byte); ok {
+		e.Recipient = formatRecipient(recipient)
+	}
+	return e
+}
+
+// BlockEvent returns an event of a block of the source chain rather than of a transfer, the reason names the block
+func BlockEvent(kind string, source msg.ChainId, block uint64, reason string) *Event {
+	return &Event{Kind: kind, Source: source, Reason: fmt.Sprintf("block %d: %s", block, reason)}
+}
+
+// SetAmount sets the amount of the event, nil amounts are ignored
+func (e *Event) SetAmount(amount *big.Int) *Event {
+	if amount != nil {
+		e.Amount = amount.String()
+	}
+	return e
+}
+
+// SetFee sets the handling fee of the event, nil fees are ignored
+func (e *Event) SetFee(fee *big.Int) *Event {
+	if fee != nil {
+		e.Fee = fee.String()
+	}
+	return e
+}
+
+// SetReason sets why the transfer failed or is parked
+func (e *Event) SetReason(reason string) *Event {
+	e.Reason = reason
+	return e
+}
+
+// formatRecipient returns a recipient given as an address string as is, and a recipient given as raw bytes in hex
+func formatRecipient(recipient []byte) string {
+	for _, r := range string(recipient) {
+		if r > unicode.MaxASCII || !unicode.IsPrint(r) {
+			return "0x" + hex.EncodeToString(recipient)
+		}
+	}
+	return string(recipient)
+}
+
+// Sign returns the signature of a request made at timestamp, as sent in the SignatureHeader
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// delivery is an event queued for an endpoint, with its encoded body
+type delivery struct {
+	event *Event
+	body  []byte
+}
+
+// Notifier delivers the events of a chain to its endpoints
+type Notifier struct {
+	cfg     *Config
+	chain   string
+	client  *http.Client
+	queues  []chan delivery // Queue of each endpoint, in the order of the URLs
+	log     log.Logger
+	metrics *Metrics
+	now     func() time.Time
+}
+
+// NewNotifier returns the notifier of a chain, the events are delivered once Run is started. metrics may be nil.
+func NewNotifier(cfg *Config, chain string, log log.Logger, m *Metrics) *Notifier {
+	n := &Notifier{
+		cfg:     cfg,
+		chain:   chain,
+		client:  &http.Client{Timeout: RequestTimeout},
+		log:     log,
+		metrics: m,
+		now:     time.Now,
+	}
+	for range cfg.URLs {
+		n.queues = append(n.queues, make(chan delivery, QueueSize))
+	}
+	return n
+}
+
+// Notify queues an event for its delivery to every endpoint without blocking, it is dropped for an endpoint whose
+// queue is full. A nil notifier ignores the events, so that the chains without webhooks don't need to check for one.
+func (n *Notifier) Notify(e *Event) {
+	if n == nil {
+		return
+	}
+	e.Chain = n.chain
+	e.Time = n.now().UTC()
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	e.Id = hex.EncodeToString(id)
+	body, err := json.Marshal(e)
+	if err != nil {
+		n.log.Error("Failed to encode a webhook event", "event", e.Kind, "err", err)
+		return
+	}
+
+	for i, queue := range n.queues {
+		select {
+		case queue <- delivery{event: e, body: body}:
+		default:
+			n.log.Error("Webhook queue full, dropping the event", "url", n.cfg.URLs[i], "event", e.Kind, "src", e.Source, "nonce", e.Nonce)
+			if n.metrics != nil {
+				n.metrics.Dropped.Inc()
+			}
+		}
+	}
+}
+
+// Run delivers the queued events to each endpoint in a goroutine of its own, until stop is closed
+func (n *Notifier) Run(stop <-chan int) {
+	var wg sync.WaitGroup
+	for i, url := range n.cfg.URLs {
+		wg.Add(1)
+		go func(url string, queue <-chan delivery) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				case d := <-queue:
+					n.deliver(url, d.event, d.body, stop)
+				}
+			}
+		}(url, n.queues[i])
+	}
+	wg.Wait()
+}
+
+// deliver posts an event to an endpoint, retrying with an exponential backoff until it is accepted or the retries
+// are exhausted
+func (n *Notifier) deliver(url string, e *Event, body []byte, stop <-chan int) {
+	interval := RetryInterval
+	for attempt := 0; ; attempt++ {
+		retry, err := n.post(url, e, body)
+		if err == nil {
+			n.log.Debug("Delivered webhook", "url", url, "event", e.Kind, "src", e.Source, "nonce", e.Nonce)
+			if n.metrics != nil {
+				n.metrics.Delivered.Inc()
+			}
+			return
+		}
+		if !retry || attempt >= n.cfg.Retries {
+			n.log.Error("Failed to deliver webhook", "url", url, "event", e.Kind, "src", e.Source, "nonce", e.Nonce,
+				"attempts", attempt+1, "err", err)
+			if n.metrics != nil {
+				n.metrics.Failed.Inc()
+			}
+			return
+		}
+		n.log.Warn("Webhook delivery failed, will retry", "url", url, "event", e.Kind, "in", interval, "err", err)
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+		interval *= 2
+	}
+}
+
+// post makes a request, it returns whether a failed request may be retried
+func (n *Notifier) post(url string, e *Event, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(n.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, e.Kind)
+	req.Header.Set(DeliveryHeader, e.Id)
+	req.Header.Set(TimestampHeader, timestamp)
+	if n.cfg.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(n.cfg.Secret, timestamp, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	// The other client errors are not fixed by retrying
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout
+	return retry, fmt.Errorf("unexpected status: %s", resp.Status)
+}
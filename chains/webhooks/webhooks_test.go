@@ -0,0 +1,157 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package webhooks
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	log "github.com/ChainSafe/log15"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+var testResource = msg.ResourceIdFromSlice([]byte{1})
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := ParseConfig(map[string]string{})
+	if err != nil || cfg != nil {
+		t.Fatalf("Got %v %v, expected no webhooks", cfg, err)
+	}
+
+	cfg, err = ParseConfig(map[string]string{URLsOpt: "http://a, https://b", SecretOpt: "s", RetriesOpt: "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.URLs) != 2 || cfg.URLs[1] != "https://b" || cfg.Secret != "s" || cfg.Retries != 2 {
+		t.Fatalf("Unexpected config: %+v", cfg)
+	}
+
+	for _, opts := range []map[string]string{{URLsOpt: "ftp://a"}, {URLsOpt: "http://a", RetriesOpt: "-1"}} {
+		if _, err = ParseConfig(opts); err == nil {
+			t.Errorf("Expected an error for %v", opts)
+		}
+	}
+}
+
+func TestNewEvent(t *testing.T) {
+	m := msg.NewMultiSigTransfer(1, 2, 3, big.NewInt(42), testResource, []byte("0xabc"))
+	e := NewEvent(VoteSubmitted, m).SetFee(big.NewInt(1))
+	if e.Source != 1 || e.Destination != 2 || e.Nonce != 3 || e.Amount != "42" || e.Fee != "1" || e.Recipient != "0xabc" {
+		t.Fatalf("Unexpected event: %+v", e)
+	}
+
+	m = msg.NewFungibleTransfer(1, 2, 3, big.NewInt(42), testResource, []byte{0xde, 0xad})
+	if e = NewEvent(DepositObserved, m); e.Recipient != "0xdead" {
+		t.Fatalf("Got recipient %s, expected the raw bytes in hex", e.Recipient)
+	}
+
+	if e = BlockEvent(DepositUnconfirmed, 1, 7, "endpoints disagree"); e.Source != 1 || e.Reason != "block 7: endpoints disagree" {
+		t.Fatalf("Unexpected event: %+v", e)
+	}
+}
+
+func TestDeliveries(t *testing.T) {
+	RetryInterval = time.Millisecond
+	statuses := []int{http.StatusInternalServerError, http.StatusOK, http.StatusBadRequest}
+	received := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+		status := http.StatusOK
+		if len(statuses) > 0 {
+			status, statuses = statuses[0], statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	stop := make(chan int)
+	defer close(stop)
+	n := NewNotifier(&Config{URLs: []string{server.URL}, Secret: "secret", Retries: 3}, "chain", log.Root(), nil)
+	go n.Run(stop)
+
+	// The first event is retried after the server error
+	m := msg.NewMultiSigTransfer(1, 2, 3, big.NewInt(42), testResource, []byte("0xabc"))
+	n.Notify(NewEvent(DepositObserved, m))
+	var id string
+	for i := 0; i < 2; i++ {
+		r, body := <-received, <-bodies
+		if r.Header.Get(SignatureHeader) != Sign("secret", r.Header.Get(TimestampHeader), body) {
+			t.Fatal("Invalid signature")
+		}
+		if i == 1 && r.Header.Get(DeliveryHeader) != id {
+			t.Fatal("Expected the same delivery id across retries")
+		}
+		id = r.Header.Get(DeliveryHeader)
+
+		var e Event
+		if err := json.Unmarshal(body, &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Kind != DepositObserved || e.Chain != "chain" || e.Nonce != 3 || e.Amount != "42" || r.Header.Get(EventHeader) != DepositObserved {
+			t.Fatalf("Unexpected event: %+v", e)
+		}
+	}
+
+	// The second one is not retried after a client error, the third one follows
+	n.Notify(NewEvent(TransferParked, m).SetReason("over the limit"))
+	n.Notify(NewEvent(TransferExecuted, m))
+	for _, kind := range []string{TransferParked, TransferExecuted} {
+		select {
+		case r := <-received:
+			<-bodies
+			if r.Header.Get(EventHeader) != kind {
+				t.Fatalf("Got %s, expected %s", r.Header.Get(EventHeader), kind)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %s", kind)
+		}
+	}
+}
+
+func TestFailingEndpoint(t *testing.T) {
+	RetryInterval = time.Hour
+	defer func() { RetryInterval = 2 * time.Second }()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	received := make(chan string, 2)
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get(EventHeader)
+	}))
+	defer healthy.Close()
+
+	stop := make(chan int)
+	defer close(stop)
+	n := NewNotifier(&Config{URLs: []string{failing.URL, healthy.URL}, Retries: 3}, "chain", log.Root(), nil)
+	go n.Run(stop)
+
+	// The endpoint waiting to retry doesn't hold back the deliveries to the other one
+	m := msg.NewMultiSigTransfer(1, 2, 3, big.NewInt(42), testResource, []byte("0xabc"))
+	n.Notify(NewEvent(DepositObserved, m))
+	n.Notify(NewEvent(TransferExecuted, m))
+	for _, kind := range []string{DepositObserved, TransferExecuted} {
+		select {
+		case got := <-received:
+			if got != kind {
+				t.Fatalf("Got %s, expected %s", got, kind)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %s", kind)
+		}
+	}
+}
+
+func TestNilNotifier(t *testing.T) {
+	var n *Notifier
+	n.Notify(NewEvent(DepositObserved, msg.Message{}))
+}
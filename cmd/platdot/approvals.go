@@ -0,0 +1,155 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	log "github.com/ChainSafe/log15"
+	"github.com/Platdot-network/Platdot/chains/approvals"
+	"github.com/Platdot-network/Platdot/chains/ethlike"
+	"github.com/Platdot-network/Platdot/chains/substrate"
+	"github.com/Platdot-network/Platdot/config"
+	"github.com/rjman-ljm/platdot-utils/core"
+	"github.com/rjman-ljm/platdot-utils/msg"
+	"github.com/urfave/cli/v2"
+)
+
+var approvalsDecideFlags = []cli.Flag{
+	config.ApprovalsChainFlag,
+	config.ApprovalsSourceFlag,
+	config.ApprovalsNonceFlag,
+}
+
+var approvalsCommand = cli.Command{
+	Name:  "approvals",
+	Usage: "manage the transfers awaiting a manual approval",
+	Description: "The approvals command manages the transfers held by the writer of a chain until an operator approves them.\n" +
+		"\tTo list the queued transfers: platdot --config config.json approvals list --chain 2\n" +
+		"\tTo approve a transfer: platdot --config config.json approvals approve --chain 2 --source 1 --nonce 42\n" +
+		"\tTo reject a transfer: platdot --config config.json approvals reject --chain 2 --source 1 --nonce 42\n" +
+		"\tDecisions are signed with the relayer key of the chain, and applied by the running relayer.",
+	Subcommands: []*cli.Command{
+		{
+			Action: listApprovals,
+			Name:   "list",
+			Usage:  "list the queued transfers",
+			Flags:  []cli.Flag{config.ApprovalsChainFlag},
+		},
+		{
+			Action: func(ctx *cli.Context) error { return decideApproval(ctx, true) },
+			Name:   "approve",
+			Usage:  "approve a queued transfer",
+			Flags:  approvalsDecideFlags,
+		},
+		{
+			Action: func(ctx *cli.Context) error { return decideApproval(ctx, false) },
+			Name:   "reject",
+			Usage:  "reject a queued transfer",
+			Flags:  approvalsDecideFlags,
+		},
+	},
+}
+
+// approvalsChain returns the config of the chain given by the flags, its type and its approval config
+func approvalsChain(ctx *cli.Context) (*core.ChainConfig, string, *approvals.Config, error) {
+	err := startLogger(ctx)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	cfg, err := config.GetConfig(ctx)
+	if err != nil {
+		if err.Error() == config.EndPointParseError.Error() {
+			log.Debug("parse config err", err)
+		} else {
+			return nil, "", nil, err
+		}
+	}
+	ks, insecure := keystorePath(ctx, cfg)
+
+	chainId := ctx.String(config.ApprovalsChainFlag.Name)
+	for _, chain := range cfg.Chains {
+		if chain.Id != chainId {
+			continue
+		}
+		chainConfig, err := newChainConfig(ctx, chain, ks, insecure)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		approvalsCfg, err := approvals.ParseConfig(chainConfig.Opts, chainConfig.BlockstorePath, chainConfig.Id)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		if approvalsCfg == nil {
+			return nil, "", nil, fmt.Errorf("chain %s has no %s", chain.Name, approvals.ThresholdOpt)
+		}
+		return chainConfig, chain.Type, approvalsCfg, nil
+	}
+	return nil, "", nil, fmt.Errorf("chain %s not found in the config", chainId)
+}
+
+func listApprovals(ctx *cli.Context) error {
+	_, _, approvalsCfg, err := approvalsChain(ctx)
+	if err != nil {
+		return err
+	}
+	entries, err := approvals.LoadEntries(approvalsCfg.Dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		fmt.Printf("status=%s src=%d dst=%d nonce=%d rId=%s amount=%s recipient=%s digest=%s queued=%s\n",
+			e.Status, e.Source, e.Destination, e.Nonce, e.ResourceId, e.Amount, e.Recipient(), e.Digest(), e.Queued.Format("2006-01-02 15:04:05"))
+	}
+	return nil
+}
+
+func decideApproval(ctx *cli.Context, approve bool) error {
+	chainConfig, chainType, approvalsCfg, err := approvalsChain(ctx)
+	if err != nil {
+		return err
+	}
+	source := msg.ChainId(ctx.Uint(config.ApprovalsSourceFlag.Name))
+	nonce := msg.Nonce(ctx.Uint64(config.ApprovalsNonceFlag.Name))
+
+	entries, err := approvals.LoadEntries(approvalsCfg.Dir)
+	if err != nil {
+		return err
+	}
+	var entry *approvals.Entry
+	for _, e := range entries {
+		if e.Source == source && e.Nonce == nonce {
+			entry = e
+		}
+	}
+	if entry == nil {
+		return fmt.Errorf("no queued transfer from chain %d with nonce %d", source, nonce)
+	}
+	if entry.Status != approvals.StatusPending {
+		return fmt.Errorf("transfer already %s", entry.Status)
+	}
+
+	var signer approvals.Signer
+	switch chainType {
+	case "ethereum":
+		signer, err = ethlike.ApprovalSigner(chainConfig)
+	case "substrate":
+		signer, err = substrate.ApprovalSigner(chainConfig)
+	default:
+		err = errors.New("unrecognized Chain Type")
+	}
+	if err != nil {
+		return err
+	}
+
+	err = approvals.Decide(approvalsCfg.Dir, chainConfig.Id, signer, entry, approve)
+	if err != nil {
+		return err
+	}
+	log.Info("Decision written, the relayer applies it shortly", "src", source, "nonce", nonce, "recipient", entry.Recipient(),
+		"amount", entry.Amount, "approve", approve)
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	log "github.com/ChainSafe/log15"
+	"github.com/Platdot-network/Platdot/chains"
 	"github.com/Platdot-network/Platdot/chains/ethlike"
 	"github.com/Platdot-network/Platdot/chains/substrate"
 	"github.com/Platdot-network/Platdot/config"
@@ -106,6 +107,11 @@ func init() {
 	app.EnableBashCompletion = true
 	app.Commands = []*cli.Command{
 		&accountCommand,
+		&replayCommand,
+		&reconcileCommand,
+		&approvalsCommand,
+		&transfersCommand,
+		&signerCommand,
 	}
 	app.Flags = append(app.Flags, cliFlags...)
 	app.Flags = append(app.Flags, devFlags...)
@@ -150,45 +156,15 @@ func run(ctx *cli.Context) error {
 		}
 	}
 
-	// Check for test key flag
-	var ks string
-	var insecure bool
-	if key := ctx.String(config.TestKeyFlag.Name); key != "" {
-		ks = key
-		insecure = true
-	} else if cfg.KeystorePath != "" {
-		ks = cfg.KeystorePath
-	} else {
-		ks = config.DefaultKeystorePath
-	}
+	ks, insecure := keystorePath(ctx, cfg)
 
 	// Used to signal core shutdown due to fatal error
 	sysErr := make(chan error)
 	c := core.NewCore(sysErr)
 
+	var initialized []core.Chain
 	for _, chain := range cfg.Chains {
-		chainId, err := strconv.Atoi(chain.Id)
-		if err != nil {
-			return err
-		}
-		chainConfig := &core.ChainConfig{
-			Name:           chain.Name,
-			Id:             msg.ChainId(chainId),
-			Endpoint:       chain.Endpoint,
-			From:           chain.From,
-			KeystorePath:   ks,
-			Insecure:       insecure,
-			BlockstorePath: ctx.String(config.BlockstorePathFlag.Name),
-			FreshStart:     ctx.Bool(config.FreshStartFlag.Name),
-			LatestBlock:    ctx.Bool(config.LatestBlockFlag.Name),
-			Opts:           chain.Opts,
-			OtherRelayer:   chain.OtherRelayer,
-		}
-		var newChain core.Chain
 		var m *metrics.ChainMetrics
-
-		logger := log.Root().New("chain", chainConfig.Name)
-
 		if ctx.Bool(config.MetricsFlag.Name) {
 			m = metrics.NewChainMetrics(chain.Name)
 		}
@@ -199,17 +175,17 @@ func run(ctx *cli.Context) error {
 		}
 		fmt.Printf("chain is %v\n", chain.Endpoint)
 
-		if chain.Type == "ethereum" {
-			newChain, err = ethlike.InitializeChain(chainConfig, logger, sysErr, m)
-		} else if chain.Type == "substrate" {
-			newChain, err = substrate.InitializeChain(chainConfig, logger, sysErr, m)
-		} else {
-			return errors.New("unrecognized Chain Type")
-		}
+		newChain, err := initializeChain(ctx, chain, ks, insecure, sysErr, m)
 		if err != nil {
 			return err
 		}
 		c.AddChain(newChain)
+		initialized = append(initialized, newChain)
+	}
+
+	err = linkIssuers(initialized)
+	if err != nil {
+		return err
 	}
 
 	// Start prometheus and health server
@@ -240,4 +216,79 @@ func run(ctx *cli.Context) error {
 	c.Start()
 
 	return nil
-}
\ No newline at end of file
+}
+
+// keystorePath returns the keystore used by the chains, and whether it is the insecure test keystore
+func keystorePath(ctx *cli.Context, cfg *config.Config) (string, bool) {
+	// Check for test key flag
+	if key := ctx.String(config.TestKeyFlag.Name); key != "" {
+		return key, true
+	} else if cfg.KeystorePath != "" {
+		return cfg.KeystorePath, false
+	}
+	return config.DefaultKeystorePath, false
+}
+
+// linkIssuers sets the issuer of every custodian checking the supply of its wrapped tokens
+func linkIssuers(initialized []core.Chain) error {
+	for _, custodian := range initialized {
+		cu, ok := custodian.(chains.Custodian)
+		if !ok {
+			continue
+		}
+		issuerId, ok := cu.IssuerId()
+		if !ok {
+			continue
+		}
+
+		var issuer chains.Issuer
+		for _, chain := range initialized {
+			if i, ok := chain.(chains.Issuer); ok && chain.Id() == issuerId {
+				issuer = i
+			}
+		}
+		if issuer == nil {
+			return fmt.Errorf("chain %s checks the supply of chain %d, which is not an issuer", custodian.Name(), issuerId)
+		}
+		cu.SetIssuer(issuer)
+	}
+	return nil
+}
+
+// newChainConfig returns the config of the chain described by the raw config
+func newChainConfig(ctx *cli.Context, chain config.RawChainConfig, ks string, insecure bool) (*core.ChainConfig, error) {
+	chainId, err := strconv.Atoi(chain.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &core.ChainConfig{
+		Name:           chain.Name,
+		Id:             msg.ChainId(chainId),
+		Endpoint:       chain.Endpoint,
+		From:           chain.From,
+		KeystorePath:   ks,
+		Insecure:       insecure,
+		BlockstorePath: ctx.String(config.BlockstorePathFlag.Name),
+		FreshStart:     ctx.Bool(config.FreshStartFlag.Name),
+		LatestBlock:    ctx.Bool(config.LatestBlockFlag.Name),
+		Opts:           chain.Opts,
+		OtherRelayer:   chain.OtherRelayer,
+	}, nil
+}
+
+// initializeChain builds the chain described by the raw config
+func initializeChain(ctx *cli.Context, chain config.RawChainConfig, ks string, insecure bool, sysErr chan<- error, m *metrics.ChainMetrics) (core.Chain, error) {
+	chainConfig, err := newChainConfig(ctx, chain, ks, insecure)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := log.Root().New("chain", chainConfig.Name)
+
+	if chain.Type == "ethereum" {
+		return ethlike.InitializeChain(chainConfig, logger, sysErr, m)
+	} else if chain.Type == "substrate" {
+		return substrate.InitializeChain(chainConfig, logger, sysErr, m)
+	}
+	return nil, errors.New("unrecognized Chain Type")
+}
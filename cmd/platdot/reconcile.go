@@ -0,0 +1,284 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/ChainSafe/log15"
+	"github.com/Platdot-network/Platdot/chains"
+	"github.com/Platdot-network/Platdot/config"
+	"github.com/rjman-ljm/platdot-utils/msg"
+	"github.com/urfave/cli/v2"
+)
+
+// Status of a deposit in the reconcile report
+const (
+	StatusMatched    = "matched"
+	StatusMissing    = "missing"
+	StatusDuplicated = "duplicated"
+	StatusMismatched = "amount-mismatched"
+	StatusOrphaned   = "orphaned" // Executed on the destination chain, without deposit in the source block range
+)
+
+var reconcileFlags = []cli.Flag{
+	config.ReplayChainFlag,
+	config.ReplayFromFlag,
+	config.ReplayToFlag,
+	config.ReconcileDestFlag,
+	config.ReconcileDestFromFlag,
+	config.ReconcileDestToFlag,
+	config.ReconcileFormatFlag,
+	config.ReconcileOutputFlag,
+}
+
+var reconcileCommand = cli.Command{
+	Action: reconcile,
+	Name:   "reconcile",
+	Usage:  "audit that the deposits of a block range were executed exactly once",
+	Flags:  reconcileFlags,
+	Description: "The reconcile command pairs the deposits of a block range of the source chain with their executions in a block range of the destination chain.\n" +
+		"\tTo write a CSV report: platdot --config config.json reconcile --chain 1 --from 100 --to 200 --dest 2 --destFrom 1000 --destTo 3000\n" +
+		"\tUse --format json for a JSON report, and --output to write it to a file.\n" +
+		"\tDeposits are reported as matched, missing, duplicated or amount-mismatched, executions without deposit in the range as orphaned.",
+}
+
+// reconcileEntry is a line of the reconcile report
+type reconcileEntry struct {
+	Status         string   `json:"status"`
+	Source         uint8    `json:"source"`
+	Destination    uint8    `json:"destination"`
+	Nonce          uint64   `json:"nonce"`
+	ResourceId     string   `json:"resourceId"`
+	ExpectedAmount string   `json:"expectedAmount"`
+	ExecutedAmount string   `json:"executedAmount"`
+	Executions     []string `json:"executions"` // Block and transaction of every execution
+}
+
+// collectRouter collects the replayed deposits bound to the destination chain
+type collectRouter struct {
+	dest     msg.ChainId
+	deposits []msg.Message
+}
+
+func (r *collectRouter) Send(m msg.Message) error {
+	if m.Destination == r.dest {
+		r.deposits = append(r.deposits, m)
+	}
+	return nil
+}
+
+func amountString(amount *big.Int) string {
+	if amount == nil {
+		return ""
+	}
+	return amount.String()
+}
+
+// reconcileDeposits pairs every deposit with its executions by source chain and nonce. A deposit whose expected
+// amount can't be computed is reported as amount-mismatched. Executions from other source chains are ignored.
+func reconcileDeposits(source msg.ChainId, dest msg.ChainId, deposits []msg.Message, executions []chains.Execution,
+	expected func(m msg.Message) (*big.Int, error)) []reconcileEntry {
+	executed := make(map[msg.Nonce][]chains.Execution)
+	for _, e := range executions {
+		if e.Source == source {
+			executed[e.Nonce] = append(executed[e.Nonce], e)
+		}
+	}
+
+	var res []reconcileEntry
+	deposited := make(map[msg.Nonce]bool)
+	for _, m := range deposits {
+		deposited[m.DepositNonce] = true
+		entry := reconcileEntry{
+			Source:      uint8(m.Source),
+			Destination: uint8(m.Destination),
+			Nonce:       uint64(m.DepositNonce),
+			ResourceId:  m.ResourceId.Hex(),
+		}
+		amount, err := expected(m)
+		if err != nil {
+			log.Warn("Unable to compute the expected amount", "src", m.Source, "nonce", m.DepositNonce, "err", err)
+		}
+		entry.ExpectedAmount = amountString(amount)
+
+		execs := executed[m.DepositNonce]
+		for _, e := range execs {
+			entry.Executions = append(entry.Executions, strconv.FormatUint(e.Block, 10)+":"+e.Tx)
+		}
+		switch {
+		case len(execs) == 0:
+			entry.Status = StatusMissing
+		case len(execs) > 1:
+			entry.Status = StatusDuplicated
+		default:
+			entry.ExecutedAmount = amountString(execs[0].Amount)
+			if amount == nil || (execs[0].Amount != nil && execs[0].Amount.Cmp(amount) != 0) {
+				entry.Status = StatusMismatched
+			} else {
+				entry.Status = StatusMatched
+			}
+		}
+		res = append(res, entry)
+	}
+
+	var orphans []reconcileEntry
+	for nonce, execs := range executed {
+		if deposited[nonce] {
+			continue
+		}
+		for _, e := range execs {
+			orphans = append(orphans, reconcileEntry{
+				Status:         StatusOrphaned,
+				Source:         uint8(source),
+				Destination:    uint8(dest),
+				Nonce:          uint64(nonce),
+				ExecutedAmount: amountString(e.Amount),
+				Executions:     []string{strconv.FormatUint(e.Block, 10) + ":" + e.Tx},
+			})
+		}
+	}
+	sort.SliceStable(orphans, func(i, j int) bool { return orphans[i].Nonce < orphans[j].Nonce })
+	return append(res, orphans...)
+}
+
+// writeReport writes the entries as CSV or JSON
+func writeReport(w io.Writer, entries []reconcileEntry, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	case "csv":
+		out := csv.NewWriter(w)
+		err := out.Write([]string{"status", "source", "destination", "nonce", "resourceId", "expectedAmount", "executedAmount", "executions"})
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			err = out.Write([]string{
+				e.Status,
+				strconv.FormatUint(uint64(e.Source), 10),
+				strconv.FormatUint(uint64(e.Destination), 10),
+				strconv.FormatUint(e.Nonce, 10),
+				e.ResourceId,
+				e.ExpectedAmount,
+				e.ExecutedAmount,
+				strings.Join(e.Executions, ";"),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		out.Flush()
+		return out.Error()
+	default:
+		return fmt.Errorf("unknown report format: %s", format)
+	}
+}
+
+func reconcile(ctx *cli.Context) error {
+	err := startLogger(ctx)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.GetConfig(ctx)
+	if err != nil {
+		if err.Error() == config.EndPointParseError.Error() {
+			log.Debug("parse config err", err)
+		} else {
+			return err
+		}
+	}
+	ks, insecure := keystorePath(ctx, cfg)
+
+	sourceId := ctx.String(config.ReplayChainFlag.Name)
+	from := ctx.Uint64(config.ReplayFromFlag.Name)
+	to := ctx.Uint64(config.ReplayToFlag.Name)
+	destId := ctx.String(config.ReconcileDestFlag.Name)
+	destFrom := ctx.Uint64(config.ReconcileDestFromFlag.Name)
+	destTo := ctx.Uint64(config.ReconcileDestToFlag.Name)
+	format := ctx.String(config.ReconcileFormatFlag.Name)
+	if to == 0 || from > to {
+		return fmt.Errorf("invalid block range [%d, %d]", from, to)
+	}
+	if destTo == 0 || destFrom > destTo {
+		return fmt.Errorf("invalid destination block range [%d, %d]", destFrom, destTo)
+	}
+	if format != "csv" && format != "json" {
+		return fmt.Errorf("unknown report format: %s", format)
+	}
+
+	sysErr := make(chan error, 1)
+	var source replayChain
+	var dest chains.Auditor
+	var destChainId msg.ChainId
+	for _, chain := range cfg.Chains {
+		if chain.Id != sourceId && chain.Id != destId {
+			continue
+		}
+
+		newChain, err := initializeChain(ctx, chain, ks, insecure, sysErr, nil)
+		if err != nil {
+			return err
+		}
+		defer newChain.Stop()
+
+		if chain.Id == sourceId {
+			c, ok := newChain.(replayChain)
+			if !ok {
+				return fmt.Errorf("chain %s can't be replayed", chain.Name)
+			}
+			source = c
+		}
+		if chain.Id == destId {
+			a, ok := newChain.(chains.Auditor)
+			if !ok {
+				return fmt.Errorf("chain %s can't be audited", chain.Name)
+			}
+			dest = a
+			destChainId = newChain.Id()
+		}
+	}
+	if source == nil {
+		return fmt.Errorf("chain %s not found in the config", sourceId)
+	}
+	if dest == nil {
+		return fmt.Errorf("chain %s not found in the config", destId)
+	}
+
+	log.Info("Collecting deposits", "chain", source.Name(), "from", from, "to", to)
+	r := &collectRouter{dest: destChainId}
+	err = source.Replay(from, to, r)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Collecting executions", "chain", destId, "from", destFrom, "to", destTo)
+	executions, err := dest.Executions(destFrom, destTo)
+	if err != nil {
+		return err
+	}
+
+	entries := reconcileDeposits(source.Id(), destChainId, r.deposits, executions, dest.ExpectedAmount)
+	out := io.Writer(os.Stdout)
+	if path := ctx.String(config.ReconcileOutputFlag.Name); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	return writeReport(out, entries, format)
+}
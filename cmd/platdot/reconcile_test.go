@@ -0,0 +1,87 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/Platdot-network/Platdot/chains"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+func TestReconcileDeposits(t *testing.T) {
+	rId := msg.ResourceIdFromSlice([]byte{1})
+	deposit := func(nonce msg.Nonce, amount int64) msg.Message {
+		return msg.NewMultiSigTransfer(1, 2, nonce, big.NewInt(amount), rId, []byte("alice"))
+	}
+	execution := func(src msg.ChainId, nonce msg.Nonce, amount int64) chains.Execution {
+		return chains.Execution{Source: src, Nonce: nonce, Block: 10, Tx: "0x01", Amount: big.NewInt(amount)}
+	}
+	deposits := []msg.Message{deposit(1, 10), deposit(2, 10), deposit(3, 10), deposit(4, 10), deposit(5, 10)}
+	executions := []chains.Execution{
+		execution(1, 1, 10),
+		execution(1, 3, 10),
+		execution(1, 3, 10),
+		execution(1, 4, 9),
+		execution(1, 5, 10),
+		execution(1, 7, 10),
+		execution(3, 2, 10),
+	}
+	expected := func(m msg.Message) (*big.Int, error) {
+		if m.DepositNonce == 5 {
+			return nil, errors.New("unknown asset")
+		}
+		return new(big.Int).SetBytes(m.Payload[0].([]byte)), nil
+	}
+
+	entries := reconcileDeposits(1, 2, deposits, executions, expected)
+	statuses := []string{StatusMatched, StatusMissing, StatusDuplicated, StatusMismatched, StatusMismatched, StatusOrphaned}
+	if len(entries) != len(statuses) {
+		t.Fatalf("Got %d entries, expected %d", len(entries), len(statuses))
+	}
+	for i, status := range statuses {
+		if entries[i].Status != status {
+			t.Errorf("Entry %d: got %s, expected %s", i, entries[i].Status, status)
+		}
+	}
+	if entries[5].Nonce != 7 {
+		t.Fatalf("Got orphaned nonce %d, expected 7", entries[5].Nonce)
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	entries := []reconcileEntry{{
+		Status:         StatusDuplicated,
+		Source:         1,
+		Destination:    2,
+		Nonce:          3,
+		ExpectedAmount: "10",
+		Executions:     []string{"10:0x01", "11:0x02"},
+	}}
+
+	var buf bytes.Buffer
+	if err := writeReport(&buf, entries, "csv"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || lines[1] != "duplicated,1,2,3,,10,,10:0x01;11:0x02" {
+		t.Fatalf("Unexpected CSV report: %q", buf.String())
+	}
+
+	buf.Reset()
+	if err := writeReport(&buf, entries, "json"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"status": "duplicated"`) {
+		t.Fatalf("Unexpected JSON report: %s", buf.String())
+	}
+
+	if err := writeReport(&buf, entries, "xml"); err == nil {
+		t.Fatal("Expected an error for an unknown format")
+	}
+}
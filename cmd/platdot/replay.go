@@ -0,0 +1,190 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/ChainSafe/log15"
+	"github.com/Platdot-network/Platdot/chains"
+	"github.com/Platdot-network/Platdot/config"
+	"github.com/rjman-ljm/platdot-utils/core"
+	"github.com/rjman-ljm/platdot-utils/msg"
+	"github.com/urfave/cli/v2"
+)
+
+// Time between two checks of the submitted messages
+var SettleInterval = time.Second * 10
+
+var replayFlags = []cli.Flag{
+	config.ReplayChainFlag,
+	config.ReplayFromFlag,
+	config.ReplayToFlag,
+	config.ReplaySubmitFlag,
+	config.ReplayWaitFlag,
+}
+
+var replayCommand = cli.Command{
+	Action: replay,
+	Name:   "replay",
+	Usage:  "replay the deposits of a block range",
+	Flags:  replayFlags,
+	Description: "The replay command runs the listener of a chain over a block range in isolation, the blockstore is left untouched.\n" +
+		"\tTo print the messages it would route: platdot --config config.json replay --chain 1 --from 100 --to 200\n" +
+		"\tTo submit them to their destination chain: platdot --config config.json replay --chain 1 --from 100 --to 200 --submit\n" +
+		"\tMessages already complete on-chain are skipped, use --wait to wait for the submitted ones to complete.\n" +
+		"\tOnly messages to ethereum chains can be submitted. The redemptions of a substrate chain are batched and approved by\n" +
+		"\tthe running relayers, --submit refuses them: restart the relayers from the block of the deposit instead.",
+}
+
+// replayChain is a chain which can be replayed and resolve messages
+type replayChain interface {
+	core.Chain
+	chains.Replayer
+	Resolver() chains.Resolver
+}
+
+// replayRouter prints the replayed messages, and submits them to their destination chain if resolvers are set.
+// The messages to the unsupported chains are refused.
+type replayRouter struct {
+	resolvers   map[msg.ChainId]chains.Resolver
+	unsupported map[msg.ChainId]string // Name of the chains the messages can't be submitted to
+	submitted   []msg.Message
+	refused     []msg.Message
+}
+
+func (r *replayRouter) Send(m msg.Message) error {
+	fmt.Printf("type=%s src=%d dst=%d nonce=%d rId=%s payload=%x\n",
+		m.Type, m.Source, m.Destination, m.DepositNonce, m.ResourceId.Hex(), m.Payload)
+	if r.resolvers == nil {
+		return nil
+	}
+
+	if name, ok := r.unsupported[m.Destination]; ok {
+		r.refused = append(r.refused, m)
+		log.Error("Can't submit to a substrate chain, its redemptions are batched by the running relayers", "chain", name,
+			"src", m.Source, "dst", m.Destination, "nonce", m.DepositNonce)
+		return fmt.Errorf("can't submit to substrate chain %s", name)
+	}
+	w, ok := r.resolvers[m.Destination]
+	if !ok {
+		return fmt.Errorf("unknown destination chainId: %d", m.Destination)
+	}
+	complete, err := w.IsComplete(m)
+	if err != nil {
+		log.Warn("Unable to check the message on its destination chain, not submitting it", "src", m.Source, "dst", m.Destination, "nonce", m.DepositNonce, "err", err)
+		return nil
+	}
+	if complete {
+		log.Info("Message already complete, skipping it", "src", m.Source, "dst", m.Destination, "nonce", m.DepositNonce)
+		return nil
+	}
+
+	// Writers may rewrite the payload, keep the replayed one intact
+	submit := m
+	submit.Payload = append([]interface{}{}, m.Payload...)
+	w.ResolveMessage(submit)
+	r.submitted = append(r.submitted, m)
+	return nil
+}
+
+// settle waits up to timeout for the submitted messages to complete on their destination chain
+func (r *replayRouter) settle(timeout time.Duration) {
+	pending := r.submitted
+	deadline := time.Now().Add(timeout)
+	for len(pending) > 0 && time.Now().Before(deadline) {
+		time.Sleep(SettleInterval)
+
+		var left []msg.Message
+		for _, m := range pending {
+			complete, err := r.resolvers[m.Destination].IsComplete(m)
+			if err != nil || !complete {
+				left = append(left, m)
+			}
+		}
+		pending = left
+	}
+
+	for _, m := range pending {
+		log.Warn("Submitted message not complete yet", "src", m.Source, "dst", m.Destination, "nonce", m.DepositNonce)
+	}
+}
+
+func replay(ctx *cli.Context) error {
+	err := startLogger(ctx)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.GetConfig(ctx)
+	if err != nil {
+		if err.Error() == config.EndPointParseError.Error() {
+			log.Debug("parse config err", err)
+		} else {
+			return err
+		}
+	}
+	ks, insecure := keystorePath(ctx, cfg)
+
+	chainId := ctx.String(config.ReplayChainFlag.Name)
+	from := ctx.Uint64(config.ReplayFromFlag.Name)
+	to := ctx.Uint64(config.ReplayToFlag.Name)
+	submit := ctx.Bool(config.ReplaySubmitFlag.Name)
+	if to == 0 || from > to {
+		return fmt.Errorf("invalid block range [%d, %d]", from, to)
+	}
+
+	// Destination chains are only needed to submit the messages
+	sysErr := make(chan error, 1)
+	r := &replayRouter{}
+	if submit {
+		r.resolvers = make(map[msg.ChainId]chains.Resolver)
+		r.unsupported = make(map[msg.ChainId]string)
+	}
+	var source replayChain
+	for _, chain := range cfg.Chains {
+		if chain.Id != chainId && !submit {
+			continue
+		}
+
+		newChain, err := initializeChain(ctx, chain, ks, insecure, sysErr, nil)
+		if err != nil {
+			return err
+		}
+		defer newChain.Stop()
+
+		c, ok := newChain.(replayChain)
+		if !ok {
+			return fmt.Errorf("chain %s can't be replayed", chain.Name)
+		}
+		if chain.Id == chainId {
+			source = c
+		}
+		// The batches of a substrate writer are only driven by a running relayer, together with the other relayers
+		if submit && chain.Type == "substrate" {
+			r.unsupported[c.Id()] = chain.Name
+		} else if submit {
+			r.resolvers[c.Id()] = c.Resolver()
+		}
+	}
+	if source == nil {
+		return fmt.Errorf("chain %s not found in the config", chainId)
+	}
+
+	log.Info("Replaying blocks", "chain", source.Name(), "from", from, "to", to, "submit", submit)
+	err = source.Replay(from, to, r)
+	if err != nil {
+		return err
+	}
+
+	if submit {
+		log.Info("Replay finished", "submitted", len(r.submitted), "refused", len(r.refused))
+		r.settle(ctx.Duration(config.ReplayWaitFlag.Name))
+	}
+	if len(r.refused) > 0 {
+		return fmt.Errorf("%d messages to substrate chains not submitted, restart the relayers from the block of their deposit instead", len(r.refused))
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Platdot-network/Platdot/chains"
+	"github.com/rjman-ljm/platdot-utils/msg"
+)
+
+type testResolver struct {
+	complete map[msg.Nonce]bool
+	resolved []msg.Message
+}
+
+func (r *testResolver) ResolveMessage(m msg.Message) bool {
+	m.Payload[0] = []byte("rewritten")
+	r.resolved = append(r.resolved, m)
+	return true
+}
+
+func (r *testResolver) IsComplete(m msg.Message) (bool, error) {
+	if m.DepositNonce == 3 {
+		return false, errors.New("unknown")
+	}
+	return r.complete[m.DepositNonce], nil
+}
+
+func TestReplayRouterSkipsComplete(t *testing.T) {
+	w := &testResolver{complete: map[msg.Nonce]bool{1: true}}
+	r := &replayRouter{resolvers: map[msg.ChainId]chains.Resolver{2: w}}
+
+	for nonce := msg.Nonce(1); nonce <= 3; nonce++ {
+		err := r.Send(msg.Message{Source: 1, Destination: 2, DepositNonce: nonce, Payload: []interface{}{[]byte("amount")}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(w.resolved) != 1 || w.resolved[0].DepositNonce != 2 {
+		t.Fatalf("Got: %v Expected: only nonce 2 resolved", w.resolved)
+	}
+	if len(r.submitted) != 1 || string(r.submitted[0].Payload[0].([]byte)) != "amount" {
+		t.Fatalf("Replayed payload should be left intact: %v", r.submitted)
+	}
+
+	err := r.Send(msg.Message{Source: 1, Destination: 5, DepositNonce: 4})
+	if err == nil {
+		t.Fatal("Expected an error for an unknown destination")
+	}
+}
+
+func TestReplayRouterRefusesSubstrate(t *testing.T) {
+	w := &testResolver{}
+	r := &replayRouter{resolvers: map[msg.ChainId]chains.Resolver{2: w}, unsupported: map[msg.ChainId]string{3: "chainx"}}
+
+	err := r.Send(msg.Message{Source: 1, Destination: 3, DepositNonce: 1, Payload: []interface{}{[]byte("amount")}})
+	if err == nil {
+		t.Fatal("Expected an error for a substrate destination")
+	}
+	if len(r.refused) != 1 || len(r.submitted) != 0 || len(w.resolved) != 0 {
+		t.Fatalf("Got: %v %v Expected: the message refused", r.refused, r.submitted)
+	}
+}
+
+func TestReplayRouterPrintOnly(t *testing.T) {
+	r := &replayRouter{}
+	err := r.Send(msg.Message{Source: 1, Destination: 2, DepositNonce: 1})
+	if err != nil || len(r.submitted) != 0 {
+		t.Fatalf("Got: %v %v Expected: nothing submitted", err, r.submitted)
+	}
+}
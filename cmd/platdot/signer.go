@@ -0,0 +1,129 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	log "github.com/ChainSafe/log15"
+	"github.com/Platdot-network/Platdot/chains/ethlike"
+	"github.com/Platdot-network/Platdot/chains/substrate"
+	"github.com/Platdot-network/Platdot/config"
+	"github.com/Platdot-network/Platdot/connections/signer"
+	"github.com/urfave/cli/v2"
+)
+
+var signerCommand = cli.Command{
+	Action: serveSigner,
+	Name:   "signer",
+	Usage:  "serve the relayer keys to the relayers configured with a remote signer",
+	Description: "The signer command decrypts the keys of the configured chains from the keystore and signs for the relayers,\n" +
+		"\tso that the keys never enter the relayer process.\n" +
+		"\tTo serve the keys: platdot --config config.json signer --socket /run/platdot/signer.sock --tokenFile /etc/platdot/signer.token\n" +
+		"\tThe relayer is then configured with the options \"signer\": \"unix:///run/platdot/signer.sock\" and\n" +
+		"\t\"signerTokenFile\": \"/etc/platdot/signer.token\" on each chain.",
+	Flags: []cli.Flag{
+		config.SignerSocketFlag,
+		config.SignerListenFlag,
+		config.SignerTokenFileFlag,
+	},
+}
+
+func serveSigner(ctx *cli.Context) error {
+	err := startLogger(ctx)
+	if err != nil {
+		return err
+	}
+
+	tokenFile := ctx.String(config.SignerTokenFileFlag.Name)
+	if tokenFile == "" {
+		return fmt.Errorf("--%s is required", config.SignerTokenFileFlag.Name)
+	}
+	data, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return fmt.Errorf("empty token in %s", tokenFile)
+	}
+
+	cfg, err := config.GetConfig(ctx)
+	if err != nil {
+		if err.Error() == config.EndPointParseError.Error() {
+			log.Debug("parse config err", err)
+		} else {
+			return err
+		}
+	}
+	ks, insecure := keystorePath(ctx, cfg)
+
+	var keys []signer.Key
+	for _, chain := range cfg.Chains {
+		chainConfig, err := newChainConfig(ctx, chain, ks, insecure)
+		if err != nil {
+			return err
+		}
+		var key signer.Key
+		switch chain.Type {
+		case "ethereum":
+			key, err = ethlike.LocalKey(chainConfig)
+		case "substrate":
+			key, err = substrate.LocalKey(chainConfig)
+		default:
+			err = errors.New("unrecognized Chain Type")
+		}
+		if err != nil {
+			return err
+		}
+		log.Info("Serving key", "chain", chain.Name, "address", key.Address, "scheme", key.Signer.Scheme())
+		keys = append(keys, key)
+	}
+
+	var l net.Listener
+	socket, listen := ctx.String(config.SignerSocketFlag.Name), ctx.String(config.SignerListenFlag.Name)
+	switch {
+	case socket != "" && listen == "":
+		l, err = signer.ListenUnix(socket)
+	case listen != "" && socket == "":
+		var host string
+		host, _, err = net.SplitHostPort(listen)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return fmt.Errorf("--%s must be a loopback address", config.SignerListenFlag.Name)
+		}
+		l, err = net.Listen("tcp", listen)
+	default:
+		return fmt.Errorf("one of --%s or --%s is required", config.SignerSocketFlag.Name, config.SignerListenFlag.Name)
+	}
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{Handler: signer.Handler(token, keys, log.Root())}
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigc
+		log.Info("Interrupt received, shutting down now.")
+		server.Close()
+	}()
+
+	log.Info("Signer listening", "address", l.Addr())
+	err = server.Serve(l)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,119 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/ChainSafe/log15"
+	"github.com/Platdot-network/Platdot/chains/lifecycle"
+	"github.com/Platdot-network/Platdot/config"
+	"github.com/rjman-ljm/platdot-utils/msg"
+	"github.com/urfave/cli/v2"
+)
+
+var transfersCommand = cli.Command{
+	Name:  "transfers",
+	Usage: "query the lifecycle of the transfers",
+	Description: "The transfers command reads the states recorded by the relayer for every transfer.\n" +
+		"\tTo list the transfers: platdot --config config.json transfers list --chain 2\n" +
+		"\tTo list the failed transfers: platdot --config config.json transfers list --chain 2 --state Failed\n" +
+		"\tTo show the transitions of a transfer: platdot --config config.json transfers show --chain 2 --source 1 --dest 2 --nonce 42\n" +
+		"\tThe chains of a relayer share the lifecycle directory of their blockstore unless configured otherwise.",
+	Subcommands: []*cli.Command{
+		{
+			Action: listTransfers,
+			Name:   "list",
+			Usage:  "list the transfers and their state",
+			Flags:  []cli.Flag{config.TransfersChainFlag, config.TransfersStateFlag},
+		},
+		{
+			Action: showTransfer,
+			Name:   "show",
+			Usage:  "show the transitions of a transfer",
+			Flags: []cli.Flag{
+				config.TransfersChainFlag,
+				config.TransfersSourceFlag,
+				config.TransfersDestFlag,
+				config.TransfersNonceFlag,
+			},
+		},
+	},
+}
+
+// transfersDir returns the lifecycle directory of the chain given by the flags
+func transfersDir(ctx *cli.Context) (string, error) {
+	err := startLogger(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	cfg, err := config.GetConfig(ctx)
+	if err != nil {
+		if err.Error() == config.EndPointParseError.Error() {
+			log.Debug("parse config err", err)
+		} else {
+			return "", err
+		}
+	}
+	ks, insecure := keystorePath(ctx, cfg)
+
+	chainId := ctx.String(config.TransfersChainFlag.Name)
+	for _, chain := range cfg.Chains {
+		if chain.Id != chainId {
+			continue
+		}
+		chainConfig, err := newChainConfig(ctx, chain, ks, insecure)
+		if err != nil {
+			return "", err
+		}
+		return lifecycle.ParseDir(chainConfig.Opts, chainConfig.BlockstorePath)
+	}
+	return "", fmt.Errorf("chain %s not found in the config", chainId)
+}
+
+func listTransfers(ctx *cli.Context) error {
+	dir, err := transfersDir(ctx)
+	if err != nil {
+		return err
+	}
+	transfers, err := lifecycle.LoadTransfers(dir)
+	if err != nil {
+		return err
+	}
+	state := ctx.String(config.TransfersStateFlag.Name)
+	for _, t := range transfers {
+		if state != "" && !strings.EqualFold(string(t.State), state) {
+			continue
+		}
+		fmt.Printf("state=%s src=%d dst=%d nonce=%d rId=%s amount=%s updated=%s\n",
+			t.State, t.Source, t.Destination, t.Nonce, t.ResourceId, t.Amount, t.Updated().Format("2006-01-02 15:04:05"))
+	}
+	return nil
+}
+
+func showTransfer(ctx *cli.Context) error {
+	dir, err := transfersDir(ctx)
+	if err != nil {
+		return err
+	}
+	key := lifecycle.Key{
+		Source:      msg.ChainId(ctx.Uint(config.TransfersSourceFlag.Name)),
+		Destination: msg.ChainId(ctx.Uint(config.TransfersDestFlag.Name)),
+		Nonce:       msg.Nonce(ctx.Uint64(config.TransfersNonceFlag.Name)),
+	}
+	t, err := lifecycle.Open(dir).Get(key)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return fmt.Errorf("no transfer from chain %d to chain %d with nonce %d", key.Source, key.Destination, key.Nonce)
+	}
+	fmt.Printf("state=%s src=%d dst=%d nonce=%d rId=%s amount=%s\n", t.State, t.Source, t.Destination, t.Nonce, t.ResourceId, t.Amount)
+	for _, tr := range t.Transitions {
+		fmt.Printf("  %s %s chain=%s tx=%s note=%s\n", tr.Time.Format("2006-01-02 15:04:05"), tr.State, tr.Chain, tr.TxHash, tr.Note)
+	}
+	return nil
+}
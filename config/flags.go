@@ -101,3 +101,108 @@ var (
 		Usage: "Applies a predetermined test keystore to the chains.",
 	}
 )
+
+// Replay subcommand flags
+var (
+	ReplayChainFlag = &cli.StringFlag{
+		Name:  "chain",
+		Usage: "Id of the chain to replay",
+	}
+	ReplayFromFlag = &cli.Uint64Flag{
+		Name:  "from",
+		Usage: "First block to replay",
+	}
+	ReplayToFlag = &cli.Uint64Flag{
+		Name:  "to",
+		Usage: "Last block to replay",
+	}
+	ReplaySubmitFlag = &cli.BoolFlag{
+		Name:  "submit",
+		Usage: "Submit the replayed messages to their ethereum destination chain, skipping the ones already complete on-chain",
+	}
+	ReplayWaitFlag = &cli.DurationFlag{
+		Name:  "wait",
+		Usage: "Time to wait for the submitted messages to complete on their destination chain",
+	}
+)
+
+// Reconcile subcommand flags, the source chain and its block range are given by the replay flags
+var (
+	ReconcileDestFlag = &cli.StringFlag{
+		Name:  "dest",
+		Usage: "Id of the destination chain of the deposits",
+	}
+	ReconcileDestFromFlag = &cli.Uint64Flag{
+		Name:  "destFrom",
+		Usage: "First block of the destination chain to search for executions",
+	}
+	ReconcileDestToFlag = &cli.Uint64Flag{
+		Name:  "destTo",
+		Usage: "Last block of the destination chain to search for executions",
+	}
+	ReconcileFormatFlag = &cli.StringFlag{
+		Name:  "format",
+		Usage: "Format of the report: csv or json",
+		Value: "csv",
+	}
+	ReconcileOutputFlag = &cli.StringFlag{
+		Name:  "output",
+		Usage: "File to write the report to, standard output by default",
+	}
+)
+
+// Approvals subcommand flags
+var (
+	ApprovalsChainFlag = &cli.StringFlag{
+		Name:  "chain",
+		Usage: "Id of the chain whose writer holds the transfers",
+	}
+	ApprovalsSourceFlag = &cli.UintFlag{
+		Name:  "source",
+		Usage: "Id of the source chain of the transfer",
+	}
+	ApprovalsNonceFlag = &cli.Uint64Flag{
+		Name:  "nonce",
+		Usage: "Deposit nonce of the transfer",
+	}
+)
+
+// Transfers subcommand flags
+var (
+	TransfersChainFlag = &cli.StringFlag{
+		Name:  "chain",
+		Usage: "Id of the chain whose lifecycle directory is read",
+	}
+	TransfersStateFlag = &cli.StringFlag{
+		Name:  "state",
+		Usage: "Only list the transfers in this state, e.g. Parked or Failed",
+	}
+	TransfersSourceFlag = &cli.UintFlag{
+		Name:  "source",
+		Usage: "Id of the source chain of the transfer",
+	}
+	TransfersDestFlag = &cli.UintFlag{
+		Name:  "dest",
+		Usage: "Id of the destination chain of the transfer",
+	}
+	TransfersNonceFlag = &cli.Uint64Flag{
+		Name:  "nonce",
+		Usage: "Deposit nonce of the transfer",
+	}
+)
+
+// Signer subcommand flags
+var (
+	SignerSocketFlag = &cli.StringFlag{
+		Name:  "socket",
+		Usage: "Unix socket to serve the keys on",
+	}
+	SignerListenFlag = &cli.StringFlag{
+		Name:  "listen",
+		Usage: "Loopback address to serve the keys on over http, e.g. 127.0.0.1:8600, instead of a unix socket",
+	}
+	SignerTokenFileFlag = &cli.StringFlag{
+		Name:  "tokenFile",
+		Usage: "File holding the token the relayers authenticate with",
+	}
+)
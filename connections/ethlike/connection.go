@@ -10,10 +10,11 @@ import (
 	"github.com/ChainSafe/log15"
 	"github.com/hacpy/go-ethereum/accounts/abi/bind"
 	ethcommon "github.com/hacpy/go-ethereum/common"
-	ethcrypto "github.com/hacpy/go-ethereum/crypto"
+	ethtypes "github.com/hacpy/go-ethereum/core/types"
 	"github.com/hacpy/go-ethereum/ethclient"
 	"github.com/hacpy/go-ethereum/rpc"
-	"github.com/rjman-ljm/platdot-utils/crypto/secp256k1"
+	"github.com/Platdot-network/Platdot/connections/pool"
+	"github.com/Platdot-network/Platdot/connections/signer"
 	"math/big"
 	"sync"
 	"time"
@@ -33,103 +34,170 @@ type Connection struct {
 	networkId     uint64
 	endpoint      string
 	http          bool
-	kp            *secp256k1.Keypair
+	signer        signer.Signer // Signs the transactions with the relayer key
+	from          ethcommon.Address
 	gasLimit      *big.Int
 	maxGasPrice   *big.Int
 	gasMultiplier *big.Float
 	conn          *ethclient.Client
+	connLock      sync.RWMutex // Locks conn and endpoint while switching endpoints
+	pool          *pool.Pool   // Health of the configured endpoints
+	clients       *endpointClients
 	opts          *bind.TransactOpts
 	callOpts      *bind.CallOpts
 	nonce         uint64
 	optsLock      sync.Mutex
 	log           log15.Logger
 	stop          chan int // All routines should exit when this channel is closed
+	heads         *heads   // Latest head notified by the websocket subscription
+	startOnce     sync.Once
 }
 
 // NewConnection returns an uninitialized connection, must call Connection.Connect() before using.
-func NewConnection(chainId uint64, endpoint string, http bool, kp *secp256k1.Keypair, log log15.Logger, gasLimit, gasPrice *big.Int, gasMultiplier *big.Float) *Connection {
-	return &Connection{
+// Endpoints lagging more than maxLag blocks behind the others are avoided, m may be nil.
+// The transactions are signed by s, which holds the relayer key or asks a signing service for the signatures.
+func NewConnection(chainId uint64, endpoints []string, http bool, s signer.Signer, log log15.Logger, gasLimit, gasPrice *big.Int, gasMultiplier *big.Float, maxLag uint64, m *pool.Metrics) *Connection {
+	c := &Connection{
 		networkId:     chainId,
-		endpoint:      endpoint,
 		http:          http,
-		kp:            kp,
+		signer:        s,
+		from:          signer.EthAddress(s),
 		gasLimit:      gasLimit,
 		maxGasPrice:   gasPrice,
 		gasMultiplier: gasMultiplier,
 		log:           log,
 		stop:          make(chan int),
+		heads:         newHeads(),
 	}
+	if len(endpoints) != 0 {
+		c.endpoint = endpoints[0]
+	}
+	c.clients = newEndpointClients(http)
+	c.pool = pool.NewPool(endpoints, c.clients.probe, maxLag, m)
+	return c
 }
 
 func (c *Connection) GetEndPoint() string {
+	c.connLock.RLock()
+	defer c.connLock.RUnlock()
 	return c.endpoint
 }
 
-func (c *Connection) Reconnect(endpoint string) error {
-	c.endpoint = endpoint
-	err := c.Connect()
+// Reconnect connects to the best endpoint other than the current one
+func (c *Connection) Reconnect() error {
+	return c.switchTo(c.pool.Failover(c.GetEndPoint()))
+}
+
+// Connect checks the health of the endpoints and starts the ethereum WS connection to the best one. The
+// endpoints are checked in the background afterwards, and the connection switches away from unhealthy or
+// lagging endpoints.
+func (c *Connection) Connect() error {
+	c.pool.Check()
+	endpoint, ok := c.pool.Best()
+	if !ok {
+		endpoint = c.GetEndPoint()
+	}
+	err := c.switchTo(endpoint)
 	if err != nil {
 		return err
 	}
+
+	c.startOnce.Do(func() {
+		go c.pool.Run(c.stop)
+		go c.pool.Follow(c.GetEndPoint, c.switchTo, c.stop)
+		// Follow the new heads over websocket, the subscription survives reconnections
+		if !c.http {
+			go c.followHeads()
+		}
+	})
 	return nil
 }
 
-// Connect starts the ethereum WS connection
-func (c *Connection) Connect() error {
-	//c.log.Info("Connecting to chain...", "url", c.endpoint)
+// switchTo connects to the endpoint, waiting for the transaction in flight if any
+func (c *Connection) switchTo(endpoint string) error {
+	c.log.Info("Connecting to chain...", "url", endpoint)
+	client, err := c.dial(endpoint)
+	if err != nil {
+		return err
+	}
+
+	// Construct tx opts, call opts, and nonce mechanism
+	opts, _, err := newTransactOpts(client, c.signer, c.from, big.NewInt(0), c.gasLimit, c.maxGasPrice)
+	if err != nil {
+		client.Close()
+		return err
+	}
+
+	c.optsLock.Lock()
+	c.connLock.Lock()
+	old := c.conn
+	c.conn = client
+	c.endpoint = endpoint
+	c.opts = opts
+	c.nonce = 0
+	c.callOpts = &bind.CallOpts{From: c.from}
+	c.connLock.Unlock()
+	c.optsLock.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// dial starts a http or ws client to the endpoint
+func (c *Connection) dial(endpoint string) (*ethclient.Client, error) {
 	var rpcClient *rpc.Client
 	var err error
 
 	// Start http or ws client
 	if c.http {
-		rpcClient, err = rpc.DialHTTP(c.endpoint)
+		rpcClient, err = rpc.DialHTTP(endpoint)
 	} else {
-		rpcClient, err = rpc.DialWebsocket(context.Background(), c.endpoint, "/ws")
+		rpcClient, err = rpc.DialWebsocket(context.Background(), endpoint, "/ws")
 	}
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	c.conn = ethclient.NewClient(rpcClient)
+	client := ethclient.NewClient(rpcClient)
 	/// Set rpc chainId
-	c.conn.SetChainID(c.networkId)
+	client.SetChainID(c.networkId)
 	switch c.networkId {
 	case ChainIdAlayaMainNet:
-		c.conn.SetChainName("alaya")
+		client.SetChainName("alaya")
 	case ChainIdAlayaTestNet:
-		c.conn.SetChainName("alaya-test")
+		client.SetChainName("alaya-test")
 	case ChainIdPlatONTestNet:
-		c.conn.SetChainName("platon")
+		client.SetChainName("platon")
 	case ChainIdPlatONMainNet:
-		c.conn.SetChainName("platon")
+		client.SetChainName("platon")
 	default:
-		c.conn.SetChainName("alaya")
-	}
-
-	// Construct tx opts, call opts, and nonce mechanism
-	opts, _, err := c.newTransactOpts(big.NewInt(0), c.gasLimit, c.maxGasPrice)
-	if err != nil {
-		return err
+		client.SetChainName("alaya")
 	}
-	c.opts = opts
-	c.nonce = 0
-	c.callOpts = &bind.CallOpts{From: c.kp.CommonAddress()}
-	return nil
+	return client, nil
 }
 
-// newTransactOpts builds the TransactOpts for the connection's keypair.
-func (c *Connection) newTransactOpts(value, gasLimit, gasPrice *big.Int) (*bind.TransactOpts, uint64, error) {
-	privateKey := c.kp.PrivateKey()
-	address := ethcrypto.PubkeyToAddress(privateKey.PublicKey)
-
-	nonce, err := c.conn.PendingNonceAt(context.Background(), address)
+// newTransactOpts builds the TransactOpts signing the transactions of the address with the signer.
+func newTransactOpts(client *ethclient.Client, s signer.Signer, address ethcommon.Address, value, gasLimit, gasPrice *big.Int) (*bind.TransactOpts, uint64, error) {
+	nonce, err := client.PendingNonceAt(context.Background(), address)
 	if err != nil {
 		return nil, 0, err
 	}
 
-	auth, err := bind.NewKeyedTransactorWithChainID(privateKey, big.NewInt(int64(c.conn.GetChainID())))
-	if err != nil {
-		return nil, 0, err
+	txSigner := ethtypes.LatestSignerForChainID(big.NewInt(int64(client.GetChainID())))
+	auth := &bind.TransactOpts{
+		From: address,
+		Signer: func(from ethcommon.Address, tx *ethtypes.Transaction) (*ethtypes.Transaction, error) {
+			if from != address {
+				return nil, bind.ErrNotAuthorized
+			}
+			sig, err := s.Sign(txSigner.Hash(tx).Bytes())
+			if err != nil {
+				return nil, err
+			}
+			return tx.WithSignature(txSigner, sig)
+		},
 	}
 
 	auth.Nonce = big.NewInt(int64(nonce))
@@ -141,24 +209,55 @@ func (c *Connection) newTransactOpts(value, gasLimit, gasPrice *big.Int) (*bind.
 	return auth, nonce, nil
 }
 
-func (c *Connection) Keypair() *secp256k1.Keypair {
-	return c.kp
+// Address returns the address of the relayer key
+func (c *Connection) Address() ethcommon.Address {
+	return c.from
 }
 
+// Client returns the client of the current endpoint
 func (c *Connection) Client() *ethclient.Client {
+	c.connLock.RLock()
+	defer c.connLock.RUnlock()
 	return c.conn
 }
 
+// Backend returns a contract backend which always calls the current endpoint, contracts bound to the client
+// itself would keep calling the endpoint they were bound to
+func (c *Connection) Backend() bind.ContractBackend {
+	return &backend{c}
+}
+
+// Confirm checks that at least quorum endpoints, counting the current one, return expected for fetch. expected is
+// computed by the caller from the data it acts on, see pool.ConfirmWithSource. It returns a pool.DisagreementError if
+// any endpoint returns another value.
+func (c *Connection) Confirm(quorum int, expected []byte, fetch func(client *ethclient.Client) ([]byte, error)) error {
+	if quorum <= 1 {
+		return nil
+	}
+	return c.pool.ConfirmWithSource(c.GetEndPoint(), quorum, expected, func(endpoint string) ([]byte, error) {
+		return c.clients.call(endpoint, fetch)
+	})
+}
+
+// EndpointStatus returns the health of the configured endpoints
+func (c *Connection) EndpointStatus() []pool.Status {
+	return c.pool.Status()
+}
+
 func (c *Connection) Opts() *bind.TransactOpts {
+	c.connLock.RLock()
+	defer c.connLock.RUnlock()
 	return c.opts
 }
 
 func (c *Connection) CallOpts() *bind.CallOpts {
+	c.connLock.RLock()
+	defer c.connLock.RUnlock()
 	return c.callOpts
 }
 
 func (c *Connection) SafeEstimateGas(ctx context.Context) (*big.Int, error) {
-	suggestedGasPrice, err := c.conn.SuggestGasPrice(context.TODO())
+	suggestedGasPrice, err := c.Client().SuggestGasPrice(context.TODO())
 
 	if err != nil {
 		return nil, err
@@ -197,7 +296,7 @@ func (c *Connection) LockAndUpdateOpts() error {
 	}
 	c.opts.GasPrice = gasPrice
 
-	nonce, err := c.conn.PendingNonceAt(context.Background(), c.opts.From)
+	nonce, err := c.Client().PendingNonceAt(context.Background(), c.opts.From)
 	if err != nil {
 		c.optsLock.Unlock()
 		return err
@@ -210,9 +309,13 @@ func (c *Connection) UnlockOpts() {
 	c.optsLock.Unlock()
 }
 
-// LatestBlock returns the latest block from the current chain
+// LatestBlock returns the latest block from the current chain, as notified by the new heads subscription if it runs
 func (c *Connection) LatestBlock() (*big.Int, error) {
-	header, err := c.conn.HeaderByNumber(context.Background(), nil)
+	if head, live := c.heads.latest(); live {
+		return head, nil
+	}
+
+	header, err := c.Client().HeaderByNumber(context.Background(), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -221,7 +324,7 @@ func (c *Connection) LatestBlock() (*big.Int, error) {
 
 // EnsureHasBytecode asserts if contract code exists at the specified address
 func (c *Connection) EnsureHasBytecode(addr ethcommon.Address) error {
-	code, err := c.conn.CodeAt(context.Background(), addr, nil)
+	code, err := c.Client().CodeAt(context.Background(), addr, nil)
 	if err != nil {
 		return err
 	}
@@ -232,14 +335,15 @@ func (c *Connection) EnsureHasBytecode(addr ethcommon.Address) error {
 	return nil
 }
 
-// WaitForBlock will poll for the block number until the current block is equal or greater.
-// If delay is provided it will wait until currBlock - delay = targetBlock
+// WaitForBlock will wait for the block number until the current block is equal or greater, woken up by
+// the new heads subscription if it runs. If delay is provided it will wait until currBlock - delay = targetBlock
 func (c *Connection) WaitForBlock(targetBlock *big.Int, delay *big.Int) error {
 	for {
 		select {
 		case <-c.stop:
 			return errors.New("connection terminated")
 		default:
+			next := c.heads.next()
 			currBlock, err := c.LatestBlock()
 			if err != nil {
 				return err
@@ -254,7 +358,12 @@ func (c *Connection) WaitForBlock(targetBlock *big.Int, delay *big.Int) error {
 				return nil
 			}
 			c.log.Trace("Block not ready, waiting", "target", targetBlock, "current", currBlock, "delay", delay)
-			time.Sleep(BlockRetryInterval)
+			select {
+			case <-next:
+			case <-time.After(BlockRetryInterval):
+			case <-c.stop:
+				return errors.New("connection terminated")
+			}
 			continue
 		}
 	}
@@ -262,8 +371,8 @@ func (c *Connection) WaitForBlock(targetBlock *big.Int, delay *big.Int) error {
 
 // Close terminates the client connection and stops any running routines
 func (c *Connection) Close() {
-	if c.conn != nil {
-		c.conn.Close()
+	if client := c.Client(); client != nil {
+		client.Close()
 	}
 	close(c.stop)
 }
@@ -0,0 +1,129 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package ethlike
+
+import (
+	"context"
+	"math/big"
+	"sync"
+
+	eth "github.com/hacpy/go-ethereum"
+	ethcommon "github.com/hacpy/go-ethereum/common"
+	ethtypes "github.com/hacpy/go-ethereum/core/types"
+	"github.com/hacpy/go-ethereum/ethclient"
+	"github.com/hacpy/go-ethereum/rpc"
+)
+
+// endpointClients are clients to every configured endpoint, used for the health checks and to confirm deposits.
+// Clients failing a call are dialed again on the next use.
+type endpointClients struct {
+	http    bool
+	lock    sync.Mutex
+	clients map[string]*ethclient.Client
+}
+
+func newEndpointClients(http bool) *endpointClients {
+	return &endpointClients{http: http, clients: make(map[string]*ethclient.Client)}
+}
+
+func (e *endpointClients) get(endpoint string) (*ethclient.Client, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if client, ok := e.clients[endpoint]; ok {
+		return client, nil
+	}
+
+	var rpcClient *rpc.Client
+	var err error
+	if e.http {
+		rpcClient, err = rpc.DialHTTP(endpoint)
+	} else {
+		rpcClient, err = rpc.DialWebsocket(context.Background(), endpoint, "/ws")
+	}
+	if err != nil {
+		return nil, err
+	}
+	client := ethclient.NewClient(rpcClient)
+	e.clients[endpoint] = client
+	return client, nil
+}
+
+func (e *endpointClients) drop(endpoint string, client *ethclient.Client) {
+	e.lock.Lock()
+	if e.clients[endpoint] == client {
+		delete(e.clients, endpoint)
+	}
+	e.lock.Unlock()
+	client.Close()
+}
+
+// call runs f with the client of the endpoint
+func (e *endpointClients) call(endpoint string, f func(client *ethclient.Client) ([]byte, error)) ([]byte, error) {
+	client, err := e.get(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	res, err := f(client)
+	if err != nil {
+		e.drop(endpoint, client)
+		return nil, err
+	}
+	return res, nil
+}
+
+// probe returns the latest head of the endpoint
+func (e *endpointClients) probe(endpoint string) (uint64, error) {
+	res, err := e.call(endpoint, func(client *ethclient.Client) ([]byte, error) {
+		header, err := client.HeaderByNumber(context.Background(), nil)
+		if err != nil {
+			return nil, err
+		}
+		return header.Number.Bytes(), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return new(big.Int).SetBytes(res).Uint64(), nil
+}
+
+// backend forwards the calls of bound contracts to the client of the current endpoint
+type backend struct {
+	conn *Connection
+}
+
+func (b *backend) CodeAt(ctx context.Context, contract ethcommon.Address, blockNumber *big.Int) ([]byte, error) {
+	return b.conn.Client().CodeAt(ctx, contract, blockNumber)
+}
+
+func (b *backend) CallContract(ctx context.Context, call eth.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	return b.conn.Client().CallContract(ctx, call, blockNumber)
+}
+
+func (b *backend) PendingCodeAt(ctx context.Context, account ethcommon.Address) ([]byte, error) {
+	return b.conn.Client().PendingCodeAt(ctx, account)
+}
+
+func (b *backend) PendingNonceAt(ctx context.Context, account ethcommon.Address) (uint64, error) {
+	return b.conn.Client().PendingNonceAt(ctx, account)
+}
+
+func (b *backend) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return b.conn.Client().SuggestGasPrice(ctx)
+}
+
+func (b *backend) EstimateGas(ctx context.Context, call eth.CallMsg) (uint64, error) {
+	return b.conn.Client().EstimateGas(ctx, call)
+}
+
+func (b *backend) SendTransaction(ctx context.Context, tx *ethtypes.Transaction) error {
+	return b.conn.Client().SendTransaction(ctx, tx)
+}
+
+func (b *backend) FilterLogs(ctx context.Context, query eth.FilterQuery) ([]ethtypes.Log, error) {
+	return b.conn.Client().FilterLogs(ctx, query)
+}
+
+func (b *backend) SubscribeFilterLogs(ctx context.Context, query eth.FilterQuery, ch chan<- ethtypes.Log) (eth.Subscription, error) {
+	return b.conn.Client().SubscribeFilterLogs(ctx, query, ch)
+}
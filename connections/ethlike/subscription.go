@@ -0,0 +1,303 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package ethlike
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"time"
+
+	eth "github.com/hacpy/go-ethereum"
+	ethtypes "github.com/hacpy/go-ethereum/core/types"
+)
+
+// Time between two attempts to subscribe after a subscription failed or dropped
+var ResubscribeInterval = time.Second * 30
+
+// Number of blocks below the head whose logs are kept by a LogSubscription
+var LogRetention uint64 = 1000
+
+// heads is the latest head notified by the new heads subscription
+type heads struct {
+	lock    sync.RWMutex
+	number  *big.Int
+	live    bool          // Whether the subscription is running
+	updated chan struct{} // Closed and replaced on every new head
+}
+
+func newHeads() *heads {
+	return &heads{updated: make(chan struct{})}
+}
+
+// latest returns the latest head, and whether the subscription is running
+func (h *heads) latest() (*big.Int, bool) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	if !h.live || h.number == nil {
+		return nil, false
+	}
+	return new(big.Int).Set(h.number), true
+}
+
+// set records a new head and wakes up all waiters
+func (h *heads) set(number *big.Int) {
+	h.lock.Lock()
+	h.number = new(big.Int).Set(number)
+	close(h.updated)
+	h.updated = make(chan struct{})
+	h.lock.Unlock()
+}
+
+func (h *heads) setLive(live bool) {
+	h.lock.Lock()
+	h.live = live
+	if !live {
+		h.number = nil
+	}
+	h.lock.Unlock()
+}
+
+// next returns a channel closed on the next head
+func (h *heads) next() <-chan struct{} {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.updated
+}
+
+// followHeads keeps a new heads subscription running until the connection is closed
+func (c *Connection) followHeads() {
+	for {
+		ch := make(chan *ethtypes.Header)
+		sub, err := c.Client().SubscribeNewHead(context.Background(), ch)
+		if err != nil {
+			c.log.Warn("New heads subscription failed, polling instead", "err", err)
+		} else {
+			c.log.Debug("Subscribed to new heads")
+			if !c.consumeHeads(sub, ch) {
+				return
+			}
+		}
+
+		select {
+		case <-c.stop:
+			return
+		case <-time.After(ResubscribeInterval):
+		}
+	}
+}
+
+// consumeHeads records the notified heads until the subscription drops, it returns false once the connection is closed
+func (c *Connection) consumeHeads(sub eth.Subscription, ch <-chan *ethtypes.Header) bool {
+	defer sub.Unsubscribe()
+	defer c.heads.setLive(false)
+	for {
+		select {
+		case <-c.stop:
+			return false
+		case header := <-ch:
+			c.heads.set(header.Number)
+			c.heads.setLive(true)
+		case err := <-sub.Err():
+			c.log.Warn("New heads subscription dropped, polling instead", "err", err)
+			return true
+		}
+	}
+}
+
+// LogSubscription buffers the logs matching a query. The buffered logs of a block are only complete if the
+// subscription ran without interruption since before the block, otherwise the logs must be filtered. When the
+// subscription starts, the logs of the blocks after the last block processed by the reader are backfilled.
+//
+// The logs are notified in block order, but not in order with the heads: the logs of a block may arrive after the
+// next head. A block is only complete once a log of a later block arrived, or once it is confirmations below the head.
+type LogSubscription struct {
+	conn          *Connection
+	query         eth.FilterQuery
+	confirmations uint64
+	lock          sync.Mutex
+	from          *big.Int                  // First block covered by the running subscription, nil when not running
+	complete      uint64                    // Last block whose logs have all arrived, meaningful when running
+	logs          map[uint64][]ethtypes.Log // Buffered logs, keyed by block number
+	processed     *big.Int                  // Last block processed by the reader, nil if unknown
+}
+
+// SubscribeLogs follows the logs matching the query until the connection is closed, the logs of a block without a
+// later log are trusted once the block has the given confirmations. Over http, the subscription never runs and all
+// logs must be filtered.
+func (c *Connection) SubscribeLogs(query eth.FilterQuery, confirmations uint64) *LogSubscription {
+	s := &LogSubscription{conn: c, query: query, confirmations: confirmations, logs: make(map[uint64][]ethtypes.Log)}
+	if !c.http {
+		go s.follow()
+	}
+	return s
+}
+
+// Logs returns the buffered logs of a block, and false if the subscription doesn't cover the block
+func (s *LogSubscription) Logs(block *big.Int) ([]ethtypes.Log, bool) {
+	if s == nil {
+		return nil, false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.from == nil || block.Cmp(s.from) < 0 {
+		return nil, false
+	}
+	if block.Uint64() > s.complete {
+		// Logs of the block may still be on their way, unless it is confirmed
+		confirmations := s.confirmations
+		if confirmations == 0 {
+			confirmations = 1
+		}
+		head, live := s.conn.heads.latest()
+		if !live || new(big.Int).Add(block, new(big.Int).SetUint64(confirmations)).Cmp(head) > 0 {
+			return nil, false
+		}
+	}
+	return append([]ethtypes.Log{}, s.logs[block.Uint64()]...), true
+}
+
+// Processed records the last block processed by the reader, the subscription backfills the blocks after it when it
+// starts
+func (s *LogSubscription) Processed(block *big.Int) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.processed = new(big.Int).Set(block)
+}
+
+func (s *LogSubscription) follow() {
+	for {
+		ch := make(chan ethtypes.Log)
+		sub, err := s.conn.Client().SubscribeFilterLogs(context.Background(), s.query, ch)
+		if err != nil {
+			s.conn.log.Warn("Log subscription failed, filtering instead", "err", err)
+		} else if !s.consume(sub, ch) {
+			return
+		}
+
+		select {
+		case <-s.conn.stop:
+			return
+		case <-time.After(ResubscribeInterval):
+		}
+	}
+}
+
+// consume buffers the logs until the subscription drops, it returns false once the connection is closed
+func (s *LogSubscription) consume(sub eth.Subscription, ch <-chan ethtypes.Log) bool {
+	defer sub.Unsubscribe()
+	defer s.reset(nil)
+
+	// The head is read from the node, the head notified by the heads subscription may be stale after a reconnection.
+	// The blocks after the head are covered by the subscription, the ones up to it are backfilled.
+	header, err := s.conn.Client().HeaderByNumber(context.Background(), nil)
+	if err != nil {
+		s.conn.log.Warn("Log subscription dropped, filtering instead", "err", err)
+		return true
+	}
+	from, logs, err := s.backfill(header.Number)
+	if err != nil {
+		s.conn.log.Warn("Log subscription backfill failed, filtering instead", "err", err)
+		return true
+	}
+	s.reset(from)
+	for _, log := range logs {
+		s.add(log)
+	}
+
+	for {
+		select {
+		case <-s.conn.stop:
+			return false
+		case log := <-ch:
+			s.add(log)
+		case err := <-sub.Err():
+			s.conn.log.Warn("Log subscription dropped, filtering instead", "err", err)
+			return true
+		}
+	}
+}
+
+// backfill filters the logs of the blocks after the last processed one up to the head, within the retention. It
+// returns the first block it covers, the block after the head if the last processed block is unknown.
+func (s *LogSubscription) backfill(head *big.Int) (*big.Int, []ethtypes.Log, error) {
+	s.lock.Lock()
+	from := new(big.Int).Add(head, big.NewInt(1))
+	if s.processed != nil && s.processed.Cmp(head) < 0 {
+		from.Add(s.processed, big.NewInt(1))
+	}
+	s.lock.Unlock()
+	if oldest := new(big.Int).Sub(head, new(big.Int).SetUint64(LogRetention)); from.Cmp(oldest) < 0 {
+		from = oldest
+	}
+	if from.Cmp(head) > 0 {
+		return from, nil, nil
+	}
+
+	query := s.query
+	query.FromBlock = from
+	query.ToBlock = head
+	logs, err := s.conn.Client().FilterLogs(context.Background(), query)
+	if err != nil {
+		return nil, nil, err
+	}
+	return from, logs, nil
+}
+
+// reset drops the buffered logs, the subscription covers the blocks from `from` on. The blocks before `from` are
+// backfilled, so that they are complete.
+func (s *LogSubscription) reset(from *big.Int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.from = from
+	s.complete = 0
+	if from != nil && from.Sign() > 0 {
+		s.complete = from.Uint64() - 1
+	}
+	s.logs = make(map[uint64][]ethtypes.Log)
+}
+
+// add buffers a log, or drops it if it was reverted by a reorganisation. A log completes the blocks before its own,
+// a reverted log makes its block incomplete until the logs of the new chain arrive.
+func (s *LogSubscription) add(log ethtypes.Log) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if log.BlockNumber > 0 && (log.Removed || log.BlockNumber-1 > s.complete) {
+		s.complete = log.BlockNumber - 1
+	}
+	logs := s.logs[log.BlockNumber]
+	if log.Removed {
+		for i, l := range logs {
+			if l.TxHash == log.TxHash && l.Index == log.Index {
+				s.logs[log.BlockNumber] = append(logs[:i:i], logs[i+1:]...)
+				break
+			}
+		}
+		return
+	}
+	for _, l := range logs {
+		// Logs of the backfilled blocks may be notified by the subscription as well
+		if l.TxHash == log.TxHash && l.Index == log.Index {
+			return
+		}
+	}
+	s.logs[log.BlockNumber] = append(logs, log)
+
+	// Forget the logs older than the retention, the blocks are no longer covered
+	if log.BlockNumber > LogRetention {
+		oldest := log.BlockNumber - LogRetention
+		for number := range s.logs {
+			if number < oldest {
+				delete(s.logs, number)
+			}
+		}
+		if s.from != nil && s.from.Uint64() < oldest {
+			s.from = new(big.Int).SetUint64(oldest)
+		}
+	}
+}
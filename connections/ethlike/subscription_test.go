@@ -0,0 +1,160 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package ethlike
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ChainSafe/log15"
+	ethcommon "github.com/hacpy/go-ethereum/common"
+	ethtypes "github.com/hacpy/go-ethereum/core/types"
+)
+
+func newTestLogSubscription(head int64, from int64) *LogSubscription {
+	c := &Connection{log: log15.New(), stop: make(chan int), heads: newHeads()}
+	c.heads.set(big.NewInt(head))
+	c.heads.setLive(true)
+	s := &LogSubscription{conn: c, logs: make(map[uint64][]ethtypes.Log)}
+	s.reset(big.NewInt(from))
+	return s
+}
+
+func TestLogSubscriptionCoverage(t *testing.T) {
+	s := newTestLogSubscription(20, 10)
+	s.add(ethtypes.Log{BlockNumber: 12, TxHash: ethcommon.Hash{1}, Index: 0})
+	s.add(ethtypes.Log{BlockNumber: 12, TxHash: ethcommon.Hash{2}, Index: 1})
+
+	logs, ok := s.Logs(big.NewInt(12))
+	if !ok || len(logs) != 2 {
+		t.Fatalf("Got: %d %v Expected: 2 true", len(logs), ok)
+	}
+	if logs, ok := s.Logs(big.NewInt(15)); !ok || len(logs) != 0 {
+		t.Fatalf("Got: %d %v Expected: a covered block without logs", len(logs), ok)
+	}
+
+	// Before the subscription, at the head, or after it dropped, the logs must be filtered
+	for _, block := range []int64{9, 20} {
+		if _, ok := s.Logs(big.NewInt(block)); ok {
+			t.Fatalf("Block %d should not be covered", block)
+		}
+	}
+	s.conn.heads.setLive(false)
+	if _, ok := s.Logs(big.NewInt(12)); ok {
+		t.Fatal("Blocks should not be covered once the heads subscription dropped")
+	}
+
+	var nilSubscription *LogSubscription
+	if _, ok := nilSubscription.Logs(big.NewInt(12)); ok {
+		t.Fatal("Blocks should not be covered without subscription")
+	}
+}
+
+func TestLogSubscriptionLateLogs(t *testing.T) {
+	s := newTestLogSubscription(20, 10)
+	s.confirmations = 5
+
+	// The logs of the blocks below the head may still be on their way
+	if _, ok := s.Logs(big.NewInt(18)); ok {
+		t.Fatal("Block 18 should not be covered before a later log or its confirmations")
+	}
+	if logs, ok := s.Logs(big.NewInt(15)); !ok || len(logs) != 0 {
+		t.Fatalf("Got: %d %v Expected: a confirmed block without logs", len(logs), ok)
+	}
+	s.add(ethtypes.Log{BlockNumber: 19, TxHash: ethcommon.Hash{1}})
+	if logs, ok := s.Logs(big.NewInt(18)); !ok || len(logs) != 0 {
+		t.Fatalf("Got: %d %v Expected: a block completed by a later log", len(logs), ok)
+	}
+	if _, ok := s.Logs(big.NewInt(19)); ok {
+		t.Fatal("Block 19 should not be covered before a later log or its confirmations")
+	}
+
+	// A reorganisation makes the blocks from the reverted log incomplete again
+	s.add(ethtypes.Log{BlockNumber: 17, TxHash: ethcommon.Hash{2}})
+	s.add(ethtypes.Log{BlockNumber: 17, TxHash: ethcommon.Hash{2}, Removed: true})
+	for _, block := range []int64{17, 18} {
+		if _, ok := s.Logs(big.NewInt(block)); ok {
+			t.Fatalf("Block %d should not be covered after a reorganisation", block)
+		}
+	}
+}
+
+func TestLogSubscriptionRemoved(t *testing.T) {
+	s := newTestLogSubscription(20, 10)
+	s.add(ethtypes.Log{BlockNumber: 12, TxHash: ethcommon.Hash{1}, Index: 0})
+	s.add(ethtypes.Log{BlockNumber: 12, TxHash: ethcommon.Hash{2}, Index: 1})
+	s.add(ethtypes.Log{BlockNumber: 12, TxHash: ethcommon.Hash{1}, Index: 0, Removed: true})
+
+	logs, _ := s.Logs(big.NewInt(12))
+	if len(logs) != 1 || logs[0].TxHash != (ethcommon.Hash{2}) {
+		t.Fatalf("Reverted log should be dropped: %v", logs)
+	}
+}
+
+func TestLogSubscriptionDuplicate(t *testing.T) {
+	s := newTestLogSubscription(20, 10)
+	// A backfilled log notified by the subscription as well
+	s.add(ethtypes.Log{BlockNumber: 12, TxHash: ethcommon.Hash{1}, Index: 0})
+	s.add(ethtypes.Log{BlockNumber: 12, TxHash: ethcommon.Hash{1}, Index: 0})
+
+	if logs, _ := s.Logs(big.NewInt(12)); len(logs) != 1 {
+		t.Fatalf("Got: %d Expected: 1", len(logs))
+	}
+}
+
+func TestLogSubscriptionBackfillRange(t *testing.T) {
+	s := newTestLogSubscription(20, 10)
+	// Without a processed block, only the blocks after the head are covered
+	if from, logs, err := s.backfill(big.NewInt(20)); err != nil || from.Int64() != 21 || logs != nil {
+		t.Fatalf("Got: %v %v %v Expected: 21", from, logs, err)
+	}
+	s.Processed(big.NewInt(25))
+	if from, _, err := s.backfill(big.NewInt(20)); err != nil || from.Int64() != 21 {
+		t.Fatalf("Got: %v %v Expected: 21", from, err)
+	}
+
+	var nilSubscription *LogSubscription
+	nilSubscription.Processed(big.NewInt(12))
+}
+
+func TestLogSubscriptionRetention(t *testing.T) {
+	s := newTestLogSubscription(int64(LogRetention)+100, 10)
+	s.add(ethtypes.Log{BlockNumber: 12})
+	s.add(ethtypes.Log{BlockNumber: LogRetention + 50})
+
+	if _, ok := s.Logs(big.NewInt(12)); ok {
+		t.Fatal("Blocks older than the retention should not be covered")
+	}
+	if logs, ok := s.Logs(big.NewInt(int64(LogRetention) + 50)); !ok || len(logs) != 1 {
+		t.Fatalf("Got: %d %v Expected: 1 true", len(logs), ok)
+	}
+}
+
+func TestWaitForBlockWokenByHead(t *testing.T) {
+	c := &Connection{log: log15.New(), stop: make(chan int), heads: newHeads()}
+	c.heads.set(big.NewInt(10))
+	c.heads.setLive(true)
+
+	done := make(chan error)
+	go func() {
+		done <- c.WaitForBlock(big.NewInt(12), big.NewInt(1))
+	}()
+	c.heads.set(big.NewInt(12))
+	select {
+	case <-done:
+		t.Fatal("Block 12 is not confirmed yet")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	c.heads.set(big.NewInt(13))
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Waiter should be woken up by the new head")
+	}
+}
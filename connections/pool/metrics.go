@@ -0,0 +1,83 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package pool
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics exposes the health of the endpoints of a chain, labelled by endpoint
+type Metrics struct {
+	Healthy *prometheus.GaugeVec
+	Lagging *prometheus.GaugeVec
+	Latency *prometheus.GaugeVec
+	Head    *prometheus.GaugeVec
+
+	Disagreements *prometheus.CounterVec
+}
+
+func NewMetrics(chain string) *Metrics {
+	labels := []string{"endpoint"}
+	metrics := &Metrics{
+		Healthy: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: fmt.Sprintf("%s_endpoint_healthy", chain),
+			Help: "Whether the endpoint answered its last health check",
+		}, labels),
+		Lagging: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: fmt.Sprintf("%s_endpoint_lagging", chain),
+			Help: "Whether the endpoint lags behind the other endpoints",
+		}, labels),
+		Latency: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: fmt.Sprintf("%s_endpoint_latency_seconds", chain),
+			Help: "Latency of the last health check of the endpoint",
+		}, labels),
+		Head: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: fmt.Sprintf("%s_endpoint_head", chain),
+			Help: "Latest block reported by the endpoint",
+		}, labels),
+		Disagreements: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: fmt.Sprintf("%s_endpoint_disagreements", chain),
+			Help: "Number of deposits the endpoint disagreed on with the endpoint connected to",
+		}, labels),
+	}
+
+	prometheus.MustRegister(metrics.Healthy)
+	prometheus.MustRegister(metrics.Lagging)
+	prometheus.MustRegister(metrics.Latency)
+	prometheus.MustRegister(metrics.Head)
+	prometheus.MustRegister(metrics.Disagreements)
+
+	return metrics
+}
+
+func (m *Metrics) update(status []Status) {
+	for _, s := range status {
+		endpoint := label(s.URL)
+		m.Healthy.WithLabelValues(endpoint).Set(boolToFloat(s.Healthy))
+		m.Lagging.WithLabelValues(endpoint).Set(boolToFloat(s.Lagging))
+		m.Latency.WithLabelValues(endpoint).Set(s.Latency.Seconds())
+		if s.Healthy {
+			m.Head.WithLabelValues(endpoint).Set(float64(s.Head))
+		}
+	}
+}
+
+// label strips the credentials and query of an endpoint url, which may hold api keys
+func label(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return endpoint
+	}
+	return u.Scheme + "://" + u.Host + u.Path
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,394 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+/*
+Package pool keeps track of the health of the rpc endpoints configured for a chain.
+
+All endpoints are probed in the background for their latest head and latency. The best endpoint is the healthy
+endpoint with the lowest latency among those not lagging more than `maxLag` blocks behind the highest head seen.
+Until the first check, all endpoints are assumed healthy and preferred in config order.
+*/
+package pool
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Time between two health checks of the endpoints
+var CheckInterval = time.Second * 15
+
+// Time after which a probe is considered failed
+var ProbeTimeout = time.Second * 10
+
+// Number of blocks an endpoint may lag behind the highest head before it is avoided
+const DefaultMaxLag = 5
+
+// Longest delay between two attempts to confirm a value, see Backoff
+var MaxBackoff = time.Minute * 5
+
+var ErrProbeTimeout = errors.New("probe timed out")
+var ErrNoQuorum = errors.New("not enough endpoints confirmed")
+
+// DisagreementError reports an endpoint which returned another value than the source endpoint
+type DisagreementError struct {
+	Source   string
+	Endpoint string
+}
+
+func (e *DisagreementError) Error() string {
+	if e.Endpoint == e.Source {
+		return fmt.Sprintf("endpoint %s disagrees with the value read from it", label(e.Endpoint))
+	}
+	return fmt.Sprintf("endpoint %s disagrees with %s", label(e.Endpoint), label(e.Source))
+}
+
+// Probe returns the latest head of the endpoint
+type Probe func(url string) (uint64, error)
+
+// Status is the health of an endpoint as of its last check
+type Status struct {
+	URL     string
+	Healthy bool
+	Lagging bool
+	Latency time.Duration
+	Head    uint64
+	Checked time.Time
+	Err     error
+}
+
+type endpoint struct {
+	url     string
+	healthy bool
+	latency time.Duration
+	head    uint64
+	checked time.Time
+	err     error
+}
+
+type Pool struct {
+	probe     Probe
+	maxLag    uint64
+	lock      sync.RWMutex
+	endpoints []*endpoint // In config order
+	metrics   *Metrics
+	checked   chan struct{} // Closed and replaced after every check
+}
+
+// NewPool returns a pool of the given endpoints, metrics may be nil
+func NewPool(urls []string, probe Probe, maxLag uint64, m *Metrics) *Pool {
+	p := &Pool{
+		probe:   probe,
+		maxLag:  maxLag,
+		metrics: m,
+		checked: make(chan struct{}),
+	}
+	for _, url := range urls {
+		p.endpoints = append(p.endpoints, &endpoint{url: url, healthy: true})
+	}
+	return p
+}
+
+// Run checks the endpoints every CheckInterval until stop is closed
+func (p *Pool) Run(stop <-chan int) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(CheckInterval):
+			p.Check()
+		}
+	}
+}
+
+// Check probes all endpoints concurrently and records their health
+func (p *Pool) Check() {
+	var wg sync.WaitGroup
+	for _, e := range p.endpoints {
+		wg.Add(1)
+		go func(e *endpoint) {
+			defer wg.Done()
+			start := time.Now()
+			head, err := p.probeWithTimeout(e.url)
+
+			p.lock.Lock()
+			e.healthy = err == nil
+			e.latency = time.Since(start)
+			e.checked = time.Now()
+			e.err = err
+			if err == nil {
+				e.head = head
+			}
+			p.lock.Unlock()
+		}(e)
+	}
+	wg.Wait()
+
+	p.lock.Lock()
+	close(p.checked)
+	p.checked = make(chan struct{})
+	p.lock.Unlock()
+
+	if p.metrics != nil {
+		p.metrics.update(p.Status())
+	}
+}
+
+func (p *Pool) probeWithTimeout(url string) (uint64, error) {
+	type result struct {
+		head uint64
+		err  error
+	}
+	res := make(chan result, 1)
+	go func() {
+		head, err := p.probe(url)
+		res <- result{head, err}
+	}()
+
+	select {
+	case r := <-res:
+		return r.head, r.err
+	case <-time.After(ProbeTimeout):
+		return 0, ErrProbeTimeout
+	}
+}
+
+func fetchWithTimeout(url string, fetch func(url string) ([]byte, error)) ([]byte, error) {
+	type result struct {
+		value []byte
+		err   error
+	}
+	res := make(chan result, 1)
+	go func() {
+		value, err := fetch(url)
+		res <- result{value, err}
+	}()
+
+	select {
+	case r := <-res:
+		return r.value, r.err
+	case <-time.After(ProbeTimeout):
+		return nil, ErrProbeTimeout
+	}
+}
+
+// Confirm checks that at least `quorum` endpoints, counting the source endpoint, return the expected value.
+// The other endpoints are queried by preference until the quorum is reached, endpoints failing to answer are
+// skipped. Any endpoint answering another value is a disagreement, and the value must not be trusted.
+func (p *Pool) Confirm(source string, quorum int, expected []byte, fetch func(url string) ([]byte, error)) error {
+	confirmed := 1
+	for _, url := range p.preferred(source) {
+		if confirmed >= quorum {
+			break
+		}
+
+		value, err := fetchWithTimeout(url, fetch)
+		if err != nil {
+			continue
+		}
+		if !bytes.Equal(value, expected) {
+			if p.metrics != nil {
+				p.metrics.Disagreements.WithLabelValues(label(url)).Inc()
+			}
+			return &DisagreementError{Source: source, Endpoint: url}
+		}
+		confirmed++
+	}
+
+	if confirmed < quorum {
+		return fmt.Errorf("%w: %d of %d", ErrNoQuorum, confirmed, quorum)
+	}
+	return nil
+}
+
+// ConfirmWithSource checks a value computed by the caller from what it read from the source endpoint, see Confirm. The
+// source endpoint is queried as well and only counts if it still returns the value, as the caller may have read from
+// another endpoint before moving to the source.
+func (p *Pool) ConfirmWithSource(source string, quorum int, expected []byte, fetch func(url string) ([]byte, error)) error {
+	value, err := fetchWithTimeout(source, fetch)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(value, expected) {
+		if p.metrics != nil {
+			p.metrics.Disagreements.WithLabelValues(label(source)).Inc()
+		}
+		return &DisagreementError{Source: source, Endpoint: source}
+	}
+	return p.Confirm(source, quorum, expected, fetch)
+}
+
+// Unconfirmed returns whether err reports a value which was not confirmed by a quorum of endpoints, either because an
+// endpoint disagreed or because too few endpoints answered
+func Unconfirmed(err error) bool {
+	var disagreement *DisagreementError
+	return errors.As(err, &disagreement) || errors.Is(err, ErrNoQuorum)
+}
+
+// Backoff returns the delay before the next attempt to confirm a value, doubling the previous delay up to MaxBackoff.
+// The first attempt waits for initial.
+func Backoff(previous time.Duration, initial time.Duration) time.Duration {
+	if previous <= 0 {
+		return initial
+	}
+	if previous >= MaxBackoff/2 {
+		return MaxBackoff
+	}
+	return previous * 2
+}
+
+// preferred returns the endpoints other than exclude, usable ones first by latency
+func (p *Pool) preferred(exclude string) []string {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	highest := p.highestHead()
+	endpoints := make([]*endpoint, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		if e.url != exclude {
+			endpoints = append(endpoints, e)
+		}
+	}
+	sort.SliceStable(endpoints, func(i, j int) bool {
+		ui, uj := p.usable(endpoints[i], highest), p.usable(endpoints[j], highest)
+		if ui != uj {
+			return ui
+		}
+		return endpoints[i].latency < endpoints[j].latency
+	})
+
+	res := make([]string, len(endpoints))
+	for i, e := range endpoints {
+		res[i] = e.url
+	}
+	return res
+}
+
+// Checked returns a channel closed once the next check completes
+func (p *Pool) Checked() <-chan struct{} {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.checked
+}
+
+// highestHead returns the highest head of the healthy endpoints, lock must be held
+func (p *Pool) highestHead() uint64 {
+	var highest uint64
+	for _, e := range p.endpoints {
+		if e.healthy && e.head > highest {
+			highest = e.head
+		}
+	}
+	return highest
+}
+
+// usable returns whether the endpoint is healthy and keeps up with the others, lock must be held
+func (p *Pool) usable(e *endpoint, highest uint64) bool {
+	return e.healthy && e.head+p.maxLag >= highest
+}
+
+// Best returns the best endpoint, or false if none is usable
+func (p *Pool) Best() (string, bool) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.best("")
+}
+
+// best returns the usable endpoint with the lowest latency other than exclude, lock must be held
+func (p *Pool) best(exclude string) (string, bool) {
+	highest := p.highestHead()
+	var best *endpoint
+	for _, e := range p.endpoints {
+		if e.url == exclude || !p.usable(e, highest) {
+			continue
+		}
+		if best == nil || e.latency < best.latency {
+			best = e
+		}
+	}
+	if best == nil {
+		return "", false
+	}
+	return best.url, true
+}
+
+// Avoid returns whether the endpoint is unhealthy or lagging, and another endpoint should be used
+func (p *Pool) Avoid(url string) bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	highest := p.highestHead()
+	for _, e := range p.endpoints {
+		if e.url == url {
+			return !p.usable(e, highest)
+		}
+	}
+	return true
+}
+
+// Failover marks the endpoint as unhealthy until its next successful check and returns the best other endpoint.
+// If none is usable, the endpoint following it in config order is returned.
+func (p *Pool) Failover(url string) string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(p.endpoints) == 0 {
+		return url
+	}
+
+	next := 0
+	for i, e := range p.endpoints {
+		if e.url == url {
+			e.healthy = false
+			next = (i + 1) % len(p.endpoints)
+		}
+	}
+
+	if best, ok := p.best(url); ok {
+		return best
+	}
+	return p.endpoints[next].url
+}
+
+// Status returns the health of all endpoints, in config order
+func (p *Pool) Status() []Status {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	highest := p.highestHead()
+	res := make([]Status, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		res = append(res, Status{
+			URL:     e.url,
+			Healthy: e.healthy,
+			Lagging: e.healthy && !p.usable(e, highest),
+			Latency: e.latency,
+			Head:    e.head,
+			Checked: e.checked,
+			Err:     e.err,
+		})
+	}
+	return res
+}
+
+// Follow switches to the best endpoint after every check in which the current endpoint is unhealthy or lagging,
+// until stop is closed
+func (p *Pool) Follow(current func() string, switchTo func(url string) error, stop <-chan int) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-p.Checked():
+			url := current()
+			if !p.Avoid(url) {
+				continue
+			}
+			if best, ok := p.Best(); ok && best != url {
+				err := switchTo(best)
+				if err != nil {
+					p.Failover(best)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package pool
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testProbe reports the configured heads, endpoints without head fail
+type testProbe struct {
+	lock  sync.Mutex
+	heads map[string]uint64
+	delay map[string]time.Duration
+}
+
+func (p *testProbe) probe(url string) (uint64, error) {
+	p.lock.Lock()
+	head, ok := p.heads[url]
+	delay := p.delay[url]
+	p.lock.Unlock()
+
+	time.Sleep(delay)
+	if !ok {
+		return 0, errors.New("unreachable")
+	}
+	return head, nil
+}
+
+func (p *testProbe) set(url string, head uint64) {
+	p.lock.Lock()
+	p.heads[url] = head
+	p.lock.Unlock()
+}
+
+func TestBestBeforeCheck(t *testing.T) {
+	p := NewPool([]string{"a", "b"}, (&testProbe{}).probe, DefaultMaxLag, nil)
+	best, ok := p.Best()
+	if !ok || best != "a" {
+		t.Fatalf("Got: %s %v Expected: the first endpoint", best, ok)
+	}
+}
+
+func TestBestAvoidsUnhealthyAndLagging(t *testing.T) {
+	probe := &testProbe{
+		heads: map[string]uint64{"fast-lagging": 90, "slow": 100, "fastest": 98},
+		delay: map[string]time.Duration{"slow": 20 * time.Millisecond, "fastest": 5 * time.Millisecond},
+	}
+	p := NewPool([]string{"down", "fast-lagging", "slow", "fastest"}, probe.probe, 5, nil)
+	p.Check()
+
+	best, ok := p.Best()
+	if !ok || best != "fastest" {
+		t.Fatalf("Got: %s %v Expected: fastest", best, ok)
+	}
+	for url, avoid := range map[string]bool{"down": true, "fast-lagging": true, "slow": false, "fastest": false, "unknown": true} {
+		if p.Avoid(url) != avoid {
+			t.Errorf("Avoid(%s) should be %v", url, avoid)
+		}
+	}
+
+	status := p.Status()
+	if status[0].Healthy || status[0].Err == nil || !status[1].Lagging || status[2].Head != 100 {
+		t.Fatalf("Unexpected status: %+v", status)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	probe := &testProbe{heads: map[string]uint64{"a": 10, "b": 10}}
+	p := NewPool([]string{"a", "b", "c"}, probe.probe, DefaultMaxLag, nil)
+	p.Check()
+
+	if next := p.Failover("a"); next != "b" {
+		t.Fatalf("Got: %s Expected: b", next)
+	}
+	// Nothing usable is left, the endpoints are tried in config order
+	if next := p.Failover("b"); next != "c" {
+		t.Fatalf("Got: %s Expected: c", next)
+	}
+	if next := p.Failover("c"); next != "a" {
+		t.Fatalf("Got: %s Expected: a", next)
+	}
+
+	// Failed endpoints are healthy again once they answer a check
+	p.Check()
+	if p.Avoid("a") {
+		t.Fatal("a should be healthy again")
+	}
+}
+
+func TestProbeTimeout(t *testing.T) {
+	timeout := ProbeTimeout
+	ProbeTimeout = 10 * time.Millisecond
+	defer func() { ProbeTimeout = timeout }()
+
+	probe := &testProbe{heads: map[string]uint64{"a": 10}, delay: map[string]time.Duration{"a": time.Second}}
+	p := NewPool([]string{"a"}, probe.probe, DefaultMaxLag, nil)
+	p.Check()
+
+	if status := p.Status(); status[0].Healthy || status[0].Err != ErrProbeTimeout {
+		t.Fatalf("Got: %+v Expected: a timed out probe", status[0])
+	}
+}
+
+func TestFollowSwitchesAwayFromLagging(t *testing.T) {
+	probe := &testProbe{heads: map[string]uint64{"a": 10, "b": 10}}
+	p := NewPool([]string{"a", "b"}, probe.probe, 2, nil)
+
+	var lock sync.Mutex
+	current := "a"
+	switched := make(chan string, 1)
+	stop := make(chan int)
+	defer close(stop)
+	go p.Follow(func() string {
+		lock.Lock()
+		defer lock.Unlock()
+		return current
+	}, func(url string) error {
+		lock.Lock()
+		current = url
+		lock.Unlock()
+		switched <- url
+		return nil
+	}, stop)
+
+	// Wait for Follow to watch the checks
+	time.Sleep(10 * time.Millisecond)
+	p.Check()
+	select {
+	case url := <-switched:
+		t.Fatalf("Should not switch away from a healthy endpoint, switched to %s", url)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	probe.set("b", 20)
+	p.Check()
+	select {
+	case url := <-switched:
+		if url != "b" {
+			t.Fatalf("Got: %s Expected: b", url)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Should switch away from the lagging endpoint")
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	values := map[string]string{"a": "deposit", "b": "deposit", "c": "deposit", "forged": "other"}
+	fetch := func(url string) ([]byte, error) {
+		value, ok := values[url]
+		if !ok {
+			return nil, errors.New("unreachable")
+		}
+		return []byte(value), nil
+	}
+
+	p := NewPool([]string{"a", "down", "b", "c"}, (&testProbe{}).probe, DefaultMaxLag, nil)
+	if err := p.Confirm("a", 3, []byte("deposit"), fetch); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Confirm("a", 1, []byte("anything"), fetch); err != nil {
+		t.Fatalf("A quorum of 1 should trust the source: %s", err)
+	}
+
+	// Unreachable endpoints are skipped, but can't make the quorum
+	if err := p.Confirm("a", 4, []byte("deposit"), fetch); !errors.Is(err, ErrNoQuorum) || !Unconfirmed(err) {
+		t.Fatalf("Got: %v Expected: %s", err, ErrNoQuorum)
+	}
+
+	// The source endpoint forged the deposit
+	err := p.Confirm("forged", 2, []byte("other"), fetch)
+	disagreement, ok := err.(*DisagreementError)
+	if !ok || disagreement.Endpoint != "a" || !Unconfirmed(err) {
+		t.Fatalf("Got: %v Expected: a disagreement with a", err)
+	}
+	// The deposit was read from the forged endpoint before moving to a
+	if err = p.ConfirmWithSource("a", 2, []byte("other"), fetch); !Unconfirmed(err) {
+		t.Fatalf("Got: %v Expected: a disagreement of a with the value", err)
+	}
+	if err = p.ConfirmWithSource("a", 3, []byte("deposit"), fetch); err != nil {
+		t.Fatal(err)
+	}
+	if Unconfirmed(errors.New("unreachable")) || Unconfirmed(nil) {
+		t.Fatal("Only disagreements and missing quorums are unconfirmed")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	var delays []time.Duration
+	var delay time.Duration
+	for i := 0; i < 11; i++ {
+		delay = Backoff(delay, time.Second)
+		delays = append(delays, delay)
+	}
+	if delays[0] != time.Second || delays[1] != 2*time.Second || delays[8] != 256*time.Second {
+		t.Fatalf("Unexpected delays %v", delays)
+	}
+	if delays[9] != MaxBackoff || delays[10] != MaxBackoff {
+		t.Fatalf("Got %v, expected the delays capped at %s", delays[9:], MaxBackoff)
+	}
+}
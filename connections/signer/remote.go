@@ -0,0 +1,195 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package signer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hacpy/go-ethereum/common/hexutil"
+)
+
+// Chain config options of the remote signer
+const (
+	URLOpt       = "signer"
+	TokenFileOpt = "signerTokenFile"
+)
+
+// Timeout of a request to the signing service
+var RequestTimeout = 10 * time.Second
+
+// Paths of the signing service
+const (
+	keyPath  = "/v1/key"
+	signPath = "/v1/sign"
+)
+
+type Config struct {
+	URL   string // unix:///path/to/socket, or a loopback http:// or https:// URL
+	Token string // Bearer token of the requests
+}
+
+// ParseConfig parses the remote signer options of the chain, the token is read from its file. It returns nil if no
+// signing service is configured.
+func ParseConfig(opts map[string]string) (*Config, error) {
+	rawURL := strings.TrimSpace(opts[URLOpt])
+	if rawURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", URLOpt, err)
+	}
+	switch u.Scheme {
+	case "unix":
+		if u.Path == "" {
+			return nil, fmt.Errorf("invalid %s: no socket path", URLOpt)
+		}
+	case "http", "https":
+		// The signing service only serves local clients, and over http the token and the signed data are sent in
+		// clear, which is only acceptable on the local host
+		host := u.Hostname()
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return nil, fmt.Errorf("invalid %s: %s is only allowed on the loopback interface", URLOpt, u.Scheme)
+		}
+	default:
+		return nil, fmt.Errorf("invalid %s: unsupported scheme %s", URLOpt, u.Scheme)
+	}
+
+	tokenFile := opts[TokenFileOpt]
+	if tokenFile == "" {
+		return nil, fmt.Errorf("%s requires %s", URLOpt, TokenFileOpt)
+	}
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", TokenFileOpt, err)
+	}
+	cfg := &Config{URL: rawURL, Token: strings.TrimSpace(string(token))}
+	if cfg.Token == "" {
+		return nil, fmt.Errorf("empty %s", TokenFileOpt)
+	}
+	return cfg, nil
+}
+
+// keyRequest identifies a key of the signing service
+type keyRequest struct {
+	Scheme  string `json:"scheme"`
+	Address string `json:"address"` // Address of the key, as configured in the `from` of the chain
+}
+
+type keyResponse struct {
+	PublicKey hexutil.Bytes `json:"publicKey"`
+}
+
+type signRequest struct {
+	keyRequest
+	Data hexutil.Bytes `json:"data"`
+}
+
+type signResponse struct {
+	Signature hexutil.Bytes `json:"signature"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type remoteSigner struct {
+	key       keyRequest
+	publicKey []byte
+	base      string
+	token     string
+	client    *http.Client
+}
+
+// NewRemote returns a signer of the key of an address held by a signing service. The public key is read once, and
+// every signature returned by the service is verified against it.
+func NewRemote(cfg *Config, scheme string, address string) (Signer, error) {
+	s := &remoteSigner{
+		key:    keyRequest{Scheme: scheme, Address: address},
+		base:   cfg.URL,
+		token:  cfg.Token,
+		client: &http.Client{Timeout: RequestTimeout},
+	}
+	if strings.HasPrefix(cfg.URL, "unix://") {
+		socket := strings.TrimPrefix(cfg.URL, "unix://")
+		s.base = "http://signer"
+		s.client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+	}
+	s.base = strings.TrimSuffix(s.base, "/")
+
+	var res keyResponse
+	err := s.call(keyPath, s.key, &res)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the key of %s from the signer: %w", address, err)
+	}
+	err = checkPublicKey(scheme, res.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	s.publicKey = res.PublicKey
+	return s, nil
+}
+
+func (s *remoteSigner) Scheme() string {
+	return s.key.Scheme
+}
+
+func (s *remoteSigner) PublicKey() []byte {
+	return s.publicKey
+}
+
+func (s *remoteSigner) Sign(data []byte) ([]byte, error) {
+	var res signResponse
+	err := s.call(signPath, signRequest{keyRequest: s.key, Data: data}, &res)
+	if err != nil {
+		return nil, fmt.Errorf("remote signing failed: %w", err)
+	}
+	if !Verify(s.key.Scheme, s.publicKey, data, res.Signature) {
+		return nil, errors.New("remote signing failed: invalid signature")
+	}
+	return res.Signature, nil
+}
+
+// call posts a request to the signing service and decodes its response
+func (s *remoteSigner) call(path string, req interface{}, res interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, s.base+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+s.token)
+
+	resp, err := s.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e errorResponse
+		if json.NewDecoder(resp.Body).Decode(&e) == nil && e.Error != "" {
+			return fmt.Errorf("%s: %s", resp.Status, e.Error)
+		}
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(res)
+}
@@ -0,0 +1,155 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package signer
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	log "github.com/ChainSafe/log15"
+	"github.com/hacpy/go-ethereum/crypto"
+)
+
+// Largest request accepted by the signing service
+const maxRequestSize = 1 << 20
+
+// Key is a key served by the signing service
+type Key struct {
+	Address string // Address of the key, as configured in the `from` of the chain
+	Signer  Signer
+}
+
+// Handler serves the keys over HTTP to the remote signers presenting the token. Only requests over a unix socket or
+// from a loopback address are served, as the keys sign whatever the token holder sends. Every signature is logged with
+// the key and the hash of the signed data, so that the signing service keeps an audit trail of the relayer.
+func Handler(token string, keys []Key, log log.Logger) http.Handler {
+	h := &handler{token: token, keys: make(map[keyRequest]Signer), log: log}
+	for _, k := range keys {
+		h.keys[keyRequest{Scheme: k.Signer.Scheme(), Address: k.Address}] = k.Signer
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(keyPath, h.publicKey)
+	mux.HandleFunc(signPath, h.sign)
+	return h.authenticate(mux)
+}
+
+type handler struct {
+	token string
+	keys  map[keyRequest]Signer
+	log   log.Logger
+}
+
+func (h *handler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !local(r) {
+			h.log.Warn("Rejected a signing request from a remote address", "remote", r.RemoteAddr, "path", r.URL.Path)
+			writeError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		auth := []byte(r.Header.Get("Authorization"))
+		if h.token == "" || subtle.ConstantTimeCompare(auth, []byte("Bearer "+h.token)) != 1 {
+			h.log.Warn("Rejected an unauthenticated signing request", "path", r.URL.Path)
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *handler) publicKey(w http.ResponseWriter, r *http.Request) {
+	var req keyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	s, ok := h.keys[req]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no %s key for %s", req.Scheme, req.Address))
+		return
+	}
+	writeJSON(w, keyResponse{PublicKey: s.PublicKey()})
+}
+
+func (h *handler) sign(w http.ResponseWriter, r *http.Request) {
+	var req signRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	s, ok := h.keys[req.keyRequest]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no %s key for %s", req.Scheme, req.Address))
+		return
+	}
+	// secp256k1 signatures are only defined over 32 byte hashes. This doesn't restrict what is signed, transaction
+	// hashes are 32 bytes as well: the token and the local transport are what protect the key.
+	if req.Scheme == Secp256k1 && len(req.Data) != 32 {
+		writeError(w, http.StatusBadRequest, "secp256k1 keys only sign 32 byte hashes")
+		return
+	}
+	sig, err := s.Sign(req.Data)
+	if err != nil {
+		h.log.Error("Signing failed", "scheme", req.Scheme, "address", req.Address, "err", err)
+		writeError(w, http.StatusInternalServerError, "signing failed")
+		return
+	}
+	h.log.Info("Signed", "scheme", req.Scheme, "address", req.Address, "size", len(req.Data), "hash", crypto.Keccak256Hash(req.Data).Hex())
+	writeJSON(w, signResponse{Signature: sig})
+}
+
+// local returns whether a request came over a unix socket or from a loopback address
+func local(r *http.Request) bool {
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok && addr.Network() == "unix" {
+		return true
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func writeJSON(w http.ResponseWriter, res interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(errorResponse{Error: msg})
+}
+
+// ListenUnix listens on a unix socket only accessible to the user of the process, replacing a socket left by a
+// previous run
+func ListenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err = os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(path, 0600)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
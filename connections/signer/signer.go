@@ -0,0 +1,155 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+/*
+Package signer signs with the relayer keys, so that the connections don't need the keys themselves.
+
+The signers of NewSecp256k1 and NewSr25519 hold a key decrypted from the keystore in the relayer process. The signer
+of NewRemote asks a signing service for every signature, so that the keys can live in a separate, hardened process;
+`platdot signer` serves the keys of the keystore this way over an authenticated local socket, see Handler.
+
+The secp256k1 signers sign 32 byte hashes and return 65 byte [R || S || V] signatures, V being 0 or 1. The sr25519
+signers sign messages in the substrate signing context, the messages longer than 256 bytes being signed by their
+blake2b hash, and return 64 byte signatures.
+*/
+package signer
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+
+	schnorrkel "github.com/ChainSafe/go-schnorrkel"
+	"github.com/Platdot-Network/go-substrate-rpc-client/v3/signature"
+	"github.com/hacpy/go-ethereum/common"
+	"github.com/hacpy/go-ethereum/crypto"
+	"github.com/rjman-ljm/platdot-utils/crypto/secp256k1"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Signature schemes of the keys
+const (
+	Secp256k1 = "secp256k1"
+	Sr25519   = "sr25519"
+)
+
+// Signer signs with a relayer key without exposing it
+type Signer interface {
+	// Scheme returns the signature scheme of the key
+	Scheme() string
+	// PublicKey returns the uncompressed public key of a secp256k1 key, or the 32 byte public key of an sr25519 key
+	PublicKey() []byte
+	Sign(data []byte) ([]byte, error)
+}
+
+type secp256k1Signer struct {
+	key *ecdsa.PrivateKey
+}
+
+// NewSecp256k1 returns a signer holding the key of an ethlike relayer
+func NewSecp256k1(kp *secp256k1.Keypair) Signer {
+	return &secp256k1Signer{key: kp.PrivateKey()}
+}
+
+func (s *secp256k1Signer) Scheme() string {
+	return Secp256k1
+}
+
+func (s *secp256k1Signer) PublicKey() []byte {
+	return crypto.FromECDSAPub(&s.key.PublicKey)
+}
+
+func (s *secp256k1Signer) Sign(hash []byte) ([]byte, error) {
+	return crypto.Sign(hash, s.key)
+}
+
+type sr25519Signer struct {
+	kp signature.KeyringPair
+}
+
+// NewSr25519 returns a signer holding the key of a substrate relayer
+func NewSr25519(kp signature.KeyringPair) Signer {
+	return &sr25519Signer{kp: kp}
+}
+
+func (s *sr25519Signer) Scheme() string {
+	return Sr25519
+}
+
+func (s *sr25519Signer) PublicKey() []byte {
+	return s.kp.PublicKey
+}
+
+func (s *sr25519Signer) Sign(data []byte) ([]byte, error) {
+	return signature.Sign(data, s.kp.URI)
+}
+
+// EthAddress returns the address of a secp256k1 signer, whose public key is checked when the signer is created
+func EthAddress(s Signer) common.Address {
+	return addressOf(s.PublicKey())
+}
+
+// Verify returns whether sig is a signature of data by the public key, in the scheme
+func Verify(scheme string, publicKey []byte, data []byte, sig []byte) bool {
+	switch scheme {
+	case Secp256k1:
+		if len(sig) != 65 {
+			return false
+		}
+		pub, err := crypto.SigToPub(data, sig)
+		return err == nil && crypto.PubkeyToAddress(*pub) == addressOf(publicKey)
+	case Sr25519:
+		return verifySr25519(publicKey, data, sig)
+	default:
+		return false
+	}
+}
+
+// addressOf returns the address of a secp256k1 public key, the zero address if it is invalid
+func addressOf(publicKey []byte) common.Address {
+	pub, err := crypto.UnmarshalPubkey(publicKey)
+	if err != nil {
+		return common.Address{}
+	}
+	return crypto.PubkeyToAddress(*pub)
+}
+
+func verifySr25519(publicKey []byte, data []byte, sig []byte) bool {
+	if len(publicKey) != 32 || len(sig) != 64 {
+		return false
+	}
+	if len(data) > 256 {
+		h := blake2b.Sum256(data)
+		data = h[:]
+	}
+	var pubBytes [32]byte
+	copy(pubBytes[:], publicKey)
+	pub := new(schnorrkel.PublicKey)
+	if err := pub.Decode(pubBytes); err != nil {
+		return false
+	}
+	var sigBytes [64]byte
+	copy(sigBytes[:], sig)
+	s := new(schnorrkel.Signature)
+	if err := s.Decode(sigBytes); err != nil {
+		return false
+	}
+	return pub.Verify(s, schnorrkel.NewSigningContext([]byte("substrate"), data))
+}
+
+// checkPublicKey returns an error if the public key is not a valid key of the scheme
+func checkPublicKey(scheme string, publicKey []byte) error {
+	switch scheme {
+	case Secp256k1:
+		if _, err := crypto.UnmarshalPubkey(publicKey); err != nil {
+			return fmt.Errorf("invalid secp256k1 public key: %w", err)
+		}
+	case Sr25519:
+		if len(publicKey) != 32 {
+			return errors.New("invalid sr25519 public key")
+		}
+	default:
+		return fmt.Errorf("unsupported scheme: %s", scheme)
+	}
+	return nil
+}
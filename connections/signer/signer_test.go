@@ -0,0 +1,191 @@
+// Copyright 2021 ChainSafe Systems
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package signer
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "github.com/ChainSafe/log15"
+	"github.com/Platdot-Network/go-substrate-rpc-client/v3/signature"
+	"github.com/hacpy/go-ethereum/crypto"
+	"github.com/rjman-ljm/platdot-utils/crypto/secp256k1"
+)
+
+const testToken = "secret"
+
+func testLogger() log.Logger {
+	l := log.New()
+	l.SetHandler(log.DiscardHandler())
+	return l
+}
+
+func writeToken(t *testing.T, dir string, token string) string {
+	path := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := writeToken(t, dir, testToken)
+
+	if cfg, err := ParseConfig(map[string]string{}); cfg != nil || err != nil {
+		t.Fatalf("Got %v %v, expected no config", cfg, err)
+	}
+	cfg, err := ParseConfig(map[string]string{URLOpt: "unix:///run/platdot/signer.sock", TokenFileOpt: tokenFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.URL != "unix:///run/platdot/signer.sock" || cfg.Token != testToken {
+		t.Fatalf("Unexpected config: %+v", cfg)
+	}
+	for _, rawURL := range []string{"http://127.0.0.1:8600", "https://localhost:8600"} {
+		if _, err = ParseConfig(map[string]string{URLOpt: rawURL, TokenFileOpt: tokenFile}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, opts := range []map[string]string{
+		{URLOpt: "http://10.0.0.1:8600", TokenFileOpt: tokenFile},
+		{URLOpt: "https://signer.example.com", TokenFileOpt: tokenFile},
+		{URLOpt: "ftp://127.0.0.1", TokenFileOpt: tokenFile},
+		{URLOpt: "unix:///run/platdot/signer.sock"},
+		{URLOpt: "unix:///run/platdot/signer.sock", TokenFileOpt: writeToken(t, dir, "")},
+	} {
+		if _, err = ParseConfig(opts); err == nil {
+			t.Fatalf("Expected an error for %v", opts)
+		}
+	}
+}
+
+func TestRemoteSigner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	kp, err := secp256k1.GenerateKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []Key{
+		{Address: kp.Address(), Signer: NewSecp256k1(kp)},
+		{Address: signature.TestKeyringPairAlice.Address, Signer: NewSr25519(signature.TestKeyringPairAlice)},
+	}
+	server := httptest.NewServer(Handler(testToken, keys, testLogger()))
+	defer server.Close()
+	cfg, err := ParseConfig(map[string]string{URLOpt: server.URL, TokenFileOpt: writeToken(t, dir, testToken)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eth, err := NewRemote(cfg, Secp256k1, kp.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if EthAddress(eth) != kp.CommonAddress() {
+		t.Fatalf("Got address %s, expected %s", EthAddress(eth).Hex(), kp.Address())
+	}
+	hash := crypto.Keccak256([]byte("transaction"))
+	sig, err := eth.Sign(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Verify(Secp256k1, eth.PublicKey(), hash, sig) {
+		t.Fatal("Expected a valid secp256k1 signature")
+	}
+	// Only hashes are signed with secp256k1 keys
+	if _, err = eth.Sign([]byte("not a hash")); err == nil {
+		t.Fatal("Expected an error when signing a message")
+	}
+
+	sub, err := NewRemote(cfg, Sr25519, signature.TestKeyringPairAlice.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sub.PublicKey(), signature.TestKeyringPairAlice.PublicKey) {
+		t.Fatalf("Unexpected public key %x", sub.PublicKey())
+	}
+	// Long messages are signed by their hash, as the extrinsic payloads are
+	for _, data := range [][]byte{[]byte("extrinsic"), bytes.Repeat([]byte{1}, 300)} {
+		sig, err = sub.Sign(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !Verify(Sr25519, sub.PublicKey(), data, sig) || Verify(Sr25519, sub.PublicKey(), []byte("other"), sig) {
+			t.Fatal("Unexpected sr25519 verification")
+		}
+	}
+
+	if _, err = NewRemote(cfg, Sr25519, "unknown"); err == nil {
+		t.Fatal("Expected an error for an unknown key")
+	}
+	if _, err = NewRemote(&Config{URL: server.URL, Token: "wrong"}, Secp256k1, kp.Address()); err == nil {
+		t.Fatal("Expected an error for a wrong token")
+	}
+}
+
+func TestRemoteAddressRejected(t *testing.T) {
+	kp, err := secp256k1.GenerateKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := Handler(testToken, []Key{{Address: kp.Address(), Signer: NewSecp256k1(kp)}}, testLogger())
+
+	for remote, status := range map[string]int{"10.0.0.1:4000": http.StatusForbidden, "[::1]:4000": http.StatusOK} {
+		body := fmt.Sprintf(`{"scheme":%q,"address":%q}`, Secp256k1, kp.Address())
+		r := httptest.NewRequest(http.MethodPost, keyPath, strings.NewReader(body))
+		r.RemoteAddr = remote
+		r.Header.Set("Authorization", "Bearer "+testToken)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != status {
+			t.Fatalf("Got status %d for %s, expected %d", w.Code, remote, status)
+		}
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	kp, err := secp256k1.GenerateKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "signer.sock")
+	l, err := ListenUnix(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: Handler(testToken, []Key{{Address: kp.Address(), Signer: NewSecp256k1(kp)}}, testLogger())}
+	go server.Serve(l)
+	defer server.Close()
+
+	s, err := NewRemote(&Config{URL: "unix://" + socket, Token: testToken}, Secp256k1, kp.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if EthAddress(s) != kp.CommonAddress() {
+		t.Fatalf("Got address %s, expected %s", EthAddress(s).Hex(), kp.Address())
+	}
+}
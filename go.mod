@@ -3,6 +3,7 @@ module github.com/Platdot-network/Platdot
 go 1.15
 
 require (
+	github.com/ChainSafe/go-schnorrkel v0.0.0-20210318173838-ccb5cd955283
 	github.com/ChainSafe/log15 v1.0.0
 	github.com/Platdot-Network/go-substrate-rpc-client/v3 v3.0.10
 	github.com/Platdot-Network/substrate-go v1.6.9
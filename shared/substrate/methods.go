@@ -23,8 +23,13 @@ var BalancesTransferMethod Method = "Balances.transfer"
 var BalancesTransferKeepAliveMethod Method = "Balances.transfer_keep_alive"
 var SystemRemark Method = "System.remark"
 var UtilityBatch Method = "Utility.batch"
-var UtilityBatchAll Method = "Utility.batchall"
+var UtilityBatchAll Method = "Utility.batch_all"
 var MultisigAsMulti Method = "Multisig.as_multi"
+var MultisigCancelAsMulti Method = "Multisig.cancel_as_multi"
 
 /// ChainX Method
-var XAssetsTransferMethod Method = "XAssets.transfer"
\ No newline at end of file
+var XAssetsTransferMethod Method = "XAssets.transfer"
+
+/// Assets Method
+var AssetsTransferMethod Method = "Assets.transfer"
+var AssetsTransferKeepAliveMethod Method = "Assets.transfer_keep_alive"
\ No newline at end of file
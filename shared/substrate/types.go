@@ -11,6 +11,7 @@ const BridgePalletName = "ChainBridge"
 const BridgeStoragePrefix = "ChainBridge"
 const HandlerPalletName = "AssetsHandler"
 const HandlerStoragePrefix = "AssetsHandler"
+const MultisigStoragePrefix = "Multisig"
 
 type Erc721Token struct {
 	Id       types.U256